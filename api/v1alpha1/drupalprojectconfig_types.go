@@ -25,6 +25,23 @@ type DrupalProjectConfigSpec struct {
 	// PrimarySiteName defines the primary DrupalSite instance of a project
 	// +optional
 	PrimarySiteName string `json:"primarySiteName,omitempty"`
+	// EnableTektonExtraPermissions grants the project's "tektoncd" service account the extra permissions needed by the
+	// Drupal Tekton tasks, through a ClusterRoleBinding. Sites using "easystart" always need it.
+	// +optional
+	EnableTektonExtraPermissions bool `json:"enableTektonExtraPermissions,omitempty"`
+	// DefaultDomain is the domain of the siteUrl given to the project's new DrupalSites without one, eg "web.cern.ch":
+	// `<namespace>.<defaultDomain>` for the primary site and `<name>-<namespace>.<defaultDomain>` for the others.
+	// +optional
+	DefaultDomain string `json:"defaultDomain,omitempty"`
+	// DefaultQoSClass is the qosClass of the project's new DrupalSites without one. By default, it's "standard".
+	// +kubebuilder:validation:Enum:=critical;test;standard
+	// +optional
+	DefaultQoSClass QoSClass `json:"defaultQoSClass,omitempty"`
+	// AllowCloneTo lists the namespaces whose DrupalSites may clone the DrupalSites of this project, with a `cloneFrom`
+	// of the form `namespace/name`. While their clone job runs, a clone export job of this project serves them a read-only
+	// copy of the database and of the files of the cloned DrupalSite; they get neither its DB credentials nor its volume.
+	// +optional
+	AllowCloneTo []string `json:"allowCloneTo,omitempty"`
 }
 
 // DrupalProjectConfigStatus defines the observed state of DrupalProjectConfig
@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	"github.com/operator-framework/operator-lib/status"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -29,23 +30,60 @@ const (
 	DBODStandard DatabaseClass = "standard"
 	DBODCritical DatabaseClass = "critical"
 	DBODSSD      DatabaseClass = "ssd"
+
+	CloneStrategyCopy  CloneStrategy = "copy"
+	CloneStrategyRsync CloneStrategy = "rsync"
+)
+
+const (
+	UpdateStepRollingOutCode UpdateStep = "RollingOutCode"
+	UpdateStepClearingCache  UpdateStep = "ClearingCache"
+	UpdateStepBackingUpDB    UpdateStep = "BackingUpDB"
+	UpdateStepRunningUpdb    UpdateStep = "RunningUpdb"
+	UpdateStepRollingBack    UpdateStep = "RollingBack"
+)
+
+const (
+	SiteStateInstalling SiteState = "Installing"
+	SiteStateReady      SiteState = "Ready"
+	SiteStateNotReady   SiteState = "NotReady"
+	SiteStateUpdating   SiteState = "Updating"
+	SiteStateError      SiteState = "Error"
+	SiteStateBlocked    SiteState = "Blocked"
 )
 
 // DrupalSiteSpec defines the desired state of DrupalSite
 type DrupalSiteSpec struct {
 	// SiteURL is the URL where the site should be made available.
 	// Recommended to set `<environmentName>-<projectname>.web.cern.ch`
-	// or `<projectname>.web.cern.ch` if this is the "live" site
-	// +kubebuilder:validation:Required
-	SiteURL []Url `json:"siteUrl"`
+	// or `<projectname>.web.cern.ch` if this is the "live" site.
+	// If empty, a new site gets a URL in the `defaultDomain` of the project's DrupalProjectConfig. A published site must have at least one.
+	// +optional
+	SiteURL []Url `json:"siteUrl,omitempty"`
+
+	// Publish makes the site available on its `siteUrl`s. Setting it to false removes the routes and the OIDC return URIs of the site,
+	// and the route of its canary, without touching its data. By default, true.
+	// +optional
+	Publish *bool `json:"publish,omitempty"`
 
 	// Version refers to the version and release of the CERN Drupal Distribution that will be deployed to serve this website.
 	// Changing this value triggers the website's update process.
 	// +kubebuilder:validation:Required
 	Version `json:"version"`
 
+	// CanaryVersion deploys a second release of the CERN Drupal Distribution next to the current one, to preview it on `canaryURL`
+	// before updating `version`. The canary serves the same database and files as the site, so only read-only operations are safe on it:
+	// it mounts the files read-only, but it connects to the database with the site's credentials.
+	// Clearing this field removes the canary.
+	// +optional
+	CanaryVersion *Version `json:"canaryVersion,omitempty"`
+
+	// CanaryURL is the URL where the canary version is made available. Required with `canaryVersion`.
+	// +optional
+	CanaryURL Url `json:"canaryURL,omitempty"`
+
 	// Configuration of the DrupalSite for specific needs. A typical default value is given for every setting, so usually these won't need to change.
-	// +kubebuilder:default={"databaseClass":"standard","qosClass":"standard"}
+	// +kubebuilder:default={"databaseClass":"standard"}
 	// +optional
 	Configuration `json:"configuration,omitempty"`
 }
@@ -74,9 +112,9 @@ type Configuration struct {
 	ExtraConfigurationRepo string `json:"extraConfigurationRepo,omitempty"`
 	// TODO: support branches https://gitlab.cern.ch/drupal/paas/drupalsite-operator/-/issues/28
 
-	// QoSClass specifies the website's performance and availability requirements.  The default value is "standard".
+	// QoSClass specifies the website's performance and availability requirements.
+	// The default value is the `defaultQoSClass` of the project's DrupalProjectConfig, or "standard".
 	// +kubebuilder:validation:Enum:=critical;test;standard
-	// +kubebuilder:default=standard
 	// +optional
 	QoSClass `json:"qosClass,omitempty"`
 
@@ -88,21 +126,198 @@ type Configuration struct {
 
 	// CloneFrom initializes this environment by cloning the specified DrupalSite (usually the "live" site),
 	// instead of installing an empty CERN-themed website.
+	// A DrupalSite of another project is given as `namespace/name`; its project must allow clones to this namespace
+	// with `allowCloneTo`, and its volume must be ReadWriteMany or ReadOnlyMany. While the clone job runs, a clone export job
+	// in that project dumps its database and serves it with its files, read-only, to the clone job with rsync on port 8873,
+	// which the network policies of that project must admit from this namespace.
 	// Immutable.
 	// +optional
 	CloneFrom `json:"cloneFrom,omitempty"`
 
+	// CloneStrategy selects how the files of the `cloneFrom` site are copied. "copy" (default) copies them in one shot,
+	// "rsync" streams them, reports the progress on the status and resumes where it stopped if the clone job is retried.
+	// +kubebuilder:validation:Enum:=copy;rsync
+	// +optional
+	CloneStrategy `json:"cloneStrategy,omitempty"`
+
+	// CloneURLRewrite replaces a host in the content of the cloned site after the database is imported,
+	// so that the new site doesn't link back to the `cloneFrom` site.
+	// Only applied if the operator has post-clone URL rewrites enabled.
+	// +optional
+	CloneURLRewrite *URLRewrite `json:"cloneURLRewrite,omitempty"`
+
+	// PriorityClassName sets the scheduling priority of the site's pods, so that important sites can preempt others on a contended cluster.
+	// It has to refer to an existing PriorityClass. By default, critical sites use "openshift-user-critical" and other sites no priority class.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// ImagePullSecret names a Secret of the site's namespace with the credentials to pull the site's images from a private registry.
+	// It's set on the site's pods, its install and clone jobs, and its S2I builds.
+	// While it doesn't exist, the site waits with the `SecretMissing` condition.
+	// +optional
+	ImagePullSecret string `json:"imagePullSecret,omitempty"`
+
+	// SiteBuilderImageOverride pins the sitebuilder image of the site's release to the given image reference (incl. tag or digest),
+	// eg to debug a specific base image without changing the release. It is used verbatim, without mirroring.
+	// +optional
+	SiteBuilderImageOverride string `json:"siteBuilderImageOverride,omitempty"`
+
+	// PhpFpmExporterImageOverride replaces the php-fpm-exporter image of the operator for this site, eg for a staged rollout of a new exporter.
+	// It is used verbatim, without mirroring.
+	// +optional
+	PhpFpmExporterImageOverride string `json:"phpFpmExporterImageOverride,omitempty"`
+
+	// WebDAVImageOverride replaces the webdav image of the operator for this site. It is used verbatim, without mirroring.
+	// +optional
+	WebDAVImageOverride string `json:"webDAVImageOverride,omitempty"`
+
+	// ExtraEnv sets additional environment variables on the php-fpm and cron containers of the site, eg feature flags or third-party API hosts.
+	// The variables that the operator sets itself, like `DRUPAL_SHARED_VOLUME`, can't be overridden.
+	// +optional
+	ExtraEnv []v1.EnvVar `json:"extraEnv,omitempty"`
+
+	// ExtraEnvFromSecrets injects the keys of the given Secrets of the site's namespace as environment variables
+	// of the php-fpm and cron containers and of the install and clone jobs, eg for third-party credentials.
+	// While one of them doesn't exist, the site waits with the `SecretMissing` condition.
+	// +optional
+	ExtraEnvFromSecrets []string `json:"extraEnvFromSecrets,omitempty"`
+
+	// PostInstallCommands are drush commands, eg "drush en -y views", that are run once on the site after it's installed,
+	// eg to enable modules or import configuration. Only some drush subcommands are allowed.
+	// They aren't run in a shell, but their arguments can be quoted like in one, eg "drush cset system.site name 'My Site'".
+	// They run in order, and each one that succeeded is recorded in `status.postInstall` and isn't run again. A failed command is retried
+	// with an exponential backoff. The `PostInstallComplete` condition is set once they all ran.
+	// +optional
+	PostInstallCommands []string `json:"postInstallCommands,omitempty"`
+
+	// InitContainerResources overrides the resource requests/limits of the init containers of the site's install job, which request few resources by default.
+	// The database dump of the clone job keeps its own resources, since it needs memory in proportion to the database.
+	// +optional
+	InitContainerResources *v1.ResourceRequirements `json:"initContainerResources,omitempty"`
+
+	// BuildResources overrides the resource requests/limits of the image builds of the site's `extraConfigurationRepo`,
+	// eg for a composer project that needs more memory to build. They apply to the next build.
+	// +optional
+	BuildResources *v1.ResourceRequirements `json:"buildResources,omitempty"`
+
+	// PhpFpmReadinessProbe overrides the timing of the TCP readiness probe of the php-fpm container
+	// +optional
+	PhpFpmReadinessProbe *ProbeTimings `json:"phpFpmReadinessProbe,omitempty"`
+
+	// PhpFpmLivenessProbe overrides the timing of the liveness probe of the php-fpm container
+	// +optional
+	PhpFpmLivenessProbe *ProbeTimings `json:"phpFpmLivenessProbe,omitempty"`
+
+	// ReplicaAntiAffinity sets how strongly the replicas of a site with more than one replica avoid running on the same node:
+	// "preferred" spreads them when the cluster allows it, "required" doesn't schedule a replica on a node that already runs one.
+	// By default, "preferred". Sites with a single replica have no anti-affinity.
+	// +kubebuilder:validation:Enum:=preferred;required
+	// +optional
+	ReplicaAntiAffinity ReplicaAntiAffinity `json:"replicaAntiAffinity,omitempty"`
+
+	// TrafficRamp shifts the traffic of the site's routes to the pods of a new release gradually during an update,
+	// in proportion to the ready pods of each release, through a Service per release. Enabling it rolls the site out once, to label its pods.
+	// By default, the site's Service balances the traffic over all of its ready pods.
+	// +optional
+	TrafficRamp bool `json:"trafficRamp,omitempty"`
+
+	// AutoUpdate starts the update as soon as `version` changes. If false, the site keeps running its release with an `UpdatePending` condition,
+	// until the update to the new release is approved by annotating the site with `drupal.cern.ch/approve-update=<version.name>-<version.releaseSpec>`.
+	// By default, true.
+	// +optional
+	AutoUpdate *bool `json:"autoUpdate,omitempty"`
+
+	// DeploymentStrategy sets how the pods of the site are replaced on a rollout: "RollingUpdate" with its maxSurge/maxUnavailable, or "Recreate".
+	// By default, a RollingUpdate with 25% maxSurge and maxUnavailable.
+	// +optional
+	DeploymentStrategy *appsv1.DeploymentStrategy `json:"deploymentStrategy,omitempty"`
+
+	// ExtraSettingsConfigMap names a ConfigMap of the site's namespace, whose `settings.local.php` key is included at the end of the site's `settings.php`,
+	// eg to set `$settings['trusted_host_patterns']` without rebuilding the image. The site rolls out when it changes.
+	// +optional
+	ExtraSettingsConfigMap string `json:"extraSettingsConfigMap,omitempty"`
+
+	// ExtraNginxConfig is included in the server block of the site's nginx, eg to add `location` blocks or headers.
+	// The site rolls out when it changes.
+	// +optional
+	ExtraNginxConfig string `json:"extraNginxConfig,omitempty"`
+
+	// RouteTLS configures the TLS termination of the site's routes.
+	// By default, TLS is terminated at the router with its certificate, and HTTP is redirected to HTTPS.
+	// +optional
+	RouteTLS *RouteTLS `json:"routeTLS,omitempty"`
+
+	// InstallJobBackoffLimit sets how many times the install or clone job of the site is retried before it is marked as failed.
+	// By default, the install job is retried 3 times and the clone job 6 times.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InstallJobBackoffLimit *int32 `json:"installJobBackoffLimit,omitempty"`
+
+	// JobTTLSeconds sets how long the install and clone jobs of the site are kept after they finish, before they are deleted.
+	// By default, they are deleted after 1 hour.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	JobTTLSeconds *int32 `json:"jobTTLSeconds,omitempty"`
+
+	// SecondaryDatabaseClass provisions a second DBOD database of this class for the site, eg for reporting or search.
+	// Its credentials are given to the php-fpm container with the `SECONDARY_` prefix, from the Secret `dbcredentials-<site>-secondary`,
+	// and the site is only Ready once both databases are provisioned. By default, the site has a single database.
+	// +kubebuilder:validation:Enum:=critical;ssd;standard
+	// +optional
+	SecondaryDatabaseClass DatabaseClass `json:"secondaryDatabaseClass,omitempty"`
+
 	// DiskSize is the max size of the site's files directory.
 	// +optional
 	// +kubebuilder:validation:Pattern=`^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$`
 	DiskSize string `json:"diskSize,omitempty"`
 
+	// SharedVolumeDirectories are the directories, relative to the shared volume, that are created when the site is installed, eg "config/sync".
+	// They replace the default ones: "files", "private", "modules" and "themes".
+	// +optional
+	SharedVolumeDirectories []string `json:"sharedVolumeDirectories,omitempty"`
+
+	// BackupHookTimeout overrides how long the database dump before a backup may take, eg "3h".
+	// By default it is derived from the DiskSize.
+	// +optional
+	BackupHookTimeout *metav1.Duration `json:"backupHookTimeout,omitempty"`
+
+	// BackupIncludedResources adds resources to the ones included in the scheduled backups of the site, eg "configmaps".
+	// The pods, whose hooks dump the database and whose volumes hold the files, are always included.
+	// Only the objects with the labels of the site's pods (`app: drupal`, `drupalSite: <name>`) are backed up.
+	// +optional
+	BackupIncludedResources []string `json:"backupIncludedResources,omitempty"`
+
+	// BackupExcludedPaths are paths of the site's files, relative to the shared volume, that are left out of the backups.
+	// Only the paths whose files Drupal regenerates can be excluded: "files/css", "files/js" and "files/php".
+	// They are mounted from a separate volume that isn't backed up. The files that the shared volume already holds under them
+	// are hidden by the mount, but still backed up until they're removed.
+	// +optional
+	BackupExcludedPaths []string `json:"backupExcludedPaths,omitempty"`
+
+	// BackupStorageLocation is the name of the velero BackupStorageLocation that the backups of the site are stored in.
+	// By default velero's default location is used.
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	BackupStorageLocation string `json:"backupStorageLocation,omitempty"`
+
+	// ExpiredBackupsHistory keeps the records of up to this many of the most recent expired backups in `status.availableBackups`,
+	// marked as expired, after velero deletes them. By default, expired backups are dropped from the status.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ExpiredBackupsHistory int `json:"expiredBackupsHistory,omitempty"`
+
 	// WebDAVPassword sets the HTTP basic auth password for WebDAV file access.
 	// A default is auto-generated if a value isn't given.
-	// Changing this field updates the password.
+	// Changing this field updates the password, and clearing it generates a new one.
 	// +optional
 	WebDAVPassword string `json:"webDAVPassword,omitempty"`
 
+	// AdminAccount is passed to the install job as `DRUPAL_ADMIN_NAME` and `DRUPAL_ADMIN_PASSWORD`, for sitebuilder images whose install script
+	// creates the administrator account from them. By default, the account "admin" with a random password, stored in the Secret `admin-account-<site>`.
+	// Rejected if the operator has admin accounts disabled.
+	// +optional
+	AdminAccount *AdminAccount `json:"adminAccount,omitempty"`
+
 	// ScheduledBackups [deprecated] when "true" will enable Scheduled Velero backups for the site and when "false" will disable scheduled backups
 	// +kubebuilder:validation:Enum:=enabled;disabled
 	// +kubebuilder:default=enabled
@@ -115,6 +330,75 @@ type Configuration struct {
 	Easystart string `json:"easystart,omitempty"`
 }
 
+// AdminAccount is the administrator account passed to the site installation
+type AdminAccount struct {
+	// Name of the account. By default, "admin".
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.@-]+$`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// PasswordSecret names a Secret of the site's namespace, whose `password` key is the password of the account.
+	// By default, a random password is generated in the Secret `admin-account-<site>`.
+	// +optional
+	PasswordSecret string `json:"passwordSecret,omitempty"`
+}
+
+// ReplicaAntiAffinity sets how strongly the replicas of a site avoid running on the same node
+type ReplicaAntiAffinity string
+
+const (
+	// ReplicaAntiAffinityPreferred spreads the replicas on different nodes when the cluster allows it
+	ReplicaAntiAffinityPreferred ReplicaAntiAffinity = "preferred"
+	// ReplicaAntiAffinityRequired only schedules the replicas on different nodes
+	ReplicaAntiAffinityRequired ReplicaAntiAffinity = "required"
+)
+
+// ProbeTimings overrides the timing of a probe. The fields that aren't set keep their default
+type ProbeTimings struct {
+	// InitialDelaySeconds is how long after the container starts the probe runs for the first time
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// PeriodSeconds is how often the probe runs
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// TimeoutSeconds is how long the probe can take
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// FailureThreshold is how many consecutive failures of the probe fail the container
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// CloneStrategy specifies how the files of a cloned site are copied
+type CloneStrategy string
+
+// URLRewrite specifies a host to replace in the site's content
+type URLRewrite struct {
+	// From is the host to replace, usually the URL of the `cloneFrom` site
+	From Url `json:"from"`
+	// To is the host that replaces it, usually one of the site's own URLs
+	To Url `json:"to"`
+}
+
+// RouteTLS specifies the TLS termination of the site's routes
+type RouteTLS struct {
+	// Termination is "edge" (default) to terminate TLS at the router, "reencrypt" to terminate it at the router
+	// and encrypt again towards the pods, or "passthrough" to terminate it at the pods.
+	// +kubebuilder:validation:Enum:=edge;reencrypt;passthrough
+	// +optional
+	Termination string `json:"termination,omitempty"`
+	// CertificateSecret is the name of a Secret in the site's namespace with the certificate ("tls.crt"), its key ("tls.key")
+	// and optionally the CA certificate ("ca.crt") to serve instead of the router's certificate.
+	// For "reencrypt", the CA certificate that validates the pods can be given as "destination-ca.crt".
+	// Ignored for "passthrough".
+	// +optional
+	CertificateSecret string `json:"certificateSecret,omitempty"`
+}
+
 // QoSClass specifies the website's performance and availability requirements
 type QoSClass string
 
@@ -128,6 +412,14 @@ type CloneFrom string
 // +kubebuilder:validation:Pattern=`[(http(s)?):\/\/(www\.)?a-zA-Z0-9@:%._\+~#=]{2,256}\.[a-z]{2,6}\b([-a-zA-Z0-9@:%_\+.~#?&//=]*)`
 type Url string
 
+// UpdateStep is the stage of the update process that the DrupalSite is going through
+// +kubebuilder:validation:Enum:=RollingOutCode;ClearingCache;BackingUpDB;RunningUpdb;RollingBack
+type UpdateStep string
+
+// SiteState summarizes the conditions of a DrupalSite
+// +kubebuilder:validation:Enum:=Installing;Ready;NotReady;Updating;Error;Blocked
+type SiteState string
+
 // DrupalSiteStatus defines the observed state of DrupalSite
 type DrupalSiteStatus struct {
 	// Conditions specifies different conditions based on the DrupalSite status
@@ -135,10 +427,29 @@ type DrupalSiteStatus struct {
 	// +optional
 	Conditions status.Conditions `json:"conditions,omitempty"`
 
+	// State summarizes the conditions, for external tooling. It is derived from them and `updateStep` every time the status is updated:
+	// "Blocked" if the site is blocked, else "Error" if it has an error or a failed update, else "Installing" until it's initialized,
+	// else "Updating" during an update, else "Ready" or "NotReady"
+	// +optional
+	State SiteState `json:"state,omitempty"`
+
 	// ReleaseID reports the actual release of CERN Drupal Distribution that is being used in the deployment.
 	// +optional
 	ReleaseID `json:"releaseID,omitempty"`
 
+	// CurrentRelease is the release of the CERN Drupal Distribution that the site runs, or is being updated to: `<version.name>-<version.releaseSpec>`.
+	// It mirrors `releaseID.current`, and is part of the stable status for external tooling, like `failsafeRelease` and `updateInProgress`.
+	// +optional
+	CurrentRelease string `json:"currentRelease,omitempty"`
+
+	// FailsafeRelease is the last release that the site ran successfully, which a failed update rolls back to. It mirrors `releaseID.failsafe`.
+	// +optional
+	FailsafeRelease string `json:"failsafeRelease,omitempty"`
+
+	// UpdateInProgress states if an update of the site to `currentRelease` is running
+	// +optional
+	UpdateInProgress bool `json:"updateInProgress,omitempty"`
+
 	// ServingPodImage reports the complete image name of the PHP-FPM container that is being used in the deployment.
 	// +optional
 	ServingPodImage string `json:"servingPodImage,omitempty"`
@@ -147,6 +458,10 @@ type DrupalSiteStatus struct {
 	// +optional
 	AvailableBackups []Backup `json:"availableBackups,omitempty"`
 
+	// LastBackupTime reports when the most recent successful backup of the site completed
+	// +optional
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+
 	// ExpectedDeploymentReplicas specifies the deployment replicas for the current DrupalSite
 	// +optional
 	ExpectedDeploymentReplicas *int32 `json:"expectedDeploymentReplicas,omitempty"`
@@ -159,6 +474,56 @@ type DrupalSiteStatus struct {
 	// IsPrimary states if the Drupalsite is the main instance of the project
 	// +kubebuilder:default=false
 	IsPrimary bool `json:"isPrimary,omitempty"`
+
+	// EffectiveResources reports the resource requests/limits that each container of the site's deployment gets,
+	// after applying the QoS class defaults and any overrides
+	// +optional
+	EffectiveResources map[string]v1.ResourceRequirements `json:"effectiveResources,omitempty"`
+
+	// CloneProgress reports the percentage of the files copied by a clone with the "rsync" cloneStrategy
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	CloneProgress int32 `json:"cloneProgress,omitempty"`
+
+	// InstallFailedGeneration is the generation of the spec whose install job failed.
+	// The install job isn't recreated until the spec changes.
+	// +optional
+	InstallFailedGeneration int64 `json:"installFailedGeneration,omitempty"`
+
+	// EnsuredResourcesHash is the hash of what the resources of the site depend on, when they were last ensured.
+	// Reconciliations of a steady site with the same hash skip ensuring them.
+	// +optional
+	EnsuredResourcesHash string `json:"ensuredResourcesHash,omitempty"`
+
+	// SiteURLs reports the Route that serves each of the URLs in `spec.siteUrl`, and whether it has been admitted by the router
+	// +optional
+	SiteURLs []URLStatus `json:"siteURLs,omitempty"`
+
+	// DrupalCoreVersion reports the Drupal core version that is actually running on the site, if the operator checks it
+	// +optional
+	DrupalCoreVersion *DrupalCoreVersion `json:"drupalCoreVersion,omitempty"`
+
+	// LastDrushOutput reports the output of the last drush command that was run through the "drupal.cern.ch/run-drush" annotation
+	// +optional
+	LastDrushOutput string `json:"lastDrushOutput,omitempty"`
+
+	// PendingDBUpdates reports how many database updates `drush updb` has to run on the site, when they were last checked
+	// +optional
+	PendingDBUpdates int `json:"pendingDBUpdates,omitempty"`
+
+	// UpdateStep reports the step of the update process that is currently running, or the step where the last update stopped.
+	// It is cleared once the update completes.
+	// +optional
+	UpdateStep UpdateStep `json:"updateStep,omitempty"`
+
+	// DatabaseInstance reports the DBOD instance that the DBOD operator assigned to the site's database
+	// +optional
+	DatabaseInstance string `json:"databaseInstance,omitempty"`
+
+	// PostInstall reports the progress of the `postInstallCommands` of the spec
+	// +optional
+	PostInstall *PostInstallProgress `json:"postInstall,omitempty"`
 }
 
 // ReleaseID reports the actual release of CERN Drupal Distribution that is being used in the deployment.
@@ -173,6 +538,41 @@ type ReleaseID struct {
 	Failsafe string `json:"failsafe,omitempty"`
 }
 
+// DrupalCoreVersion reports the Drupal core version running on the site, as seen in the Drupal admin UI
+type DrupalCoreVersion struct {
+	// Version is the Drupal core version, eg "9.3.3"
+	Version string `json:"version,omitempty"`
+	// ReleaseID is the releaseID that was running when the version was checked
+	ReleaseID string `json:"releaseID,omitempty"`
+	// CheckTime is when the version was last checked
+	CheckTime metav1.Time `json:"checkTime,omitempty"`
+}
+
+// PostInstallProgress reports which of the `postInstallCommands` ran, and the failures of the next one
+type PostInstallProgress struct {
+	// Completed is the number of the `postInstallCommands` that succeeded, in order
+	// +optional
+	Completed int `json:"completed,omitempty"`
+	// Failures counts the consecutive failures of the next command
+	// +optional
+	Failures int `json:"failures,omitempty"`
+	// LastFailureTime is when the next command last failed. It's retried after a delay that doubles with each failure
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+}
+
+// URLStatus represents the state of the Route of one of the site's URLs
+type URLStatus struct {
+	// URL is the site URL that the Route serves
+	URL Url `json:"url"`
+
+	// RouteName is the name of the Route that was generated for the URL
+	RouteName string `json:"routeName"`
+
+	// Admitted is true when the Route has been admitted by the router, i.e. the URL is live
+	Admitted bool `json:"admitted"`
+}
+
 // Backup item represents information of a single velero 'Backup' object
 type Backup struct {
 	// BackupName represents the name of a given velero 'Backup' resource
@@ -190,6 +590,10 @@ type Backup struct {
 	// DrupalSiteName represents the name of the drupalSite for the given velero 'Backup' resource
 	// +optional
 	DrupalSiteName string `json:"drupalSiteName,omitempty"`
+
+	// Expired is set when the velero 'Backup' resource has been deleted, and only its record is kept. It can't be restored
+	// +optional
+	Expired bool `json:"expired,omitempty"`
 }
 
 // +kubebuilder:object:root=true
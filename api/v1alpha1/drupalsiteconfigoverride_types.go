@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -34,7 +35,25 @@ type DrupalSiteConfigOverrideSpec struct {
 	// Cron includes configuration for the Cron container of the DrupalSite server pods
 	Cron Resources `json:"cron,omitempty"`
 	// DrupalLogs includes configuration for the DrupalLogs container of the DrupalSite server pods
-	DrupalLogs Resources `json:"drupallogs,omitempty"`
+	DrupalLogs DrupalLogs `json:"drupallogs,omitempty"`
+}
+
+// DrupalLogs includes configuration for the DrupalLogs container of the DrupalSite server pods
+type DrupalLogs struct {
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Rotation adds a log-rotation sidecar to the server pods, which rotates the Drupal and php-fpm log files of their shared emptyDir volume.
+	// +optional
+	Rotation *LogRotation `json:"rotation,omitempty"`
+}
+
+// LogRotation bounds the size and the age of the log files
+type LogRotation struct {
+	// MaxSize rotates a log file once it's larger than this size, eg "50Mi". Defaults to "100Mi"
+	// +optional
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+	// Retention deletes the rotated log files once they are older than this duration, eg "72h". Defaults to "24h"
+	// +optional
+	Retention *metav1.Duration `json:"retention,omitempty"`
 }
 
 type Resources struct {
@@ -22,9 +22,27 @@ package v1alpha1
 
 import (
 	"github.com/operator-framework/operator-lib/status"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdminAccount) DeepCopyInto(out *AdminAccount) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminAccount.
+func (in *AdminAccount) DeepCopy() *AdminAccount {
+	if in == nil {
+		return nil
+	}
+	out := new(AdminAccount)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Backup) DeepCopyInto(out *Backup) {
 	*out = *in
@@ -51,6 +69,98 @@ func (in *Backup) DeepCopy() *Backup {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Configuration) DeepCopyInto(out *Configuration) {
 	*out = *in
+	if in.CloneURLRewrite != nil {
+		in, out := &in.CloneURLRewrite, &out.CloneURLRewrite
+		*out = new(URLRewrite)
+		**out = **in
+	}
+	if in.ExtraEnv != nil {
+		in, out := &in.ExtraEnv, &out.ExtraEnv
+		*out = make([]v1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraEnvFromSecrets != nil {
+		in, out := &in.ExtraEnvFromSecrets, &out.ExtraEnvFromSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PostInstallCommands != nil {
+		in, out := &in.PostInstallCommands, &out.PostInstallCommands
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.InitContainerResources != nil {
+		in, out := &in.InitContainerResources, &out.InitContainerResources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BuildResources != nil {
+		in, out := &in.BuildResources, &out.BuildResources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PhpFpmReadinessProbe != nil {
+		in, out := &in.PhpFpmReadinessProbe, &out.PhpFpmReadinessProbe
+		*out = new(ProbeTimings)
+		**out = **in
+	}
+	if in.PhpFpmLivenessProbe != nil {
+		in, out := &in.PhpFpmLivenessProbe, &out.PhpFpmLivenessProbe
+		*out = new(ProbeTimings)
+		**out = **in
+	}
+	if in.AutoUpdate != nil {
+		in, out := &in.AutoUpdate, &out.AutoUpdate
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DeploymentStrategy != nil {
+		in, out := &in.DeploymentStrategy, &out.DeploymentStrategy
+		*out = new(appsv1.DeploymentStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RouteTLS != nil {
+		in, out := &in.RouteTLS, &out.RouteTLS
+		*out = new(RouteTLS)
+		**out = **in
+	}
+	if in.InstallJobBackoffLimit != nil {
+		in, out := &in.InstallJobBackoffLimit, &out.InstallJobBackoffLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.JobTTLSeconds != nil {
+		in, out := &in.JobTTLSeconds, &out.JobTTLSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SharedVolumeDirectories != nil {
+		in, out := &in.SharedVolumeDirectories, &out.SharedVolumeDirectories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BackupHookTimeout != nil {
+		in, out := &in.BackupHookTimeout, &out.BackupHookTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.BackupIncludedResources != nil {
+		in, out := &in.BackupIncludedResources, &out.BackupIncludedResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BackupExcludedPaths != nil {
+		in, out := &in.BackupExcludedPaths, &out.BackupExcludedPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdminAccount != nil {
+		in, out := &in.AdminAccount, &out.AdminAccount
+		*out = new(AdminAccount)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Configuration.
@@ -63,12 +173,49 @@ func (in *Configuration) DeepCopy() *Configuration {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrupalCoreVersion) DeepCopyInto(out *DrupalCoreVersion) {
+	*out = *in
+	in.CheckTime.DeepCopyInto(&out.CheckTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrupalCoreVersion.
+func (in *DrupalCoreVersion) DeepCopy() *DrupalCoreVersion {
+	if in == nil {
+		return nil
+	}
+	out := new(DrupalCoreVersion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrupalLogs) DeepCopyInto(out *DrupalLogs) {
+	*out = *in
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.Rotation != nil {
+		in, out := &in.Rotation, &out.Rotation
+		*out = new(LogRotation)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrupalLogs.
+func (in *DrupalLogs) DeepCopy() *DrupalLogs {
+	if in == nil {
+		return nil
+	}
+	out := new(DrupalLogs)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DrupalProjectConfig) DeepCopyInto(out *DrupalProjectConfig) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 }
 
@@ -125,6 +272,11 @@ func (in *DrupalProjectConfigList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DrupalProjectConfigSpec) DeepCopyInto(out *DrupalProjectConfigSpec) {
 	*out = *in
+	if in.AllowCloneTo != nil {
+		in, out := &in.AllowCloneTo, &out.AllowCloneTo
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrupalProjectConfigSpec.
@@ -314,8 +466,18 @@ func (in *DrupalSiteSpec) DeepCopyInto(out *DrupalSiteSpec) {
 		*out = make([]Url, len(*in))
 		copy(*out, *in)
 	}
+	if in.Publish != nil {
+		in, out := &in.Publish, &out.Publish
+		*out = new(bool)
+		**out = **in
+	}
 	out.Version = in.Version
-	out.Configuration = in.Configuration
+	if in.CanaryVersion != nil {
+		in, out := &in.CanaryVersion, &out.CanaryVersion
+		*out = new(Version)
+		**out = **in
+	}
+	in.Configuration.DeepCopyInto(&out.Configuration)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrupalSiteSpec.
@@ -346,11 +508,37 @@ func (in *DrupalSiteStatus) DeepCopyInto(out *DrupalSiteStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastBackupTime != nil {
+		in, out := &in.LastBackupTime, &out.LastBackupTime
+		*out = (*in).DeepCopy()
+	}
 	if in.ExpectedDeploymentReplicas != nil {
 		in, out := &in.ExpectedDeploymentReplicas, &out.ExpectedDeploymentReplicas
 		*out = new(int32)
 		**out = **in
 	}
+	if in.EffectiveResources != nil {
+		in, out := &in.EffectiveResources, &out.EffectiveResources
+		*out = make(map[string]v1.ResourceRequirements, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.SiteURLs != nil {
+		in, out := &in.SiteURLs, &out.SiteURLs
+		*out = make([]URLStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.DrupalCoreVersion != nil {
+		in, out := &in.DrupalCoreVersion, &out.DrupalCoreVersion
+		*out = new(DrupalCoreVersion)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PostInstall != nil {
+		in, out := &in.PostInstall, &out.PostInstall
+		*out = new(PostInstallProgress)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrupalSiteStatus.
@@ -379,6 +567,65 @@ func (in *DrupalVersion) DeepCopy() *DrupalVersion {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogRotation) DeepCopyInto(out *LogRotation) {
+	*out = *in
+	if in.MaxSize != nil {
+		in, out := &in.MaxSize, &out.MaxSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogRotation.
+func (in *LogRotation) DeepCopy() *LogRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(LogRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PostInstallProgress) DeepCopyInto(out *PostInstallProgress) {
+	*out = *in
+	if in.LastFailureTime != nil {
+		in, out := &in.LastFailureTime, &out.LastFailureTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PostInstallProgress.
+func (in *PostInstallProgress) DeepCopy() *PostInstallProgress {
+	if in == nil {
+		return nil
+	}
+	out := new(PostInstallProgress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbeTimings) DeepCopyInto(out *ProbeTimings) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbeTimings.
+func (in *ProbeTimings) DeepCopy() *ProbeTimings {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeTimings)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ReleaseID) DeepCopyInto(out *ReleaseID) {
 	*out = *in
@@ -425,6 +672,21 @@ func (in *Resources) DeepCopy() *Resources {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouteTLS) DeepCopyInto(out *RouteTLS) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouteTLS.
+func (in *RouteTLS) DeepCopy() *RouteTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(RouteTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SupportedDrupalVersions) DeepCopyInto(out *SupportedDrupalVersions) {
 	*out = *in
@@ -524,6 +786,36 @@ func (in *SupportedDrupalVersionsStatus) DeepCopy() *SupportedDrupalVersionsStat
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *URLRewrite) DeepCopyInto(out *URLRewrite) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new URLRewrite.
+func (in *URLRewrite) DeepCopy() *URLRewrite {
+	if in == nil {
+		return nil
+	}
+	out := new(URLRewrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *URLStatus) DeepCopyInto(out *URLStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new URLStatus.
+func (in *URLStatus) DeepCopy() *URLStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(URLStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Version) DeepCopyInto(out *Version) {
 	*out = *in
@@ -0,0 +1,371 @@
+/*
+Copyright 2021 CERN.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	webservicesv1a1 "gitlab.cern.ch/drupal/paas/drupalsite-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// cloneExportPort is the port of the rsync daemon of the clone export job
+	cloneExportPort = 8873
+	// cloneExportDirectory is where the clone export job dumps the database of the cloneFrom DrupalSite
+	cloneExportDirectory = "/var/empty-run/"
+	// cloneExportDeadline bounds how long a clone export job runs, in case the clone job never finishes
+	cloneExportDeadline = 24 * time.Hour
+)
+
+// cloneSourceKey returns the namespace and name of the cloneFrom DrupalSite, given as `name` or `namespace/name`
+func cloneSourceKey(d *webservicesv1a1.DrupalSite) types.NamespacedName {
+	cloneFrom := string(d.Spec.Configuration.CloneFrom)
+	if i := strings.Index(cloneFrom, "/"); i >= 0 {
+		return types.NamespacedName{Namespace: cloneFrom[:i], Name: cloneFrom[i+1:]}
+	}
+	return types.NamespacedName{Namespace: d.Namespace, Name: cloneFrom}
+}
+
+// crossNamespaceClone checks if the cloneFrom DrupalSite belongs to another project.
+// The files and the database of a cloneFrom DrupalSite of another project never leave its namespace through volumes or credentials:
+// a clone export job in that namespace dumps the database with the site's own credentials, mounts its volume read-only,
+// and serves both through an authenticated, read-only rsync daemon that the clone job pulls from.
+func crossNamespaceClone(d *webservicesv1a1.DrupalSite) bool {
+	return d.Spec.Configuration.CloneFrom != "" && cloneSourceKey(d).Namespace != d.Namespace
+}
+
+// cloneSourceClaimName is the PVC that the clone job mounts the files of a cloneFrom DrupalSite of the same namespace from
+func cloneSourceClaimName(d *webservicesv1a1.DrupalSite) string {
+	return "pv-claim-" + cloneSourceKey(d).Name
+}
+
+// cloneSourceDBSecretName is the secret that the clone job reads the DB credentials of a cloneFrom DrupalSite of the same namespace from
+func cloneSourceDBSecretName(d *webservicesv1a1.DrupalSite) string {
+	return "dbcredentials-" + cloneSourceKey(d).Name
+}
+
+// cloneSourceVolumeName is the name of the clone job's volume with the files of a cloneFrom DrupalSite of the same namespace
+func cloneSourceVolumeName(d *webservicesv1a1.DrupalSite) string {
+	return "drupal-directory-" + cloneSourceKey(d).Name
+}
+
+// cloneExportName is the name of the job, service and secret that export a cloneFrom DrupalSite of another project, in its namespace.
+// It's named after a hash of the site's namespace and name, since eg `a-b/c` and `a/b-c` can't be told apart once joined with a dash.
+func cloneExportName(d *webservicesv1a1.DrupalSite) string {
+	hash := sha256.Sum256([]byte(d.Namespace + "/" + d.Name))
+	return "clone-export-" + hex.EncodeToString(hash[:16])
+}
+
+// cloneExportHost is the address of the rsync daemon of the clone export job
+func cloneExportHost(d *webservicesv1a1.DrupalSite) string {
+	return cloneExportName(d) + "." + cloneSourceKey(d).Namespace + ".svc"
+}
+
+// cloneSourceSecretName is the secret of the site's namespace with the password of the clone export job, as `RSYNC_PASSWORD`
+func cloneSourceSecretName(d *webservicesv1a1.DrupalSite) string {
+	return "clone-source-" + d.Name
+}
+
+// cloneExportLabels are the labels of the objects that export a cloneFrom DrupalSite of another project.
+// They live in another namespace than the site, so they can't be owned by it.
+func cloneExportLabels(d *webservicesv1a1.DrupalSite) map[string]string {
+	return map[string]string{"app": "clone-export", "cloneExport": cloneExportName(d)}
+}
+
+// validateCloneSource fetches the cloneFrom DrupalSite. A DrupalSite of another project can only be cloned if that project
+// lists the site's namespace in the `allowCloneTo` of its DrupalProjectConfig.
+func (r *DrupalSiteReconciler) validateCloneSource(ctx context.Context, d *webservicesv1a1.DrupalSite) (*webservicesv1a1.DrupalSite, reconcileError) {
+	key := cloneSourceKey(d)
+	if key.Namespace == "" || key.Name == "" {
+		return nil, newApplicationError(fmt.Errorf("CloneFrom must be `name` or `namespace/name`"), ErrInvalidSpec)
+	}
+	if key.Namespace != d.Namespace && WatchNamespace != "" {
+		return nil, newApplicationError(fmt.Errorf("CloneFrom DrupalSite of another namespace can't be cloned while the operator only watches %s", WatchNamespace), ErrInvalidSpec)
+	}
+	sourceSite := &webservicesv1a1.DrupalSite{}
+	err := r.Get(ctx, key, sourceSite)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil, newApplicationError(fmt.Errorf("CloneFrom DrupalSite doesn't exist"), ErrInvalidSpec)
+	case err != nil:
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	if key.Namespace == d.Namespace {
+		return sourceSite, nil
+	}
+	sourceProject, reconcileErr := r.GetDrupalProjectConfig(ctx, sourceSite)
+	if reconcileErr != nil {
+		return nil, reconcileErr
+	}
+	if sourceProject != nil {
+		for _, namespace := range sourceProject.Spec.AllowCloneTo {
+			if namespace == d.Namespace {
+				return sourceSite, nil
+			}
+		}
+	}
+	return nil, newApplicationError(fmt.Errorf("CloneFrom DrupalSite %s doesn't allow clones to namespace %s", key, d.Namespace), ErrInvalidSpec)
+}
+
+// ensureCloneSourceExport exports a cloneFrom DrupalSite of another project to the site's clone job, until the clone job finishes.
+// In the namespace of the cloneFrom DrupalSite, it runs a clone export job with a service in front of it, and the password of its rsync daemon.
+// The same password is given to the clone job through a secret of the site's namespace. Neither the DB credentials nor the volume
+// of the cloneFrom DrupalSite are shared with the site's project, which only gets read access to the export while it runs.
+func (r *DrupalSiteReconciler) ensureCloneSourceExport(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	key := cloneSourceKey(d)
+	sourceSite := &webservicesv1a1.DrupalSite{}
+	if err := r.Get(ctx, key, sourceSite); err != nil {
+		return newApplicationError(fmt.Errorf("cloneFrom DrupalSite: %w", err), ErrClientK8s)
+	}
+	// The clone export job mounts the volume of the cloneFrom DrupalSite next to its running pods
+	sourceClaim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pv-claim-" + key.Name, Namespace: key.Namespace}, sourceClaim); err != nil {
+		return newApplicationError(fmt.Errorf("PVC of the cloneFrom DrupalSite: %w", err), ErrClientK8s)
+	}
+	multiNode := false
+	for _, mode := range sourceClaim.Spec.AccessModes {
+		multiNode = multiNode || mode == corev1.ReadWriteMany || mode == corev1.ReadOnlyMany
+	}
+	if !multiNode {
+		return newApplicationError(fmt.Errorf("the volume of the cloneFrom DrupalSite of another namespace must be ReadWriteMany or ReadOnlyMany"), ErrInvalidSpec)
+	}
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "clone"
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: cloneSourceSecretName(d), Namespace: d.Namespace}}
+	_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		addOwnerRefToObject(secret, asOwner(d))
+		secret.Labels = ls
+		if len(secret.Data["RSYNC_PASSWORD"]) == 0 {
+			secret.Data = map[string][]byte{"RSYNC_PASSWORD": []byte(generateRandomPassword())}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", secret.TypeMeta.Kind, "Resource.Namespace", secret.Namespace, "Resource.Name", secret.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+
+	exportSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: cloneExportName(d), Namespace: key.Namespace}}
+	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, exportSecret, func() error {
+		exportSecret.Labels = cloneExportLabels(d)
+		exportSecret.Data = map[string][]byte{"RSYNC_PASSWORD": secret.Data["RSYNC_PASSWORD"]}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", exportSecret.TypeMeta.Kind, "Resource.Namespace", exportSecret.Namespace, "Resource.Name", exportSecret.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: cloneExportName(d), Namespace: key.Namespace}}
+	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, job, func() error {
+		return jobForCloneExport(job, d, sourceSite)
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", job.TypeMeta.Kind, "Resource.Namespace", job.Namespace, "Resource.Name", job.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: cloneExportName(d), Namespace: key.Namespace}}
+	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, service, func() error {
+		service.Labels = cloneExportLabels(d)
+		service.Spec.Selector = cloneExportLabels(d)
+		service.Spec.Ports = []corev1.ServicePort{{
+			Name:       "rsync",
+			Port:       cloneExportPort,
+			TargetPort: intstr.FromInt(cloneExportPort),
+			Protocol:   corev1.ProtocolTCP,
+		}}
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", service.TypeMeta.Kind, "Resource.Namespace", service.Namespace, "Resource.Name", service.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// jobForCloneExport returns the clone export job of a cloneFrom DrupalSite of another project, in the namespace of the cloneFrom DrupalSite.
+// Its init container dumps the database with the DB credentials of the cloneFrom DrupalSite, then an rsync daemon serves the dump and
+// the volume of the cloneFrom DrupalSite, mounted read-only, until the clone job of the site is finished and the export is deleted.
+func jobForCloneExport(currentobject *batchv1.Job, d *webservicesv1a1.DrupalSite, sourceSite *webservicesv1a1.DrupalSite) error {
+	if !currentobject.CreationTimestamp.IsZero() {
+		return nil
+	}
+	ls := cloneExportLabels(d)
+	currentobject.Labels = ls
+	currentobject.Spec.Template.ObjectMeta = metav1.ObjectMeta{Labels: ls}
+	currentobject.Spec.BackoffLimit = pointer.Int32Ptr(3)
+	currentobject.Spec.ActiveDeadlineSeconds = pointer.Int64Ptr(int64(cloneExportDeadline.Seconds()))
+	srcDBBackupResources, err := reqLimDict("src-db-backup", sourceSite.Spec.QoSClass)
+	if err != nil {
+		return err
+	}
+	image := sitebuilderImageRefToUse(sourceSite, releaseID(sourceSite)).Name
+	currentobject.Spec.Template.Spec = corev1.PodSpec{
+		InitContainers: []corev1.Container{{
+			Image:           image,
+			Name:            "src-db-backup",
+			ImagePullPolicy: "Always",
+			Command:         takeBackup(cloneExportDirectory + "dbBackUp.sql"),
+			Resources:       srcDBBackupResources,
+			Env: []corev1.EnvVar{{
+				Name:  "DRUPAL_SHARED_VOLUME",
+				Value: "/drupal-data",
+			}},
+			EnvFrom: []corev1.EnvFromSource{{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: databaseSecretName(sourceSite)},
+				},
+			}},
+			VolumeMounts: []corev1.VolumeMount{{
+				Name:      "tmp-dir",
+				MountPath: cloneExportDirectory,
+			}},
+		}},
+		RestartPolicy:     "Never",
+		PriorityClassName: priorityClassName(sourceSite),
+		ImagePullSecrets:  imagePullSecrets(sourceSite),
+		Containers: []corev1.Container{{
+			Image:           image,
+			Name:            "rsync-daemon",
+			ImagePullPolicy: "Always",
+			Command:         rsyncDaemonCommand(),
+			Ports: []corev1.ContainerPort{{
+				ContainerPort: cloneExportPort,
+				Name:          "rsync",
+				Protocol:      corev1.ProtocolTCP,
+			}},
+			Env: []corev1.EnvVar{{
+				Name: "RSYNC_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cloneExportName(d)},
+					Key:                  "RSYNC_PASSWORD",
+				}},
+			}},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "drupal-directory-" + sourceSite.Name,
+					MountPath: "/drupal-data",
+					ReadOnly:  true,
+				},
+				{
+					Name:      "tmp-dir",
+					MountPath: cloneExportDirectory,
+					ReadOnly:  true,
+				},
+				{
+					Name:      "rsyncd",
+					MountPath: "/var/rsyncd/",
+				},
+			},
+		}},
+		Volumes: []corev1.Volume{
+			{
+				Name: "drupal-directory-" + sourceSite.Name,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: "pv-claim-" + sourceSite.Name,
+						ReadOnly:  true,
+					},
+				},
+			},
+			{
+				Name:         "tmp-dir",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			},
+			{
+				Name:         "rsyncd",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			},
+		},
+	}
+	return nil
+}
+
+// rsyncDaemonCommand outputs the command of the rsync daemon of the clone export job. It serves the files of the cloneFrom DrupalSite
+// as the "files" module and its database dump as the "database" module, both read-only, to the "clone" user with the `RSYNC_PASSWORD`.
+func rsyncDaemonCommand() []string {
+	config := strings.Join([]string{
+		"pid file = /var/rsyncd/rsyncd.pid",
+		"use chroot = false",
+		"read only = true",
+		"auth users = clone",
+		"secrets file = /var/rsyncd/secrets",
+		"[files]",
+		"path = /drupal-data",
+		"[database]",
+		"path = " + cloneExportDirectory,
+	}, "\\n")
+	return []string{"sh", "-c", "printf 'clone:%s\\n' \"$RSYNC_PASSWORD\" > /var/rsyncd/secrets && chmod 600 /var/rsyncd/secrets" +
+		" && printf '" + config + "\\n' > /var/rsyncd/rsyncd.conf" +
+		" && exec rsync --daemon --no-detach --config=/var/rsyncd/rsyncd.conf --port=" + strconv.Itoa(cloneExportPort)}
+}
+
+// cloneFromExport outputs the command of the clone job that pulls a cloneFrom DrupalSite of another project from its clone export job.
+// It waits until the rsync daemon serves, ie once the database was dumped, then copies the files like the "rsync" clone strategy.
+func cloneFromExport(d *webservicesv1a1.DrupalSite, filepath string, progressFile string) []string {
+	export := "rsync://clone@" + cloneExportHost(d) + ":" + strconv.Itoa(cloneExportPort)
+	return []string{"sh", "-c", "until rsync " + export + "/ > /dev/null 2>&1; do sleep 10; done" +
+		" && rsync " + export + "/database/dbBackUp.sql " + filepath +
+		" && rsync --archive --partial --no-inc-recursive --info=progress2 " + export + "/files/ /drupal-data/ > " + progressFile +
+		" && " + strings.Join(restoreBackup(filepath), " ")}
+}
+
+// ensureNoCloneSourceExport deletes the export of a cloneFrom DrupalSite of another project, once the clone job has finished or the site is deleted
+func (r *DrupalSiteReconciler) ensureNoCloneSourceExport(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	// The secret of the site's namespace is deleted last, so that its absence means that the whole export is gone
+	err := r.Get(ctx, types.NamespacedName{Name: cloneSourceSecretName(d), Namespace: d.Namespace}, &corev1.Secret{})
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	}
+	sourceNamespace := cloneSourceKey(d).Namespace
+	for _, obj := range []client.Object{
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: cloneExportName(d), Namespace: sourceNamespace}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: cloneExportName(d), Namespace: sourceNamespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: cloneExportName(d), Namespace: sourceNamespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: cloneSourceSecretName(d), Namespace: d.Namespace}},
+	} {
+		if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			if k8sapierrors.IsNotFound(err) {
+				continue
+			}
+			return newApplicationError(err, ErrClientK8s)
+		}
+		log.V(3).Info("Deleted the export of the cloneFrom DrupalSite", "Resource.Namespace", obj.GetNamespace(), "Resource.Name", obj.GetName())
+	}
+	return nil
+}
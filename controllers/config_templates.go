@@ -0,0 +1,267 @@
+/*
+Copyright 2021 CERN.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	webservicesv1a1 "gitlab.cern.ch/drupal/paas/drupalsite-operator/api/v1alpha1"
+)
+
+// RuntimeConfigDir is the directory where the configuration templates for each QoS class are mounted
+const RuntimeConfigDir = "/tmp/runtime-config"
+
+// qosClasses are the QoS classes with a configuration template directory, as enumerated by ValidateQoSConfigTemplates at startup
+var qosClasses map[webservicesv1a1.QoSClass]bool
+
+// ValidateQoSConfigTemplates checks the php-fpm and nginx configuration templates of every QoS class in the given directory.
+// It performs the checks that `php-fpm -t` and `nginx -t` would fail on, so that a broken template is caught
+// before it rolls out to the sites. The QoS classes found are the ones that `validateSpec` accepts.
+func ValidateQoSConfigTemplates(dir string) error {
+	found, err := validateQoSConfigTemplateDirs(dir)
+	if err != nil {
+		return err
+	}
+	qosClasses = found
+	return nil
+}
+
+// QoSConfigTemplatesCheck returns a check that validates the configuration templates in the given directory again on each request,
+// eg after their ConfigMaps were edited, without changing the QoS classes accepted since startup.
+// The manager serves it as a dry run of the templates, at `/readyz/<name of the check>` of the health probe address.
+func QoSConfigTemplatesCheck(dir string) func(req *http.Request) error {
+	return func(_ *http.Request) error {
+		_, err := validateQoSConfigTemplateDirs(dir)
+		return err
+	}
+}
+
+// validateQoSConfigTemplateDirs checks the templates of every QoS class in the given directory, and returns the QoS classes found
+func validateQoSConfigTemplateDirs(dir string) (map[webservicesv1a1.QoSClass]bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	found := map[webservicesv1a1.QoSClass]bool{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "qos-") {
+			continue
+		}
+		qosClass := webservicesv1a1.QoSClass(strings.TrimPrefix(entry.Name(), "qos-"))
+		qosDir := filepath.Join(dir, entry.Name())
+		content, err := ioutil.ReadFile(filepath.Join(qosDir, "php-fpm.conf"))
+		if err != nil {
+			return nil, err
+		}
+		if err := validatePHPFPMConfig(string(content)); err != nil {
+			return nil, fmt.Errorf("QoS class %s: php-fpm.conf: %w", qosClass, err)
+		}
+		content, err = ioutil.ReadFile(filepath.Join(qosDir, "nginx-global.conf"))
+		if err != nil {
+			return nil, err
+		}
+		if err := validateNginxConfig(string(content)); err != nil {
+			return nil, fmt.Errorf("QoS class %s: nginx-global.conf: %w", qosClass, err)
+		}
+		found[qosClass] = true
+	}
+	// The sites without a QoS class are defaulted to the standard one
+	if !found[webservicesv1a1.QoSStandard] {
+		return nil, fmt.Errorf("QoS class %s: missing template directory %s", webservicesv1a1.QoSStandard, filepath.Join(dir, "qos-"+string(webservicesv1a1.QoSStandard)))
+	}
+	return found, nil
+}
+
+// validateQoSClass checks that the QoS class has a configuration template directory
+func validateQoSClass(qosClass webservicesv1a1.QoSClass) error {
+	if qosClass == "" || qosClasses == nil || qosClasses[qosClass] {
+		return nil
+	}
+	available := []string{}
+	for class := range qosClasses {
+		available = append(available, string(class))
+	}
+	sort.Strings(available)
+	return fmt.Errorf("qosClass %q has no configuration templates, available: %s", qosClass, strings.Join(available, ", "))
+}
+
+// validatePHPFPMConfig checks the INI syntax of a php-fpm pool configuration and the consistency of the process manager settings
+func validatePHPFPMConfig(content string) error {
+	pools := map[string]map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				return fmt.Errorf("line %d: malformed section %q", lineNumber, line)
+			}
+			section = line[1 : len(line)-1]
+			if pools[section] == nil {
+				pools[section] = map[string]string{}
+			}
+		default:
+			keyValue := strings.SplitN(line, "=", 2)
+			if len(keyValue) != 2 || strings.TrimSpace(keyValue[0]) == "" {
+				return fmt.Errorf("line %d: expected \"key = value\", got %q", lineNumber, line)
+			}
+			if section == "" {
+				return fmt.Errorf("line %d: setting %q outside of a section", lineNumber, strings.TrimSpace(keyValue[0]))
+			}
+			pools[section][strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for name, pool := range pools {
+		if err := validatePHPFPMProcessManager(pool); err != nil {
+			return fmt.Errorf("pool [%s]: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validatePHPFPMProcessManager applies the checks that php-fpm does on the `pm.*` settings of a pool
+func validatePHPFPMProcessManager(pool map[string]string) error {
+	pm, set := pool["pm"]
+	if !set {
+		return nil
+	}
+	value := func(key string) (int, error) {
+		v, err := strconv.Atoi(pool[key])
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, got %q", key, pool[key])
+		}
+		return v, nil
+	}
+	switch pm {
+	case "static", "ondemand":
+		maxChildren, err := value("pm.max_children")
+		if err != nil {
+			return err
+		}
+		if maxChildren < 1 {
+			return fmt.Errorf("pm.max_children(%d) must be a positive value", maxChildren)
+		}
+	case "dynamic":
+		maxChildren, err := value("pm.max_children")
+		if err != nil {
+			return err
+		}
+		minSpare, err := value("pm.min_spare_servers")
+		if err != nil {
+			return err
+		}
+		maxSpare, err := value("pm.max_spare_servers")
+		if err != nil {
+			return err
+		}
+		startServers := minSpare + (maxSpare-minSpare)/2
+		if _, set := pool["pm.start_servers"]; set {
+			if startServers, err = value("pm.start_servers"); err != nil {
+				return err
+			}
+		}
+		switch {
+		case maxChildren < 1 || minSpare < 1 || maxSpare < 1:
+			return fmt.Errorf("pm.max_children, pm.min_spare_servers and pm.max_spare_servers must be positive values")
+		case minSpare > maxChildren || maxSpare > maxChildren:
+			return fmt.Errorf("pm.min_spare_servers(%d) and pm.max_spare_servers(%d) cannot be greater than pm.max_children(%d)", minSpare, maxSpare, maxChildren)
+		case minSpare > maxSpare:
+			return fmt.Errorf("pm.max_spare_servers(%d) must not be less than pm.min_spare_servers(%d)", maxSpare, minSpare)
+		case startServers < minSpare || startServers > maxSpare:
+			return fmt.Errorf("pm.start_servers(%d) must not be less than pm.min_spare_servers(%d) and not greater than pm.max_spare_servers(%d)", startServers, minSpare, maxSpare)
+		}
+	default:
+		return fmt.Errorf("pm must be one of static, dynamic or ondemand, got %q", pm)
+	}
+	return nil
+}
+
+// validateNginxConfig checks that the nginx configuration has balanced blocks and that every directive is terminated
+func validateNginxConfig(content string) error {
+	depth := 0
+	statement := ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		quote := rune(0)
+	characters:
+		for _, c := range line {
+			if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+				statement += string(c)
+				continue
+			}
+			switch c {
+			case '#':
+				break characters
+			case '"', '\'':
+				quote = c
+				statement += string(c)
+			case '{':
+				if strings.TrimSpace(statement) == "" {
+					return fmt.Errorf("line %d: block without a name", lineNumber)
+				}
+				depth++
+				statement = ""
+			case '}':
+				if strings.TrimSpace(statement) != "" {
+					return fmt.Errorf("line %d: directive %q is not terminated by \";\"", lineNumber, strings.TrimSpace(statement))
+				}
+				depth--
+				if depth < 0 {
+					return fmt.Errorf("line %d: unexpected \"}\"", lineNumber)
+				}
+			case ';':
+				if strings.TrimSpace(statement) == "" {
+					return fmt.Errorf("line %d: empty directive", lineNumber)
+				}
+				statement = ""
+			default:
+				statement += string(c)
+			}
+		}
+		if quote != 0 {
+			return fmt.Errorf("line %d: unterminated quote", lineNumber)
+		}
+		statement += " "
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(statement) != "" {
+		return fmt.Errorf("directive %q is not terminated by \";\"", strings.TrimSpace(statement))
+	}
+	if depth != 0 {
+		return fmt.Errorf("unexpected end of file, expecting \"}\"")
+	}
+	return nil
+}
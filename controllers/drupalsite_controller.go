@@ -18,14 +18,20 @@ package controllers
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"reflect"
+	"path"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/go-logr/logr"
+	imagename "github.com/google/go-containerregistry/pkg/name"
 	buildv1 "github.com/openshift/api/build/v1"
 	imagev1 "github.com/openshift/api/image/v1"
 	routev1 "github.com/openshift/api/route/v1"
@@ -36,12 +42,18 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"knative.dev/pkg/apis"
 
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -56,6 +68,39 @@ const (
 	finalizerStr    = "controller.drupalsite.webservices.cern.ch"
 	debugAnnotation = "debug"
 	oidcSecretName  = "oidc-client-secret"
+	// promoteAnnotation requests that a DrupalSite becomes the primary site of its project
+	promoteAnnotation = "drupal.webservices.cern.ch/promoteToPrimary"
+	// promoteSiteURLAnnotation remembers the SiteURL of a DrupalSite being promoted, to hand it over to the former primary site
+	promoteSiteURLAnnotation = "drupal.webservices.cern.ch/promoteFormerSiteURL"
+	// promotePrimarySiteURLAnnotation remembers the SiteURL of the former primary site on the DrupalSite being promoted,
+	// so that the promotion completes even if the former primary site is deleted half-way
+	promotePrimarySiteURLAnnotation = "drupal.webservices.cern.ch/promotePrimarySiteURL"
+	// demotedAnnotation marks the former primary site while it waits for its new SiteURL. Its value is the name of the promoted site
+	demotedAnnotation = "drupal.webservices.cern.ch/demotedBy"
+	// runDrushAnnotation requests to run one of the whitelisted drush commands on the site, see drushCommands
+	runDrushAnnotation = "drupal.cern.ch/run-drush"
+	// clearCacheAnnotation requests to reload the caches of the site once, eg after a content deploy. Its value is ignored
+	clearCacheAnnotation = "drupal.cern.ch/clear-cache"
+	// restartAnnotation requests a rollout of the server deployment, eg to clear the opcache. Its value is an arbitrary token
+	restartAnnotation = "drupal.webservices.cern.ch/restart"
+	// restartedAtAnnotation on the pod template of the server deployment records the last requested restart
+	restartedAtAnnotation = "drupal.webservices.cern.ch/restartedAt"
+	// productionLabel marks the DrupalSites whose cleanup must be confirmed with the confirmDeleteAnnotation.
+	// Promoting a site to primary moves the label from the former primary site to it
+	productionLabel = "production"
+	// confirmDeleteAnnotation confirms the deletion of a production DrupalSite. Its value must be the name of the site
+	confirmDeleteAnnotation = "drupal.cern.ch/confirm-delete"
+	// approveUpdateAnnotation approves the update of a DrupalSite without autoUpdate. Its value must be the releaseID of the spec
+	approveUpdateAnnotation = "drupal.cern.ch/approve-update"
+	// retryDBUpdateAnnotation requests to retry the failed DB update of a site once, eg after its root cause was fixed. Its value is ignored
+	retryDBUpdateAnnotation = "drupal.cern.ch/retry-db-update"
+	// siteGenerationAnnotation records the generation of the DrupalSite spec that a job was created for
+	siteGenerationAnnotation = "drupal.cern.ch/site-generation"
+	// maxDrushOutputLength limits the drush output kept on the status
+	maxDrushOutputLength = 4096
+	// postInstallRetryDelay and postInstallMaxRetryDelay are the first and the longest delay before a failed post-install command is retried
+	postInstallRetryDelay    = 30 * time.Second
+	postInstallMaxRetryDelay = time.Hour
 )
 
 var (
@@ -77,19 +122,84 @@ var (
 	DefaultD93ReleaseSpec string
 	// ParallelThreadCount refers to the number of parallel reconciliations done by the Operator
 	ParallelThreadCount int
+	// StartRateLimiterMillis and MaxRateLimiterSeconds refer to the initial and the maximum delay of the exponential backoff
+	// with which failed reconciliations are retried
+	StartRateLimiterMillis int
+	MaxRateLimiterSeconds  int
 	// EnableTopologySpread refers to enabling avaliability zone scheduling for critical site deployments
 	EnableTopologySpread bool
 	// ClusterName refers to the name of the cluster the operator is running on
 	ClusterName string
 	// EasystartBackupName refers to the name of the easystart backup
 	EasystartBackupName string
+	// MaxConcurrentUpgrades limits the number of version upgrades running at the same time across the cluster. 0 means no limit
+	MaxConcurrentUpgrades int
+	// ImageRegistryMirror refers to the registry that replaces the registry of every image the operator deploys, eg for air-gapped clusters
+	ImageRegistryMirror string
+	// EnableCloneURLRewrite refers to enabling the post-clone URL rewrite of the sites that set `cloneURLRewrite`
+	EnableCloneURLRewrite bool
+	// DeploymentRevisionHistoryLimit refers to the number of old ReplicaSets kept for each server deployment, to allow a manual rollback
+	DeploymentRevisionHistoryLimit int
+	// DrupalCoreVersionInterval refers to how often the Drupal core version running on the sites is checked. 0 disables the check
+	DrupalCoreVersionInterval time.Duration
+	// Paused refers to freezing the reconciliation of all the resources, eg during cluster maintenance
+	Paused bool
+	// DefaultStorageClass refers to the storage class of the sites' PVCs
+	DefaultStorageClass string
+	// OidcReturnURIScheme refers to the scheme of the OIDC return URIs of the sites, https since their routes redirect to TLS
+	OidcReturnURIScheme string
+	// DBUpdateLockTimeout refers to how long a DB update holds the lock of its site, before another one can take it over
+	DBUpdateLockTimeout time.Duration
+	// VersionDriftGracePeriod refers to how long a site can run a pod of an older release without an update in progress, before it's flagged with `VersionDrift`
+	VersionDriftGracePeriod time.Duration
+	// DBODProvisioningTimeout refers to how long the DBOD operator can take to assign an instance to the database of a site, before the provisioning is considered failed
+	DBODProvisioningTimeout time.Duration
+	// PVCProvisioningGracePeriod refers to how long the PVC of a site can stay pending, before it's flagged with `StorageProvisioningFailed`
+	PVCProvisioningGracePeriod time.Duration
+	// ResourcesResyncPeriod refers to how often the resources of a steady site are ensured even if nothing they depend on changed. 0 ensures them on every reconciliation
+	ResourcesResyncPeriod time.Duration
+	// PodStartGracePeriod refers to how long the pod of a new release can stay pending during an update, before the rollout is considered failed
+	PodStartGracePeriod time.Duration
+	// StuckConditionThreshold refers to how long a failure condition can stay true, or the Ready condition false, before it's reported as stuck. 0 disables the reports
+	StuckConditionThreshold time.Duration
+	// WatchNamespace restricts the operator to the DrupalSites of a single namespace, if set. All namespaces are watched by default
+	WatchNamespace string
+	// AllowedSiteURLSuffixes are the domains that the URLs of the sites must belong to, eg the domains that the router admits. Any domain if empty
+	AllowedSiteURLSuffixes []string
+	// StartupTimeout refers to how long the nginx and php-fpm containers of a site can take to start, before they're restarted. Their liveness probes only run after it
+	StartupTimeout time.Duration
+	// EnableAdminAccount refers to passing the `adminAccount` of the sites to their install job, for sitebuilder images whose install script reads it
+	EnableAdminAccount bool
 )
 
+// drushCommands are the drush commands that can be run through the runDrushAnnotation
+var drushCommands = map[string][]string{
+	"cr":     {"drush", "cr"},
+	"cron":   {"drush", "cron"},
+	"status": {"drush", "status"},
+	// clear-cache is the cache reload of the updates, also requested through the clearCacheAnnotation
+	"clear-cache": cacheReload(),
+}
+
+// postInstallDrushCommands are the drush subcommands that can be run through `postInstallCommands`
+var postInstallDrushCommands = map[string]bool{
+	"en": true, "pm:enable": true, "pm-enable": true,
+	"pmu": true, "pm:uninstall": true, "pm-uninstall": true,
+	"cim": true, "config:import": true, "config-import": true,
+	"cset": true, "config:set": true, "config-set": true,
+	"sset": true, "state:set": true, "state-set": true,
+	"cr": true, "cache:rebuild": true, "cache-rebuild": true,
+	"locale:update": true, "locale-update": true,
+}
+
 // DrupalSiteReconciler reconciles a DrupalSite object
 type DrupalSiteReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	// APIReader reads from the API server directly, for the resources that aren't worth caching, like Events
+	APIReader client.Reader
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=drupal.webservices.cern.ch,resources=drupalsites,verbs=get;list;watch;create;update;patch;delete
@@ -110,15 +220,36 @@ type DrupalSiteReconciler struct {
 // +kubebuilder:rbac:groups=dbod.cern.ch,resources=databaseclasses,verbs=get;list;watch;
 // +kubebuilder:rbac:groups=webservices.cern.ch,resources=oidcreturnuris,verbs=*
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=*;
-// +kubebuilder:rbac:groups=velero.io,resources=backups,verbs=get;list;watch;
+// +kubebuilder:rbac:groups=velero.io,resources=backups,verbs=get;list;watch;delete;
+// +kubebuilder:rbac:groups=velero.io,resources=deletebackuprequests,verbs=get;list;watch;create;
 // +kubebuilder:rbac:groups=velero.io,resources=schedules,verbs=*;
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;list;watch;create;
 // +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=resourcequotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;patch
+// +kubebuilder:rbac:groups=scheduling.k8s.io,resources=priorityclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
 // +kubebuilder:rbac:groups=tekton.dev,resources=taskruns,verbs=get;list;watch;create;delete
 
+// RestrictToWatchNamespace restricts the cache of the manager to WatchNamespace, if it's set.
+// The velero objects live in VeleroNamespace, outside of the cache, so they are read directly from the API server.
+func RestrictToWatchNamespace(options *ctrl.Options) {
+	if WatchNamespace == "" {
+		return
+	}
+	options.Namespace = WatchNamespace
+	options.ClientDisableCacheFor = append(options.ClientDisableCacheFor, &velerov1.Schedule{}, &velerov1.Backup{}, &velerov1.DeleteBackupRequest{})
+}
+
+// watchesVeleroBackups checks if the controller watches the velero Backups.
+// A namespaced operator doesn't: the Backups live in VeleroNamespace, outside of its cache, and it may not be allowed to watch them.
+func watchesVeleroBackups() bool {
+	return WatchNamespace == ""
+}
+
 // SetupWithManager adds a manager which watches the resources
 func (r *DrupalSiteReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&webservicesv1a1.DrupalSite{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&buildv1.BuildConfig{}).
@@ -130,18 +261,28 @@ func (r *DrupalSiteReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&dbodv1a1.Database{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
-		Owns(&pipelinev1.TaskRun{}).
-		Watches(&source.Kind{Type: &velerov1.Backup{}}, handler.EnqueueRequestsFromMapFunc(
+		Owns(&pipelinev1.TaskRun{})
+	if watchesVeleroBackups() {
+		builder = builder.Watches(&source.Kind{Type: &velerov1.Backup{}}, handler.EnqueueRequestsFromMapFunc(
 			// Reconcile every DrupalSite in the project referred to by the Backup
 			func(a client.Object) []reconcile.Request {
 				log := r.Log.WithValues("Source", "Velero Backup event handler", "Namespace", a.GetNamespace())
+				// Backups taken before the project name was hashed carry it in the `project` label
 				projectName, exists := a.GetLabels()["drupal.webservices.cern.ch/project"]
 				if exists {
 					return fetchDrupalSitesInNamespace(mgr, log, projectName)
 				}
+				projectHash, exists := a.GetLabels()["drupal.webservices.cern.ch/projectHash"]
+				if exists {
+					return fetchDrupalSitesWithProjectHash(mgr, log, projectHash)
+				}
 				return []reconcile.Request{}
 			}),
-		).
+		)
+	}
+	return builder.
+		// Reconcile the DrupalSite as soon as one of its S2I builds changes phase, instead of on its next reconciliation
+		Watches(&source.Kind{Type: &buildv1.Build{}}, handler.EnqueueRequestsFromMapFunc(drupalSiteForBuild)).
 		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(
 			// Reconcile every DrupalSite in a given namespace
 			func(a client.Object) []reconcile.Request {
@@ -170,12 +311,41 @@ func (r *DrupalSiteReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				return fetchDrupalSitesInNamespace(mgr, log, a.GetNamespace())
 			}),
 		).
-		WithOptions(controller.Options{
-			MaxConcurrentReconciles: ParallelThreadCount,
-		}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(
+			// Reconcile the DrupalSites that include the ConfigMap in their settings, to roll them out when it changes
+			func(a client.Object) []reconcile.Request {
+				log := r.Log.WithValues("Source", "ConfigMap event handler", "Namespace", a.GetNamespace())
+				return fetchDrupalSitesWithExtraSettings(mgr, log, a.GetNamespace(), a.GetName())
+			}),
+		).
+		WithOptions(controllerOptions()).
 		Complete(r)
 }
 
+// controllerOptions returns the options of the DrupalSite controller: ParallelThreadCount concurrent reconciliations, and the rate limiter.
+// The concurrent reconciliations only share the package-level settings, which are only set at startup from the cmdline flags
+func controllerOptions() controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: ParallelThreadCount,
+		RateLimiter:             newRateLimiter(),
+	}
+}
+
+// newRateLimiter returns the rate limiter of the failed reconciliations, backing off exponentially
+// from StartRateLimiterMillis up to MaxRateLimiterSeconds
+func newRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewItemExponentialFailureRateLimiter(time.Duration(StartRateLimiterMillis)*time.Millisecond, time.Duration(MaxRateLimiterSeconds)*time.Second)
+}
+
+// drupalSiteForBuild maps a Build to the DrupalSite of its BuildConfig, whose labels the Build inherits
+func drupalSiteForBuild(a client.Object) []reconcile.Request {
+	name, exists := a.GetLabels()["drupalSite"]
+	if !exists {
+		return []reconcile.Request{}
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: a.GetNamespace()}}}
+}
+
 // fetchDrupalSitesInNamespace feteches all the Drupalsites in a given namespace
 func fetchDrupalSitesInNamespace(mgr ctrl.Manager, log logr.Logger, namespace string) []reconcile.Request {
 	drupalSiteList := webservicesv1a1.DrupalSiteList{}
@@ -195,10 +365,49 @@ func fetchDrupalSitesInNamespace(mgr ctrl.Manager, log logr.Logger, namespace st
 	return requests
 }
 
+// fetchDrupalSitesWithProjectHash fetches the Drupalsites whose namespace has the given md5 hash, as found in the labels of the velero Backups
+func fetchDrupalSitesWithProjectHash(mgr ctrl.Manager, log logr.Logger, projectHash string) []reconcile.Request {
+	drupalSiteList := webservicesv1a1.DrupalSiteList{}
+	if err := mgr.GetClient().List(context.TODO(), &drupalSiteList); err != nil {
+		log.Error(err, "Couldn't query drupalsites")
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, drupalSite := range drupalSiteList.Items {
+		hash := md5.Sum([]byte(drupalSite.Namespace))
+		if hex.EncodeToString(hash[:]) == projectHash {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: drupalSite.Name, Namespace: drupalSite.Namespace}})
+		}
+	}
+	return requests
+}
+
+// fetchDrupalSitesWithExtraSettings fetches the Drupalsites in a given namespace whose `extraSettingsConfigMap` is the given ConfigMap
+func fetchDrupalSitesWithExtraSettings(mgr ctrl.Manager, log logr.Logger, namespace string, configMap string) []reconcile.Request {
+	drupalSiteList := webservicesv1a1.DrupalSiteList{}
+	if err := mgr.GetClient().List(context.TODO(), &drupalSiteList, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "Couldn't query drupalsites in the namespace")
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, drupalSite := range drupalSiteList.Items {
+		if drupalSite.Spec.Configuration.ExtraSettingsConfigMap == configMap {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: drupalSite.Name, Namespace: drupalSite.Namespace}})
+		}
+	}
+	return requests
+}
+
 func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	// _ = context.Background()
+	// The read-only checks in the server pod are run once per reconciliation
+	ctx = withExecCache(ctx)
 	log := r.Log.WithValues("Request.Namespace", req.NamespacedName, "Request.Name", req.Name)
 	log.V(1).Info("Reconciling request")
+	if Paused {
+		log.V(1).Info("The operator is paused, skipping the reconciliation")
+		return ctrl.Result{}, nil
+	}
 	var requeueFlag error
 
 	// Fetch the DrupalSite instance
@@ -233,6 +442,10 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
+	// Trace the decisions of this pass in a single line, whichever way it returns
+	summary := &reconcileSummary{}
+	defer logReconcileSummary(log, drupalSite, summary)
+
 	handleTransientErr := func(transientErr reconcileError, logstrFmt string, status string) (reconcile.Result, error) {
 		if status == "Ready" {
 			setConditionStatus(drupalSite, "Ready", false, transientErr, false)
@@ -264,7 +477,7 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	// 1. Init: Check if finalizer is set. If not, set it, validate and update CR status
 
-	if update, err := r.ensureSpecFinalizer(ctx, drupalSite, log); err != nil {
+	if update, err := r.ensureSpecFinalizer(ctx, drupalSite, drupalProjectConfig, log); err != nil {
 		log.Error(err, fmt.Sprintf("%v failed to ensure DrupalSite spec defaults", err.Unwrap()))
 		setErrorCondition(drupalSite, err)
 		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
@@ -272,12 +485,48 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		log.V(3).Info("Initializing DrupalSite Spec")
 		return r.updateCRorFailReconcile(ctx, log, drupalSite)
 	}
-	if err := validateSpec(drupalSite.Spec); err != nil {
+	// A former primary site is left without SiteURL while the promoted site takes it over
+	_, demoted := drupalSite.Annotations[demotedAnnotation]
+	if err := validateSpec(drupalSite.Spec, drupalSite.ConditionTrue("Initialized") && !demoted); err != nil {
+		log.Error(err, fmt.Sprintf("%v failed to validate DrupalSite spec", err.Unwrap()))
+		setErrorCondition(drupalSite, err)
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+	}
+	if err := validateSiteURLSuffixes(drupalSite, drupalProjectConfig); err != nil {
+		log.Error(err, fmt.Sprintf("%v failed to validate DrupalSite spec", err.Unwrap()))
+		setErrorCondition(drupalSite, err)
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+	}
+	if err := r.validateDiskSize(ctx, drupalSite); err != nil {
+		if err.Temporary() {
+			return handleTransientErr(err, "%v while validating the disk size", "")
+		}
 		log.Error(err, fmt.Sprintf("%v failed to validate DrupalSite spec", err.Unwrap()))
 		setErrorCondition(drupalSite, err)
 		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 	}
 
+	// A missing Secret of the spec is reported on the status until it's created.
+	// The Secrets that the site doesn't own aren't watched, so they are checked again periodically
+	missingSecret := r.checkExtraEnvFromSecrets(ctx, drupalSite)
+	if missingSecret == nil {
+		missingSecret = r.checkImagePullSecret(ctx, drupalSite)
+	}
+	switch {
+	case missingSecret != nil && missingSecret.Unwrap() != ErrSecretMissing:
+		return handleTransientErr(missingSecret, "%v while checking the secrets of the spec", "")
+	case missingSecret != nil:
+		log.V(3).Info("Waiting for a Secret of the spec", "error", missingSecret.Error())
+		if setConditionStatus(drupalSite, "SecretMissing", true, missingSecret, false) {
+			if result, err := r.updateCRStatusOrFailReconcile(ctx, log, drupalSite); err != nil || result.Requeue {
+				return result, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	case drupalSite.Status.Conditions.RemoveCondition("SecretMissing"):
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+	}
+
 	// 2. Check all conditions and update them if needed
 	update := false
 
@@ -287,6 +536,17 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		update = true || update
 	}
 
+	// A blocked namespace scales the site to zero, so the checks that exec into the server pod are skipped until it is unblocked
+	blocked, reconcileErr := r.isNamespaceBlocked(ctx, drupalSite)
+	if reconcileErr != nil {
+		return handleTransientErr(reconcileErr, "%v while checking if the namespace is blocked", "")
+	}
+	if blocked {
+		update = setConditionStatus(drupalSite, "Blocked", true, nil, false) || update
+	} else {
+		update = drupalSite.Status.Conditions.RemoveCondition("Blocked") || update
+	}
+
 	// Check if the drupal site is ready to serve requests
 	// We need to check for isDBODProvisioned explicitly here. Because if we don't, the status is put as Ready here considering the pod is running, but later on
 	// in the reconcile function, when DBOD provisioning is checked, the status is put as DBODError. There's a slight conflict here
@@ -295,13 +555,73 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	} else {
 		update = setNotReady(drupalSite, nil) || update
 	}
+	r.reportStuckConditions(drupalSite, log)
+
+	// A PVC that can't be provisioned, eg because its access mode isn't supported by the storage class, keeps the site from ever becoming ready
+	storageFailure, reconcileErr := r.storageProvisioningFailure(ctx, drupalSite)
+	if reconcileErr != nil {
+		return handleTransientErr(reconcileErr, "%v while checking the provisioning of the PVC", "")
+	}
+	if storageFailure != nil {
+		update = setConditionStatus(drupalSite, "StorageProvisioningFailed", true, storageFailure, false) || update
+	} else {
+		update = drupalSite.Status.Conditions.RemoveCondition("StorageProvisioningFailed") || update
+	}
+
+	// Serving is stricter than Ready: all the desired replicas must be ready and run the releaseID of the spec
+	serving, reconcileErr := r.isDrupalSiteServing(ctx, drupalSite)
+	if reconcileErr != nil {
+		return handleTransientErr(reconcileErr, "%v while checking if the site is serving", "")
+	}
+	update = setConditionStatus(drupalSite, "Serving", serving, nil, false) || update
+
+	// WebDAVReady reflects the admission of the WebDAV routes of the site, if it has any
+	webDAVReady, webDAVFound, reconcileErr := r.webDAVReady(ctx, drupalSite)
+	switch {
+	case reconcileErr != nil:
+		return handleTransientErr(reconcileErr, "%v while checking the WebDAV routes", "")
+	case webDAVFound:
+		update = setConditionStatus(drupalSite, "WebDAVReady", webDAVReady, nil, false) || update
+	default:
+		update = drupalSite.Status.Conditions.RemoveCondition("WebDAVReady") || update
+	}
 
 	// Check if the site is installed, cloned or easystart and mark the condition
 	if !drupalSite.ConditionTrue("Initialized") {
 		if r.isDrupalSiteInstalled(ctx, drupalSite) || r.isCloneJobCompleted(ctx, drupalSite) || r.isEasystartTaskRunCompleted(ctx, drupalSite) {
 			update = setInitialized(drupalSite) || update
+			// The clone imports the DB schema of the cloneFrom site, which is stale if that site runs a different version
+			if drupalSite.Spec.Configuration.CloneFrom != "" {
+				versionMismatch, reconcileErr := r.cloneVersionMismatch(ctx, drupalSite)
+				if reconcileErr != nil {
+					return handleTransientErr(reconcileErr, "%v while comparing the version of the cloneFrom DrupalSite", "")
+				}
+				if versionMismatch {
+					update = setConditionStatus(drupalSite, "CloneDBUpdatePending", true, nil, false) || update
+				}
+			}
 		} else {
 			update = setNotInitialized(drupalSite) || update
+			installFailedUpdate, reconcileErr := r.checkInstallFailure(ctx, drupalSite)
+			if reconcileErr != nil {
+				return handleTransientErr(reconcileErr, "%v while checking the install job", "")
+			}
+			update = installFailedUpdate || update
+		}
+		// Don't clone a site that is in the middle of an update, its database could be inconsistent
+		if drupalSite.Spec.Configuration.CloneFrom != "" {
+			cloneBlocked, reconcileErr := r.cloneSourceUpdating(ctx, drupalSite)
+			if reconcileErr != nil {
+				return handleTransientErr(reconcileErr, "%v while checking the cloneFrom DrupalSite", "")
+			}
+			if cloneBlocked {
+				update = setConditionStatus(drupalSite, "CloneBlocked", true, newApplicationError(fmt.Errorf("the cloneFrom DrupalSite %s is being updated", drupalSite.Spec.Configuration.CloneFrom), ErrTemporary), false) || update
+			} else {
+				update = drupalSite.Status.Conditions.RemoveCondition("CloneBlocked") || update
+			}
+		}
+		if drupalSite.Spec.Configuration.CloneFrom != "" && drupalSite.Spec.Configuration.CloneStrategy == webservicesv1a1.CloneStrategyRsync {
+			update = r.updateCloneProgress(ctx, drupalSite) || update
 		}
 	}
 
@@ -313,8 +633,12 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if drupalSite.ConditionTrue("DBUpdatesFailed") {
 			update = drupalSite.Status.Conditions.RemoveCondition("DBUpdatesFailed") || update
 		}
+		update = drupalSite.Status.Conditions.RemoveCondition("RollbackFailed") || update
 	}
 
+	// Don't publish the routes of a site whose last update failed
+	update = setPublishBlocked(drupalSite) || update
+
 	// If it's a site with extraConfig Spec, add the gitlab webhook trigger to the Status
 	// The URL is dependent on BuildConfig name, which is based on nameVersionHash() function. Therefore it needs to be updated when there is a ReleaseID update
 	// For consistency, we update the field on every reconcile
@@ -345,17 +669,46 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	backupList, err := r.checkNewBackups(ctx, drupalSite, log)
+	// Promote this DrupalSite to primary site of the project, if requested
+	promoted, reconcileErr := r.promoteToPrimary(ctx, drupalSite, drupalProjectConfig, log)
+	switch {
+	case reconcileErr != nil && reconcileErr.Temporary():
+		return handleTransientErr(reconcileErr, "%v while promoting DrupalSite to primary", "")
+	case reconcileErr != nil:
+		log.Error(reconcileErr, fmt.Sprintf("%v failed to promote DrupalSite to primary", reconcileErr.Unwrap()))
+		setErrorCondition(drupalSite, reconcileErr)
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+	case promoted:
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	backupList, failedBackup, err := r.checkNewBackups(ctx, drupalSite, log)
 	switch {
 	case err != nil:
 		log.Error(err, fmt.Sprintf("%v failed to check for new backups", reconcileErr.Unwrap()))
 		return ctrl.Result{}, err
-	// DeepEqual returns false when one of the slice is empty
-	case len(backupList) != len(drupalSite.Status.AvailableBackups) && !reflect.DeepEqual(backupList, drupalSite.Status.AvailableBackups):
+	}
+	backupList = retainExpiredBackups(drupalSite, backupList)
+	lastBackupTimeChanged := setLastBackupTime(drupalSite, backupList)
+	backupFailedChanged := r.setBackupFailed(drupalSite, failedBackup)
+	switch {
+	// A backup that expires can be replaced by its record, so the number of backups alone doesn't tell if they changed
+	case backupsChanged(backupList, drupalSite.Status.AvailableBackups) || lastBackupTimeChanged || backupFailedChanged:
 		drupalSite.Status.AvailableBackups = backupList
 		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 	}
 
+	// Finish a clone from a site on a different version by running the DB updates, with a backup, before anything else runs on the site
+	if drupalSite.ConditionTrue("CloneDBUpdatePending") && drupalSite.ConditionTrue("Ready") && !drupalSite.ConditionTrue("Blocked") {
+		update, requeue := r.updateClonedDBSchema(ctx, drupalSite, log)
+		switch {
+		case update:
+			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+		case requeue:
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
 	// 2.1 Set conditions related to update
 
 	// Check for updates after all resources are ensured. Else, this blocks the other logic like ensure resources, blocking sites when the controller can not exec/ run updb
@@ -363,7 +716,10 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// Check for an update, only when the site is initialized and ready to prevent checks during an installation/ upgrade
 	codeUpdateNeeded := false
 	dbUpdateNeeded := false
-	if drupalSite.ConditionTrue("Ready") && drupalSite.ConditionTrue("Initialized") && !drupalSite.ConditionTrue("CodeUpdateFailed") {
+	upgradeQueued := false
+	updatePending := false
+	previousPendingDBUpdates := drupalSite.Status.PendingDBUpdates
+	if drupalSite.ConditionTrue("Ready") && drupalSite.ConditionTrue("Initialized") && !drupalSite.ConditionTrue("CodeUpdateFailed") && !drupalSite.ConditionTrue("Blocked") {
 		codeUpdateNeeded, reconcileErr = r.codeUpdateNeeded(ctx, drupalSite)
 		if reconcileErr != nil {
 			handleNonfatalErr(reconcileErr, "%v while checking if an update is needed")
@@ -378,27 +734,72 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// 1. Decide the value of the annotation "updateInProgress"
 		switch {
 		case (codeUpdateNeeded || dbUpdateNeeded):
+			// New version upgrades wait in the queue while too many upgrades are running across the cluster
+			if _, isUpdateInProgress := drupalSite.Annotations["updateInProgress"]; codeUpdateNeeded && !isUpdateInProgress {
+				// Sites without autoUpdate wait for the approval of the update
+				if unapproved := updateUnapproved(drupalSite); unapproved != nil {
+					updatePending = true
+					if setConditionStatus(drupalSite, "UpdatePending", true, unapproved, false) {
+						log.V(3).Info("Waiting for the approval of the update", "releaseID", releaseID(drupalSite))
+						return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+					}
+					break
+				}
+				slotAvailable, reconcileErr := r.upgradeSlotAvailable(ctx, drupalSite)
+				if reconcileErr != nil {
+					return handleTransientErr(reconcileErr, "%v while counting the running upgrades", "")
+				}
+				if !slotAvailable {
+					upgradeQueued = true
+					if setUpgradeQueued(drupalSite) {
+						log.V(3).Info("Too many upgrades running, queueing the upgrade")
+						return r.updateCRorFailReconcile(ctx, log, drupalSite)
+					}
+					break
+				}
+			}
 			if setUpdateInProgress(drupalSite) {
+				unsetUpgradeQueued(drupalSite)
 				return r.updateCRorFailReconcile(ctx, log, drupalSite)
 			}
 		case !(codeUpdateNeeded || dbUpdateNeeded):
 			// We only unset here, when the failSafe and current are the same i.e the update succeeded
-			if unsetUpdateInProgress(drupalSite) {
+			if unsetUpdateInProgress(drupalSite) || unsetUpgradeQueued(drupalSite) {
 				return r.updateCRorFailReconcile(ctx, log, drupalSite)
 			}
+			if setUpdateStep(drupalSite, "") {
+				return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+			}
 		}
 		// 2. Set status condition DBUpdatesPending
 		switch {
 		case dbUpdateNeeded:
-			if setDBUpdatesPending(drupalSite) {
+			update := setDBUpdatesPending(drupalSite)
+			update = previousPendingDBUpdates != drupalSite.Status.PendingDBUpdates || update
+			if update {
 				return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 			}
 		case !dbUpdateNeeded:
-			if removeDBUpdatesPending(drupalSite) {
+			update := removeDBUpdatesPending(drupalSite)
+			update = previousPendingDBUpdates != drupalSite.Status.PendingDBUpdates || update
+			if update {
 				return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 			}
 		}
 	}
+	if !upgradeQueued && unsetUpgradeQueued(drupalSite) {
+		return r.updateCRorFailReconcile(ctx, log, drupalSite)
+	}
+	// UpdatePending is only cleared once the update can start or isn't needed anymore, not whenever the site isn't ready for the check above
+	if !updatePending && drupalSite.ConditionTrue("UpdatePending") {
+		deployment, err := r.getRunningdeployment(ctx, drupalSite)
+		if err != nil && !k8sapierrors.IsNotFound(err) {
+			return handleTransientErr(newApplicationError(err, ErrClientK8s), "%v while checking the running release", "")
+		}
+		if updatePendingResolved(drupalSite, deployment.Spec.Template.ObjectMeta.Annotations["releaseID"]) && drupalSite.Status.Conditions.RemoveCondition("UpdatePending") {
+			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+		}
+	}
 	if drupalSite.ConditionTrue("CodeUpdateFailed") {
 		if unsetUpdateInProgress(drupalSite) {
 			return r.updateCRorFailReconcile(ctx, log, drupalSite)
@@ -409,6 +810,21 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
+	// Flag a site that keeps running an older release without an update in progress, eg if its update was never started
+	if drupalSite.ConditionTrue("Initialized") {
+		drift, reconcileErr := r.versionDrift(ctx, drupalSite)
+		switch {
+		case reconcileErr != nil:
+			handleNonfatalErr(reconcileErr, "%v while checking for a version drift")
+		case drift != nil:
+			if setConditionStatus(drupalSite, "VersionDrift", true, drift, false) {
+				return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+			}
+		case drupalSite.Status.Conditions.RemoveCondition("VersionDrift"):
+			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+		}
+	}
+
 	log.V(3).Info("Status up to date.")
 
 	// 3. After all conditions have been checked, perform actions relying on the Conditions for information.
@@ -420,6 +836,8 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if reconcileErr.Temporary() {
 			return handleTransientErr(reconcileErr, "Failed to calculate deployment configuration: %v", "")
 		} else {
+			log.Error(reconcileErr, fmt.Sprintf("%v failed to calculate deployment configuration", reconcileErr.Unwrap()))
+			setErrorCondition(drupalSite, reconcileErr)
 			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 		}
 	case requeue:
@@ -428,9 +846,8 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 	}
 
-	// Ensure all resources (server deployment is excluded here during updates)
-	if transientErrs := r.ensureResources(drupalSite, deploymentConfig, log); transientErrs != nil {
-		transientErr := concat(transientErrs)
+	// Ensure all resources (server deployment is excluded here during updates), unless nothing they depend on changed
+	if transientErr := r.ensureResourcesIfChanged(ctx, drupalSite, deploymentConfig, drupalProjectConfig, summary, log, time.Now()); transientErr != nil {
 		return handleTransientErr(transientErr, "%v while ensuring the resources", "Ready")
 	}
 
@@ -445,13 +862,70 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	log.V(3).Info("Ensured all resources are present.")
 
+	// Clear the caches requested through the annotation, once the site can serve it
+	if statusChanged, requested := r.clearCacheIfRequested(ctx, drupalSite, log); requested {
+		if statusChanged {
+			if result, err := r.updateCRStatusOrFailReconcile(ctx, log, drupalSite); err != nil || result.Requeue {
+				return result, err
+			}
+		}
+		return r.updateCRorFailReconcile(ctx, log, drupalSite)
+	}
+
+	// Run the drush command requested through the annotation, once the site can serve it. Rejected commands are dropped right away.
+	if command, set := drupalSite.Annotations[runDrushAnnotation]; set && ((drupalSite.ConditionTrue("Ready") && !drupalSite.ConditionTrue("Blocked")) || drushCommands[command] == nil) {
+		if r.runDrushCommand(ctx, drupalSite, command, log) {
+			if result, err := r.updateCRStatusOrFailReconcile(ctx, log, drupalSite); err != nil || result.Requeue {
+				return result, err
+			}
+		}
+		delete(drupalSite.Annotations, runDrushAnnotation)
+		return r.updateCRorFailReconcile(ctx, log, drupalSite)
+	}
+
+	// Run the post-install commands of the spec once, after the site is installed
+	if len(drupalSite.Spec.Configuration.PostInstallCommands) > 0 && !drupalSite.ConditionTrue("PostInstallComplete") &&
+		drupalSite.ConditionTrue("Initialized") && drupalSite.ConditionTrue("Ready") && !drupalSite.ConditionTrue("Blocked") && postInstallRetryIn(drupalSite, time.Now()) <= 0 {
+		if r.runPostInstallCommands(ctx, drupalSite, log, time.Now()) {
+			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+		}
+	}
+
+	// Report the Drupal core version that is actually running, periodically
+	if DrupalCoreVersionInterval > 0 && drupalSite.ConditionTrue("Ready") && drupalSite.ConditionTrue("Initialized") && !drupalSite.ConditionTrue("Blocked") && drupalCoreVersionCheckDue(drupalSite, time.Now()) {
+		r.updateDrupalCoreVersion(ctx, drupalSite, log)
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+	}
+
+	// Restart the site's pods if requested through the annotation
+	if token, set := drupalSite.Annotations[restartAnnotation]; set {
+		if reconcileErr := r.restartDeployment(ctx, drupalSite); reconcileErr != nil {
+			return handleTransientErr(reconcileErr, "%v while restarting the deployment", "")
+		}
+		log.Info("Restarted the deployment", "token", token)
+		delete(drupalSite.Annotations, restartAnnotation)
+		return r.updateCRorFailReconcile(ctx, log, drupalSite)
+	}
+
 	// 4. Check DBOD has been provisioned and reconcile if needed
 
-	if dbodReady := r.isDBODProvisioned(ctx, drupalSite); !dbodReady {
-		if update := setNotReady(drupalSite, newApplicationError(nil, ErrDBOD)); update {
+	previousDatabaseInstance := drupalSite.Status.DatabaseInstance
+	if dbodErr := r.checkDBODProvisioning(ctx, drupalSite); dbodErr != nil {
+		update := setConditionStatus(drupalSite, "DatabaseProvisioned", false, dbodErr, false)
+		update = drupalSite.Status.DatabaseInstance != previousDatabaseInstance || update
+		update = setNotReady(drupalSite, dbodErr) || update
+		if update {
 			r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 		}
-		return reconcile.Result{Requeue: true}, nil
+		if dbodErr.Temporary() {
+			return reconcile.Result{Requeue: true}, nil
+		}
+		// Changes to the Database will trigger a new reconciliation
+		log.Error(dbodErr, fmt.Sprintf("%v failed to provision the database", dbodErr.Unwrap()))
+		return reconcile.Result{}, nil
+	}
+	if setConditionStatus(drupalSite, "DatabaseProvisioned", true, nil, false) || drupalSite.Status.DatabaseInstance != previousDatabaseInstance {
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 	}
 
 	// 5. Perform drupalsite updates
@@ -480,15 +954,43 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
+	// Verify that the site came back on the failsafe version after a failed code update
+	if drupalSite.ConditionTrue("CodeUpdateFailed") && len(drupalSite.Status.ReleaseID.Failsafe) > 0 && !drupalSite.ConditionTrue("Blocked") {
+		update, requeue := r.verifyRollback(ctx, drupalSite)
+		switch {
+		case update:
+			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+		case requeue:
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
 	// Take db Backup on PVC
 	// Put site in maintenance mode
 	// Run drush updatedb
 	// Remove site from maintenance mode
 	// Restore backup in case of a failure
 
+	// A failed DB update is retried once through the annotation
+	if retryDBUpdate(drupalSite) {
+		log.Info("Retrying the failed DB update")
+		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+	}
 	if isUpdateAnnotationSet && dbUpdateNeeded && !drupalSite.ConditionTrue("DBUpdatesFailed") && !drupalSite.ConditionTrue("CodeUpdateFailed") {
-		if update := r.updateDBSchema(ctx, drupalSite, log); update {
+		update, retried, attempted := r.runDBUpdate(ctx, drupalSite, log)
+		switch {
+		case retried:
+			if update {
+				if result, err := r.updateCRStatusOrFailReconcile(ctx, log, drupalSite); err != nil || result.Requeue {
+					return result, err
+				}
+			}
+			return r.updateCRorFailReconcile(ctx, log, drupalSite)
+		case update:
 			return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
+		case !attempted:
+			// Attempt the DB update again later, eg once the lock is released
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 		}
 	}
 
@@ -500,6 +1002,32 @@ func (r *DrupalSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return r.updateCRStatusOrFailReconcile(ctx, log, drupalSite)
 	}
 
+	// Check again later if the queued upgrade can start
+	if upgradeQueued && requeueFlag == nil {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	// Check again later if the cloneFrom DrupalSite has finished its update
+	if drupalSite.ConditionTrue("CloneBlocked") && requeueFlag == nil {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	// Poll the progress of the rsync clone until the site is initialized
+	if !drupalSite.ConditionTrue("Initialized") && drupalSite.Spec.Configuration.CloneFrom != "" && drupalSite.Spec.Configuration.CloneStrategy == webservicesv1a1.CloneStrategyRsync && requeueFlag == nil {
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	// Retry the failed post-install command after its backoff
+	if len(drupalSite.Spec.Configuration.PostInstallCommands) > 0 && !drupalSite.ConditionTrue("PostInstallComplete") && requeueFlag == nil {
+		if retryIn := postInstallRetryIn(drupalSite, time.Now()); retryIn > 0 {
+			return ctrl.Result{RequeueAfter: retryIn}, nil
+		}
+	}
+
+	// Check the Drupal core version again after the interval
+	if DrupalCoreVersionInterval > 0 && drupalSite.ConditionTrue("Initialized") && requeueFlag == nil {
+		return ctrl.Result{RequeueAfter: DrupalCoreVersionInterval}, nil
+	}
+
 	// Returning err with Reconcile functions causes a requeue by default following exponential backoff
 	// Ref https://gitlab.cern.ch/paas-tools/operators/authz-operator/-/merge_requests/76#note_4501887
 	return ctrl.Result{}, requeueFlag
@@ -520,6 +1048,83 @@ func (r *DrupalSiteReconciler) isInstallJobCompleted(ctx context.Context, d *web
 	return false
 }
 
+// checkInstallFailure sets the `InstallFailed` condition when the install job has failed permanently, so that it's not recreated.
+// Once the spec changes, the failed job is deleted and the condition removed, so that the installation is tried again.
+func (r *DrupalSiteReconciler) checkInstallFailure(ctx context.Context, d *webservicesv1a1.DrupalSite) (update bool, reconcileErr reconcileError) {
+	if d.ConditionTrue("InstallFailed") && d.Status.InstallFailedGeneration != d.Generation {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ensure-site-install-" + d.Name, Namespace: d.Namespace}}
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !k8sapierrors.IsNotFound(err) {
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+		d.Status.InstallFailedGeneration = 0
+		d.Status.Conditions.RemoveCondition("InstallFailed")
+		return true, nil
+	}
+	failure, reconcileErr := r.installJobFailure(ctx, d)
+	if reconcileErr != nil || failure == nil {
+		return false, reconcileErr
+	}
+	update = setConditionStatus(d, "InstallFailed", true, failure, false)
+	if !update && d.Status.InstallFailedGeneration == d.Generation {
+		return false, nil
+	}
+	d.Status.InstallFailedGeneration = d.Generation
+	return true, nil
+}
+
+// installJobFailure returns a permanent error with the message of the failed container, if the install job has failed after all of its retries
+func (r *DrupalSiteReconciler) installJobFailure(ctx context.Context, d *webservicesv1a1.DrupalSite) (failure reconcileError, reconcileErr reconcileError) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: "ensure-site-install-" + d.Name, Namespace: d.Namespace}, job)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	// A job that is being deleted, eg after the spec changed, doesn't report on the current spec
+	if !job.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	failed := job.Spec.BackoffLimit != nil && job.Status.Failed > *job.Spec.BackoffLimit
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			failed = true
+		}
+	}
+	if !failed {
+		return nil, nil
+	}
+	// The failed job of a previous spec is deleted instead, so that the installation is tried again with the current one
+	if generation, _ := strconv.ParseInt(job.Annotations[siteGenerationAnnotation], 10, 64); generation < d.Generation {
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !k8sapierrors.IsNotFound(err) {
+			return nil, newApplicationError(err, ErrClientK8s)
+		}
+		return nil, nil
+	}
+	podList := corev1.PodList{}
+	if err := r.List(ctx, &podList, client.InNamespace(d.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	message := fmt.Sprintf("the install job failed %d times", job.Status.Failed)
+	var lastFinish time.Time
+	for _, pod := range podList.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			terminated := container.State.Terminated
+			if terminated == nil || terminated.ExitCode == 0 || terminated.FinishedAt.Time.Before(lastFinish) {
+				continue
+			}
+			lastFinish = terminated.FinishedAt.Time
+			reason := terminated.Message
+			if reason == "" {
+				reason = fmt.Sprintf("%s, exit code %d", terminated.Reason, terminated.ExitCode)
+			}
+			message = fmt.Sprintf("the install job failed %d times, container %s of pod %s: %s", job.Status.Failed, container.Name, pod.Name, strings.TrimSpace(reason))
+		}
+	}
+	return newApplicationError(errors.New(message), ErrInstallFailed), nil
+}
+
 // isCloneJobCompleted checks if the clone job is successfully completed
 func (r *DrupalSiteReconciler) isCloneJobCompleted(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
 	cloneJob := &batchv1.Job{}
@@ -531,93 +1136,938 @@ func (r *DrupalSiteReconciler) isCloneJobCompleted(ctx context.Context, d *webse
 	return cloneJob.Status.Succeeded != 0
 }
 
-// isEasystartTaskRunCompleted checks if the easystart taskRun is successfully completed
-func (r *DrupalSiteReconciler) isEasystartTaskRunCompleted(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
-	easystartTaskRun := &pipelinev1.TaskRun{}
-	err := r.Get(ctx, types.NamespacedName{Name: "easystart-" + d.Name, Namespace: d.Namespace}, easystartTaskRun)
+// isCloneJobFinished checks if the clone job has either succeeded or failed after all of its retries
+func (r *DrupalSiteReconciler) isCloneJobFinished(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
+	cloneJob := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: "clone-" + d.Name, Namespace: d.Namespace}, cloneJob)
 	if err != nil {
 		return false
 	}
-	// business logic, ie check "Succeeded"
-	return easystartTaskRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue()
-}
-
-// isDrupalSiteReady checks if the drupal site is to ready to serve requests by checking the status of Nginx & PHP pods
-func (r *DrupalSiteReconciler) isDrupalSiteReady(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
-	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
-	err1 := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, deployment)
-	if err1 == nil {
-		// Change the implementation here
-		if deployment.Status.ReadyReplicas != 0 {
+	for _, condition := range cloneJob.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
 			return true
 		}
 	}
-	return false
+	return cloneJob.Status.Succeeded != 0
 }
 
-// isDrupalSiteInstalled checks if the drupal site is initialized by running drush status command in the PHP pod
-func (r *DrupalSiteReconciler) isDrupalSiteInstalled(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
-	if r.isDrupalSiteReady(ctx, d) {
-		if _, err := r.execToServerPodErrOnStderr(ctx, d, "php-fpm", nil, checkIfSiteIsInstalled()...); err != nil {
-			return false
-		}
-		return true
+// runDrushCommand runs one of the whitelisted drushCommands on the site and reports its output on the status.
+// Commands that are not whitelisted are rejected with a warning event.
+func (r *DrupalSiteReconciler) runDrushCommand(ctx context.Context, d *webservicesv1a1.DrupalSite, command string, log logr.Logger) (update bool) {
+	drushCommand, whitelisted := drushCommands[command]
+	if !whitelisted {
+		log.Info("Rejected drush command that is not whitelisted", "command", command)
+		r.Recorder.Eventf(d, corev1.EventTypeWarning, "DrushCommandRejected", "drush command %q is not one of the whitelisted commands", command)
+		return false
 	}
-	return false
+	stdout, stderr, err := r.execToServerPod(ctx, d, "php-fpm", nil, drushCommand...)
+	output := formatDrushOutput(drushCommand, stdout, stderr, err)
+	if d.Status.LastDrushOutput == output {
+		return false
+	}
+	d.Status.LastDrushOutput = output
+	return true
 }
 
-// isDBODProvisioned checks if the DBOD has been provisioned by checking the status of DBOD custom resource
-func (r *DrupalSiteReconciler) isDBODProvisioned(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
-	database := &dbodv1a1.Database{}
-	err := r.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, database)
-	if err != nil {
+// runPostInstallCommands runs the `postInstallCommands` of the spec on the site, in order, and sets the PostInstallComplete condition once they all succeeded.
+// The commands that succeeded are recorded on the status and aren't run again. If one fails, the condition reports it,
+// and it's retried once postInstallRetryIn allows it.
+func (r *DrupalSiteReconciler) runPostInstallCommands(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger, now time.Time) (update bool) {
+	if d.ConditionTrue("PostInstallComplete") {
 		return false
 	}
-	return len(database.Status.DbodInstance) > 0
+	if d.Status.PostInstall == nil {
+		d.Status.PostInstall = &webservicesv1a1.PostInstallProgress{}
+	}
+	progress := d.Status.PostInstall
+	commands := d.Spec.Configuration.PostInstallCommands
+	for ; progress.Completed < len(commands); progress.Completed++ {
+		command := commands[progress.Completed]
+		args, err := splitCommandArgs(command)
+		stderr := ""
+		if err == nil {
+			_, stderr, err = r.execToServerPod(ctx, d, "php-fpm", nil, args...)
+		}
+		if err != nil {
+			log.Error(err, "Failed to run the post-install command", "command", command)
+			r.Recorder.Eventf(d, corev1.EventTypeWarning, "PostInstallCommandFailed", "post-install command %q failed: %v", command, err)
+			progress.Failures++
+			progress.LastFailureTime = &metav1.Time{Time: now}
+			failure := newApplicationError(fmt.Errorf("post-install command %q failed: %v %s", command, err, strings.TrimSpace(stderr)), ErrPodExec)
+			setConditionStatus(d, "PostInstallComplete", false, failure, false)
+			return true
+		}
+		log.Info("Ran the post-install command", "command", command)
+		progress.Failures = 0
+		progress.LastFailureTime = nil
+	}
+	setConditionStatus(d, "PostInstallComplete", true, nil, false)
+	return true
 }
 
-// databaseSecretName fetches the secret name of the DBOD provisioned secret by checking the status of DBOD custom resource
-func databaseSecretName(d *webservicesv1a1.DrupalSite) string {
-	return "dbcredentials-" + d.Name
+// postInstallRetryIn returns how long the failed post-install command of the site waits before it's retried, if at all.
+// The delay doubles with each consecutive failure, from postInstallRetryDelay up to postInstallMaxRetryDelay.
+func postInstallRetryIn(d *webservicesv1a1.DrupalSite, now time.Time) time.Duration {
+	progress := d.Status.PostInstall
+	if progress == nil || progress.Failures == 0 || progress.LastFailureTime == nil {
+		return 0
+	}
+	delay := postInstallRetryDelay
+	for i := 1; i < progress.Failures && delay < postInstallMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > postInstallMaxRetryDelay {
+		delay = postInstallMaxRetryDelay
+	}
+	return progress.LastFailureTime.Add(delay).Sub(now)
 }
 
-// cleanupDrupalSite checks and removes if a finalizer exists on the resource
-// It also removes the site from the DrupalProjectConfig in case it was the primary site.
-func (r *DrupalSiteReconciler) cleanupDrupalSite(ctx context.Context, log logr.Logger, drp *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig) (ctrl.Result, error) {
-	log.V(1).Info("Deleting DrupalSite")
-
-	// Remove site from DrupalProjectConfig if it was the primary site
-	if dpc != nil && dpc.Spec.PrimarySiteName == drp.Name {
-		dpc.Spec.PrimarySiteName = ""
-		if err := r.updateDrupalProjectConfigCR(ctx, log, dpc); err != nil {
-			return ctrl.Result{}, err
-		}
+// clearCacheIfRequested reloads the caches of the site if the clearCacheAnnotation is set and the site can serve it, and removes the annotation.
+// The output is reported on the status like the one of the drush commands.
+func (r *DrupalSiteReconciler) clearCacheIfRequested(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (statusChanged bool, requested bool) {
+	if _, set := d.Annotations[clearCacheAnnotation]; !set || !d.ConditionTrue("Ready") || d.ConditionTrue("Blocked") {
+		return false, false
 	}
+	statusChanged = r.runDrushCommand(ctx, d, "clear-cache", log)
+	delete(d.Annotations, clearCacheAnnotation)
+	return statusChanged, true
+}
 
-	controllerutil.RemoveFinalizer(drp, finalizerStr)
-	if err := r.ensureNoBackupSchedule(ctx, drp, log); err != nil {
+// updateDrupalCoreVersion checks the Drupal core version running on the site and reports it on the status.
+// If the check fails, the previous version is kept until the next check
+func (r *DrupalSiteReconciler) updateDrupalCoreVersion(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) {
+	coreVersion := &webservicesv1a1.DrupalCoreVersion{}
+	if d.Status.DrupalCoreVersion != nil {
+		coreVersion = d.Status.DrupalCoreVersion.DeepCopy()
+	}
+	coreVersion.ReleaseID = d.Status.ReleaseID.Current
+	coreVersion.CheckTime = metav1.Now()
+	sout, err := r.execCheckToServerPod(ctx, d, "php-fpm", checkDrupalCoreVersion()...)
+	if err != nil {
+		log.Error(err, "Failed to check the Drupal core version")
+	} else {
+		coreVersion.Version = strings.TrimSpace(sout)
+	}
+	d.Status.DrupalCoreVersion = coreVersion
+}
+
+// drupalCoreVersionCheckDue checks if the Drupal core version has to be checked again: after the DrupalCoreVersionInterval,
+// or as soon as a different releaseID runs
+func drupalCoreVersionCheckDue(d *webservicesv1a1.DrupalSite, now time.Time) bool {
+	coreVersion := d.Status.DrupalCoreVersion
+	return coreVersion == nil || coreVersion.ReleaseID != d.Status.ReleaseID.Current || now.Sub(coreVersion.CheckTime.Time) >= DrupalCoreVersionInterval
+}
+
+// restartDeployment rolls out the server deployment again, by bumping an annotation on its pod template
+func (r *DrupalSiteReconciler) restartDeployment(ctx context.Context, d *webservicesv1a1.DrupalSite) reconcileError {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deployment)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		// There are no pods to restart yet
+		return nil
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	}
+	if deployment.Spec.Template.ObjectMeta.Annotations == nil {
+		deployment.Spec.Template.ObjectMeta.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.ObjectMeta.Annotations[restartedAtAnnotation] = time.Now().Format(time.RFC3339)
+	if err := r.Update(ctx, deployment); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// formatDrushOutput joins the command with its stdout, stderr and exec error, keeping the end of long outputs
+func formatDrushOutput(command []string, stdout string, stderr string, err error) string {
+	output := "$ " + strings.Join(command, " ") + "\n" + stdout + stderr
+	if err != nil {
+		output += "\n" + err.Error()
+	}
+	if len(output) > maxDrushOutputLength {
+		output = output[len(output)-maxDrushOutputLength:]
+	}
+	return output
+}
+
+// cloneSourceUpdating checks if the cloneFrom DrupalSite is in the middle of an update, until the clone job has been created
+func (r *DrupalSiteReconciler) cloneSourceUpdating(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
+	err := r.Get(ctx, types.NamespacedName{Name: "clone-" + d.Name, Namespace: d.Namespace}, &batchv1.Job{})
+	switch {
+	case err == nil:
+		return false, nil
+	case !k8sapierrors.IsNotFound(err):
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	sourceSite := &webservicesv1a1.DrupalSite{}
+	err = r.Get(ctx, cloneSourceKey(d), sourceSite)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		// The spec validation reports a missing cloneFrom DrupalSite
+		return false, nil
+	case err != nil:
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	return isSiteUpdating(sourceSite), nil
+}
+
+// cloneVersionMismatch checks if the cloneFrom DrupalSite runs a different version than the DrupalSite, in which case
+// the cloned database needs DB updates
+func (r *DrupalSiteReconciler) cloneVersionMismatch(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
+	sourceSite := &webservicesv1a1.DrupalSite{}
+	err := r.Get(ctx, cloneSourceKey(d), sourceSite)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		// The regular DB update check still catches a stale schema
+		return false, nil
+	case err != nil:
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	return releaseID(sourceSite) != releaseID(d), nil
+}
+
+// routeCertificateSecret fetches the Secret with the certificate of the site's routes, if the RouteTLS spec refers to one
+func (r *DrupalSiteReconciler) routeCertificateSecret(ctx context.Context, d *webservicesv1a1.DrupalSite) (*corev1.Secret, reconcileError) {
+	routeTLS := d.Spec.Configuration.RouteTLS
+	if routeTLS == nil || routeTLS.CertificateSecret == "" {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: routeTLS.CertificateSecret, Namespace: d.Namespace}, secret)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil, newApplicationError(fmt.Errorf("the certificate Secret %s of the routes doesn't exist", routeTLS.CertificateSecret), ErrInvalidSpec)
+	case err != nil:
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	return secret, nil
+}
+
+// isSiteUpdating checks if a version update or DB updates are running on the DrupalSite
+func isSiteUpdating(d *webservicesv1a1.DrupalSite) bool {
+	return d.Annotations["updateInProgress"] == "true" || d.ConditionTrue("DBUpdatesPending")
+}
+
+// updateCloneProgress reports the progress of the rsync clone on the status, reading it from the running clone container.
+// The clone container is an init container of the clone pod if the pod rewrites the URLs after the clone.
+func (r *DrupalSiteReconciler) updateCloneProgress(ctx context.Context, d *webservicesv1a1.DrupalSite) (update bool) {
+	progress := d.Status.CloneProgress
+	if r.isCloneJobCompleted(ctx, d) {
+		progress = 100
+	} else {
+		podList := corev1.PodList{}
+		if err := r.List(ctx, &podList, client.InNamespace(d.Namespace), client.MatchingLabels{"job-name": "clone-" + d.Name}); err != nil {
+			return false
+		}
+		for _, pod := range podList.Items {
+			if !containerRunning(pod, "dest-clone") {
+				continue
+			}
+			sout, _, err := execToPodThroughAPI("dest-clone", pod.Name, d.Namespace, nil, readCloneProgress("/var/empty-run/"+cloneProgressFile)...)
+			if err != nil {
+				return false
+			}
+			if reported, ok := parseCloneProgress(sout); ok {
+				progress = reported
+			}
+			break
+		}
+	}
+	if d.Status.CloneProgress == progress {
+		return false
+	}
+	d.Status.CloneProgress = progress
+	return true
+}
+
+// containerRunning checks if the given container or init container of the pod is running
+func containerRunning(pod corev1.Pod, containerName string) bool {
+	for _, container := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if container.Name == containerName {
+			return container.State.Running != nil
+		}
+	}
+	return false
+}
+
+// isEasystartTaskRunCompleted checks if the easystart taskRun is successfully completed
+func (r *DrupalSiteReconciler) isEasystartTaskRunCompleted(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
+	easystartTaskRun := &pipelinev1.TaskRun{}
+	err := r.Get(ctx, types.NamespacedName{Name: "easystart-" + d.Name, Namespace: d.Namespace}, easystartTaskRun)
+	if err != nil {
+		return false
+	}
+	// business logic, ie check "Succeeded"
+	return easystartTaskRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue()
+}
+
+// isDrupalSiteReady checks if the drupal site is to ready to serve requests by checking the status of Nginx & PHP pods
+func (r *DrupalSiteReconciler) isDrupalSiteReady(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
+	err1 := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, deployment)
+	if err1 == nil {
+		// Change the implementation here
+		if deployment.Status.ReadyReplicas != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// storageProvisioningFailure returns the failure, if the PVC of the site has been pending for longer than PVCProvisioningGracePeriod.
+// The failure carries the message of the latest warning event of the PVC, which usually tells why it can't be provisioned.
+func (r *DrupalSiteReconciler) storageProvisioningFailure(ctx context.Context, d *webservicesv1a1.DrupalSite) (failure reconcileError, err reconcileError) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	switch err := r.Get(ctx, types.NamespacedName{Name: "pv-claim-" + d.Name, Namespace: d.Namespace}, pvc); {
+	case k8sapierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	if pvc.Status.Phase == corev1.ClaimBound || time.Since(pvc.CreationTimestamp.Time) < PVCProvisioningGracePeriod {
+		return nil, nil
+	}
+	// The Events of the namespace aren't cached, so only the PVC's are read from the API server
+	eventList := &corev1.EventList{}
+	if err := r.APIReader.List(ctx, eventList, client.InNamespace(d.Namespace), client.MatchingFields{"involvedObject.name": pvc.Name}); err != nil {
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	reason := fmt.Sprintf("PVC %s is %s since %s", pvc.Name, pvc.Status.Phase, pvc.CreationTimestamp.Format(time.RFC3339))
+	var latest *corev1.Event
+	for i, event := range eventList.Items {
+		if event.InvolvedObject.Kind != "PersistentVolumeClaim" || event.InvolvedObject.Name != pvc.Name || event.InvolvedObject.UID != pvc.UID || event.Type != corev1.EventTypeWarning {
+			continue
+		}
+		if latest == nil || latest.LastTimestamp.Before(&event.LastTimestamp) {
+			latest = &eventList.Items[i]
+		}
+	}
+	if latest != nil {
+		reason = fmt.Sprintf("%s: %s", reason, latest.Message)
+	}
+	return newApplicationError(errors.New(reason), ErrStorageProvisioningFailed), nil
+}
+
+// isDrupalSiteServing checks if all the desired replicas of the server deployment are ready and the running pods serve the releaseID of the spec
+func (r *DrupalSiteReconciler) isDrupalSiteServing(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deployment)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	podList := corev1.PodList{}
+	if err := r.List(ctx, &podList, client.InNamespace(d.Namespace), client.MatchingLabels{"drupalSite": d.Name, "app": "drupal"}); err != nil {
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	return deploymentServing(deployment, podList.Items, releaseID(d)), nil
+}
+
+// deploymentServing checks if all the desired replicas of the deployment are ready and if none of its running pods serves a different releaseID
+func deploymentServing(deployment *appsv1.Deployment, pods []corev1.Pod, releaseID string) bool {
+	desiredReplicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desiredReplicas = *deployment.Spec.Replicas
+	}
+	if desiredReplicas == 0 || deployment.Status.ReadyReplicas < desiredReplicas {
+		return false
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil && pod.Annotations["releaseID"] != releaseID {
+			return false
+		}
+	}
+	return true
+}
+
+// isDrupalSiteInstalled checks if the drupal site is initialized by running drush status command in the PHP pod
+func (r *DrupalSiteReconciler) isDrupalSiteInstalled(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
+	if d.ConditionTrue("Blocked") {
+		return false
+	}
+	if r.isDrupalSiteReady(ctx, d) {
+		if _, err := r.execCheckToServerPod(ctx, d, "php-fpm", checkIfSiteIsInstalled()...); err != nil {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// isNamespaceBlocked checks if the namespace of the DrupalSite is blocked, which scales its server deployment to zero
+func (r *DrupalSiteReconciler) isNamespaceBlocked(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
+	namespace := &corev1.Namespace{}
+	err := r.Get(ctx, types.NamespacedName{Name: d.Namespace}, namespace)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	return namespaceBlocked(namespace), nil
+}
+
+// reportStuckConditions logs the conditions of the site that have been stuck for longer than StuckConditionThreshold, and emits an event for each.
+// The event message only changes when the condition transitions, so that the repeated reports are aggregated.
+func (r *DrupalSiteReconciler) reportStuckConditions(d *webservicesv1a1.DrupalSite, log logr.Logger) {
+	now := time.Now()
+	for _, c := range stuckConditions(d, now) {
+		log.Info("Condition stuck", "condition", c.Type, "status", c.Status, "reason", c.Reason, "duration", now.Sub(c.LastTransitionTime.Time).Round(time.Second).String())
+		r.Recorder.Eventf(d, corev1.EventTypeWarning, "ConditionStuck", "%s has been %s since %s: %s %s", c.Type, c.Status, c.LastTransitionTime.UTC().Format(time.RFC3339), c.Reason, c.Message)
+	}
+}
+
+// isDBODProvisioned checks if the DBOD has been provisioned by checking the status of DBOD custom resource.
+// A site with a secondary database also needs it to be provisioned.
+func (r *DrupalSiteReconciler) isDBODProvisioned(ctx context.Context, d *webservicesv1a1.DrupalSite) bool {
+	names := []string{d.Name}
+	if d.Spec.Configuration.SecondaryDatabaseClass != "" {
+		names = append(names, secondaryDatabaseName(d))
+	}
+	for _, name := range names {
+		database := &dbodv1a1.Database{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: d.Namespace}, database)
+		if err != nil || len(database.Status.DbodInstance) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// upgradeSlotAvailable checks if a new version upgrade can start, given the MaxConcurrentUpgrades limit.
+// The upgrades in progress are counted by the "updateInProgress" annotation of all the DrupalSites in the cluster.
+// Since the count is based on the cache, parallel reconciliations can slightly exceed the limit.
+func (r *DrupalSiteReconciler) upgradeSlotAvailable(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
+	if MaxConcurrentUpgrades <= 0 {
+		return true, nil
+	}
+	drupalSiteList := &webservicesv1a1.DrupalSiteList{}
+	if err := r.List(ctx, drupalSiteList); err != nil {
+		return false, newApplicationError(errors.New("fetching drupalSiteList failed"), ErrClientK8s)
+	}
+	return countUpgradesInProgress(drupalSiteList.Items, d) < MaxConcurrentUpgrades, nil
+}
+
+// countUpgradesInProgress counts the DrupalSites, other than the given one, that have the "updateInProgress" annotation
+func countUpgradesInProgress(drupalSites []webservicesv1a1.DrupalSite, d *webservicesv1a1.DrupalSite) int {
+	count := 0
+	for _, site := range drupalSites {
+		if site.Namespace == d.Namespace && site.Name == d.Name {
+			continue
+		}
+		if site.Annotations["updateInProgress"] == "true" {
+			count++
+		}
+	}
+	return count
+}
+
+// checkDBODProvisioning checks the DBOD custom resource and returns an error describing why the database isn't provisioned yet.
+// A database that is still being provisioned gives a temporary ErrDBOD, while a failed provisioning gives a permanent ErrDBODProvisioningFailed.
+// The secondary database of the site, if it has one, is checked after the primary one. The DBOD instance of the primary one is reported on the status.
+func (r *DrupalSiteReconciler) checkDBODProvisioning(ctx context.Context, d *webservicesv1a1.DrupalSite) reconcileError {
+	instance, err := r.checkDatabaseProvisioning(ctx, d.Namespace, d.Name)
+	if err != nil {
+		return err
+	}
+	d.Status.DatabaseInstance = instance
+	if d.Spec.Configuration.SecondaryDatabaseClass != "" {
+		if _, err := r.checkDatabaseProvisioning(ctx, d.Namespace, secondaryDatabaseName(d)); err != nil {
+			return err.Wrap("secondary database")
+		}
+	}
+	return nil
+}
+
+// checkDatabaseProvisioning checks the provisioning of the given DBOD custom resource, and returns its DBOD instance once it's provisioned.
+// The DBOD operator only reports the assigned instance, so a Database without one for longer than DBODProvisioningTimeout is considered failed.
+func (r *DrupalSiteReconciler) checkDatabaseProvisioning(ctx context.Context, namespace, name string) (string, reconcileError) {
+	database := &dbodv1a1.Database{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, database); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return "", newApplicationError(errors.New("Database resource not created yet"), ErrDBOD)
+		}
+		return "", newApplicationError(err, ErrClientK8s)
+	}
+	if instance := database.Status.DbodInstance; len(instance) > 0 {
+		return instance, nil
+	}
+	if time.Since(database.CreationTimestamp.Time) >= DBODProvisioningTimeout {
+		return "", newApplicationError(fmt.Errorf("the DBOD operator didn't assign an instance to the database in %s", DBODProvisioningTimeout), ErrDBODProvisioningFailed)
+	}
+	return "", newApplicationError(errors.New("waiting for the DBOD operator to provision the database"), ErrDBOD)
+}
+
+// databaseSecretName fetches the secret name of the DBOD provisioned secret by checking the status of DBOD custom resource
+func databaseSecretName(d *webservicesv1a1.DrupalSite) string {
+	return "dbcredentials-" + d.Name
+}
+
+// secondaryDatabaseName is the name of the DBOD resource of the site's secondary database.
+// It's also the name of the primary database of a site called `<name>-secondary`, which ownedBySite keeps from being adopted or deleted
+func secondaryDatabaseName(d *webservicesv1a1.DrupalSite) string {
+	return d.Name + "-secondary"
+}
+
+// secondaryDatabaseSecretName is the secret name of the DBOD provisioned secret of the site's secondary database
+func secondaryDatabaseSecretName(d *webservicesv1a1.DrupalSite) string {
+	return "dbcredentials-" + secondaryDatabaseName(d)
+}
+
+// cleanupDrupalSite checks and removes if a finalizer exists on the resource
+// It also removes the site from the DrupalProjectConfig in case it was the primary site.
+func (r *DrupalSiteReconciler) cleanupDrupalSite(ctx context.Context, log logr.Logger, drp *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig) (ctrl.Result, error) {
+	log.V(1).Info("Deleting DrupalSite")
+
+	// A production site keeps its finalizer until its deletion is confirmed. The deletion itself can't be cancelled, but the database
+	// and the backups of the site are kept until then, eg to export its content after an accidental deletion
+	if unconfirmed := deletionUnconfirmed(drp); unconfirmed != nil {
+		log.Info("The deletion of the production site isn't confirmed, keeping it", "annotation", confirmDeleteAnnotation)
+		if setConditionStatus(drp, "DeletionBlocked", true, unconfirmed, false) {
+			r.Recorder.Event(drp, corev1.EventTypeWarning, "DeletionBlocked", unconfirmed.Error())
+			return r.updateCRStatusOrFailReconcile(ctx, log, drp)
+		}
+		// Annotating the site triggers a new reconciliation
+		return ctrl.Result{}, nil
+	}
+
+	// Remove site from DrupalProjectConfig if it was the primary site
+	if dpc != nil && dpc.Spec.PrimarySiteName == drp.Name {
+		dpc.Spec.PrimarySiteName = ""
+		if err := r.updateDrupalProjectConfigCR(ctx, log, dpc); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := r.ensureNoBackupSchedule(ctx, drp, log); err != nil {
 		return ctrl.Result{}, err
 	}
+	if crossNamespaceClone(drp) {
+		if err := r.ensureNoCloneSourceExport(ctx, drp, log); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	// The finalizer is kept until the backups and the database of the site are gone, so that nothing is orphaned
+	backupsPending, err := r.ensureNoSiteBackups(ctx, drp, log)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	databasePending, err := r.ensureNoDatabase(ctx, drp, log)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if backupsPending || databasePending {
+		log.V(3).Info("Waiting for the backups and the database of the site to be deleted")
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	controllerutil.RemoveFinalizer(drp, finalizerStr)
 	return r.updateCRorFailReconcile(ctx, log, drp)
 }
 
+// deletionUnconfirmed returns an error if the site is labelled as production and its deletion isn't confirmed with the confirmDeleteAnnotation
+func deletionUnconfirmed(d *webservicesv1a1.DrupalSite) reconcileError {
+	if d.Labels[productionLabel] != "true" || d.Annotations[confirmDeleteAnnotation] == d.Name {
+		return nil
+	}
+	return newApplicationError(fmt.Errorf("the production site is only deleted once annotated with %s=%s", confirmDeleteAnnotation, d.Name), ErrDeletionUnconfirmed)
+}
+
+// updatePendingResolved checks if the update that the site waits for can start, because it was approved or autoUpdate was enabled,
+// or isn't needed anymore, because the deployment runs the releaseID of the spec, eg after `version` was reverted
+func updatePendingResolved(d *webservicesv1a1.DrupalSite, runningReleaseID string) bool {
+	return updateUnapproved(d) == nil || runningReleaseID == releaseID(d)
+}
+
+// updateUnapproved returns an error if the site doesn't update automatically and the update to the releaseID of the spec isn't approved
+// with the approveUpdateAnnotation
+func updateUnapproved(d *webservicesv1a1.DrupalSite) reconcileError {
+	if d.Spec.Configuration.AutoUpdate == nil || *d.Spec.Configuration.AutoUpdate || d.Annotations[approveUpdateAnnotation] == releaseID(d) {
+		return nil
+	}
+	return newApplicationError(fmt.Errorf("the update to %s starts once the site is annotated with %s=%s", releaseID(d), approveUpdateAnnotation, releaseID(d)), ErrUpdateUnapproved)
+}
+
 //validateSpec validates the spec against the DrupalSiteSpec definition
-func validateSpec(drpSpec webservicesv1a1.DrupalSiteSpec) reconcileError {
+// Once the site is published (initialized), it must be served on at least 1 URL
+func validateSpec(drpSpec webservicesv1a1.DrupalSiteSpec, published bool) reconcileError {
 	_, err := govalidator.ValidateStruct(drpSpec)
 	if err != nil {
 		return newApplicationError(err, ErrInvalidSpec)
 	}
+	if err := validateSiteURLs(drpSpec.SiteURL, published); err != nil {
+		return newApplicationError(err, ErrInvalidSpec)
+	}
+	if err := validateCanary(drpSpec); err != nil {
+		return newApplicationError(err, ErrInvalidSpec)
+	}
+	if err := validateQoSClass(drpSpec.QoSClass); err != nil {
+		return newApplicationError(err, ErrInvalidSpec)
+	}
+	for _, resource := range drpSpec.Configuration.BackupIncludedResources {
+		if !backupResources[resource] {
+			return newApplicationError(fmt.Errorf("backupIncludedResources: %q is not a resource that can be backed up", resource), ErrInvalidSpec)
+		}
+	}
+	if strategy := drpSpec.Configuration.DeploymentStrategy; strategy != nil {
+		switch strategy.Type {
+		case "", appsv1.RollingUpdateDeploymentStrategyType:
+		case appsv1.RecreateDeploymentStrategyType:
+			if strategy.RollingUpdate != nil {
+				return newApplicationError(errors.New("deploymentStrategy: rollingUpdate can only be set with the RollingUpdate type"), ErrInvalidSpec)
+			}
+		default:
+			return newApplicationError(fmt.Errorf("deploymentStrategy: %q is not RollingUpdate or Recreate", strategy.Type), ErrInvalidSpec)
+		}
+	}
+	for _, excluded := range drpSpec.Configuration.BackupExcludedPaths {
+		if !backupExcludablePaths[path.Clean(excluded)] {
+			return newApplicationError(fmt.Errorf("backupExcludedPaths: %q is not a path whose files Drupal regenerates", excluded), ErrInvalidSpec)
+		}
+	}
+	for _, directory := range drpSpec.Configuration.SharedVolumeDirectories {
+		if directory == "" || path.IsAbs(directory) || strings.HasPrefix(path.Clean(directory), "..") || path.Clean(directory) == "." {
+			return newApplicationError(fmt.Errorf("sharedVolumeDirectories: %q must be a path inside the shared volume", directory), ErrInvalidSpec)
+		}
+	}
+	if location := drpSpec.Configuration.BackupStorageLocation; location != "" {
+		if errs := validation.IsDNS1123Subdomain(location); len(errs) > 0 {
+			return newApplicationError(fmt.Errorf("backupStorageLocation %q is not a valid name: %s", location, strings.Join(errs, ", ")), ErrInvalidSpec)
+		}
+	}
+	if secret := drpSpec.Configuration.ImagePullSecret; secret != "" {
+		if errs := validation.IsDNS1123Subdomain(secret); len(errs) > 0 {
+			return newApplicationError(fmt.Errorf("imagePullSecret %q is not a valid name: %s", secret, strings.Join(errs, ", ")), ErrInvalidSpec)
+		}
+	}
+	if drpSpec.Configuration.AdminAccount != nil && !EnableAdminAccount {
+		return newApplicationError(errors.New("adminAccount can't be set, the operator doesn't pass it to the install job"), ErrInvalidSpec)
+	}
+	if account := drpSpec.Configuration.AdminAccount; account != nil && account.PasswordSecret != "" {
+		if errs := validation.IsDNS1123Subdomain(account.PasswordSecret); len(errs) > 0 {
+			return newApplicationError(fmt.Errorf("adminAccount.passwordSecret %q is not a valid name: %s", account.PasswordSecret, strings.Join(errs, ", ")), ErrInvalidSpec)
+		}
+	}
+	if err := validateExtraEnv(drpSpec.Configuration.ExtraEnv); err != nil {
+		return newApplicationError(err, ErrInvalidSpec)
+	}
+	if err := validatePostInstallCommands(drpSpec.Configuration.PostInstallCommands); err != nil {
+		return newApplicationError(err, ErrInvalidSpec)
+	}
+	if err := validateExtraNginxConfig(drpSpec.Configuration.ExtraNginxConfig); err != nil {
+		return newApplicationError(err, ErrInvalidSpec)
+	}
+	if resources := drpSpec.Configuration.BuildResources; resources != nil {
+		if err := validateResourceRequirements(*resources); err != nil {
+			return newApplicationError(fmt.Errorf("buildResources: %w", err), ErrInvalidSpec)
+		}
+	}
+	for _, secret := range drpSpec.Configuration.ExtraEnvFromSecrets {
+		if secret == "" {
+			return newApplicationError(errors.New("extraEnvFromSecrets: secret names can't be empty"), ErrInvalidSpec)
+		}
+	}
+	for _, override := range []struct{ field, image string }{
+		{"siteBuilderImageOverride", drpSpec.Configuration.SiteBuilderImageOverride},
+		{"phpFpmExporterImageOverride", drpSpec.Configuration.PhpFpmExporterImageOverride},
+		{"webDAVImageOverride", drpSpec.Configuration.WebDAVImageOverride},
+	} {
+		if override.image == "" {
+			continue
+		}
+		if _, err := imagename.ParseReference(override.image, imagename.StrictValidation); err != nil {
+			return newApplicationError(fmt.Errorf("%s %q is not a valid image reference: %v", override.field, override.image, err), ErrInvalidSpec)
+		}
+	}
+	if rewrite := drpSpec.Configuration.CloneURLRewrite; rewrite != nil {
+		if !govalidator.IsDNSName(string(rewrite.From)) || !govalidator.IsDNSName(string(rewrite.To)) {
+			return newApplicationError(fmt.Errorf("cloneURLRewrite %q -> %q must replace a hostname with another", rewrite.From, rewrite.To), ErrInvalidSpec)
+		}
+	}
+	return nil
+}
+
+// validateResourceRequirements checks that the requests and limits only set positive CPU and memory quantities, and that no request exceeds its limit
+func validateResourceRequirements(resources corev1.ResourceRequirements) error {
+	for _, list := range []corev1.ResourceList{resources.Requests, resources.Limits} {
+		for name, quantity := range list {
+			if name != corev1.ResourceCPU && name != corev1.ResourceMemory {
+				return fmt.Errorf("%q is not cpu or memory", name)
+			}
+			if quantity.Sign() <= 0 {
+				return fmt.Errorf("%s %q must be positive", name, quantity.String())
+			}
+		}
+	}
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("the %s request %s exceeds the limit %s", name, request.String(), limit.String())
+		}
+	}
+	return nil
+}
+
+// validatePostInstallCommands checks that the post-install commands only run the allowed drush subcommands
+func validatePostInstallCommands(commands []string) error {
+	for _, command := range commands {
+		args, err := splitCommandArgs(command)
+		if err != nil {
+			return fmt.Errorf("postInstallCommands: %q: %v", command, err)
+		}
+		if len(args) < 2 || args[0] != "drush" {
+			return fmt.Errorf("postInstallCommands: %q is not a drush command", command)
+		}
+		if !postInstallDrushCommands[args[1]] {
+			return fmt.Errorf("postInstallCommands: drush %s is not one of the allowed subcommands", args[1])
+		}
+	}
+	return nil
+}
+
+// splitCommandArgs splits a command into its arguments with the quoting rules of the shell, without running a shell:
+// whitespace separates the arguments, single quotes keep their content as is, a backslash escapes the next character,
+// and inside double quotes only a double quote or a backslash.
+// Eg `drush cset system.site name 'My Site'` has "My Site" as its last argument.
+func splitCommandArgs(command string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		case c == '\\':
+			i++
+			if i == len(runes) {
+				return nil, errors.New("ends with a backslash")
+			}
+			arg.WriteRune(runes[i])
+		case c == '\'':
+			end := strings.IndexRune(string(runes[i+1:]), '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			quoted := []rune(string(runes[i+1:])[:end])
+			arg.WriteString(string(quoted))
+			i += len(quoted) + 1
+		case c == '"':
+			for i++; ; i++ {
+				if i == len(runes) {
+					return nil, errors.New("unterminated \" quote")
+				}
+				if runes[i] == '"' {
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					i++
+				}
+				arg.WriteRune(runes[i])
+			}
+		default:
+			arg.WriteRune(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// validateExtraNginxConfig checks that the extraNginxConfig is readable text with balanced blocks and terminated directives
+func validateExtraNginxConfig(config string) error {
+	switch {
+	case config == "":
+		return nil
+	case strings.TrimSpace(config) == "":
+		return errors.New("extraNginxConfig: can't be blank")
+	case !utf8.ValidString(config) || strings.ContainsRune(config, 0):
+		return errors.New("extraNginxConfig: must be text, not binary")
+	}
+	if err := validateNginxConfig(config); err != nil {
+		return fmt.Errorf("extraNginxConfig: %w", err)
+	}
+	return nil
+}
+
+// validateExtraEnv checks that the extra env vars of the site don't override the ones set by the operator, nor each other
+func validateExtraEnv(extraEnv []corev1.EnvVar) error {
+	seen := map[string]bool{}
+	for _, env := range extraEnv {
+		if reservedEnvVars[env.Name] {
+			return fmt.Errorf("extraEnv: %q is set by the operator and can't be overridden", env.Name)
+		}
+		if seen[env.Name] {
+			return fmt.Errorf("extraEnv: %q is set more than once", env.Name)
+		}
+		seen[env.Name] = true
+	}
+	return nil
+}
+
+// checkExtraEnvFromSecrets checks that the `extraEnvFromSecrets` of the site exist, so that its pods don't get stuck waiting for them.
+// A missing secret is a temporary ErrSecretMissing: the site waits until it's created.
+func (r *DrupalSiteReconciler) checkExtraEnvFromSecrets(ctx context.Context, d *webservicesv1a1.DrupalSite) reconcileError {
+	for _, secret := range d.Spec.Configuration.ExtraEnvFromSecrets {
+		if err := r.checkSecretExists(ctx, d.Namespace, secret); err != nil {
+			return err.Wrap("extraEnvFromSecrets")
+		}
+	}
+	return nil
+}
+
+// checkImagePullSecret checks that the `imagePullSecret` of the site exists, so that its images can be pulled.
+// A missing secret is a temporary ErrSecretMissing: the site waits until it's created.
+func (r *DrupalSiteReconciler) checkImagePullSecret(ctx context.Context, d *webservicesv1a1.DrupalSite) reconcileError {
+	secret := d.Spec.Configuration.ImagePullSecret
+	if secret == "" {
+		return nil
+	}
+	if err := r.checkSecretExists(ctx, d.Namespace, secret); err != nil {
+		return err.Wrap("imagePullSecret")
+	}
+	return nil
+}
+
+// checkSecretExists returns ErrSecretMissing if the given Secret doesn't exist
+func (r *DrupalSiteReconciler) checkSecretExists(ctx context.Context, namespace, name string) reconcileError {
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &corev1.Secret{})
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return newApplicationError(fmt.Errorf("secret %q doesn't exist", name), ErrSecretMissing)
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// validateDiskSize checks that the DiskSize doesn't shrink the site's existing PVC, and that it only grows it if its storage class allows expansion
+func (r *DrupalSiteReconciler) validateDiskSize(ctx context.Context, d *webservicesv1a1.DrupalSite) reconcileError {
+	if d.Spec.Configuration.DiskSize == "" {
+		return nil
+	}
+	diskSize, err := resource.ParseQuantity(d.Spec.Configuration.DiskSize)
+	if err != nil {
+		return newApplicationError(fmt.Errorf("diskSize %q is not a valid quantity: %v", d.Spec.Configuration.DiskSize, err), ErrInvalidSpec)
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	err = r.Get(ctx, types.NamespacedName{Name: "pv-claim-" + d.Name, Namespace: d.Namespace}, pvc)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	}
+	currentSize := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch diskSize.Cmp(currentSize) {
+	case -1:
+		return newApplicationError(fmt.Errorf("diskSize %s is smaller than the site's volume (%s), it can only grow", diskSize.String(), currentSize.String()), ErrInvalidSpec)
+	case 0:
+		return nil
+	}
+	if pvc.Spec.StorageClassName == nil {
+		return newApplicationError(fmt.Errorf("the site's volume has no storage class, it can't be expanded"), ErrInvalidSpec)
+	}
+	storageClass := &storagev1.StorageClass{}
+	err = r.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, storageClass)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return newApplicationError(fmt.Errorf("storage class %s of the site's volume doesn't exist", *pvc.Spec.StorageClassName), ErrInvalidSpec)
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	case storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion:
+		return newApplicationError(fmt.Errorf("storage class %s of the site's volume doesn't allow expansion", storageClass.Name), ErrInvalidSpec)
+	}
+	return nil
+}
+
+// validateSiteURLs checks that every SiteURL is a unique, lowercase hostname, so that each one gets its own Route and OidcReturnURI
+func validateSiteURLs(urls []webservicesv1a1.Url, published bool) error {
+	if published && len(urls) == 0 {
+		return fmt.Errorf("siteUrl can't be empty for a published site")
+	}
+	seen := map[webservicesv1a1.Url]bool{}
+	for _, url := range urls {
+		if !govalidator.IsDNSName(string(url)) || strings.ToLower(string(url)) != string(url) {
+			return fmt.Errorf("siteUrl %q is not a valid lowercase hostname", url)
+		}
+		if seen[url] {
+			return fmt.Errorf("siteUrl %q is duplicated", url)
+		}
+		seen[url] = true
+	}
+	return nil
+}
+
+// validateSiteURLSuffixes checks that the URLs of the site, and its canary URL, belong to one of the AllowedSiteURLSuffixes.
+// The default URL that the DrupalProjectConfig gives to the site is always allowed.
+func validateSiteURLSuffixes(d *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig) reconcileError {
+	if len(AllowedSiteURLSuffixes) == 0 {
+		return nil
+	}
+	urls := append([]webservicesv1a1.Url{}, d.Spec.SiteURL...)
+	if d.Spec.CanaryURL != "" {
+		urls = append(urls, d.Spec.CanaryURL)
+	}
+	for _, url := range urls {
+		if url == defaultSiteURL(d, dpc) || siteURLAllowed(url) {
+			continue
+		}
+		return newApplicationError(fmt.Errorf("siteUrl %q doesn't belong to any of the allowed domains %s", url, strings.Join(AllowedSiteURLSuffixes, ", ")), ErrInvalidSpec)
+	}
+	return nil
+}
+
+// siteURLAllowed checks if the URL is one of the AllowedSiteURLSuffixes or a subdomain of one of them
+func siteURLAllowed(url webservicesv1a1.Url) bool {
+	for _, suffix := range AllowedSiteURLSuffixes {
+		suffix = strings.TrimPrefix(strings.TrimSpace(suffix), ".")
+		if suffix != "" && (string(url) == suffix || strings.HasSuffix(string(url), "."+suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCanary checks that a canary version comes with its own URL, which the site doesn't use
+func validateCanary(drpSpec webservicesv1a1.DrupalSiteSpec) error {
+	switch {
+	case drpSpec.CanaryVersion == nil && drpSpec.CanaryURL == "":
+		return nil
+	case drpSpec.CanaryVersion == nil || drpSpec.CanaryURL == "":
+		return fmt.Errorf("canaryVersion and canaryURL must be set together")
+	case len(drpSpec.Configuration.ExtraConfigurationRepo) > 0:
+		return fmt.Errorf("canaryVersion is not supported for sites with extraConfigurationRepo")
+	case !govalidator.IsDNSName(string(drpSpec.CanaryURL)):
+		return fmt.Errorf("canaryURL %q is not a valid hostname", drpSpec.CanaryURL)
+	}
+	for _, url := range drpSpec.SiteURL {
+		if url == drpSpec.CanaryURL {
+			return fmt.Errorf("canaryURL %q is already a siteUrl", url)
+		}
+	}
 	return nil
 }
 
 // ensureSpecFinalizer ensures that the spec is valid, adding extra info if necessary, and that the finalizer is there,
-// then returns if it needs to be updated.
-func (r *DrupalSiteReconciler) ensureSpecFinalizer(ctx context.Context, drp *webservicesv1a1.DrupalSite, log logr.Logger) (update bool, err reconcileError) {
+// then returns if it needs to be updated. The project-wide defaults come from the DrupalProjectConfig, if any.
+func (r *DrupalSiteReconciler) ensureSpecFinalizer(ctx context.Context, drp *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig, log logr.Logger) (update bool, err reconcileError) {
 	if !controllerutil.ContainsFinalizer(drp, finalizerStr) {
 		log.V(3).Info("Adding finalizer")
 		controllerutil.AddFinalizer(drp, finalizerStr)
 		update = true
 	}
+	update = applyProjectDefaults(drp, dpc) || update
 	if drp.Spec.Configuration.WebDAVPassword == "" {
 		drp.Spec.Configuration.WebDAVPassword = generateRandomPassword()
 		update = true
@@ -626,15 +2076,11 @@ func (r *DrupalSiteReconciler) ensureSpecFinalizer(ctx context.Context, drp *web
 	if drp.Spec.Configuration.CloneFrom == "" && drp.Spec.Configuration.DiskSize == "" {
 		drp.Spec.Configuration.DiskSize = "2000Mi"
 	}
-	// Validate that CloneFrom is an existing DrupalSite
+	// Validate that CloneFrom is an existing DrupalSite that may be cloned
 	if drp.Spec.Configuration.CloneFrom != "" {
-		sourceSite := webservicesv1a1.DrupalSite{}
-		err := r.Get(ctx, types.NamespacedName{Name: string(drp.Spec.Configuration.CloneFrom), Namespace: drp.Namespace}, &sourceSite)
-		switch {
-		case k8sapierrors.IsNotFound(err):
-			return false, newApplicationError(fmt.Errorf("CloneFrom DrupalSite doesn't exist"), ErrInvalidSpec)
-		case err != nil:
-			return false, newApplicationError(err, ErrClientK8s)
+		sourceSite, err := r.validateCloneSource(ctx, drp)
+		if err != nil {
+			return false, err
 		}
 		// The destination disk size must be at least as large as the source
 		if drp.Spec.Configuration.DiskSize < sourceSite.Spec.Configuration.DiskSize {
@@ -645,6 +2091,16 @@ func (r *DrupalSiteReconciler) ensureSpecFinalizer(ctx context.Context, drp *web
 			drp.Spec.Configuration.ExtraConfigurationRepo = sourceSite.Spec.Configuration.ExtraConfigurationRepo
 		}
 	}
+	// Validate that the PriorityClass exists, else the pods of the site can't be created
+	if drp.Spec.Configuration.PriorityClassName != "" {
+		err := r.Get(ctx, types.NamespacedName{Name: drp.Spec.Configuration.PriorityClassName}, &schedulingv1.PriorityClass{})
+		switch {
+		case k8sapierrors.IsNotFound(err):
+			return false, newApplicationError(fmt.Errorf("PriorityClass %s doesn't exist", drp.Spec.Configuration.PriorityClassName), ErrInvalidSpec)
+		case err != nil:
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+	}
 	// Initialize 'spec.version.releaseSpec' if empty
 	if len(drp.Spec.Version.ReleaseSpec) == 0 {
 		if strings.HasPrefix(drp.Spec.Version.Name, "v8") {
@@ -661,6 +2117,38 @@ func (r *DrupalSiteReconciler) ensureSpecFinalizer(ctx context.Context, drp *web
 	return update, nil
 }
 
+// applyProjectDefaults sets the QoSClass and SiteURL of a site that doesn't specify them, from the defaults of its project
+func applyProjectDefaults(drp *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig) (update bool) {
+	if drp.Spec.QoSClass == "" {
+		drp.Spec.QoSClass = webservicesv1a1.QoSStandard
+		if dpc != nil && dpc.Spec.DefaultQoSClass != "" {
+			drp.Spec.QoSClass = dpc.Spec.DefaultQoSClass
+		}
+		update = true
+	}
+	// The URL is only defaulted before the site is published, as it must keep being served on the same URL after,
+	// and not while a demoted primary site waits for its new URL
+	_, demoted := drp.Annotations[demotedAnnotation]
+	if len(drp.Spec.SiteURL) == 0 && dpc != nil && dpc.Spec.DefaultDomain != "" && !drp.ConditionTrue("Initialized") && !demoted {
+		drp.Spec.SiteURL = []webservicesv1a1.Url{defaultSiteURL(drp, dpc)}
+		update = true
+	}
+	return update
+}
+
+// defaultSiteURL is the URL given to the site without one, in the defaultDomain of its DrupalProjectConfig:
+// `<namespace>.<defaultDomain>` for the primary site and `<name>-<namespace>.<defaultDomain>` for the others
+func defaultSiteURL(drp *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig) webservicesv1a1.Url {
+	if dpc == nil || dpc.Spec.DefaultDomain == "" {
+		return ""
+	}
+	host := drp.Name + "-" + drp.Namespace
+	if dpc.Spec.PrimarySiteName == drp.Name {
+		host = drp.Namespace
+	}
+	return webservicesv1a1.Url(host + "." + dpc.Spec.DefaultDomain)
+}
+
 // getRunningdeployment fetches the running drupal deployment
 func (r *DrupalSiteReconciler) getRunningdeployment(ctx context.Context, d *webservicesv1a1.DrupalSite) (*appsv1.Deployment, error) {
 	deployment := &appsv1.Deployment{}
@@ -669,8 +2157,8 @@ func (r *DrupalSiteReconciler) getRunningdeployment(ctx context.Context, d *webs
 }
 
 // didVersionRollOutSucceed checks if the deployment has rolled out the new pods successfully and the new pods are running
-func (r *DrupalSiteReconciler) didVersionRollOutSucceed(ctx context.Context, d *webservicesv1a1.DrupalSite) (requeue bool, err reconcileError) {
-	pod, err := r.getPodForVersion(ctx, d, releaseID(d))
+func (r *DrupalSiteReconciler) didVersionRollOutSucceed(ctx context.Context, d *webservicesv1a1.DrupalSite, releaseID string) (requeue bool, err reconcileError) {
+	pod, err := r.getPodForVersion(ctx, d, releaseID)
 	if err != nil && err.Temporary() {
 		return false, newApplicationError(err, ErrClientK8s)
 	}
@@ -678,8 +2166,7 @@ func (r *DrupalSiteReconciler) didVersionRollOutSucceed(ctx context.Context, d *
 		return false, newApplicationError(errors.New("pod did not roll out successfully"), ErrDeploymentUpdateFailed)
 	}
 	if pod.Status.Phase == corev1.PodPending {
-		currentTime := time.Now()
-		if currentTime.Sub(pod.GetCreationTimestamp().Time).Minutes() < getGracePeriodForPodToStartDuringUpgrade(d) {
+		if time.Since(pod.GetCreationTimestamp().Time) < getGracePeriodForPodToStartDuringUpgrade(d) {
 			return true, newApplicationError(errors.New("waiting for pod to start"), ErrPodNotRunning)
 		}
 		return false, newApplicationError(errors.New("pod failed to start after grace period"), ErrDeploymentUpdateFailed)
@@ -687,6 +2174,34 @@ func (r *DrupalSiteReconciler) didVersionRollOutSucceed(ctx context.Context, d *
 	return false, nil
 }
 
+// versionDrift returns the drift, if the site's running pods have been of an older release than `releaseID(d)` for longer than VersionDriftGracePeriod,
+// without an update in progress. The age of the drift is measured from the creation of the pods.
+func (r *DrupalSiteReconciler) versionDrift(ctx context.Context, d *webservicesv1a1.DrupalSite) (drift reconcileError, err reconcileError) {
+	if d.Annotations["updateInProgress"] == "true" || d.Annotations["upgradeQueued"] == "true" || d.ConditionTrue("CodeUpdateFailed") || updateUnapproved(d) != nil {
+		return nil, nil
+	}
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(d.Namespace), client.MatchingLabels{"drupalSite": d.Name, "app": "drupal"}); err != nil {
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	var stalePod *corev1.Pod
+	for i, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if pod.Annotations["releaseID"] == releaseID(d) {
+			return nil, nil
+		}
+		if stalePod == nil || pod.CreationTimestamp.Before(&stalePod.CreationTimestamp) {
+			stalePod = &podList.Items[i]
+		}
+	}
+	if stalePod == nil || time.Since(stalePod.CreationTimestamp.Time) < VersionDriftGracePeriod {
+		return nil, nil
+	}
+	return newApplicationError(fmt.Errorf("pod %s runs release %s instead of %s, without an update in progress", stalePod.Name, stalePod.Annotations["releaseID"], releaseID(d)), ErrDeploymentUpdateFailed), nil
+}
+
 // UpdateNeeded checks if a DB update is required based on the image tag and releaseID in the CR spec.
 // Only safe to call `if d.ConditionTrue("Ready") && d.ConditionTrue("Initialized")`
 func (r *DrupalSiteReconciler) codeUpdateNeeded(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
@@ -705,12 +2220,16 @@ func (r *DrupalSiteReconciler) codeUpdateNeeded(ctx context.Context, d *webservi
 // dbUpdateNeeded checks updbst to see if DB updates are needed
 // If there is an error, the return value is false
 func (r *DrupalSiteReconciler) dbUpdateNeeded(ctx context.Context, d *webservicesv1a1.DrupalSite) (bool, reconcileError) {
-	sout, err := r.execToServerPodErrOnStderr(ctx, d, "php-fpm", nil, checkUpdbStatus()...)
+	if d.ConditionTrue("Blocked") {
+		return false, nil
+	}
+	sout, err := r.execCheckToServerPod(ctx, d, "php-fpm", checkUpdbStatus()...)
 	if err != nil {
 		// When exec fails, we need to return false. Else it affects the other operations on the controller
 		// Returning true will also make local tests fails as execToPod is not possible to emulate
 		return false, newApplicationError(err, ErrPodExec)
 	}
+	d.Status.PendingDBUpdates = pendingDBUpdates(sout)
 	// DB table updates needed
 	if sout != "" {
 		return true, nil
@@ -719,6 +2238,19 @@ func (r *DrupalSiteReconciler) dbUpdateNeeded(ctx context.Context, d *webservice
 	return false, nil
 }
 
+// pendingDBUpdates counts the updates listed in the output of `check-updb-status.sh`, one per line.
+// The header and the borders of drush's table output are not counted
+func pendingDBUpdates(sout string) (count int) {
+	for _, line := range strings.Split(sout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "Module ") || strings.Trim(line, "-+ ") == "" {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
 // GetDeploymentCondition returns the condition with the provided type.
 func GetDeploymentCondition(status appsv1.DeploymentStatus, condType appsv1.DeploymentConditionType) *appsv1.DeploymentCondition {
 	for i := range status.Conditions {
@@ -756,17 +2288,25 @@ func (r *DrupalSiteReconciler) ensureUpdatedDeployment(ctx context.Context, d *w
 // 5. If there is a permanent unrecoverable error, the deployment is rolled back to the previous version
 // using the 'Failsafe' on the status and a 'CodeUpdateFailed' status is set on the CR
 func (r *DrupalSiteReconciler) updateDrupalVersion(ctx context.Context, d *webservicesv1a1.DrupalSite, deploymentConfig DeploymentConfig) (update bool, requeue bool, err reconcileError, errorMessage string) {
+	if err := r.reportUpdateStep(ctx, d, webservicesv1a1.UpdateStepRollingOutCode); err != nil {
+		return false, false, err, "%v while reporting the update step"
+	}
 	// Ensure the new deployment is rolledout
 	result, err := r.ensureUpdatedDeployment(ctx, d, deploymentConfig)
 	if err != nil {
 		return false, false, err, "%v while deploying the updated Drupal images of version"
 	}
+	if trafficRampActive(d) {
+		if err := r.ensureTrafficRamp(ctx, d, r.Log.WithValues("Request.Namespace", d.Namespace, "Request.Name", d.Name)); err != nil {
+			return false, false, err, "%v while shifting the traffic to the new release"
+		}
+	}
 
 	// Check the result of deployment update using ctrl.CreateOrUpdate
 	// If unchanged proceed to check if deployment succeeded, else reconcile
 	if result == controllerutil.OperationResultNone {
 		// Check if deployment has rolled out
-		requeue, err := r.didVersionRollOutSucceed(ctx, d)
+		requeue, err := r.didVersionRollOutSucceed(ctx, d, releaseID(d))
 		switch {
 		case err != nil:
 			if err.Temporary() {
@@ -776,6 +2316,7 @@ func (r *DrupalSiteReconciler) updateDrupalVersion(ctx context.Context, d *webse
 			} else {
 				setConditionStatus(d, "CodeUpdateFailed", true, err, false)
 				err.Wrap("%v: Failed to update version " + releaseID(d))
+				setUpdateStep(d, webservicesv1a1.UpdateStepRollingBack)
 				rollBackErr := r.rollBackCodeUpdate(ctx, d, deploymentConfig)
 				if rollBackErr != nil {
 					return false, false, rollBackErr, "Error while rolling back version"
@@ -791,6 +2332,9 @@ func (r *DrupalSiteReconciler) updateDrupalVersion(ctx context.Context, d *webse
 		return false, true, nil, ""
 	}
 
+	if err := r.reportUpdateStep(ctx, d, webservicesv1a1.UpdateStepClearingCache); err != nil {
+		return false, false, err, "%v while reporting the update step"
+	}
 	// Do a drush cr after the new deployment is rolled out. Try it a second time, in case of a failure during the first
 	sout, stderr := r.execToServerPodErrOnStderr(ctx, d, "php-fpm", nil, cacheReload()...)
 	if stderr != nil {
@@ -800,6 +2344,7 @@ func (r *DrupalSiteReconciler) updateDrupalVersion(ctx context.Context, d *webse
 		}
 	}
 	if sout != "" {
+		setUpdateStep(d, webservicesv1a1.UpdateStepRollingBack)
 		r.rollBackCodeUpdate(ctx, d, deploymentConfig)
 		setConditionStatus(d, "CodeUpdateFailed", true, newApplicationError(nil, errors.New("Error clearing cache")), false)
 		return true, false, nil, ""
@@ -820,28 +2365,137 @@ func (r *DrupalSiteReconciler) updateDrupalVersion(ctx context.Context, d *webse
 // 4. If any updates pending, set 'DBUpdatesPending' in the status, take DB backup, run 'drush updb',
 // 5. If there is a permanent unrecoverable error, restore the DB using the backup and set 'DBUpdateFailed' status
 // 6. If no error, remove the 'DBUpdatesPending' status and continue
-func (r *DrupalSiteReconciler) updateDBSchema(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (update bool) {
+// It reports if the DB update was attempted: it isn't while another one holds the lock, or if the update step can't be reported.
+func (r *DrupalSiteReconciler) updateDBSchema(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (update bool, attempted bool) {
+	// Only one DB update runs at a time on the site, eg if reconciliations race after a controller restart
+	release, acquired := r.acquireDBUpdateLock(ctx, d, log)
+	if !acquired {
+		return false, false
+	}
+	defer release()
+
 	// Take backup
 	backupFileName := "db_backup_update_rollback.sql"
+	if err := r.reportUpdateStep(ctx, d, webservicesv1a1.UpdateStepBackingUpDB); err != nil {
+		log.Error(err, fmt.Sprintf("%v failed to report the update step", err.Unwrap()))
+		return false, false
+	}
 	// We set Backup on "Drupal-data" so the DB backup is stored on the PV of the website
 	if _, err := r.execToServerPodErrOnStderr(ctx, d, "php-fpm", nil, takeBackup("/drupal-data/"+backupFileName)...); err != nil {
 		setConditionStatus(d, "DBUpdatesFailed", true, newApplicationError(err, ErrPodExec), false)
-		return true
+		return true, true
 	}
 
 	// Run updb
 	// The updb scripts, puts the site in maintenance mode, runs updb and removes the site from maintenance mode
-	_, err := r.execToServerPodErrOnStderr(ctx, d, "php-fpm", nil, runUpDBCommand()...)
-	if err != nil {
+	if err := r.reportUpdateStep(ctx, d, webservicesv1a1.UpdateStepRunningUpdb); err != nil {
+		log.Error(err, fmt.Sprintf("%v failed to report the update step", err.Unwrap()))
+		return false, false
+	}
+	stdout, stderr, err := r.execToServerPod(ctx, d, "php-fpm", nil, runUpDBCommand()...)
+	if err != nil || stderr != "" {
 		// Removing rollBackDBUpdate as we broken sites to keep up with updating
 		// We let the site administrators to rectify the problem manually
-		setConditionStatus(d, "DBUpdatesFailed", true, newApplicationError(err, ErrDBUpdateFailed), false)
-		return true
+		setConditionStatus(d, "DBUpdatesFailed", true, dbUpdateFailure(stdout, stderr, err), false)
+		return true, true
 	}
 	// DB update successful, remove conditions
 	update = d.Status.Conditions.RemoveCondition("DBUpdatesPending")
 	update = d.Status.Conditions.RemoveCondition("DBUpdatesFailed") || update
-	return
+	if d.Status.PendingDBUpdates != 0 {
+		d.Status.PendingDBUpdates = 0
+		update = true
+	}
+	return update, true
+}
+
+// runDBUpdate runs the DB update of the site, and removes the retryDBUpdateAnnotation once the DB update was attempted
+func (r *DrupalSiteReconciler) runDBUpdate(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (update bool, retried bool, attempted bool) {
+	update, attempted = r.updateDBSchema(ctx, d, log)
+	return update, attempted && unsetRetryDBUpdate(d), attempted
+}
+
+// updateClonedDBSchema runs the DB updates of a site cloned from a site on a different version, and removes its
+// CloneDBUpdatePending condition once they were attempted. It requeues while they can't be attempted, eg while another DB update holds the lock.
+func (r *DrupalSiteReconciler) updateClonedDBSchema(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (update bool, requeue bool) {
+	log.Info("Running the DB updates of the site cloned from a different version", "cloneFrom", d.Spec.Configuration.CloneFrom)
+	if _, attempted := r.updateDBSchema(ctx, d, log); !attempted {
+		return false, true
+	}
+	setUpdateStep(d, "")
+	d.Status.Conditions.RemoveCondition("CloneDBUpdatePending")
+	return true, false
+}
+
+// dbUpdateFailure returns the error of a failed `run-updb.sh`, with the end of its output for the DBUpdatesFailed condition message
+func dbUpdateFailure(stdout string, stderr string, err error) reconcileError {
+	return newApplicationError(errors.New(formatDrushOutput(runUpDBCommand(), stdout, stderr, err)), ErrDBUpdateFailed)
+}
+
+// dbUpdateLockName is the name of the ConfigMap that is held as a lock while a DB update runs on the site
+func dbUpdateLockName(d *webservicesv1a1.DrupalSite) string {
+	return "dbupdate-lock-" + d.Name
+}
+
+// acquireDBUpdateLock takes the DB update lock of the site by creating its ConfigMap, which fails if another DB update holds it.
+// A lock older than DBUpdateLockTimeout is taken over, so that a DB update interrupted before releasing it can't block the site forever.
+// The returned function releases the lock.
+func (r *DrupalSiteReconciler) acquireDBUpdateLock(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (release func(), acquired bool) {
+	newLock := func() *corev1.ConfigMap {
+		lock := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: dbUpdateLockName(d), Namespace: d.Namespace, Labels: labelsForDrupalSite(d.Name)}}
+		addOwnerRefToObject(lock, asOwner(d))
+		return lock
+	}
+	release = func() {
+		if err := r.Delete(ctx, newLock()); err != nil && !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to release the DB update lock")
+		}
+	}
+	err := r.Create(ctx, newLock())
+	switch {
+	case err == nil:
+		return release, true
+	case !k8sapierrors.IsAlreadyExists(err):
+		log.Error(err, "Failed to take the DB update lock")
+		return nil, false
+	}
+	heldLock := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: dbUpdateLockName(d), Namespace: d.Namespace}, heldLock); err != nil {
+		if !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to check the DB update lock")
+		}
+		return nil, false
+	}
+	if time.Since(heldLock.CreationTimestamp.Time) < DBUpdateLockTimeout {
+		log.V(3).Info("Another DB update is running on the site", "since", heldLock.CreationTimestamp)
+		return nil, false
+	}
+	// The UID precondition makes sure that only the expired lock is deleted, if another reconciliation takes it over first
+	log.Info("Taking over an expired DB update lock", "since", heldLock.CreationTimestamp)
+	if err := r.Delete(ctx, heldLock, client.Preconditions{UID: &heldLock.UID}); err != nil && !k8sapierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the expired DB update lock")
+		return nil, false
+	}
+	if err := r.Create(ctx, newLock()); err != nil {
+		if !k8sapierrors.IsAlreadyExists(err) {
+			log.Error(err, "Failed to take the DB update lock")
+		}
+		return nil, false
+	}
+	return release, true
+}
+
+// reportUpdateStep updates the DrupalSite status with the step of the update process that is about to run,
+// so that it is visible while the step is running
+func (r *DrupalSiteReconciler) reportUpdateStep(ctx context.Context, d *webservicesv1a1.DrupalSite, step webservicesv1a1.UpdateStep) reconcileError {
+	if !setUpdateStep(d, step) {
+		return nil
+	}
+	summarizeStatus(d)
+	if err := r.Status().Update(ctx, d); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
 }
 
 // rollBackCodeUpdate rolls back the code update process to the previous version when it is called
@@ -860,6 +2514,25 @@ func (r *DrupalSiteReconciler) rollBackCodeUpdate(ctx context.Context, d *webser
 	return nil
 }
 
+// verifyRollback checks that the failsafe version, which a failed code update rolled back to, rolls out.
+// If it doesn't, the site is down: the RollbackFailed condition is set and a warning event is emitted.
+func (r *DrupalSiteReconciler) verifyRollback(ctx context.Context, d *webservicesv1a1.DrupalSite) (update bool, requeue bool) {
+	requeue, err := r.didVersionRollOutSucceed(ctx, d, d.Status.ReleaseID.Failsafe)
+	switch {
+	case requeue:
+		return false, true
+	case err != nil && err.Temporary():
+		return false, true
+	case err != nil:
+		if !setConditionStatus(d, "RollbackFailed", true, err.Wrap("%v: rolling back to "+d.Status.ReleaseID.Failsafe), false) {
+			return false, false
+		}
+		r.Recorder.Eventf(d, corev1.EventTypeWarning, "RollbackFailed", "The rollback to the failsafe version %s failed, the site is down: %v", d.Status.ReleaseID.Failsafe, err)
+		return true, false
+	}
+	return d.Status.Conditions.RemoveCondition("RollbackFailed"), false
+}
+
 // rollBackDBUpdate rolls back the DB update process to the previous version of the database from the backup
 func (r *DrupalSiteReconciler) rollBackDBUpdate(ctx context.Context, d *webservicesv1a1.DrupalSite, backupFileName string) reconcileError {
 	// Restore the database backup
@@ -939,3 +2612,140 @@ func (r *DrupalSiteReconciler) checkIfPrimaryDrupalsite(ctx context.Context, drp
 	}
 	return false
 }
+
+// promoteToPrimary makes the DrupalSite the primary site of the project, if it has the promoteAnnotation.
+// The SiteURLs of this site and the former primary site are swapped, the productionLabel moves to this site,
+// and the DrupalProjectConfig points to this site.
+// Each step is a single idempotent update, so that an interrupted promotion resumes where it stopped.
+// No two sites claim the same SiteURL at any step, and the steps that only need this site complete
+// even if the former primary site is deleted half-way:
+// 1. this site remembers its own SiteURL and the one of the former primary site in annotations
+// 2. the former primary site is demoted: it loses its SiteURL and the productionLabel
+// 3. this site takes the SiteURL of the former primary site and the productionLabel
+// 4. the former primary site takes the former SiteURL of this site
+// 5. the DrupalProjectConfig points to this site
+// 6. the annotations are removed
+// It returns true if any object was updated, in which case the reconciliation should be requeued.
+func (r *DrupalSiteReconciler) promoteToPrimary(ctx context.Context, drp *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig, log logr.Logger) (update bool, reconcileErr reconcileError) {
+	if _, promote := drp.Annotations[promoteAnnotation]; !promote {
+		return false, nil
+	}
+	if dpc == nil {
+		return false, newApplicationError(errors.New("promoting a DrupalSite to primary requires a DrupalProjectConfig in the project"), ErrInvalidSpec)
+	}
+
+	// 6. Promotion complete
+	if dpc.Spec.PrimarySiteName == drp.Name {
+		log.Info("DrupalSite promoted to primary site of the project")
+		delete(drp.Annotations, promoteAnnotation)
+		delete(drp.Annotations, promoteSiteURLAnnotation)
+		delete(drp.Annotations, promotePrimarySiteURLAnnotation)
+		if err := r.Update(ctx, drp); err != nil {
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+		return true, nil
+	}
+
+	drupalSiteList := &webservicesv1a1.DrupalSiteList{}
+	if err := r.List(ctx, drupalSiteList, &client.ListOptions{Namespace: drp.Namespace}); err != nil {
+		return false, newApplicationError(errors.New("fetching drupalSiteList failed"), ErrClientK8s)
+	}
+	var formerPrimary *webservicesv1a1.DrupalSite
+	for i, site := range drupalSiteList.Items {
+		if site.Name == drp.Name {
+			continue
+		}
+		if _, promote := site.Annotations[promoteAnnotation]; promote {
+			return false, newApplicationError(fmt.Errorf("DrupalSite %s is also being promoted, only one DrupalSite can be the primary site of the project", site.Name), ErrInvalidSpec)
+		}
+		if site.Name == dpc.Spec.PrimarySiteName && site.DeletionTimestamp == nil {
+			formerPrimary = &drupalSiteList.Items[i]
+		}
+	}
+
+	// 1. Remember the SiteURLs to swap
+	ownSiteURL, urlsSaved := drp.Annotations[promoteSiteURLAnnotation]
+	if !urlsSaved && formerPrimary != nil {
+		log.Info("Preparing to take over the SiteURL of the former primary site", "DrupalSite", formerPrimary.Name)
+		drp.Annotations[promoteSiteURLAnnotation] = joinSiteURLs(drp.Spec.SiteURL)
+		drp.Annotations[promotePrimarySiteURLAnnotation] = joinSiteURLs(formerPrimary.Spec.SiteURL)
+		if err := r.Update(ctx, drp); err != nil {
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+		return true, nil
+	}
+	formerSiteURL := splitSiteURLs(ownSiteURL)
+
+	// 2. Demote the former primary site, so that its SiteURL is free
+	if formerPrimary != nil && formerPrimary.Annotations[demotedAnnotation] != drp.Name && joinSiteURLs(formerPrimary.Spec.SiteURL) != joinSiteURLs(formerSiteURL) {
+		log.Info("Demoting the former primary site", "DrupalSite", formerPrimary.Name)
+		if formerPrimary.Annotations == nil {
+			formerPrimary.Annotations = map[string]string{}
+		}
+		formerPrimary.Annotations[demotedAnnotation] = drp.Name
+		delete(formerPrimary.Labels, productionLabel)
+		formerPrimary.Spec.SiteURL = nil
+		if err := r.Update(ctx, formerPrimary); err != nil {
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+		return true, nil
+	}
+
+	// 3. Take over the SiteURL of the former primary site and the productionLabel
+	primarySiteURL := drp.Spec.SiteURL
+	if urlsSaved {
+		if saved := splitSiteURLs(drp.Annotations[promotePrimarySiteURLAnnotation]); len(saved) > 0 {
+			primarySiteURL = saved
+		}
+	}
+	if joinSiteURLs(drp.Spec.SiteURL) != joinSiteURLs(primarySiteURL) || drp.Labels[productionLabel] != "true" {
+		log.Info("Taking over the SiteURL and the production label of the former primary site")
+		if drp.Labels == nil {
+			drp.Labels = map[string]string{}
+		}
+		drp.Labels[productionLabel] = "true"
+		drp.Spec.SiteURL = primarySiteURL
+		if err := r.Update(ctx, drp); err != nil {
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+		return true, nil
+	}
+
+	// 4. Hand over the former SiteURL of this site to the former primary site
+	if formerPrimary != nil && formerPrimary.Annotations[demotedAnnotation] == drp.Name {
+		log.Info("Handing over the former SiteURL of this site to the former primary site", "DrupalSite", formerPrimary.Name)
+		delete(formerPrimary.Annotations, demotedAnnotation)
+		formerPrimary.Spec.SiteURL = formerSiteURL
+		if err := r.Update(ctx, formerPrimary); err != nil {
+			return false, newApplicationError(err, ErrClientK8s)
+		}
+		return true, nil
+	}
+
+	// 5. Point the DrupalProjectConfig to this site
+	dpc.Spec.PrimarySiteName = drp.Name
+	if err := r.updateDrupalProjectConfigCR(ctx, log, dpc); err != nil {
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	return true, nil
+}
+
+// joinSiteURLs serializes SiteURLs in an annotation
+func joinSiteURLs(siteURL []webservicesv1a1.Url) string {
+	urls := make([]string, 0, len(siteURL))
+	for _, url := range siteURL {
+		urls = append(urls, string(url))
+	}
+	return strings.Join(urls, ",")
+}
+
+// splitSiteURLs parses the SiteURLs serialized by joinSiteURLs
+func splitSiteURLs(annotation string) []webservicesv1a1.Url {
+	siteURL := []webservicesv1a1.Url{}
+	for _, url := range strings.Split(annotation, ",") {
+		if url != "" {
+			siteURL = append(siteURL, webservicesv1a1.Url(url))
+		}
+	}
+	return siteURL
+}
@@ -35,6 +35,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -411,6 +412,18 @@ var _ = Describe("DrupalSite controller", func() {
 					return cr.Annotations["updateInProgress"] == "true"
 				}, timeout, interval).Should(BeTrue())
 
+				By("Expecting the update step to be reported on the drupalSiteObject status")
+				Eventually(func() drupalwebservicesv1alpha1.UpdateStep {
+					k8sClient.Get(ctx, key, &cr)
+					return cr.Status.UpdateStep
+				}, timeout, interval).Should(Equal(drupalwebservicesv1alpha1.UpdateStepRollingOutCode))
+
+				By("Expecting the release and the update on the status")
+				Eventually(func() bool {
+					k8sClient.Get(ctx, key, &cr)
+					return cr.Status.CurrentRelease == newVersion+"-"+newReleaseSpec && cr.Status.UpdateInProgress
+				}, timeout, interval).Should(BeTrue())
+
 				// Check the annotation on the deployment
 				By("Expecting the new drupal Version on the pod annotation")
 				Eventually(func() bool {
@@ -720,11 +733,67 @@ var _ = Describe("DrupalSite controller", func() {
 	Describe("Deleting the drupalsite object", func() {
 		Context("With basic spec", func() {
 			It("Should be deleted successfully", func() {
+				// Create a backup that is still in progress when the site gets deleted
+				hash := md5.Sum([]byte(key.Namespace))
+				backup := velerov1.Backup{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: "velero.io/v1",
+						Kind:       "Backup",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      key.Name + "backup-leftover",
+						Namespace: veleroNamespace,
+						Labels: map[string]string{
+							"drupal.webservices.cern.ch/projectHash": hex.EncodeToString(hash[:]),
+							"drupal.webservices.cern.ch/project":     key.Namespace,
+							"drupal.webservices.cern.ch/drupalSite":  key.Name,
+						},
+					},
+					Status: velerov1.BackupStatus{
+						Phase: velerov1.BackupPhaseInProgress,
+					},
+				}
+				By("By creating a backup resource in progress for the drupalSite")
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &backup)
+				}, timeout, interval).Should(Succeed())
+
 				By("Expecting to delete successfully")
 				Eventually(func() error {
 					return k8sClient.Delete(context.Background(), drupalSiteObject)
 				}, timeout, interval).Should(Succeed())
 
+				By("Expecting a deletion request for the completed backups, and the Database to be deleted")
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "backup", Namespace: veleroNamespace}, &velerov1.DeleteBackupRequest{})
+				}, timeout, interval).Should(Succeed())
+				// velero deletes the backup once it processed the request
+				Expect(k8sClient.Delete(ctx, &velerov1.Backup{ObjectMeta: metav1.ObjectMeta{Name: key.Name + "backup", Namespace: veleroNamespace}})).To(Succeed())
+				Eventually(func() bool {
+					return k8sapierrors.IsNotFound(k8sClient.Get(ctx, key, &dbodv1a1.Database{}))
+				}, timeout, interval).Should(BeTrue())
+
+				By("Expecting the drupalSite to wait for the backup in progress")
+				Consistently(func() error {
+					return k8sClient.Get(ctx, key, &drupalwebservicesv1alpha1.DrupalSite{})
+				}, time.Second*3, interval).Should(Succeed())
+
+				By("Finishing the backup in progress")
+				Eventually(func() error {
+					k8sClient.Get(ctx, types.NamespacedName{Name: backup.Name, Namespace: veleroNamespace}, &backup)
+					backup.Status.Phase = velerov1.BackupPhaseCompleted
+					return k8sClient.Update(ctx, &backup)
+				}, timeout, interval).Should(Succeed())
+
+				By("Expecting a deletion request for the finished backup")
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: backup.Name, Namespace: veleroNamespace}, &velerov1.DeleteBackupRequest{})
+				}, timeout, interval).Should(Succeed())
+				Consistently(func() error {
+					return k8sClient.Get(ctx, key, &drupalwebservicesv1alpha1.DrupalSite{})
+				}, time.Second*3, interval).Should(Succeed())
+				Expect(k8sClient.Delete(ctx, &velerov1.Backup{ObjectMeta: metav1.ObjectMeta{Name: backup.Name, Namespace: veleroNamespace}})).To(Succeed())
+
 				By("Expecting to delete finish")
 				Eventually(func() error {
 					return k8sClient.Get(ctx, key, drupalSiteObject)
@@ -1170,6 +1239,52 @@ var _ = Describe("DrupalSite controller", func() {
 				}, timeout, interval).ShouldNot(Succeed())
 			})
 		})
+		Context("Labelled as production", func() {
+			It("Should only be deleted once the deletion is confirmed", func() {
+				key = types.NamespacedName{
+					Name:      Name + "-production",
+					Namespace: Namespace,
+				}
+				drupalSiteObject.ObjectMeta = metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+					Labels:    map[string]string{productionLabel: "true"},
+				}
+				drupalSiteObject.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"production.webtest.cern.ch"}
+				By("By creating the drupalSite and waiting for its finalizer")
+				Eventually(func() error {
+					return k8sClient.Create(ctx, drupalSiteObject)
+				}, timeout, interval).Should(Succeed())
+				cr := drupalwebservicesv1alpha1.DrupalSite{}
+				Eventually(func() []string {
+					k8sClient.Get(ctx, key, &cr)
+					return cr.Finalizers
+				}, timeout, interval).Should(ContainElement(finalizerStr))
+
+				By("Expecting the deletion to be blocked without the annotation")
+				Expect(k8sClient.Delete(ctx, &cr)).To(Succeed())
+				Eventually(func() bool {
+					k8sClient.Get(ctx, key, &cr)
+					return cr.ConditionTrue("DeletionBlocked")
+				}, timeout, interval).Should(BeTrue())
+				Consistently(func() error {
+					return k8sClient.Get(ctx, key, &cr)
+				}, 2*time.Second, interval).Should(Succeed())
+
+				By("Expecting the deletion to proceed with the annotation")
+				Eventually(func() error {
+					k8sClient.Get(ctx, key, &cr)
+					if cr.Annotations == nil {
+						cr.Annotations = map[string]string{}
+					}
+					cr.Annotations[confirmDeleteAnnotation] = key.Name
+					return k8sClient.Update(ctx, &cr)
+				}, timeout, interval).Should(Succeed())
+				Eventually(func() error {
+					return k8sClient.Get(ctx, key, &cr)
+				}, timeout, interval).ShouldNot(Succeed())
+			})
+		})
 	})
 
 	Describe("Creating a drupalSite object", func() {
@@ -1337,6 +1452,16 @@ var _ = Describe("DrupalSite controller", func() {
 					return k8sClient.Status().Update(ctx, &cr)
 				}, timeout, interval).Should(Succeed())
 
+				// Update deployment status fields to allow the 'Serving' status field to be set on the drupalSite resource
+				By("Updating 'ReadyReplicas' and 'AvailableReplicas' status fields in deployment resource")
+				Eventually(func() error {
+					k8sClient.Get(ctx, key, &deploy)
+					deploy.Status.Replicas = 1
+					deploy.Status.AvailableReplicas = 1
+					deploy.Status.ReadyReplicas = 1
+					return k8sClient.Status().Update(ctx, &deploy)
+				}, timeout, interval).Should(Succeed())
+
 				// Check Routes
 				By("Expecting Drupal Route(s) to be created")
 				for _, url := range cr.Spec.SiteURL {
@@ -1608,9 +1733,9 @@ var _ = Describe("DrupalSite controller", func() {
 				By("Updating 'ReadyReplicas' and 'AvailableReplicas' status fields in deployment resource")
 				Eventually(func() error {
 					k8sClient.Get(ctx, key, &deploy)
-					deploy.Status.Replicas = 1
-					deploy.Status.AvailableReplicas = 1
-					deploy.Status.ReadyReplicas = 1
+					deploy.Status.Replicas = 3
+					deploy.Status.AvailableReplicas = 3
+					deploy.Status.ReadyReplicas = 3
 					return k8sClient.Status().Update(ctx, &deploy)
 				}, timeout, interval).Should(Succeed())
 
@@ -1761,9 +1886,9 @@ var _ = Describe("DrupalSite controller", func() {
 				By("Updating 'ReadyReplicas' and 'AvailableReplicas' status fields in deployment resource")
 				Eventually(func() error {
 					k8sClient.Get(ctx, key, &deploy)
-					deploy.Status.Replicas = 1
-					deploy.Status.AvailableReplicas = 1
-					deploy.Status.ReadyReplicas = 1
+					deploy.Status.Replicas = 3
+					deploy.Status.AvailableReplicas = 3
+					deploy.Status.ReadyReplicas = 3
 					return k8sClient.Status().Update(ctx, &deploy)
 				}, timeout, interval).Should(Succeed())
 
@@ -1957,6 +2082,217 @@ var _ = Describe("DrupalSite controller", func() {
 			})
 		})
 	})
+	Describe("Promoting a drupalSite to primary", func() {
+		Context("With a DrupalProjectConfig pointing to another site", func() {
+			It("Should swap the siteUrls and update the DrupalProjectConfig", func() {
+				key = types.NamespacedName{
+					Name:      Name + "-promote",
+					Namespace: "promote",
+				}
+				primaryKey := types.NamespacedName{Name: Name + "-primary", Namespace: key.Namespace}
+				newSite := func(name string, url drupalwebservicesv1alpha1.Url) *drupalwebservicesv1alpha1.DrupalSite {
+					return &drupalwebservicesv1alpha1.DrupalSite{
+						TypeMeta: metav1.TypeMeta{
+							APIVersion: "drupal.webservices.cern.ch/v1alpha1",
+							Kind:       "DrupalSite",
+						},
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      name,
+							Namespace: key.Namespace,
+						},
+						Spec: drupalwebservicesv1alpha1.DrupalSiteSpec{
+							Version: drupalwebservicesv1alpha1.Version{
+								Name:        "v8.9-1",
+								ReleaseSpec: "stable",
+							},
+							Configuration: drupalwebservicesv1alpha1.Configuration{
+								DiskSize:      "10Gi",
+								QoSClass:      drupalwebservicesv1alpha1.QoSStandard,
+								DatabaseClass: drupalwebservicesv1alpha1.DBODStandard,
+							},
+							SiteURL: []drupalwebservicesv1alpha1.Url{url},
+						},
+					}
+				}
+
+				By("By creating the namespace, the DrupalProjectConfig and the drupalSites")
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: key.Namespace}})
+				}, timeout, interval).Should(Succeed())
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &drupalwebservicesv1alpha1.DrupalProjectConfig{
+						ObjectMeta: metav1.ObjectMeta{Name: key.Namespace, Namespace: key.Namespace},
+						Spec:       drupalwebservicesv1alpha1.DrupalProjectConfigSpec{PrimarySiteName: primaryKey.Name},
+					})
+				}, timeout, interval).Should(Succeed())
+				Eventually(func() error {
+					primary := newSite(primaryKey.Name, "promote.webtest.cern.ch")
+					primary.Labels = map[string]string{productionLabel: "true"}
+					return k8sClient.Create(ctx, primary)
+				}, timeout, interval).Should(Succeed())
+				Eventually(func() error {
+					return k8sClient.Create(ctx, newSite(key.Name, "clone-promote.webtest.cern.ch"))
+				}, timeout, interval).Should(Succeed())
+
+				By("Expecting the primary drupalSite to be marked as primary")
+				cr := drupalwebservicesv1alpha1.DrupalSite{}
+				Eventually(func() bool {
+					k8sClient.Get(ctx, primaryKey, &cr)
+					return cr.Status.IsPrimary
+				}, timeout, interval).Should(BeTrue())
+
+				By("Adding the promotion annotation to the clone")
+				Eventually(func() error {
+					k8sClient.Get(ctx, key, &cr)
+					if cr.Annotations == nil {
+						cr.Annotations = map[string]string{}
+					}
+					cr.Annotations[promoteAnnotation] = "true"
+					return k8sClient.Update(ctx, &cr)
+				}, timeout, interval).Should(Succeed())
+
+				By("Expecting the clone to be promoted with the primary siteUrl")
+				Eventually(func() bool {
+					k8sClient.Get(ctx, key, &cr)
+					_, promoting := cr.Annotations[promoteAnnotation]
+					return !promoting && cr.Status.IsPrimary
+				}, timeout, interval).Should(BeTrue())
+				Expect(cr.Spec.SiteURL).To(Equal([]drupalwebservicesv1alpha1.Url{"promote.webtest.cern.ch"}))
+				Expect(cr.Labels).To(HaveKeyWithValue(productionLabel, "true"))
+				Expect(cr.Annotations).NotTo(HaveKey(promoteSiteURLAnnotation))
+				Expect(cr.Annotations).NotTo(HaveKey(promotePrimarySiteURLAnnotation))
+
+				By("Expecting the former primary to be demoted with the clone siteUrl")
+				Eventually(func() bool {
+					k8sClient.Get(ctx, primaryKey, &cr)
+					return !cr.Status.IsPrimary
+				}, timeout, interval).Should(BeTrue())
+				Expect(cr.Spec.SiteURL).To(Equal([]drupalwebservicesv1alpha1.Url{"clone-promote.webtest.cern.ch"}))
+				Expect(cr.Labels).NotTo(HaveKey(productionLabel))
+				Expect(cr.Annotations).NotTo(HaveKey(demotedAnnotation))
+
+				dpc := drupalwebservicesv1alpha1.DrupalProjectConfig{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Namespace, Namespace: key.Namespace}, &dpc)).To(Succeed())
+				Expect(dpc.Spec.PrimarySiteName).To(Equal(key.Name))
+			})
+		})
+		Context("With a former primary site deleted half-way through the promotion", func() {
+			It("Should complete the promotion", func() {
+				key = types.NamespacedName{
+					Name:      Name + "-promote-recover",
+					Namespace: "promote-recover",
+				}
+
+				By("By creating the namespace, a DrupalProjectConfig pointing to a deleted site and the drupalSite being promoted")
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: key.Namespace}})
+				}, timeout, interval).Should(Succeed())
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &drupalwebservicesv1alpha1.DrupalProjectConfig{
+						ObjectMeta: metav1.ObjectMeta{Name: key.Namespace, Namespace: key.Namespace},
+						Spec:       drupalwebservicesv1alpha1.DrupalProjectConfigSpec{PrimarySiteName: Name + "-deleted"},
+					})
+				}, timeout, interval).Should(Succeed())
+				// The URLs were saved before the former primary site was deleted
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &drupalwebservicesv1alpha1.DrupalSite{
+						TypeMeta: metav1.TypeMeta{
+							APIVersion: "drupal.webservices.cern.ch/v1alpha1",
+							Kind:       "DrupalSite",
+						},
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      key.Name,
+							Namespace: key.Namespace,
+							Annotations: map[string]string{
+								promoteAnnotation:               "true",
+								promoteSiteURLAnnotation:        "clone-promote-recover.webtest.cern.ch",
+								promotePrimarySiteURLAnnotation: "promote-recover.webtest.cern.ch",
+							},
+						},
+						Spec: drupalwebservicesv1alpha1.DrupalSiteSpec{
+							Version: drupalwebservicesv1alpha1.Version{
+								Name:        "v8.9-1",
+								ReleaseSpec: "stable",
+							},
+							Configuration: drupalwebservicesv1alpha1.Configuration{
+								DiskSize:      "10Gi",
+								QoSClass:      drupalwebservicesv1alpha1.QoSStandard,
+								DatabaseClass: drupalwebservicesv1alpha1.DBODStandard,
+							},
+							SiteURL: []drupalwebservicesv1alpha1.Url{"clone-promote-recover.webtest.cern.ch"},
+						},
+					})
+				}, timeout, interval).Should(Succeed())
+
+				By("Expecting the drupalSite to be promoted with the saved siteUrl")
+				cr := drupalwebservicesv1alpha1.DrupalSite{}
+				Eventually(func() bool {
+					k8sClient.Get(ctx, key, &cr)
+					_, promoting := cr.Annotations[promoteAnnotation]
+					return !promoting && cr.Status.IsPrimary
+				}, timeout, interval).Should(BeTrue())
+				Expect(cr.Spec.SiteURL).To(Equal([]drupalwebservicesv1alpha1.Url{"promote-recover.webtest.cern.ch"}))
+				Expect(cr.Labels).To(HaveKeyWithValue(productionLabel, "true"))
+				Expect(cr.Annotations).NotTo(HaveKey(promotePrimarySiteURLAnnotation))
+			})
+		})
+	})
+	Describe("Creating a drupalSite object with an administrator account", func() {
+		Context("With admin accounts enabled, as by default", func() {
+			BeforeEach(func() {
+				EnableAdminAccount = true
+			})
+			AfterEach(func() {
+				EnableAdminAccount = false
+			})
+			It("Should pass the administrator account to the site install job", func() {
+				adminKey := types.NamespacedName{Name: Name + "-admin-account", Namespace: Namespace}
+				site := drupalSiteObject.DeepCopy()
+				site.ObjectMeta = metav1.ObjectMeta{Name: adminKey.Name, Namespace: adminKey.Namespace}
+				site.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-admin-account.webtest.cern.ch"}
+				site.Spec.Configuration.AdminAccount = &drupalwebservicesv1alpha1.AdminAccount{Name: "webmaster"}
+
+				By("By creating a new drupalSite")
+				Eventually(func() error {
+					return k8sClient.Create(ctx, site)
+				}, timeout, interval).Should(Succeed())
+
+				By("Updating DBOD instance in Database resource status")
+				dbod := dbodv1a1.Database{}
+				Eventually(func() error {
+					if err := k8sClient.Get(ctx, adminKey, &dbod); err != nil {
+						return err
+					}
+					dbod.Status.DbodInstance = "test"
+					return k8sClient.Status().Update(ctx, &dbod)
+				}, timeout, interval).Should(Succeed())
+
+				By("Expecting the admin account Secret and the site install job to be created")
+				secret := corev1.Secret{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: "admin-account-" + adminKey.Name, Namespace: adminKey.Namespace}, &secret)
+				}, timeout, interval).Should(Succeed())
+				job := batchv1.Job{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: "ensure-site-install-" + adminKey.Name, Namespace: adminKey.Namespace}, &job)
+				}, timeout, interval).Should(Succeed())
+				env := map[string]corev1.EnvVar{}
+				for _, envVar := range job.Spec.Template.Spec.Containers[0].Env {
+					env[envVar.Name] = envVar
+				}
+				Expect(env).To(HaveKey("DRUPAL_ADMIN_NAME"))
+				Expect(env["DRUPAL_ADMIN_NAME"].Value).To(Equal("webmaster"))
+				Expect(env).To(HaveKey("DRUPAL_ADMIN_PASSWORD"))
+				Expect(env["DRUPAL_ADMIN_PASSWORD"].ValueFrom.SecretKeyRef.Name).To(Equal(secret.Name))
+
+				By("Expecting to delete successfully")
+				Expect(k8sClient.Delete(ctx, site)).To(Succeed())
+				Eventually(func() error {
+					return k8sClient.Get(ctx, adminKey, site)
+				}, timeout, interval).ShouldNot(Succeed())
+			})
+		})
+	})
 	Describe("TODO Using DrupalProjectConfig", func() {
 		Context("", func() {
 			It("", func() {
@@ -19,15 +19,20 @@ package controllers
 import (
 	"context"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
+	"math"
 	"net/url"
 	"path"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -43,6 +48,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -58,15 +64,50 @@ import (
 
 // Const vars
 const (
+	// cloneProgressFile is where the rsync clone reports its progress, in the temporary folder of the clone job
+	cloneProgressFile string = "clone-progress.log"
+	// siteBackupsDeletionTimeout bounds how long a deleted site waits for its backups to be deleted, eg while a backup is stuck in progress
+	siteBackupsDeletionTimeout time.Duration = 2 * time.Hour
 	// Variable used to define Default WebDAV login Username
 	webDAVDefaultLogin string = "admin"
 	// Variable to set the used Memory for all Jobs generated by the Operator
 	jobMemoryRequest string = "512Mi"
+	// logsDirectory is the emptyDir where the server containers write their log files
+	logsDirectory string = "/var/run"
+	// defaultLogRotationMaxSize and defaultLogRotationRetention apply to a log rotation of the config override that doesn't set them
+	defaultLogRotationMaxSize   string        = "100Mi"
+	defaultLogRotationRetention time.Duration = 24 * time.Hour
+	// extraNginxConfigKey is the key of the nginx ConfigMap with the `extraNginxConfig` of a site
+	extraNginxConfigKey string = "server.conf"
+	// extraNginxConfigPath is where nginx reads the `extraNginxConfig` of a site from. The server block of the nginx image includes `/etc/nginx/custom.d/*.conf`
+	extraNginxConfigPath string = "/etc/nginx/custom.d/extra-nginx-config.conf"
+	// extraSettingsVolume is the volume of the `extraSettingsConfigMap` of a site
+	extraSettingsVolume string = "extra-settings-php"
+	// backupExcludedVolume is the volume that holds the `backupExcludedPaths` of a site, outside of its backed up volume
+	backupExcludedVolume string = "backup-excluded"
+	// backupExcludedVolumeSize is the size of the claim of the backupExcludedVolume
+	backupExcludedVolumeSize string = "2Gi"
 )
 
+// backupExcludablePaths are the paths of the shared volume that can be left out of the backups, since Drupal regenerates their files:
+// the aggregated CSS and JS, and the compiled Twig templates
+var backupExcludablePaths = map[string]bool{
+	"files/css": true,
+	"files/js":  true,
+	"files/php": true,
+}
+
 var (
 	// BuildResources are the resource requests/limits for the image builds. Set during initEnv()
 	BuildResources corev1.ResourceRequirements
+	// NginxResources, PhpFpmResources, PhpFpmExporterResources and WebDAVResources override the QoS class defaults
+	// of the respective server deployment containers, if set. Set during initEnv()
+	NginxResources          corev1.ResourceRequirements
+	PhpFpmResources         corev1.ResourceRequirements
+	PhpFpmExporterResources corev1.ResourceRequirements
+	WebDAVResources         corev1.ResourceRequirements
+	// BackupTTL is the duration after which the scheduled backups are deleted
+	BackupTTL time.Duration
 )
 
 // execToServerPod executes a command to the first running server pod of the Drupal site.
@@ -90,7 +131,7 @@ func (r *DrupalSiteReconciler) execToServerPod(ctx context.Context, d *webservic
 	if err != nil {
 		return "", "", err
 	}
-	return execToPodThroughAPI(containerName, pod.Name, d.Namespace, stdin, command...)
+	return execToPod(containerName, pod.Name, d.Namespace, stdin, command...)
 }
 
 // getRunningPodForVersion fetches the list of the running pods for the current deployment and returns the first one from the list
@@ -135,6 +176,36 @@ func (r *DrupalSiteReconciler) execToServerPodErrOnStderr(ctx context.Context, d
 	return stdout, nil
 }
 
+// execCacheKey is the context key of the checks memoized during a reconciliation, see withExecCache
+type execCacheKey struct{}
+
+// execResult is the memoized result of a check run in the server pod
+type execResult struct {
+	stdout string
+	err    error
+}
+
+// withExecCache returns a context in which the checks run with execCheckToServerPod are memoized.
+// Each reconciliation gets its own, so that repeated checks within the same pass exec into the pod only once.
+func withExecCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, execCacheKey{}, map[string]execResult{})
+}
+
+// execCheckToServerPod runs a read-only check in the server pod like execToServerPodErrOnStderr.
+// With a context from withExecCache, an identical check on the same releaseID is only run once.
+func (r *DrupalSiteReconciler) execCheckToServerPod(ctx context.Context, d *webservicesv1a1.DrupalSite, containerName string, command ...string) (stdout string, err error) {
+	cache, _ := ctx.Value(execCacheKey{}).(map[string]execResult)
+	key := strings.Join(append([]string{d.Namespace, d.Name, releaseID(d), containerName}, command...), " ")
+	if result, cached := cache[key]; cached {
+		return result.stdout, result.err
+	}
+	stdout, err = r.execToServerPodErrOnStderr(ctx, d, containerName, nil, command...)
+	if cache != nil {
+		cache[key] = execResult{stdout: stdout, err: err}
+	}
+	return stdout, err
+}
+
 func (r *DrupalSiteReconciler) getDeployConfigmap(ctx context.Context, d *webservicesv1a1.DrupalSite) (deploy appsv1.Deployment,
 	cmPhp corev1.ConfigMap, cmNginxGlobal corev1.ConfigMap, cmSettings corev1.ConfigMap, cmPhpCli corev1.ConfigMap, err error) {
 	err = r.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, &deploy)
@@ -153,7 +224,7 @@ func (r *DrupalSiteReconciler) getDeployConfigmap(ctx context.Context, d *webser
 	if err != nil {
 		return
 	}
-	err = r.Get(ctx, types.NamespacedName{Name: "php-cli-config-" + d.Name, Namespace: d.Namespace}, &cmSettings)
+	err = r.Get(ctx, types.NamespacedName{Name: "php-cli-config-" + d.Name, Namespace: d.Namespace}, &cmPhpCli)
 	return
 }
 
@@ -167,6 +238,12 @@ func (r *DrupalSiteReconciler) ensureDeploymentConfigmapHash(ctx context.Context
 	case err != nil:
 		return false, newApplicationError(err, ErrClientK8s)
 	}
+	cmExtraSettings := corev1.ConfigMap{}
+	if configMap := d.Spec.Configuration.ExtraSettingsConfigMap; configMap != "" {
+		if err := r.Get(ctx, types.NamespacedName{Name: configMap, Namespace: d.Namespace}, &cmExtraSettings); err != nil {
+			return false, newApplicationError(fmt.Errorf("extraSettingsConfigMap %q: %w", configMap, err), ErrClientK8s)
+		}
+	}
 	updateDeploymentAnnotations := func(deploy *appsv1.Deployment, d *webservicesv1a1.DrupalSite) error {
 		hashPhp := md5.Sum([]byte(createKeyValuePairs(cmPhp.Data)))
 		hashNginxGlobal := md5.Sum([]byte(createKeyValuePairs(cmNginxGlobal.Data)))
@@ -177,6 +254,12 @@ func (r *DrupalSiteReconciler) ensureDeploymentConfigmapHash(ctx context.Context
 		deploy.Spec.Template.ObjectMeta.Annotations["nginx-configmap/hash"] = hex.EncodeToString(hashNginxGlobal[:])
 		deploy.Spec.Template.ObjectMeta.Annotations["settings.php-configmap/hash"] = hex.EncodeToString(hashSettings[:])
 		deploy.Spec.Template.ObjectMeta.Annotations["php-cli-configmap/hash"] = hex.EncodeToString(hashPhpCli[:])
+		if d.Spec.Configuration.ExtraSettingsConfigMap != "" {
+			hashExtraSettings := md5.Sum([]byte(cmExtraSettings.Data["settings.local.php"]))
+			deploy.Spec.Template.ObjectMeta.Annotations["extra-settings-configmap/hash"] = hex.EncodeToString(hashExtraSettings[:])
+		} else {
+			delete(deploy.Spec.Template.ObjectMeta.Annotations, "extra-settings-configmap/hash")
+		}
 		return nil
 	}
 	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, &deploy, func() error {
@@ -192,58 +275,161 @@ func (r *DrupalSiteReconciler) ensureDeploymentConfigmapHash(ctx context.Context
 	return false, nil
 }
 
+// operatorStart is part of the hash of the ensured resources, so that they are all ensured again when the operator restarts, eg with new images
+var operatorStart = time.Now()
+
+// ensureResourcesIfChanged ensures the resources of the site, unless the site is steady and nothing they depend on changed since they were
+// last ensured, ie `status.ensuredResourcesHash` is up to date. Every ResourcesResyncPeriod they are ensured anyway, eg to recreate deleted ones.
+func (r *DrupalSiteReconciler) ensureResourcesIfChanged(ctx context.Context, d *webservicesv1a1.DrupalSite, deploymentConfig DeploymentConfig, dpc *webservicesv1a1.DrupalProjectConfig, summary *reconcileSummary, log logr.Logger, now time.Time) (transientErr reconcileError) {
+	hash, err := ensuredResourcesHash(d, deploymentConfig, dpc, now)
+	if err != nil {
+		return newApplicationError(err, ErrFunctionDomain)
+	}
+	if ResourcesResyncPeriod > 0 && resourcesSteady(d) && hash == d.Status.EnsuredResourcesHash {
+		summary.skipped = append(summary.skipped, "resources")
+		return nil
+	}
+	if transientErrs := r.ensureResources(d, deploymentConfig, summary, log); transientErrs != nil {
+		return concat(transientErrs)
+	}
+	return r.recordEnsuredResourcesHash(ctx, d, hash, log)
+}
+
+// recordEnsuredResourcesHash writes the hash of the ensured resources on the status.
+// The status is only written if the hash changed, and only for a steady site, whose resources don't change by themselves.
+func (r *DrupalSiteReconciler) recordEnsuredResourcesHash(ctx context.Context, d *webservicesv1a1.DrupalSite, hash string, log logr.Logger) (transientErr reconcileError) {
+	if !resourcesSteady(d) || hash == d.Status.EnsuredResourcesHash {
+		return nil
+	}
+	d.Status.EnsuredResourcesHash = hash
+	summarizeStatus(d)
+	if err := r.Status().Update(ctx, d); err != nil {
+		log.Error(err, "Failed to update the hash of the ensured resources on the status")
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// resourcesSteady checks if the site is serving and no update is in progress, so that its resources don't change by themselves
+func resourcesSteady(d *webservicesv1a1.DrupalSite) bool {
+	return d.ConditionTrue("Initialized") && d.ConditionTrue("Ready") && d.ConditionTrue("Serving") &&
+		!d.ConditionTrue("CodeUpdateFailed") && !d.ConditionTrue("DBUpdatesFailed") &&
+		d.Annotations["updateInProgress"] != "true" && d.Annotations["upgradeQueued"] != "true"
+}
+
+// ensuredResourcesHash returns the hash of what the resources of the site depend on: its spec, annotations, releaseID and conditions,
+// the deployment configuration, the DrupalProjectConfig, the start of the operator and the current ResourcesResyncPeriod
+func ensuredResourcesHash(d *webservicesv1a1.DrupalSite, deploymentConfig DeploymentConfig, dpc *webservicesv1a1.DrupalProjectConfig, now time.Time) (string, error) {
+	conditions := map[string]corev1.ConditionStatus{}
+	for _, condition := range d.Status.Conditions {
+		conditions[string(condition.Type)] = condition.Status
+	}
+	inputs := map[string]interface{}{
+		"spec":        d.Spec,
+		"annotations": d.Annotations,
+		"releaseID":   d.Status.ReleaseID,
+		"conditions":  conditions,
+		"replicas":    deploymentConfig.replicas,
+		"resources": []corev1.ResourceRequirements{deploymentConfig.phpResources, deploymentConfig.nginxResources, deploymentConfig.phpExporterResources,
+			deploymentConfig.webDAVResources, deploymentConfig.cronResources, deploymentConfig.drupalLogsResources, deploymentConfig.logRotationResources},
+		"operatorStart": operatorStart.UnixNano(),
+		"logRotation":   deploymentConfig.drupalLogsRotation,
+	}
+	if dpc != nil {
+		inputs["projectConfig"] = dpc.Spec
+	}
+	if ResourcesResyncPeriod > 0 {
+		inputs["resync"] = now.Truncate(ResourcesResyncPeriod).Unix()
+	}
+	serialized, err := json.Marshal(inputs)
+	if err != nil {
+		return "", err
+	}
+	hash := md5.Sum(serialized)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 /*
 ensureResources ensures the presence of all the resources that the DrupalSite needs to serve content.
 This includes BuildConfigs/ImageStreams, DB, PVC, PHP/Nginx deployment + service, site install job, Routes.
 */
-func (r *DrupalSiteReconciler) ensureResources(drp *webservicesv1a1.DrupalSite, deploymentConfig DeploymentConfig, log logr.Logger) (transientErrs []reconcileError) {
+func (r *DrupalSiteReconciler) ensureResources(drp *webservicesv1a1.DrupalSite, deploymentConfig DeploymentConfig, summary *reconcileSummary, log logr.Logger) (transientErrs []reconcileError) {
 	ctx := context.TODO()
+	ensureResourceX := func(resType string) reconcileError {
+		summary.ensured = append(summary.ensured, resType)
+		return r.ensureResourceX(ctx, drp, resType, log)
+	}
 
 	// 1. BuildConfigs and ImageStreams
 
 	if len(drp.Spec.Configuration.ExtraConfigurationRepo) > 0 {
-		if transientErr := r.ensureResourceX(ctx, drp, "is_s2i", log); transientErr != nil {
+		if transientErr := ensureResourceX("is_s2i"); transientErr != nil {
 			transientErrs = append(transientErrs, transientErr.Wrap("%v: for S2I SiteBuilder ImageStream"))
 		}
-		if transientErr := r.ensureResourceX(ctx, drp, "bc_s2i", log); transientErr != nil {
+		if transientErr := ensureResourceX("bc_s2i"); transientErr != nil {
 			transientErrs = append(transientErrs, transientErr.Wrap("%v: for S2I SiteBuilder BuildConfig"))
 		}
-		if transientErr := r.ensureResourceX(ctx, drp, "gitlab_trigger_secret", log); transientErr != nil {
+		if transientErr := r.ensureNoStaleBuildConfigs(ctx, drp, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the stale S2I SiteBuilder BuildConfigs"))
+		}
+		if transientErr := ensureResourceX("gitlab_trigger_secret"); transientErr != nil {
 			transientErrs = append(transientErrs, transientErr.Wrap("%v: for S2I SiteBuilder Secret"))
 		}
 	}
 	// 2. Data layer
 
-	if transientErr := r.ensureResourceX(ctx, drp, "pvc_drupal", log); transientErr != nil {
+	if transientErr := ensureResourceX("pvc_drupal"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for Drupal PVC"))
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "dbod_cr", log); transientErr != nil {
+	if len(drp.Spec.Configuration.BackupExcludedPaths) > 0 {
+		if transientErr := ensureResourceX("pvc_backup_excluded"); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: for the PVC of the paths excluded from the backups"))
+		}
+	} else {
+		if transientErr := r.ensureNoBackupExcludedClaim(ctx, drp, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the PVC of the paths excluded from the backups"))
+		}
+	}
+	if transientErr := ensureResourceX("dbod_cr"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for DBOD resource"))
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "webdav_secret", log); transientErr != nil {
+	if drp.Spec.Configuration.SecondaryDatabaseClass != "" {
+		if transientErr := ensureResourceX("secondary_dbod_cr"); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: for secondary DBOD resource"))
+		}
+	}
+	if transientErr := ensureResourceX("webdav_secret"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for WebDAV Secret"))
 	}
+	if EnableAdminAccount && adminAccountPasswordSecret(drp) == "" {
+		if transientErr := ensureResourceX("admin_account_secret"); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: for admin account Secret"))
+		}
+	}
 
 	// 3. Serving layer
 
-	if transientErr := r.ensureResourceX(ctx, drp, "cm_php", log); transientErr != nil {
+	if transientErr := ensureResourceX("cm_php"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for PHP-FPM CM"))
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "cm_nginx_global", log); transientErr != nil {
+	if transientErr := ensureResourceX("cm_nginx_global"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for Nginx CM"))
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "cm_settings", log); transientErr != nil {
+	if transientErr := ensureResourceX("cm_settings"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for settings.php CM"))
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "cm_php_cli", log); transientErr != nil {
+	if transientErr := ensureResourceX("cm_php_cli"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for PHP Job CM"))
 	}
 	if r.isDBODProvisioned(ctx, drp) {
+		summary.ensured = append(summary.ensured, "deployment")
 		if transientErr := r.ensureDrupalDeployment(ctx, drp, deploymentConfig, log); transientErr != nil {
 			transientErrs = append(transientErrs, transientErr.Wrap("%v: for Drupal deployment"))
 		}
+	} else {
+		summary.skipped = append(summary.skipped, "deployment")
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "svc_nginx", log); transientErr != nil {
+	if transientErr := ensureResourceX("svc_nginx"); transientErr != nil {
 		transientErrs = append(transientErrs, transientErr.Wrap("%v: for Nginx SVC"))
 	}
 	/* A new drupalsite can be initialized with 3 different ways depending its Spec:
@@ -256,39 +442,124 @@ func (r *DrupalSiteReconciler) ensureResources(drp *webservicesv1a1.DrupalSite,
 	if r.isDBODProvisioned(ctx, drp) && !(drp.ConditionTrue("Initialized")) {
 		switch {
 		case drp.Spec.Configuration.CloneFrom != "":
-			if transientErr := r.ensureResourceX(ctx, drp, "clone_job", log); transientErr != nil {
+			// The clone waits until the cloneFrom DrupalSite is not being updated
+			if drp.ConditionTrue("CloneBlocked") {
+				summary.skipped = append(summary.skipped, "clone_job")
+				break
+			}
+			if transientErr := ensureResourceX("clone_job"); transientErr != nil {
 				transientErrs = append(transientErrs, transientErr.Wrap("%v: for clone Job"))
 			}
 		case drp.Spec.Configuration.Easystart == "enable":
-			if transientErr := r.ensureResourceX(ctx, drp, "easystart_taskrun", log); transientErr != nil {
+			if transientErr := ensureResourceX("easystart_taskrun"); transientErr != nil {
 				transientErrs = append(transientErrs, transientErr.Wrap("%v: for easystart TaskRun"))
 			}
 		default:
-			if transientErr := r.ensureResourceX(ctx, drp, "site_install_job", log); transientErr != nil {
+			if transientErr := ensureResourceX("site_install_job"); transientErr != nil {
 				transientErrs = append(transientErrs, transientErr.Wrap("%v: for site install Job"))
 			}
 		}
 	}
+	// The export of a cloneFrom DrupalSite of another project is only needed while the clone job runs
+	if crossNamespaceClone(drp) && (drp.ConditionTrue("Initialized") || r.isCloneJobFinished(ctx, drp)) {
+		if transientErr := r.ensureNoCloneSourceExport(ctx, drp, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the export of the cloneFrom DrupalSite"))
+		}
+	}
 
 	// 4. Ingress
 
-	if drp.ConditionTrue("Initialized") {
-		// each function below ensures 1 route per entry in `spec.siteUrl[]`. This is understandably part of the job of "ensuring resource X".
-		if transientErr := r.ensureResourceX(ctx, drp, "route", log); transientErr != nil {
-			transientErrs = append(transientErrs, transientErr.Wrap("%v: for Route"))
+	transientErrs = append(transientErrs, r.ensureIngress(ctx, drp, summary, log)...)
+
+	// Canary: a second deployment, service and route that serve `spec.canaryVersion` on `spec.canaryURL`
+
+	if drp.Spec.CanaryVersion != nil && drp.ConditionTrue("Initialized") && r.isDBODProvisioned(ctx, drp) {
+		summary.ensured = append(summary.ensured, "canary")
+		if transientErr := r.ensureCanary(ctx, drp, deploymentConfig, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: for the canary"))
 		}
-		if transientErr := r.ensureResourceX(ctx, drp, "oidc_return_uri", log); transientErr != nil {
-			transientErrs = append(transientErrs, transientErr.Wrap("%v: for OidcReturnURI"))
+	} else if drp.Spec.CanaryVersion == nil {
+		if transientErr := r.ensureNoCanary(ctx, drp, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the canary"))
 		}
+	}
 
-		// each function below removes any unwanted routes
-		if transientErr := r.ensureNoExtraRouteResource(ctx, drp, "drupal", log); transientErr != nil {
-			transientErrs = append(transientErrs, transientErr.Wrap("%v: while ensuring no extra routes"))
-		}
-		if transientErr := r.ensureNoExtraOidcReturnUriResource(ctx, drp, "drupal", log); transientErr != nil {
-			transientErrs = append(transientErrs, transientErr.Wrap("%v: while ensuring no extra OidcReturnURIs"))
+	// 5. Cluster-scoped: Backup schedule, Tekton RBAC
+	// Create Velero schedule only after site is initialized in order for the first backup to not report 'Failed' or 'PartiallyFailed' status
+	if drp.ConditionTrue("Initialized") && (drp.Status.IsPrimary || drp.Spec.Configuration.ScheduledBackups == "enabled") {
+		if transientErr := ensureResourceX("backup_schedule"); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: for Velero Schedule"))
 		}
 	} else {
+		summary.skipped = append(summary.skipped, "backup_schedule")
+		if transientErr := r.ensureNoBackupSchedule(ctx, drp, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the Velero schedule"))
+		}
+	}
+	if transientErr := r.ensureTektonExtraPermissions(ctx, drp, log); transientErr != nil {
+		transientErrs = append(transientErrs, transientErr.Wrap("%v: for Tekton Extra Permissions ClusterRoleBinding"))
+	}
+	return transientErrs
+}
+
+// ensureCanary ensures the deployment, service and route that serve the canary version of the site, without touching the site's own
+func (r *DrupalSiteReconciler) ensureCanary(ctx context.Context, d *webservicesv1a1.DrupalSite, config DeploymentConfig, log logr.Logger) (transientErr reconcileError) {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: canaryName(d), Namespace: d.Namespace}}
+	_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		return canaryDeploymentForDrupalSite(deploy, databaseSecretName(d), d, config)
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", deploy.TypeMeta.Kind, "Resource.Namespace", deploy.Namespace, "Resource.Name", deploy.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: canaryName(d), Namespace: d.Namespace}}
+	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		return canaryServiceForDrupalSite(svc, d)
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", svc.TypeMeta.Kind, "Resource.Namespace", svc.Namespace, "Resource.Name", svc.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+	route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: canaryName(d), Namespace: d.Namespace}}
+	// Like the site's routes, the canary is only reachable while the site is published
+	if !sitePublished(d) {
+		if err := r.Delete(ctx, route); err != nil && !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the canary route", "Resource.Namespace", route.Namespace, "Resource.Name", route.Name)
+			return newApplicationError(err, ErrClientK8s)
+		}
+		return nil
+	}
+	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, route, func() error {
+		return canaryRouteForDrupalSite(route, d)
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", route.TypeMeta.Kind, "Resource.Namespace", route.Namespace, "Resource.Name", route.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// ensureIngress ensures 1 route and OIDC return URI per entry in `spec.siteUrl[]`, once the site is installed and published,
+// and removes any other route or OIDC return URI of the site
+func (r *DrupalSiteReconciler) ensureIngress(ctx context.Context, drp *webservicesv1a1.DrupalSite, summary *reconcileSummary, log logr.Logger) (transientErrs []reconcileError) {
+	ensureResourceX := func(resType string) reconcileError {
+		summary.ensured = append(summary.ensured, resType)
+		return r.ensureResourceX(ctx, drp, resType, log)
+	}
+	ensureNoExtraIngress := func() {
+		// each function below removes any unwanted routes, of the site and of its WebDAV endpoint
+		for _, label := range []string{"drupal", "webdav"} {
+			if transientErr := r.ensureNoExtraRouteResource(ctx, drp, label, log); transientErr != nil {
+				transientErrs = append(transientErrs, transientErr.Wrap("%v: while ensuring no extra routes"))
+			}
+			if transientErr := r.ensureNoExtraOidcReturnUriResource(ctx, drp, label, log); transientErr != nil {
+				transientErrs = append(transientErrs, transientErr.Wrap("%v: while ensuring no extra OidcReturnURIs"))
+			}
+		}
+	}
+
+	if !drp.ConditionTrue("Initialized") || !sitePublished(drp) {
+		summary.skipped = append(summary.skipped, "route", "oidc_return_uri")
 		for _, url := range drp.Spec.SiteURL {
 			if transientErr := r.ensureNoRoute(ctx, drp, string(url), log); transientErr != nil {
 				transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the Route"))
@@ -297,28 +568,175 @@ func (r *DrupalSiteReconciler) ensureResources(drp *webservicesv1a1.DrupalSite,
 				transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the OidcReturnURI"))
 			}
 		}
+		// These remove the routes and OIDC return URIs of any other URL, and all of them if the site isn't published
+		ensureNoExtraIngress()
+		return transientErrs
 	}
 
-	// 5. Cluster-scoped: Backup schedule, Tekton RBAC
-	// Create Velero schedule only after site is initialized in order for the first backup to not report 'Failed' or 'PartiallyFailed' status
-	if drp.ConditionTrue("Initialized") && (drp.Status.IsPrimary || drp.Spec.Configuration.ScheduledBackups == "enabled") {
-		if transientErr := r.ensureResourceX(ctx, drp, "backup_schedule", log); transientErr != nil {
-			transientErrs = append(transientErrs, transientErr.Wrap("%v: for Velero Schedule"))
+	// each function below ensures 1 route per entry in `spec.siteUrl[]`. This is understandably part of the job of "ensuring resource X".
+	// Routes are only created once the pods of the site serve the expected version, and not after a failed update
+	if drp.ConditionTrue("Serving") && !drp.ConditionTrue("PublishBlocked") {
+		if transientErr := ensureResourceX("route"); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: for Route"))
 		}
 	} else {
-		if transientErr := r.ensureNoBackupSchedule(ctx, drp, log); transientErr != nil {
-			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the Velero schedule"))
+		summary.skipped = append(summary.skipped, "route")
+	}
+	if !trafficRampActive(drp) {
+		if transientErr := r.ensureNoReleaseServices(ctx, drp, log); transientErr != nil {
+			transientErrs = append(transientErrs, transientErr.Wrap("%v: while deleting the Services of the traffic ramp"))
 		}
 	}
-	if transientErr := r.ensureResourceX(ctx, drp, "tekton_extra_perm_rbac", log); transientErr != nil {
-		transientErrs = append(transientErrs, transientErr.Wrap("%v: for Tekton Extra Permissions ClusterRoleBinding"))
+	if transientErr := ensureResourceX("oidc_return_uri"); transientErr != nil {
+		transientErrs = append(transientErrs, transientErr.Wrap("%v: for OidcReturnURI"))
+	}
+	ensureNoExtraIngress()
+
+	if transientErr := r.ensureSiteURLStatus(ctx, drp, log); transientErr != nil {
+		transientErrs = append(transientErrs, transientErr.Wrap("%v: while reporting the site URLs"))
 	}
 	return transientErrs
 }
 
+// sitePublished checks if the site should be available on its URLs, which is the default
+func sitePublished(d *webservicesv1a1.DrupalSite) bool {
+	return d.Spec.Publish == nil || *d.Spec.Publish
+}
+
+// publishedSiteURLs returns the URLs that the site should be available on: none if it's not published
+func publishedSiteURLs(d *webservicesv1a1.DrupalSite) []webservicesv1a1.Url {
+	if !sitePublished(d) {
+		return nil
+	}
+	return d.Spec.SiteURL
+}
+
+// ensureNoCanary ensures there are no canary resources for the drupalsite
+func (r *DrupalSiteReconciler) ensureNoCanary(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	for _, obj := range []client.Object{&routev1.Route{}, &corev1.Service{}, &appsv1.Deployment{}} {
+		if err := r.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, obj); err != nil {
+			if k8sapierrors.IsNotFound(err) {
+				continue
+			}
+			return newApplicationError(err, ErrClientK8s)
+		}
+		if err := r.Delete(ctx, obj); err != nil && !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the canary", "Resource.Namespace", d.Namespace, "Resource.Name", canaryName(d))
+			return newApplicationError(err, ErrClientK8s)
+		}
+	}
+	return nil
+}
+
+// ensureNoBackupExcludedClaim deletes the PVC of the `backupExcludedPaths` once the site doesn't exclude any path.
+// The PVC is only deleted by kubernetes once the pods that still mount it are gone.
+func (r *DrupalSiteReconciler) ensureNoBackupExcludedClaim(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: backupExcludedClaimName(d), Namespace: d.Namespace}, pvc); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return nil
+		}
+		return newApplicationError(err, ErrClientK8s)
+	}
+	if !ownedBySite(pvc, d) {
+		return nil
+	}
+	if err := r.Delete(ctx, pvc); err != nil && !k8sapierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the PVC of the paths excluded from the backups", "Resource.Namespace", d.Namespace, "Resource.Name", pvc.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// ensureNoStaleBuildConfigs deletes the S2I BuildConfigs of previous versions of the site.
+// The BuildConfigs of the current and of the failsafe release are kept, as well as any BuildConfig with a build in progress.
+func (r *DrupalSiteReconciler) ensureNoStaleBuildConfigs(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "sitebuilder"
+	bcList := &buildv1.BuildConfigList{}
+	if err := r.List(ctx, bcList, client.InNamespace(d.Namespace), client.MatchingLabels(ls)); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	keep := map[string]bool{"sitebuilder-s2i-" + nameVersionHash(d): true}
+	if len(d.Status.ReleaseID.Failsafe) > 0 {
+		keep["sitebuilder-s2i-"+nameReleaseHash(d.Name, d.Status.ReleaseID.Failsafe)] = true
+	}
+	for i := range bcList.Items {
+		bc := &bcList.Items[i]
+		if keep[bc.Name] {
+			continue
+		}
+		building, transientErr := r.buildInProgress(ctx, bc)
+		if transientErr != nil {
+			return transientErr
+		}
+		if building {
+			log.V(3).Info("Not deleting the stale BuildConfig yet, since one of its builds is in progress", "Resource.Name", bc.Name)
+			continue
+		}
+		if err := r.Delete(ctx, bc); err != nil && !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the stale BuildConfig", "Resource.Namespace", d.Namespace, "Resource.Name", bc.Name)
+			return newApplicationError(err, ErrClientK8s)
+		}
+		log.V(3).Info("Deleted the stale BuildConfig", "Resource.Name", bc.Name)
+	}
+	return nil
+}
+
+// buildInProgress checks if any build of the given BuildConfig hasn't completed yet
+func (r *DrupalSiteReconciler) buildInProgress(ctx context.Context, bc *buildv1.BuildConfig) (bool, reconcileError) {
+	buildList := &buildv1.BuildList{}
+	if err := r.List(ctx, buildList, client.InNamespace(bc.Namespace), client.MatchingLabels{"openshift.io/build-config.name": bc.Name}); err != nil {
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	for _, build := range buildList.Items {
+		switch build.Status.Phase {
+		case buildv1.BuildPhaseNew, buildv1.BuildPhasePending, buildv1.BuildPhaseRunning:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ensureTektonExtraPermissions ensures the Tekton extra permissions ClusterRoleBinding, if the project has opted in.
+// Otherwise, an existing binding is left in place, because it may be shared by other sites of the project.
+func (r *DrupalSiteReconciler) ensureTektonExtraPermissions(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	// An operator restricted to a namespace doesn't manage cluster-scoped RBAC
+	if WatchNamespace != "" {
+		return nil
+	}
+	dpc, transientErr := r.GetDrupalProjectConfig(ctx, d)
+	if transientErr != nil {
+		return transientErr
+	}
+	if tektonExtraPermissionsEnabled(d, dpc) {
+		return r.ensureResourceX(ctx, d, "tekton_extra_perm_rbac", log)
+	}
+	rbac := &rbacv1.ClusterRoleBinding{}
+	err := r.Get(ctx, types.NamespacedName{Name: "tektoncd-extra-permissions-" + d.Namespace}, rbac)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	}
+	log.Info("Tekton extra permissions are not enabled for the project, but the ClusterRoleBinding exists. Not deleting it, as it may be shared", "Resource.Name", rbac.Name)
+	return nil
+}
+
+// tektonExtraPermissionsEnabled checks if the project has opted in to the Tekton extra permissions.
+// Easystart restores the site with a Tekton TaskRun, so it needs them regardless.
+func tektonExtraPermissionsEnabled(d *webservicesv1a1.DrupalSite, dpc *webservicesv1a1.DrupalProjectConfig) bool {
+	if d.Spec.Configuration.Easystart == "enable" {
+		return true
+	}
+	return dpc != nil && dpc.Spec.EnableTektonExtraPermissions
+}
+
 /*
 ensureResourceX ensure the requested resource is created, with the following valid values
 	- pvc_drupal: PersistentVolume for the drupalsite
+	- pvc_backup_excluded: PersistentVolume for the paths of the drupalsite that are excluded from the backups
 	- site_install_job: Kubernetes Job for the drush ensure-site-install
 	- clone_job: Kubernetes Job for cloning a drupal site
 	- easystart_taskrun: Taskrun for restoring easystart backup
@@ -334,7 +752,9 @@ ensureResourceX ensure the requested resource is created, with the following val
 	- route: Route for the drupalsite
 	- oidc_return_uri: Redirection URI for OIDC
 	- dbod_cr: DBOD custom resource to establish database & respective connection for the drupalsite
+	- secondary_dbod_cr: DBOD custom resource of the site's secondary database
 	- webdav_secret: Secret with credential for WebDAV
+	- admin_account_secret: Secret with the password of the administrator account, if the spec doesn't give one
 	- backup_schedule: Velero Schedule for scheduled backups of the drupalSite
 	- tekton_extra_perm_rbac: ClusterRoleBinding for tekton tasks
 	- gitlab_trigger_secret: Secret for Gitlab trigger config in buildconfig
@@ -367,14 +787,32 @@ func (r *DrupalSiteReconciler) ensureResourceX(ctx context.Context, d *webservic
 		// the webdav secret is too long.
 		// In order to shorten this name we'll have to change the deployment to enforce the volumes.
 		webdav_secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "webdav-secret-" + d.Name, Namespace: d.Namespace}}
+		malformed := false
 		_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, webdav_secret, func() error {
 			log.V(4).Info("Ensuring Resource", "Kind", webdav_secret.TypeMeta.Kind, "Resource.Namespace", webdav_secret.Namespace, "Resource.Name", webdav_secret.Name)
+			malformed = !webdav_secret.CreationTimestamp.IsZero() && !webDAVDigestWellFormed(string(webdav_secret.Data["htdigest"]))
 			return secretForWebDAV(webdav_secret, d)
 		})
 		if err != nil {
 			log.Error(err, "Failed to ensure Resource", "Kind", webdav_secret.TypeMeta.Kind, "Resource.Namespace", webdav_secret.Namespace, "Resource.Name", webdav_secret.Name)
 			return newApplicationError(err, ErrClientK8s)
 		}
+		// The pods are restarted, so that the WebDAV container doesn't keep serving with the malformed htdigest
+		if malformed {
+			log.Info("Regenerated the malformed WebDAV htdigest", "Resource.Name", webdav_secret.Name)
+			return r.restartDeployment(ctx, d)
+		}
+		return nil
+	case "admin_account_secret":
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: adminAccountSecretName(d), Namespace: d.Namespace}}
+		_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			log.V(4).Info("Ensuring Resource", "Kind", secret.TypeMeta.Kind, "Resource.Namespace", secret.Namespace, "Resource.Name", secret.Name)
+			return secretForAdminAccount(secret, d)
+		})
+		if err != nil {
+			log.Error(err, "Failed to ensure Resource", "Kind", secret.TypeMeta.Kind, "Resource.Namespace", secret.Namespace, "Resource.Name", secret.Name)
+			return newApplicationError(err, ErrClientK8s)
+		}
 		return nil
 	case "svc_nginx":
 		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
@@ -396,13 +834,38 @@ func (r *DrupalSiteReconciler) ensureResourceX(ctx context.Context, d *webservic
 			return newApplicationError(err, ErrClientK8s)
 		}
 		return nil
+	case "pvc_backup_excluded":
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: backupExcludedClaimName(d), Namespace: d.Namespace}}
+		_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, pvc, func() error {
+			return backupExcludedClaimForDrupalSite(pvc, d)
+		})
+		if err != nil {
+			log.Error(err, "Failed to ensure Resource", "Kind", pvc.TypeMeta.Kind, "Resource.Namespace", pvc.Namespace, "Resource.Name", pvc.Name)
+			return newApplicationError(err, ErrClientK8s)
+		}
+		return nil
 	case "route":
+		// Unpublished sites have no routes
+		if !sitePublished(d) {
+			return nil
+		}
+		// A route without its target service would serve 503s, so wait until the service exists
+		if err := r.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, &corev1.Service{}); err != nil {
+			if k8sapierrors.IsNotFound(err) {
+				return newApplicationError(fmt.Errorf("service %s doesn't exist yet", d.Name), ErrTemporary)
+			}
+			return newApplicationError(err, ErrClientK8s)
+		}
+		certificateSecret, reconcileErr := r.routeCertificateSecret(ctx, d)
+		if reconcileErr != nil {
+			return reconcileErr
+		}
 		routeRequestList := d.Spec.SiteURL
 		for _, req := range routeRequestList {
 			hash := md5.Sum([]byte(req))
 			route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}}
 			_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, route, func() error {
-				return routeForDrupalSite(route, d, string(req))
+				return routeForDrupalSite(route, d, string(req), certificateSecret)
 			})
 			// TODO: don't throw on conflict
 			if err != nil {
@@ -412,24 +875,23 @@ func (r *DrupalSiteReconciler) ensureResourceX(ctx context.Context, d *webservic
 		}
 		return nil
 	case "oidc_return_uri":
+		if !sitePublished(d) {
+			return nil
+		}
 		routeRequestList := d.Spec.SiteURL
 		for _, req := range routeRequestList {
 			hash := md5.Sum([]byte(req))
 			OidcReturnURI := &authz.OidcReturnURI{ObjectMeta: metav1.ObjectMeta{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}}
 			_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, OidcReturnURI, func() error {
 				log.V(4).Info("Ensuring Resource", "Kind", OidcReturnURI.TypeMeta.Kind, "Resource.Namespace", OidcReturnURI.Namespace, "Resource.Name", OidcReturnURI.Name)
-				return newOidcReturnURI(OidcReturnURI, d, string(req), true)
+				return newOidcReturnURI(OidcReturnURI, d, string(req), OidcReturnURIScheme)
 			})
 			if err != nil {
 				log.Error(err, "Failed to ensure Resource", "Kind", OidcReturnURI.TypeMeta.Kind, "Resource.Namespace", OidcReturnURI.Namespace, "Resource.Name", OidcReturnURI.Name)
 			}
-			OidcReturnURIHTTPS := &authz.OidcReturnURI{ObjectMeta: metav1.ObjectMeta{Name: d.Name + "-https-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}}
-			_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, OidcReturnURIHTTPS, func() error {
-				log.V(4).Info("Ensuring Resource", "Kind", OidcReturnURIHTTPS.TypeMeta.Kind, "Resource.Namespace", OidcReturnURIHTTPS.Namespace, "Resource.Name", OidcReturnURIHTTPS.Name)
-				return newOidcReturnURI(OidcReturnURIHTTPS, d, string(req), false)
-			})
-			if err != nil {
-				log.Error(err, "Failed to ensure Resource", "Kind", OidcReturnURI.TypeMeta.Kind, "Resource.Namespace", OidcReturnURIHTTPS.Namespace, "Resource.Name", OidcReturnURIHTTPS.Name)
+			// The return URI used to be registered for both schemes, the https one under its own name
+			if transientErr := r.ensureNoLegacyHTTPSReturnURI(ctx, d, string(req)); transientErr != nil {
+				return transientErr
 			}
 		}
 		return nil
@@ -438,6 +900,11 @@ func (r *DrupalSiteReconciler) ensureResourceX(ctx context.Context, d *webservic
 		if len(databaseSecretName) == 0 {
 			return nil
 		}
+		// The install job is not recreated once the site is installed, eg after the completed job has been garbage-collected,
+		// nor after it failed, until the spec changes
+		if d.ConditionTrue("Initialized") || d.ConditionTrue("InstallFailed") {
+			return nil
+		}
 		// TODO: this name is too long
 		// change to `install-*`
 		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ensure-site-install-" + d.Name, Namespace: d.Namespace}}
@@ -451,6 +918,11 @@ func (r *DrupalSiteReconciler) ensureResourceX(ctx context.Context, d *webservic
 		return nil
 	case "clone_job":
 		if databaseSecret := databaseSecretName(d); len(databaseSecret) != 0 {
+			if crossNamespaceClone(d) && !r.isCloneJobFinished(ctx, d) {
+				if transientErr := r.ensureCloneSourceExport(ctx, d, log); transientErr != nil {
+					return transientErr
+				}
+			}
 			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "clone-" + d.Name, Namespace: d.Namespace}}
 			_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, job, func() error {
 				log.V(4).Info("Ensuring Resource", "Kind", job.TypeMeta.Kind, "Resource.Namespace", job.Namespace, "Resource.Name", job.Name)
@@ -530,6 +1002,16 @@ func (r *DrupalSiteReconciler) ensureResourceX(ctx context.Context, d *webservic
 			return newApplicationError(err, ErrClientK8s)
 		}
 		return nil
+	case "secondary_dbod_cr":
+		dbod := &dbodv1a1.Database{ObjectMeta: metav1.ObjectMeta{Name: secondaryDatabaseName(d), Namespace: d.Namespace}}
+		_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, dbod, func() error {
+			return secondaryDbodForDrupalSite(dbod, d)
+		})
+		if err != nil {
+			log.Error(err, "Failed to ensure Resource", "Kind", dbod.TypeMeta.Kind, "Resource.Namespace", dbod.Namespace, "Resource.Name", dbod.Name)
+			return newApplicationError(err, ErrClientK8s)
+		}
+		return nil
 	case "backup_schedule":
 		schedule := &velerov1.Schedule{ObjectMeta: metav1.ObjectMeta{Name: generateScheduleName(d.Namespace, d.Name), Namespace: VeleroNamespace}}
 		_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, schedule, func() error {
@@ -578,24 +1060,56 @@ func (r *DrupalSiteReconciler) ensureDrupalDeployment(ctx context.Context, d *we
 
 	// Check if a deployment exists & if any of the given conditions satisfy
 	// In scenarios where, the deployment is deleted during a failed upgrade, this check is needed to bring it back
-	if err == nil && (d.Annotations["updateInProgress"] == "true" || d.ConditionTrue("CodeUpdateFailed") || d.ConditionTrue("DBUpdatesFailed")) {
+	// A queued upgrade must not be rolled out until it can start
+	if err == nil && (d.Annotations["updateInProgress"] == "true" || d.Annotations["upgradeQueued"] == "true" || d.ConditionTrue("CodeUpdateFailed") || d.ConditionTrue("DBUpdatesFailed")) {
 		return nil
 	}
-	if databaseSecret := databaseSecretName(d); len(databaseSecret) != 0 {
-		deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
-		_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, deploy, func() error {
-			releaseID := releaseID(d)
-			return deploymentForDrupalSite(deploy, databaseSecret, d, releaseID, config)
-		})
-		if err != nil {
-			log.Error(err, "Failed to ensure Resource", "Kind", deploy.TypeMeta.Kind, "Resource.Namespace", deploy.Namespace, "Resource.Name", deploy.Name)
-			return newApplicationError(err, ErrClientK8s)
+	// A new release waits for the approval of the update, unless the site updates automatically.
+	// Until then, the deployment keeps the running release, while the rest of its spec is still kept up to date
+	release := releaseID(d)
+	if runningRelease := deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]; err == nil && runningRelease != "" && runningRelease != release && updateUnapproved(d) != nil {
+		release = runningRelease
+	}
+	databaseSecret := databaseSecretName(d)
+	if len(databaseSecret) == 0 {
+		return newApplicationError(fmt.Errorf("the database secret of the site is not known yet"), ErrTemporary)
+	}
+	// An empty database secret is transient, eg while DBOD recreates it.
+	// An existing deployment is left untouched until the secret is populated again, instead of being rolled out without credentials
+	if err == nil {
+		if transientErr := r.ensureDatabaseSecretPopulated(ctx, d.Namespace, databaseSecret); transientErr != nil {
+			return transientErr
 		}
 	}
+	deploy = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
+	_, err = controllerruntime.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		return deploymentForDrupalSite(deploy, databaseSecret, d, release, config)
+	})
+	if err != nil {
+		log.Error(err, "Failed to ensure Resource", "Kind", deploy.TypeMeta.Kind, "Resource.Namespace", deploy.Namespace, "Resource.Name", deploy.Name)
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// ensureDatabaseSecretPopulated checks that the database secret isn't empty.
+// A secret that doesn't exist yet is accepted: the pods wait for it, while the rolling update keeps the running ones
+func (r *DrupalSiteReconciler) ensureDatabaseSecretPopulated(ctx context.Context, namespace string, databaseSecret string) reconcileError {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: databaseSecret, Namespace: namespace}, secret)
+	switch {
+	case k8sapierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return newApplicationError(err, ErrClientK8s)
+	case len(secret.Data) == 0:
+		return newApplicationError(fmt.Errorf("the database secret %s is empty", databaseSecret), ErrTemporary)
+	}
 	return nil
 }
 
-// ensureNoExtraRouteResource uses the current SiteURL resource as reference and deletes any extra route
+// ensureNoExtraRouteResource uses the current SiteURL resource as reference and deletes any extra route with the given label.
+// The hosts of the "webdav" routes are prefixed with `webdav-`
 func (r *DrupalSiteReconciler) ensureNoExtraRouteResource(ctx context.Context, d *webservicesv1a1.DrupalSite, label string, log logr.Logger) (transientErr reconcileError) {
 	ls := labelsForDrupalSite(d.Name)
 	ls["app"] = "drupal"
@@ -616,8 +1130,8 @@ func (r *DrupalSiteReconciler) ensureNoExtraRouteResource(ctx context.Context, d
 		log.Error(err, "Couldn't query routes with the given labels")
 		return newApplicationError(err, ErrClientK8s)
 	}
-	routeRequestList := d.Spec.SiteURL
-	routesToRemove := []webservicesv1a1.Url{}
+	routeRequestList := publishedSiteURLs(d)
+	routesToRemove := []routev1.Route{}
 	for _, route := range existingRoutes.Items {
 		flag := false
 		for _, req := range routeRequestList {
@@ -630,18 +1144,20 @@ func (r *DrupalSiteReconciler) ensureNoExtraRouteResource(ctx context.Context, d
 			}
 		}
 		if !flag {
-			routesToRemove = append(routesToRemove, webservicesv1a1.Url(route.Spec.Host))
+			routesToRemove = append(routesToRemove, route)
 		}
 	}
-	for _, route := range routesToRemove {
-		if transientErr := r.ensureNoRoute(ctx, d, string(route), log); transientErr != nil {
-			return transientErr
+	// The routes are deleted as they were found, since the names of the webdav routes aren't derived from their host like the drupal ones
+	for i := range routesToRemove {
+		if err := r.Delete(ctx, &routesToRemove[i]); err != nil && !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to delete extra route", "Resource.Namespace", d.Namespace, "Resource.Name", routesToRemove[i].Name)
+			return newApplicationError(err, ErrClientK8s)
 		}
 	}
 	return nil
 }
 
-// ensureNoExtraOidcReturnUriResource uses the current SiteURL resource as reference and deletes any extra oidcReturnURI
+// ensureNoExtraOidcReturnUriResource uses the current SiteURL resource as reference and deletes any extra oidcReturnURI with the given label
 func (r *DrupalSiteReconciler) ensureNoExtraOidcReturnUriResource(ctx context.Context, d *webservicesv1a1.DrupalSite, label string, log logr.Logger) (transientErr reconcileError) {
 	ls := labelsForDrupalSite(d.Name)
 	ls["app"] = "drupal"
@@ -662,8 +1178,8 @@ func (r *DrupalSiteReconciler) ensureNoExtraOidcReturnUriResource(ctx context.Co
 		log.Error(err, "Couldn't query oidcReturnUris with the given labels")
 		return newApplicationError(err, ErrClientK8s)
 	}
-	oidcReturnUriRequestList := d.Spec.SiteURL
-	oidcReturnUrisToRemove := []string{}
+	oidcReturnUriRequestList := publishedSiteURLs(d)
+	oidcReturnUrisToRemove := []authz.OidcReturnURI{}
 	for _, route := range existingOidcReturnUris.Items {
 		flag := false
 		for _, req := range oidcReturnUriRequestList {
@@ -671,61 +1187,143 @@ func (r *DrupalSiteReconciler) ensureNoExtraOidcReturnUriResource(ctx context.Co
 			if err != nil {
 				return newApplicationError(err, ErrFunctionDomain)
 			}
+			if label == "webdav" {
+				req = "webdav-" + req
+			}
 			if string(req) == url.Host {
 				flag = true
 				continue
 			}
 		}
 		if !flag {
-			url, err := url.Parse(route.Spec.RedirectURI)
-			if err != nil {
-				return newApplicationError(err, ErrFunctionDomain)
-			}
-			oidcReturnUrisToRemove = append(oidcReturnUrisToRemove, url.Host)
+			oidcReturnUrisToRemove = append(oidcReturnUrisToRemove, route)
 		}
 	}
-	for _, oidcReturnURI := range oidcReturnUrisToRemove {
-		if transientErr := r.ensureNoReturnURI(ctx, d, oidcReturnURI, log); transientErr != nil {
-			return transientErr
+	// Deleting the objects that were found also removes the https return URIs, whose names differ from the http ones
+	for i := range oidcReturnUrisToRemove {
+		if err := r.Delete(ctx, &oidcReturnUrisToRemove[i]); err != nil && !k8sapierrors.IsNotFound(err) {
+			log.Error(err, "Failed to delete extra oidcReturnURI", "Resource.Namespace", d.Namespace, "Resource.Name", oidcReturnUrisToRemove[i].Name)
+			return newApplicationError(err, ErrClientK8s)
 		}
 	}
 	return nil
 }
 
-// ensureNoRoute ensures there is no route object for the drupalsite
-func (r *DrupalSiteReconciler) ensureNoRoute(ctx context.Context, d *webservicesv1a1.DrupalSite, Url string, log logr.Logger) (transientErr reconcileError) {
-	hash := md5.Sum([]byte(Url))
-	route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}}
-	if err := r.Get(ctx, types.NamespacedName{Name: route.Name, Namespace: route.Namespace}, route); err != nil {
-		switch {
-		case k8sapierrors.IsNotFound(err):
-			return nil
-		default:
-			return newApplicationError(err, ErrClientK8s)
-		}
+// ensureSiteURLStatus reports the Route of each site URL and its admission on the DrupalSite status
+func (r *DrupalSiteReconciler) ensureSiteURLStatus(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	siteURLs, transientErr := r.siteURLStatus(ctx, d)
+	if transientErr != nil {
+		return transientErr
 	}
-	if err := r.Delete(ctx, route); err != nil {
+	if reflect.DeepEqual(siteURLs, d.Status.SiteURLs) {
+		return nil
+	}
+	d.Status.SiteURLs = siteURLs
+	summarizeStatus(d)
+	if err := r.Status().Update(ctx, d); err != nil {
+		log.Error(err, "Failed to update the site URLs on the status")
 		return newApplicationError(err, ErrClientK8s)
 	}
 	return nil
 }
 
-// ensureNoReturnURI ensures there is no OIDC Return URI object for the drupalsite
-func (r *DrupalSiteReconciler) ensureNoReturnURI(ctx context.Context, d *webservicesv1a1.DrupalSite, Url string, log logr.Logger) (transientErr reconcileError) {
-	hash := md5.Sum([]byte(Url))
-	oidc_return_uri := &authz.OidcReturnURI{}
-	if err := r.Get(ctx, types.NamespacedName{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}, oidc_return_uri); err != nil {
+// siteURLStatus returns the status of the Route of each entry in `spec.siteUrl[]`
+func (r *DrupalSiteReconciler) siteURLStatus(ctx context.Context, d *webservicesv1a1.DrupalSite) ([]webservicesv1a1.URLStatus, reconcileError) {
+	var siteURLs []webservicesv1a1.URLStatus
+	for _, url := range d.Spec.SiteURL {
+		hash := md5.Sum([]byte(url))
+		route := &routev1.Route{}
+		siteURL := webservicesv1a1.URLStatus{URL: url, RouteName: d.Name + "-" + hex.EncodeToString(hash[0:4])}
+		err := r.Get(ctx, types.NamespacedName{Name: siteURL.RouteName, Namespace: d.Namespace}, route)
 		switch {
 		case k8sapierrors.IsNotFound(err):
-			return nil
+		case err != nil:
+			return nil, newApplicationError(err, ErrClientK8s)
 		default:
-			return newApplicationError(err, ErrClientK8s)
+			siteURL.Admitted = isRouteAdmitted(route)
 		}
+		siteURLs = append(siteURLs, siteURL)
 	}
-	if err := r.Delete(ctx, oidc_return_uri); err != nil {
-		return newApplicationError(err, ErrClientK8s)
-	}
-	return nil
+	return siteURLs, nil
+}
+
+// webDAVReady checks if every WebDAV route of the site has been admitted by a router.
+// `found` is false if the site has no WebDAV route.
+func (r *DrupalSiteReconciler) webDAVReady(ctx context.Context, d *webservicesv1a1.DrupalSite) (ready bool, found bool, transientErr reconcileError) {
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "drupal"
+	ls["route"] = "webdav"
+	routes := &routev1.RouteList{}
+	if err := r.List(ctx, routes, client.InNamespace(d.Namespace), client.MatchingLabels(ls)); err != nil {
+		return false, false, newApplicationError(err, ErrClientK8s)
+	}
+	if len(routes.Items) == 0 {
+		return false, false, nil
+	}
+	for i := range routes.Items {
+		if !isRouteAdmitted(&routes.Items[i]) {
+			return false, true, nil
+		}
+	}
+	return true, true, nil
+}
+
+// isRouteAdmitted checks if any router has admitted the route
+func isRouteAdmitted(route *routev1.Route) bool {
+	for _, ingress := range route.Status.Ingress {
+		for _, condition := range ingress.Conditions {
+			if condition.Type == routev1.RouteAdmitted && condition.Status == corev1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureNoRoute ensures there is no route object for the drupalsite
+func (r *DrupalSiteReconciler) ensureNoRoute(ctx context.Context, d *webservicesv1a1.DrupalSite, Url string, log logr.Logger) (transientErr reconcileError) {
+	hash := md5.Sum([]byte(Url))
+	route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}}
+	if err := r.Get(ctx, types.NamespacedName{Name: route.Name, Namespace: route.Namespace}, route); err != nil {
+		switch {
+		case k8sapierrors.IsNotFound(err):
+			return nil
+		default:
+			return newApplicationError(err, ErrClientK8s)
+		}
+	}
+	if err := r.Delete(ctx, route); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
+}
+
+// ensureNoReturnURI ensures there is no OIDC Return URI object for the drupalsite
+func (r *DrupalSiteReconciler) ensureNoReturnURI(ctx context.Context, d *webservicesv1a1.DrupalSite, Url string, log logr.Logger) (transientErr reconcileError) {
+	hash := md5.Sum([]byte(Url))
+	oidc_return_uri := &authz.OidcReturnURI{}
+	if err := r.Get(ctx, types.NamespacedName{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}, oidc_return_uri); err != nil {
+		switch {
+		case k8sapierrors.IsNotFound(err):
+			return nil
+		default:
+			return newApplicationError(err, ErrClientK8s)
+		}
+	}
+	if err := r.Delete(ctx, oidc_return_uri); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return r.ensureNoLegacyHTTPSReturnURI(ctx, d, Url)
+}
+
+// ensureNoLegacyHTTPSReturnURI ensures there is no separate https OIDC Return URI object for the given URL
+func (r *DrupalSiteReconciler) ensureNoLegacyHTTPSReturnURI(ctx context.Context, d *webservicesv1a1.DrupalSite, Url string) (transientErr reconcileError) {
+	hash := md5.Sum([]byte(Url))
+	legacyReturnURI := &authz.OidcReturnURI{ObjectMeta: metav1.ObjectMeta{Name: d.Name + "-https-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}}
+	if err := r.Delete(ctx, legacyReturnURI); err != nil && !k8sapierrors.IsNotFound(err) {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	return nil
 }
 
 // ensureNoBackupSchedule ensures there is no Schedule object for the drupalsite
@@ -745,8 +1343,136 @@ func (r *DrupalSiteReconciler) ensureNoBackupSchedule(ctx context.Context, d *we
 	return nil
 }
 
-// checkNewBackups returns the list of velero backups that exist for a given site
-func (r *DrupalSiteReconciler) checkNewBackups(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (backups []webservicesv1a1.Backup, reconcileErr reconcileError) {
+// ensureNoSiteBackups requests velero to delete the finished backups of the given site and reports if any backup deletion is still pending.
+// Deleting a Backup object directly would leave its data in the backup storage, and velero would sync the Backup object back.
+// Backups that are still being taken are left alone until they finish. After siteBackupsDeletionTimeout, the remaining backups
+// are left to their TTL, so that a backup stuck in progress doesn't block the deletion of the site.
+func (r *DrupalSiteReconciler) ensureNoSiteBackups(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (pending bool, transientErr reconcileError) {
+	backupList := velerov1.BackupList{}
+	hash := md5.Sum([]byte(d.Namespace))
+	backupLabels, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels: map[string]string{"drupal.webservices.cern.ch/projectHash": hex.EncodeToString(hash[:])},
+	})
+	if err != nil {
+		return false, newApplicationError(err, ErrFunctionDomain)
+	}
+	if err := r.List(ctx, &backupList, &client.ListOptions{LabelSelector: backupLabels, Namespace: VeleroNamespace}); err != nil {
+		return false, newApplicationError(err, ErrClientK8s)
+	}
+	for i := range backupList.Items {
+		backup := &backupList.Items[i]
+		if !isSiteBackup(d, backup) {
+			continue
+		}
+		switch backup.Status.Phase {
+		case velerov1.BackupPhaseCompleted, velerov1.BackupPhasePartiallyFailed, velerov1.BackupPhaseFailed, velerov1.BackupPhaseFailedValidation:
+			deletionPending, transientErr := r.ensureBackupDeletionRequest(ctx, backup, log)
+			if transientErr != nil {
+				return true, transientErr
+			}
+			pending = pending || deletionPending
+		default:
+			log.V(3).Info("Waiting for backup to finish before deleting it", "Backup", backup.Name, "Phase", backup.Status.Phase)
+			pending = true
+		}
+	}
+	if pending && d.DeletionTimestamp != nil && time.Since(d.DeletionTimestamp.Time) > siteBackupsDeletionTimeout {
+		log.Info("Timed out waiting for the backups of the site to be deleted, leaving the rest to their TTL", "Timeout", siteBackupsDeletionTimeout)
+		return false, nil
+	}
+	return pending, nil
+}
+
+// ensureBackupDeletionRequest creates a velero DeleteBackupRequest for the given backup, and reports if its deletion is still pending.
+// A deletion that velero processed with errors isn't pending anymore, since velero doesn't retry it.
+func (r *DrupalSiteReconciler) ensureBackupDeletionRequest(ctx context.Context, backup *velerov1.Backup, log logr.Logger) (pending bool, transientErr reconcileError) {
+	request := &velerov1.DeleteBackupRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: backup.Name, Namespace: backup.Namespace},
+		Spec:       velerov1.DeleteBackupRequestSpec{BackupName: backup.Name},
+	}
+	err := r.Create(ctx, request)
+	switch {
+	case err == nil:
+		log.V(3).Info("Requested the deletion of backup", "Backup", backup.Name)
+		return true, nil
+	case !k8sapierrors.IsAlreadyExists(err):
+		return true, newApplicationError(err, ErrClientK8s)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: request.Name, Namespace: request.Namespace}, request); err != nil {
+		return true, newApplicationError(err, ErrClientK8s)
+	}
+	if request.Status.Phase == velerov1.DeleteBackupRequestPhaseProcessed && len(request.Status.Errors) > 0 {
+		log.Info("Velero failed to delete backup", "Backup", backup.Name, "Errors", request.Status.Errors)
+		return false, nil
+	}
+	return true, nil
+}
+
+// ensureNoDatabase deletes the DBOD Databases of the given site and reports if their deletion is still pending.
+// The secondary database is only deleted if the site has one and it belongs to the site. Otherwise, its owner reference lets the garbage collector delete it
+func (r *DrupalSiteReconciler) ensureNoDatabase(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (pending bool, transientErr reconcileError) {
+	names := []string{d.Name}
+	if d.Spec.Configuration.SecondaryDatabaseClass != "" {
+		names = append(names, secondaryDatabaseName(d))
+	}
+	for _, name := range names {
+		database := &dbodv1a1.Database{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: d.Namespace}, database); err != nil {
+			switch {
+			case k8sapierrors.IsNotFound(err):
+				continue
+			default:
+				return false, newApplicationError(err, ErrClientK8s)
+			}
+		}
+		if name != d.Name && !ownedBySite(database, d) {
+			log.Info("Not deleting a Database that doesn't belong to the site", "Database", database.Name)
+			continue
+		}
+		if database.DeletionTimestamp.IsZero() {
+			log.V(3).Info("Deleting Database", "Database", database.Name)
+			if err := r.Delete(ctx, database); err != nil && !k8sapierrors.IsNotFound(err) {
+				return true, newApplicationError(err, ErrClientK8s)
+			}
+		}
+		pending = true
+	}
+	return pending, nil
+}
+
+// isSiteBackup checks if the velero backup was taken for the given site
+func isSiteBackup(d *webservicesv1a1.DrupalSite, backup *velerov1.Backup) bool {
+	siteHash := md5.Sum([]byte(d.Name))
+	// Backups taken before the site name was hashed carry it in the `drupalSite` label
+	return backup.Labels["drupal.webservices.cern.ch/drupalSiteHash"] == hex.EncodeToString(siteHash[:]) || backup.Labels["drupal.webservices.cern.ch/drupalSite"] == d.Name
+}
+
+// lastBackupFailed returns the most recent finished backup of the site, if it failed.
+// A backup whose DB dump hook exceeds its timeout ends up PartiallyFailed.
+func lastBackupFailed(d *webservicesv1a1.DrupalSite, veleroBackups []velerov1.Backup) *velerov1.Backup {
+	var last *velerov1.Backup
+	for i := range veleroBackups {
+		backup := &veleroBackups[i]
+		switch backup.Status.Phase {
+		case velerov1.BackupPhaseCompleted, velerov1.BackupPhasePartiallyFailed, velerov1.BackupPhaseFailed:
+		default:
+			continue
+		}
+		if !isSiteBackup(d, backup) || backup.Status.StartTimestamp == nil {
+			continue
+		}
+		if last == nil || last.Status.StartTimestamp.Before(backup.Status.StartTimestamp) {
+			last = backup
+		}
+	}
+	if last == nil || last.Status.Phase == velerov1.BackupPhaseCompleted {
+		return nil
+	}
+	return last
+}
+
+// checkNewBackups returns the list of velero backups that exist for a given site, and its most recent backup if it failed
+func (r *DrupalSiteReconciler) checkNewBackups(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (backups []webservicesv1a1.Backup, failedBackup *velerov1.Backup, reconcileErr reconcileError) {
 	backupList := velerov1.BackupList{}
 	backups = make([]webservicesv1a1.Backup, 0)
 	hash := md5.Sum([]byte(d.Namespace))
@@ -773,10 +1499,76 @@ func (r *DrupalSiteReconciler) checkNewBackups(ctx context.Context, d *webservic
 				backups = append(backups, webservicesv1a1.Backup{BackupName: backupList.Items[i].Name, Date: backupList.Items[i].Status.CompletionTimestamp, Expires: backupList.Items[i].Status.Expiration, DrupalSiteName: d.Name})
 			}
 		}
+		failedBackup = lastBackupFailed(d, backupList.Items)
 	}
 	return
 }
 
+// setBackupFailed reports on the BackupFailed condition if the most recent backup of the site failed, with an event for every failed backup
+func (r *DrupalSiteReconciler) setBackupFailed(d *webservicesv1a1.DrupalSite, failedBackup *velerov1.Backup) (update bool) {
+	if failedBackup == nil {
+		return d.Status.Conditions.RemoveCondition("BackupFailed")
+	}
+	failure := fmt.Errorf("backup %s %s with %d errors", failedBackup.Name, failedBackup.Status.Phase, failedBackup.Status.Errors)
+	if setConditionStatus(d, "BackupFailed", true, newApplicationError(failure, ErrBackupFailed), false) {
+		r.Recorder.Event(d, corev1.EventTypeWarning, "BackupFailed", failure.Error())
+		return true
+	}
+	return false
+}
+
+// retainExpiredBackups appends to the available backups the records of the previously reported ones that have expired since, marked as expired.
+// Only the `expiredBackupsHistory` most recent expired backups are kept.
+func retainExpiredBackups(d *webservicesv1a1.DrupalSite, backups []webservicesv1a1.Backup) []webservicesv1a1.Backup {
+	available := map[string]bool{}
+	for _, backup := range backups {
+		available[backup.BackupName] = true
+	}
+	expired := []webservicesv1a1.Backup{}
+	for _, backup := range d.Status.AvailableBackups {
+		if !available[backup.BackupName] {
+			backup.Expired = true
+			expired = append(expired, backup)
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool {
+		return expired[j].Date.Before(expired[i].Date)
+	})
+	if len(expired) > d.Spec.Configuration.ExpiredBackupsHistory {
+		expired = expired[:d.Spec.Configuration.ExpiredBackupsHistory]
+	}
+	return append(backups, expired...)
+}
+
+// backupsChanged checks if the two lists have different backups, or if any of them has expired since
+func backupsChanged(backups, previous []webservicesv1a1.Backup) bool {
+	if len(backups) != len(previous) {
+		return true
+	}
+	expired := map[string]bool{}
+	for _, backup := range previous {
+		expired[backup.BackupName] = backup.Expired
+	}
+	for _, backup := range backups {
+		if wasExpired, listed := expired[backup.BackupName]; !listed || wasExpired != backup.Expired {
+			return true
+		}
+	}
+	return false
+}
+
+// setLastBackupTime reports the completion of the most recent backup on the status, if it's newer than the reported one
+func setLastBackupTime(d *webservicesv1a1.DrupalSite, backups []webservicesv1a1.Backup) (update bool) {
+	for _, backup := range backups {
+		if backup.Date == nil || (d.Status.LastBackupTime != nil && !d.Status.LastBackupTime.Before(backup.Date)) {
+			continue
+		}
+		d.Status.LastBackupTime = backup.Date.DeepCopy()
+		update = true
+	}
+	return update
+}
+
 // labelsForDrupalSite returns the labels for selecting the resources
 // belonging to the given drupalSite CR name.
 func labelsForDrupalSite(name string) map[string]string {
@@ -790,6 +1582,7 @@ func releaseID(d *webservicesv1a1.DrupalSite) string {
 
 // sitebuilderImageRefToUse returns which base image to use, depending on whether the field `ExtraConfigurationRepo` is set.
 // If yes, the S2I buildconfig will be used; sitebuilderImageRefToUse returns the output of imageStreamForDrupalSiteBuilderS2I().
+// Otherwise, the image of the site's release is `SiteBuilderImageOverride` if set.
 // Otherwise, returns the sitebuilder base
 func sitebuilderImageRefToUse(d *webservicesv1a1.DrupalSite, releaseID string) corev1.ObjectReference {
 	if len(d.Spec.Configuration.ExtraConfigurationRepo) > 0 {
@@ -800,10 +1593,19 @@ func sitebuilderImageRefToUse(d *webservicesv1a1.DrupalSite, releaseID string) c
 	}
 	return corev1.ObjectReference{
 		Kind: "DockerImage",
-		Name: SiteBuilderImage + ":" + releaseID,
+		Name: sitebuilderBaseImage(d, releaseID),
 	}
 }
 
+// sitebuilderBaseImage returns the sitebuilder image of the given release.
+// The override only pins the site's own release, so that the canary and the failsafe rollback keep their images.
+func sitebuilderBaseImage(d *webservicesv1a1.DrupalSite, release string) string {
+	if override := d.Spec.Configuration.SiteBuilderImageOverride; override != "" && release == releaseID(d) {
+		return override
+	}
+	return mirroredImage(SiteBuilderImage + ":" + release)
+}
+
 // imageStreamForDrupalSiteBuilderS2I returns a ImageStream object for Drupal SiteBuilder S2I
 func imageStreamForDrupalSiteBuilderS2I(currentobject *imagev1.ImageStream, d *webservicesv1a1.DrupalSite) error {
 	addOwnerRefToObject(currentobject, asOwner(d))
@@ -819,13 +1621,21 @@ func imageStreamForDrupalSiteBuilderS2I(currentobject *imagev1.ImageStream, d *w
 	return nil
 }
 
+// buildResources returns the resource requests/limits of the site's image builds: the `buildResources` of the site if set,
+// otherwise the operator-wide BuildResources
+func buildResources(d *webservicesv1a1.DrupalSite) corev1.ResourceRequirements {
+	if d.Spec.Configuration.BuildResources != nil {
+		return *d.Spec.Configuration.BuildResources
+	}
+	return BuildResources
+}
+
 // buildConfigForDrupalSiteBuilderS2I returns a BuildConfig object for Drupal SiteBuilder S2I
 func buildConfigForDrupalSiteBuilderS2I(currentobject *buildv1.BuildConfig, d *webservicesv1a1.DrupalSite) error {
 	addOwnerRefToObject(currentobject, asOwner(d))
 	if currentobject.CreationTimestamp.IsZero() {
 		currentobject.Spec = buildv1.BuildConfigSpec{
 			CommonSpec: buildv1.CommonSpec{
-				Resources:                 BuildResources,
 				CompletionDeadlineSeconds: pointer.Int64Ptr(1200),
 				Source: buildv1.BuildSource{
 					Git: &buildv1.GitBuildSource{
@@ -838,7 +1648,7 @@ func buildConfigForDrupalSiteBuilderS2I(currentobject *buildv1.BuildConfig, d *w
 					SourceStrategy: &buildv1.SourceBuildStrategy{
 						From: corev1.ObjectReference{
 							Kind: "DockerImage",
-							Name: SiteBuilderImage + ":" + releaseID(d),
+							Name: sitebuilderBaseImage(d, releaseID(d)),
 						},
 					},
 				},
@@ -862,6 +1672,14 @@ func buildConfigForDrupalSiteBuilderS2I(currentobject *buildv1.BuildConfig, d *w
 			},
 		}
 	}
+	// The resources apply to the next build
+	currentobject.Spec.Resources = buildResources(d)
+	if currentobject.Spec.Strategy.SourceStrategy != nil {
+		currentobject.Spec.Strategy.SourceStrategy.PullSecret = nil
+		if d.Spec.Configuration.ImagePullSecret != "" {
+			currentobject.Spec.Strategy.SourceStrategy.PullSecret = &corev1.LocalObjectReference{Name: d.Spec.Configuration.ImagePullSecret}
+		}
+	}
 	if currentobject.Labels == nil {
 		currentobject.Labels = map[string]string{}
 	}
@@ -899,6 +1717,60 @@ func dbodForDrupalSite(currentobject *dbodv1a1.Database, d *webservicesv1a1.Drup
 	return nil
 }
 
+// secondaryDbodForDrupalSite returns the DBOD resource of the secondary database of the Drupal Site.
+// An existing Database that doesn't belong to the site isn't adopted
+func secondaryDbodForDrupalSite(currentobject *dbodv1a1.Database, d *webservicesv1a1.DrupalSite) error {
+	if !currentobject.CreationTimestamp.IsZero() && !ownedBySite(currentobject, d) {
+		return fmt.Errorf("Database %s exists and doesn't belong to the site", currentobject.Name)
+	}
+	if err := dbodForDrupalSite(currentobject, d); err != nil {
+		return err
+	}
+	if currentobject.CreationTimestamp.IsZero() {
+		dbID := md5.Sum([]byte(d.Namespace + "-" + secondaryDatabaseName(d)))
+		currentobject.Spec.DatabaseClass = string(d.Spec.Configuration.SecondaryDatabaseClass)
+		currentobject.Spec.DbName = hex.EncodeToString(dbID[1:10])
+		currentobject.Spec.DbUser = hex.EncodeToString(dbID[1:10])
+	}
+	return nil
+}
+
+// secondaryDatabaseEnvFrom returns the credentials of the site's secondary database, prefixed with `SECONDARY_` so that they don't override the primary ones
+func secondaryDatabaseEnvFrom(d *webservicesv1a1.DrupalSite) []corev1.EnvFromSource {
+	if d.Spec.Configuration.SecondaryDatabaseClass == "" {
+		return nil
+	}
+	return []corev1.EnvFromSource{{
+		Prefix: "SECONDARY_",
+		SecretRef: &corev1.SecretEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: secondaryDatabaseSecretName(d),
+			},
+		},
+	}}
+}
+
+// extraEnvFromSecrets returns the `extraEnvFromSecrets` of the site, to append after the secrets of the operator
+func extraEnvFromSecrets(d *webservicesv1a1.DrupalSite) []corev1.EnvFromSource {
+	var envFrom []corev1.EnvFromSource
+	for _, secret := range d.Spec.Configuration.ExtraEnvFromSecrets {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: secret,
+				},
+			},
+		})
+	}
+	return envFrom
+}
+
+// reservedEnvVars are the env vars of the site's containers that are set by the operator and can't be overridden through `extraEnv`
+var reservedEnvVars = map[string]bool{
+	"DRUPAL_SHARED_VOLUME": true,
+	"SMTPHOST":             true,
+}
+
 // deploymentForDrupalSite defines the server runtime deployment of a DrupalSite
 func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret string, d *webservicesv1a1.DrupalSite, releaseID string, config DeploymentConfig) error {
 	ls := labelsForDrupalSite(d.Name)
@@ -1061,32 +1933,6 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 					Name:          "php-fpm",
 					Protocol:      "TCP",
 				}}
-				currentobject.Spec.Template.Spec.Containers[i].Env = []corev1.EnvVar{
-					{
-						Name:  "DRUPAL_SHARED_VOLUME",
-						Value: "/drupal-data",
-					},
-					{
-						Name:  "SMTPHOST",
-						Value: SMTPHost,
-					},
-				}
-				currentobject.Spec.Template.Spec.Containers[i].EnvFrom = []corev1.EnvFromSource{
-					{
-						SecretRef: &corev1.SecretEnvSource{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: databaseSecret,
-							},
-						},
-					},
-					{
-						SecretRef: &corev1.SecretEnvSource{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: oidcSecretName, //This is always set the same way
-							},
-						},
-					},
-				}
 				currentobject.Spec.Template.Spec.Containers[i].VolumeMounts = []corev1.VolumeMount{
 					{
 						Name:      "drupal-directory-" + d.Name,
@@ -1181,6 +2027,13 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 		return nil
 	}
 
+	// The log-rotation sidecar only runs while the config override sets a rotation
+	if config.drupalLogsRotation != nil {
+		containerExists("log-rotation", currentobject)
+	} else {
+		removeContainer("log-rotation", currentobject)
+	}
+
 	// Settings on update
 	// We should not enforce image field on every reconcile for containers that rely on imagestreams. For imagestream, the image value will be resolved from the tag name to SHA value by openshift. This in turn causes indefinite rollouts.
 	_, annotExists := currentobject.Spec.Template.ObjectMeta.Annotations["releaseID"]
@@ -1195,6 +2048,8 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 				currentobject.Spec.Template.Spec.Containers[i].Image = sitebuilderImageRefToUse(d, releaseID).Name
 			case "drupal-logs":
 				currentobject.Spec.Template.Spec.Containers[i].Image = sitebuilderImageRefToUse(d, releaseID).Name
+			case "log-rotation":
+				currentobject.Spec.Template.Spec.Containers[i].Image = sitebuilderImageRefToUse(d, releaseID).Name
 			}
 		}
 	}
@@ -1205,8 +2060,46 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 		case "nginx":
 			currentobject.Spec.Template.Spec.Containers[i].Command = []string{"/run-nginx.sh"}
 			currentobject.Spec.Template.Spec.Containers[i].Resources = config.nginxResources
+			currentobject.Spec.Template.Spec.Containers[i].StartupProbe = &v1.Probe{
+				Handler: v1.Handler{
+					TCPSocket: &v1.TCPSocketAction{
+						Port: intstr.FromInt(8080),
+					},
+				},
+				InitialDelaySeconds: 2,
+				TimeoutSeconds:      3,
+				PeriodSeconds:       5,
+				FailureThreshold:    startupFailureThreshold(5),
+				SuccessThreshold:    1,
+			}
 		case "php-fpm":
 			currentobject.Spec.Template.Spec.Containers[i].Command = []string{"/run-php-fpm.sh"}
+			currentobject.Spec.Template.Spec.Containers[i].Env = append([]corev1.EnvVar{
+				{
+					Name:  "DRUPAL_SHARED_VOLUME",
+					Value: "/drupal-data",
+				},
+				{
+					Name:  "SMTPHOST",
+					Value: SMTPHost,
+				},
+			}, d.Spec.Configuration.ExtraEnv...)
+			currentobject.Spec.Template.Spec.Containers[i].EnvFrom = append([]corev1.EnvFromSource{
+				{
+					SecretRef: &corev1.SecretEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: databaseSecret,
+						},
+					},
+				},
+				{
+					SecretRef: &corev1.SecretEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: oidcSecretName, //This is always set the same way
+						},
+					},
+				},
+			}, append(secondaryDatabaseEnvFrom(d), extraEnvFromSecrets(d)...)...)
 			currentobject.Spec.Template.Spec.Containers[i].Resources = config.phpResources
 			currentobject.Spec.Template.Spec.Containers[i].LivenessProbe = &v1.Probe{
 				Handler: v1.Handler{
@@ -1214,12 +2107,28 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 						Command: customProbe("liveness"),
 					},
 				},
-				InitialDelaySeconds: 1800, // Restarting soon after initialization can't fix anything
+				InitialDelaySeconds: 60, // Only counts after the startup probe succeeded
 				TimeoutSeconds:      202,
 				PeriodSeconds:       210,
 				FailureThreshold:    5,
 				SuccessThreshold:    1,
 			}
+			applyProbeTimings(currentobject.Spec.Template.Spec.Containers[i].LivenessProbe, d.Spec.Configuration.PhpFpmLivenessProbe)
+			// The master of php-fpm accepts connections even while all the workers are busy, eg during a heavy cron run,
+			// so the pod is only taken out of the service if php-fpm stops listening
+			currentobject.Spec.Template.Spec.Containers[i].ReadinessProbe = &v1.Probe{
+				Handler: v1.Handler{
+					TCPSocket: &v1.TCPSocketAction{
+						Port: intstr.FromInt(9000),
+					},
+				},
+				InitialDelaySeconds: 5,
+				TimeoutSeconds:      5,
+				PeriodSeconds:       10,
+				FailureThreshold:    6,
+				SuccessThreshold:    1,
+			}
+			applyProbeTimings(currentobject.Spec.Template.Spec.Containers[i].ReadinessProbe, d.Spec.Configuration.PhpFpmReadinessProbe)
 			currentobject.Spec.Template.Spec.Containers[i].StartupProbe = &v1.Probe{
 				Handler: v1.Handler{
 					Exec: &v1.ExecAction{
@@ -1229,14 +2138,14 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 				InitialDelaySeconds: 2, // fast check, since this is a startup probe
 				TimeoutSeconds:      3,
 				PeriodSeconds:       3,
-				FailureThreshold:    3,
+				FailureThreshold:    startupFailureThreshold(3),
 				SuccessThreshold:    1,
 			}
 		case "php-fpm-exporter":
-			currentobject.Spec.Template.Spec.Containers[i].Image = PhpFpmExporterImage
+			currentobject.Spec.Template.Spec.Containers[i].Image = imageOrOverride(PhpFpmExporterImage, d.Spec.Configuration.PhpFpmExporterImageOverride)
 			currentobject.Spec.Template.Spec.Containers[i].Resources = config.phpExporterResources
 		case "webdav":
-			currentobject.Spec.Template.Spec.Containers[i].Image = WebDAVImage
+			currentobject.Spec.Template.Spec.Containers[i].Image = imageOrOverride(WebDAVImage, d.Spec.Configuration.WebDAVImageOverride)
 			currentobject.Spec.Template.Spec.Containers[i].Command = []string{"php-fpm"}
 			currentobject.Spec.Template.Spec.Containers[i].Resources = config.webDAVResources
 		case "cron":
@@ -1245,6 +2154,8 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 				"-c",
 				"/operations/cronjob.sh -s " + d.Name,
 			}
+			currentobject.Spec.Template.Spec.Containers[i].Env = d.Spec.Configuration.ExtraEnv
+			currentobject.Spec.Template.Spec.Containers[i].EnvFrom = extraEnvFromSecrets(d)
 			currentobject.Spec.Template.Spec.Containers[i].Resources = config.cronResources
 			currentobject.Spec.Template.Spec.Containers[i].ImagePullPolicy = "Always"
 			currentobject.Spec.Template.Spec.Containers[i].VolumeMounts = []corev1.VolumeMount{
@@ -1271,24 +2182,57 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 					MountPath: "/var/run/",
 				},
 			}
+		case "log-rotation":
+			// The sidecar can be added to a running deployment, without a new release to set its image
+			if container.Image == "" {
+				currentobject.Spec.Template.Spec.Containers[i].Image = sitebuilderImageRefToUse(d, releaseID).Name
+			}
+			currentobject.Spec.Template.Spec.Containers[i].Command = logRotationCommand(config.drupalLogsRotation)
+			currentobject.Spec.Template.Spec.Containers[i].Resources = config.logRotationResources
+			currentobject.Spec.Template.Spec.Containers[i].ImagePullPolicy = "Always"
+			currentobject.Spec.Template.Spec.Containers[i].VolumeMounts = []corev1.VolumeMount{
+				{
+					Name:      "empty-dir",
+					MountPath: "/var/run/",
+				},
+			}
 		}
 	}
 	currentobject.Spec.Replicas = &config.replicas
+	currentobject.Spec.Strategy = deploymentStrategy(d)
+	currentobject.Spec.RevisionHistoryLimit = pointer.Int32Ptr(int32(DeploymentRevisionHistoryLimit))
 	// Add an annotation to be able to verify what releaseID of pod is running. Did not use labels, as it will affect the labelselector for the deployment and might cause downtime
 	currentobject.Spec.Template.ObjectMeta.Annotations["releaseID"] = releaseID
+	// The release label selects the pods of each release for the Services of the traffic ramp. It's not part of the selector of the deployment
+	templateLabels := map[string]string{}
+	for k, v := range currentobject.Spec.Template.ObjectMeta.Labels {
+		templateLabels[k] = v
+	}
+	delete(templateLabels, releaseLabel)
+	if d.Spec.Configuration.TrafficRamp {
+		templateLabels[releaseLabel] = releaseLabelValue(releaseID)
+	}
+	currentobject.Spec.Template.ObjectMeta.Labels = templateLabels
+	// The hash of the WebDAV credentials rolls the deployment out when the password is rotated
+	webDAVHash := md5.Sum([]byte(encryptBasicAuthPassword(d.Spec.Configuration.WebDAVPassword)))
+	currentobject.Spec.Template.ObjectMeta.Annotations["webdav-secret/hash"] = hex.EncodeToString(webDAVHash[:])
 	currentobject.Spec.Template.ObjectMeta.Annotations["pre.hook.backup.velero.io/container"] = "php-fpm"
-	currentobject.Spec.Template.ObjectMeta.Annotations["pre.hook.backup.velero.io/command"] = "[\"sh\",\"-c\", \"/operations/database-backup.sh -f database_backup.sql\"]"
+	currentobject.Spec.Template.ObjectMeta.Annotations["pre.hook.backup.velero.io/command"] = backupHookCommand()
 	// Since we have varying sizes of databases, the timeout needs to be large enough. Else the backups will fail.
 	// Ref: https://gitlab.cern.ch/drupal/paas/drupalsite-operator/-/issues/71
-	currentobject.Spec.Template.ObjectMeta.Annotations["pre.hook.backup.velero.io/timeout"] = "90m"
+	currentobject.Spec.Template.ObjectMeta.Annotations["pre.hook.backup.velero.io/timeout"] = backupHookTimeout(d).String()
 	currentobject.Spec.Template.ObjectMeta.Annotations["backup.velero.io/backup-volumes"] = "drupal-directory-" + d.Name
 	if d.Spec.QoSClass == webservicesv1a1.QoSCritical {
 		currentobject.Annotations["critical-site"] = "true"
-		// TODO: move this to the `DeploymentConfig` function
-		// openshift-user-critical is part of the default OKD4 Priority classes
-		// https://github.com/openshift/cluster-config-operator/blob/168704868381c88551627239d132a3900eedc14f/manifests/0000_50_config-operator_09_user-priority-class.yaml
-		currentobject.Spec.Template.Spec.PriorityClassName = "openshift-user-critical"
 	}
+	// TODO: move this to the `DeploymentConfig` function
+	currentobject.Spec.Template.Spec.PriorityClassName = priorityClassName(d)
+	currentobject.Spec.Template.Spec.ImagePullSecrets = imagePullSecrets(d)
+	mountExtraSettings(&currentobject.Spec.Template.Spec, d, "php-fpm")
+	mountBackupExcludedPaths(&currentobject.Spec.Template.Spec, d, "nginx", "php-fpm", "webdav", "cron")
+	mountExtraNginxConfig(&currentobject.Spec.Template.Spec)
+
+	currentobject.Spec.Template.Spec.Affinity = replicaAntiAffinity(d, config.replicas)
 
 	// Ensure availability zones for critical sites if enabled
 	if d.Spec.QoSClass == webservicesv1a1.QoSCritical && EnableTopologySpread {
@@ -1319,6 +2263,72 @@ func deploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret st
 	return nil
 }
 
+// mountExtraNginxConfig mounts the `extraNginxConfig` of the site's nginx ConfigMap where the server block of nginx includes it.
+// The mount is enforced on every reconcile, so that the deployments created before it get it too.
+func mountExtraNginxConfig(podSpec *corev1.PodSpec) {
+	mount := corev1.VolumeMount{Name: "nginx-global-config", MountPath: extraNginxConfigPath, SubPath: extraNginxConfigKey, ReadOnly: true}
+	for i, container := range podSpec.Containers {
+		if container.Name != "nginx" {
+			continue
+		}
+		for _, volumeMount := range container.VolumeMounts {
+			if volumeMount.MountPath == extraNginxConfigPath {
+				return
+			}
+		}
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, mount)
+	}
+}
+
+// mountExtraSettings mounts the `settings.local.php` of the site's `extraSettingsConfigMap` next to the `settings.php` of the given containers,
+// which includes it. The mount is removed when the site stops referencing a ConfigMap.
+func mountExtraSettings(podSpec *corev1.PodSpec, d *webservicesv1a1.DrupalSite, containerNames ...string) {
+	volumes := []corev1.Volume{}
+	for _, volume := range podSpec.Volumes {
+		if volume.Name != extraSettingsVolume {
+			volumes = append(volumes, volume)
+		}
+	}
+	configMap := d.Spec.Configuration.ExtraSettingsConfigMap
+	if configMap != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: extraSettingsVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: configMap,
+					},
+				},
+			},
+		})
+	}
+	podSpec.Volumes = volumes
+	for i, container := range podSpec.Containers {
+		mount := false
+		for _, name := range containerNames {
+			mount = mount || container.Name == name
+		}
+		if !mount {
+			continue
+		}
+		mounts := []corev1.VolumeMount{}
+		for _, volumeMount := range container.VolumeMounts {
+			if volumeMount.Name != extraSettingsVolume {
+				mounts = append(mounts, volumeMount)
+			}
+		}
+		if configMap != "" {
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      extraSettingsVolume,
+				MountPath: "/app/web/sites/default/settings.local.php",
+				SubPath:   "settings.local.php",
+				ReadOnly:  true,
+			})
+		}
+		podSpec.Containers[i].VolumeMounts = mounts
+	}
+}
+
 // secretForWebDAV returns a Secret object
 func secretForWebDAV(currentobject *corev1.Secret, d *webservicesv1a1.DrupalSite) error {
 	addOwnerRefToObject(currentobject, asOwner(d))
@@ -1338,25 +2348,133 @@ func secretForWebDAV(currentobject *corev1.Secret, d *webservicesv1a1.DrupalSite
 	return nil
 }
 
-// persistentVolumeClaimForDrupalSite returns a PVC object
-func persistentVolumeClaimForDrupalSite(currentobject *corev1.PersistentVolumeClaim, d *webservicesv1a1.DrupalSite) error {
+// adminAccountName returns the name of the administrator account passed to the site installation
+func adminAccountName(d *webservicesv1a1.DrupalSite) string {
+	if d.Spec.Configuration.AdminAccount != nil && d.Spec.Configuration.AdminAccount.Name != "" {
+		return d.Spec.Configuration.AdminAccount.Name
+	}
+	return "admin"
+}
+
+// adminAccountPasswordSecret returns the Secret with the password of the administrator account given in the spec, if any
+func adminAccountPasswordSecret(d *webservicesv1a1.DrupalSite) string {
+	if d.Spec.Configuration.AdminAccount != nil {
+		return d.Spec.Configuration.AdminAccount.PasswordSecret
+	}
+	return ""
+}
+
+// adminAccountSecretName returns the Secret with the password of the administrator account:
+// the one given in the spec, else the one generated by the operator
+func adminAccountSecretName(d *webservicesv1a1.DrupalSite) string {
+	if secret := adminAccountPasswordSecret(d); secret != "" {
+		return secret
+	}
+	return "admin-account-" + d.Name
+}
+
+// adminAccountEnv returns the environment variables with the administrator account for the install job, if EnableAdminAccount is set.
+// The password is referenced from its Secret, never set literally.
+func adminAccountEnv(d *webservicesv1a1.DrupalSite) []corev1.EnvVar {
+	if !EnableAdminAccount {
+		return nil
+	}
+	return []corev1.EnvVar{
+		{
+			Name:  "DRUPAL_ADMIN_NAME",
+			Value: adminAccountName(d),
+		},
+		{
+			Name: "DRUPAL_ADMIN_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: adminAccountSecretName(d)},
+					Key:                  "password",
+				},
+			},
+		},
+	}
+}
+
+// secretForAdminAccount returns a Secret with a random password for the administrator account.
+// The password is only generated when the Secret is created.
+func secretForAdminAccount(currentobject *corev1.Secret, d *webservicesv1a1.DrupalSite) error {
+	addOwnerRefToObject(currentobject, asOwner(d))
+	currentobject.Type = "kubernetes.io/opaque"
+	if currentobject.CreationTimestamp.IsZero() {
+		password, err := generateSecurePassword()
+		if err != nil {
+			return newApplicationError(err, ErrFunctionDomain)
+		}
+		currentobject.StringData = map[string]string{
+			"password": password,
+		}
+	}
+	if currentobject.Labels == nil {
+		currentobject.Labels = map[string]string{}
+	}
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "drupal"
+	for k, v := range ls {
+		currentobject.Labels[k] = v
+	}
+	return nil
+}
+
+// persistentVolumeClaimForDrupalSite returns a PVC object
+func persistentVolumeClaimForDrupalSite(currentobject *corev1.PersistentVolumeClaim, d *webservicesv1a1.DrupalSite) error {
+	addOwnerRefToObject(currentobject, asOwner(d))
+	if currentobject.CreationTimestamp.IsZero() {
+		currentobject.Spec = corev1.PersistentVolumeClaimSpec{
+			// Selector: &metav1.LabelSelector{
+			// 	MatchLabels: ls,
+			// },
+			StorageClassName: pointer.StringPtr(DefaultStorageClass),
+			AccessModes:      []corev1.PersistentVolumeAccessMode{"ReadWriteMany"},
+		}
+	}
+
+	// The volume can only be expanded, a smaller DiskSize is rejected by validateDiskSize
+	diskSize := resource.MustParse(d.Spec.Configuration.DiskSize)
+	if currentSize, set := currentobject.Spec.Resources.Requests[corev1.ResourceStorage]; !set || diskSize.Cmp(currentSize) > 0 {
+		currentobject.Spec.Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceName(corev1.ResourceStorage): diskSize,
+			},
+		}
+	}
+
+	if currentobject.Labels == nil {
+		currentobject.Labels = map[string]string{}
+	}
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "drupal"
+	for k, v := range ls {
+		currentobject.Labels[k] = v
+	}
+	return nil
+}
+
+// backupExcludedClaimName is the name of the PVC that holds the `backupExcludedPaths` of the site
+func backupExcludedClaimName(d *webservicesv1a1.DrupalSite) string {
+	return "pv-claim-excluded-" + d.Name
+}
+
+// backupExcludedClaimForDrupalSite returns the PVC that holds the `backupExcludedPaths` of the site.
+// It's shared by the replicas like the site's volume, but velero doesn't back it up
+func backupExcludedClaimForDrupalSite(currentobject *corev1.PersistentVolumeClaim, d *webservicesv1a1.DrupalSite) error {
 	addOwnerRefToObject(currentobject, asOwner(d))
 	if currentobject.CreationTimestamp.IsZero() {
 		currentobject.Spec = corev1.PersistentVolumeClaimSpec{
-			// Selector: &metav1.LabelSelector{
-			// 	MatchLabels: ls,
-			// },
-			StorageClassName: pointer.StringPtr("cephfs-no-backup"),
+			StorageClassName: pointer.StringPtr(DefaultStorageClass),
 			AccessModes:      []corev1.PersistentVolumeAccessMode{"ReadWriteMany"},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse(backupExcludedVolumeSize),
+				},
+			},
 		}
 	}
-
-	currentobject.Spec.Resources = corev1.ResourceRequirements{
-		Requests: corev1.ResourceList{
-			corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(d.Spec.Configuration.DiskSize),
-		},
-	}
-
 	if currentobject.Labels == nil {
 		currentobject.Labels = map[string]string{}
 	}
@@ -1368,6 +2486,141 @@ func persistentVolumeClaimForDrupalSite(currentobject *corev1.PersistentVolumeCl
 	return nil
 }
 
+// mountBackupExcludedPaths mounts the `backupExcludedPaths` of the site from the backupExcludedVolume on the given containers and init containers,
+// over the same paths of the shared volume. Since the volume isn't in the "backup.velero.io/backup-volumes" annotation, they aren't backed up
+func mountBackupExcludedPaths(podSpec *corev1.PodSpec, d *webservicesv1a1.DrupalSite, containerNames ...string) {
+	volumes := []corev1.Volume{}
+	for _, volume := range podSpec.Volumes {
+		if volume.Name != backupExcludedVolume {
+			volumes = append(volumes, volume)
+		}
+	}
+	excludedPaths := d.Spec.Configuration.BackupExcludedPaths
+	if len(excludedPaths) > 0 {
+		volumes = append(volumes, corev1.Volume{
+			Name: backupExcludedVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: backupExcludedClaimName(d),
+				},
+			},
+		})
+	}
+	podSpec.Volumes = volumes
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for i, container := range containers {
+			mount := false
+			for _, name := range containerNames {
+				mount = mount || container.Name == name
+			}
+			if !mount {
+				continue
+			}
+			mounts := []corev1.VolumeMount{}
+			for _, volumeMount := range container.VolumeMounts {
+				if volumeMount.Name != backupExcludedVolume {
+					mounts = append(mounts, volumeMount)
+				}
+			}
+			for _, excluded := range excludedPaths {
+				mounts = append(mounts, corev1.VolumeMount{
+					Name:      backupExcludedVolume,
+					MountPath: "/drupal-data/" + path.Clean(excluded),
+					SubPath:   path.Clean(excluded),
+				})
+			}
+			containers[i].VolumeMounts = mounts
+		}
+	}
+}
+
+// canaryName is the name of the deployment, service and route of the canary version of the site
+func canaryName(d *webservicesv1a1.DrupalSite) string {
+	return d.Name + "-canary"
+}
+
+// canaryReleaseID is the image tag of the canary version of the site
+func canaryReleaseID(d *webservicesv1a1.DrupalSite) string {
+	return d.Spec.CanaryVersion.Name + "-" + d.Spec.CanaryVersion.ReleaseSpec
+}
+
+// labelsForCanary returns the labels of the canary resources, which are distinct from the labels of the site's server
+// so that the site's service and operations never select the canary pods
+func labelsForCanary(d *webservicesv1a1.DrupalSite) map[string]string {
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "drupal-canary"
+	return ls
+}
+
+// canaryDeploymentForDrupalSite returns the deployment that serves the canary version of the site.
+// It runs a single replica of the server against the site's database and files, without the containers that write on their own (cron, webdav).
+// The files of the site are mounted read-only, and the pods aren't backed up, since the site's pods already back up the same volume and database.
+// The canary still connects to the database with the site's credentials, since DBOD doesn't provision read-only users.
+func canaryDeploymentForDrupalSite(currentobject *appsv1.Deployment, databaseSecret string, d *webservicesv1a1.DrupalSite, config DeploymentConfig) error {
+	if err := deploymentForDrupalSite(currentobject, databaseSecret, d, canaryReleaseID(d), config); err != nil {
+		return err
+	}
+	ls := labelsForCanary(d)
+	for k, v := range ls {
+		currentobject.Labels[k] = v
+	}
+	currentobject.Spec.Selector = &metav1.LabelSelector{
+		MatchLabels: ls,
+	}
+	currentobject.Spec.Template.ObjectMeta.Labels = ls
+	currentobject.Spec.Replicas = pointer.Int32Ptr(1)
+	currentobject.Spec.Template.Spec.Affinity = nil
+	containers := []corev1.Container{}
+	for _, container := range currentobject.Spec.Template.Spec.Containers {
+		if container.Name != "cron" && container.Name != "webdav" {
+			containers = append(containers, container)
+		}
+	}
+	for i := range containers {
+		for j, volumeMount := range containers[i].VolumeMounts {
+			if volumeMount.Name == "drupal-directory-"+d.Name || volumeMount.Name == backupExcludedVolume {
+				containers[i].VolumeMounts[j].ReadOnly = true
+			}
+		}
+	}
+	currentobject.Spec.Template.Spec.Containers = containers
+	for _, annotation := range []string{"pre.hook.backup.velero.io/container", "pre.hook.backup.velero.io/command", "pre.hook.backup.velero.io/timeout", "backup.velero.io/backup-volumes"} {
+		delete(currentobject.Spec.Template.ObjectMeta.Annotations, annotation)
+	}
+	return nil
+}
+
+// canaryServiceForDrupalSite returns the service of the canary version of the site
+func canaryServiceForDrupalSite(currentobject *corev1.Service, d *webservicesv1a1.DrupalSite) error {
+	if err := serviceForDrupalSite(currentobject, d); err != nil {
+		return err
+	}
+	ls := labelsForCanary(d)
+	for k, v := range ls {
+		currentobject.Labels[k] = v
+	}
+	currentobject.Spec.Selector = ls
+	return nil
+}
+
+// canaryRouteForDrupalSite returns the route of the canary version of the site
+func canaryRouteForDrupalSite(currentobject *routev1.Route, d *webservicesv1a1.DrupalSite) error {
+	// The certificate of the site doesn't cover the canary URL
+	if err := routeForDrupalSite(currentobject, d, string(d.Spec.CanaryURL), nil); err != nil {
+		return err
+	}
+	currentobject.Spec.To = routev1.RouteTargetReference{
+		Kind:   "Service",
+		Name:   canaryName(d),
+		Weight: pointer.Int32Ptr(100),
+	}
+	currentobject.Spec.AlternateBackends = nil
+	// The canary route must not be removed as an extra route of the site
+	currentobject.Labels["app"] = "drupal-canary"
+	currentobject.Labels["route"] = "canary"
+	return nil
+}
+
 // serviceForDrupalSite returns a service object
 func serviceForDrupalSite(currentobject *corev1.Service, d *webservicesv1a1.DrupalSite) error {
 	if currentobject.Labels == nil {
@@ -1398,16 +2651,17 @@ func serviceForDrupalSite(currentobject *corev1.Service, d *webservicesv1a1.Drup
 }
 
 // routeForDrupalSite returns a route object
-func routeForDrupalSite(currentobject *routev1.Route, d *webservicesv1a1.DrupalSite, Url string) error {
+func routeForDrupalSite(currentobject *routev1.Route, d *webservicesv1a1.DrupalSite, Url string, certificateSecret *corev1.Secret) error {
 	addOwnerRefToObject(currentobject, asOwner(d))
-	currentobject.Spec.TLS = &routev1.TLSConfig{
-		InsecureEdgeTerminationPolicy: "Redirect",
-		Termination:                   "edge",
-	}
-	currentobject.Spec.To = routev1.RouteTargetReference{
-		Kind:   "Service",
-		Name:   d.Name,
-		Weight: pointer.Int32Ptr(100),
+	currentobject.Spec.TLS = routeTLSConfig(d, certificateSecret)
+	// During a traffic ramp, the backends of the route are weighted by ensureTrafficRamp
+	if !trafficRampActive(d) || currentobject.CreationTimestamp.IsZero() {
+		currentobject.Spec.To = routev1.RouteTargetReference{
+			Kind:   "Service",
+			Name:   d.Name,
+			Weight: pointer.Int32Ptr(100),
+		}
+		currentobject.Spec.AlternateBackends = nil
 	}
 	currentobject.Spec.Port = &routev1.RoutePort{
 		TargetPort: intstr.FromInt(8080),
@@ -1436,11 +2690,35 @@ func routeForDrupalSite(currentobject *routev1.Route, d *webservicesv1a1.DrupalS
 	return nil
 }
 
+// routeTLSConfig returns the TLS configuration of the site's routes: edge termination with the router's certificate by default,
+// else the termination of the RouteTLS spec with the certificates of the given Secret
+func routeTLSConfig(d *webservicesv1a1.DrupalSite, certificateSecret *corev1.Secret) *routev1.TLSConfig {
+	tls := &routev1.TLSConfig{
+		InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyRedirect,
+		Termination:                   routev1.TLSTerminationEdge,
+	}
+	routeTLS := d.Spec.Configuration.RouteTLS
+	if routeTLS == nil {
+		return tls
+	}
+	if routeTLS.Termination != "" {
+		tls.Termination = routev1.TLSTerminationType(routeTLS.Termination)
+	}
+	if certificateSecret != nil && tls.Termination != routev1.TLSTerminationPassthrough {
+		tls.Certificate = string(certificateSecret.Data[corev1.TLSCertKey])
+		tls.Key = string(certificateSecret.Data[corev1.TLSPrivateKeyKey])
+		tls.CACertificate = string(certificateSecret.Data["ca.crt"])
+		if tls.Termination == routev1.TLSTerminationReencrypt {
+			tls.DestinationCACertificate = string(certificateSecret.Data["destination-ca.crt"])
+		}
+	}
+	return tls
+}
+
 // newOidcReturnURI returns a oidcReturnURI object
-func newOidcReturnURI(currentobject *authz.OidcReturnURI, d *webservicesv1a1.DrupalSite, Url string, http bool) error {
-	returnURI := ""
+func newOidcReturnURI(currentobject *authz.OidcReturnURI, d *webservicesv1a1.DrupalSite, Url string, scheme string) error {
 	addOwnerRefToObject(currentobject, asOwner(d))
-	url, err := url.Parse(Url)
+	returnURI, err := oidcReturnURI(scheme, Url)
 	if err != nil {
 		return err
 	}
@@ -1456,36 +2734,66 @@ func newOidcReturnURI(currentobject *authz.OidcReturnURI, d *webservicesv1a1.Dru
 		currentobject.Labels[k] = v
 	}
 
-	// This will append `/openid-connect/*` to the URL, guaranteeing all subpaths of the link can be redirected
-	url.Path = path.Join(url.Path, "openid-connect")
-	if http {
-		returnURI = "http://" + url.String() + "/*" // Hardcoded since with path.Join method creates `%2A` which will not work in the AuthzAPI, and the prefix `http`
-	} else {
-		returnURI = "https://" + url.String() + "/*" // Hardcoded since with path.Join method creates `%2A` which will not work in the AuthzAPI, and the prefix `http`
-	}
 	currentobject.Spec = authz.OidcReturnURISpec{
 		RedirectURI: returnURI,
 	}
 	return nil
 }
 
+// oidcReturnURI returns the OIDC return URI of the given site URL with the given scheme.
+// It appends `/openid-connect/*` to the URL, guaranteeing all subpaths of the link can be redirected.
+// The wildcard is appended after the URL is encoded, which would turn it into `%2A` that doesn't work in the AuthzAPI
+func oidcReturnURI(scheme string, siteURL string) (string, error) {
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("OIDC return URI scheme must be http or https, got %q", scheme)
+	}
+	returnURI := url.URL{Scheme: scheme, Host: siteURL, Path: "/openid-connect"}
+	return returnURI.String() + "/*", nil
+}
+
+// defaultJobTTLSeconds is how long the finished install and clone jobs are kept, if the DrupalSite doesn't set `jobTTLSeconds`
+const defaultJobTTLSeconds = 3600
+
+// jobBackoffLimit returns the backoff limit of the install and clone jobs: `installJobBackoffLimit` if set, else the given default
+func jobBackoffLimit(d *webservicesv1a1.DrupalSite, defaultLimit *int32) *int32 {
+	if d.Spec.Configuration.InstallJobBackoffLimit != nil {
+		return pointer.Int32Ptr(*d.Spec.Configuration.InstallJobBackoffLimit)
+	}
+	return defaultLimit
+}
+
+// jobTTLSeconds returns how long the install and clone jobs are kept after they finish
+func jobTTLSeconds(d *webservicesv1a1.DrupalSite) *int32 {
+	if d.Spec.Configuration.JobTTLSeconds != nil {
+		return pointer.Int32Ptr(*d.Spec.Configuration.JobTTLSeconds)
+	}
+	return pointer.Int32Ptr(defaultJobTTLSeconds)
+}
+
 // jobForDrupalSiteInstallation returns a job object thats runs drush
 func jobForDrupalSiteInstallation(currentobject *batchv1.Job, databaseSecret string, d *webservicesv1a1.DrupalSite) error {
 	ls := labelsForDrupalSite(d.Name)
 	if currentobject.CreationTimestamp.IsZero() {
 		addOwnerRefToObject(currentobject, asOwner(d))
 		currentobject.Labels = map[string]string{}
+		currentobject.Annotations = map[string]string{siteGenerationAnnotation: strconv.FormatInt(d.Generation, 10)}
 		currentobject.Spec.Template.ObjectMeta = metav1.ObjectMeta{
 			Labels: ls,
 		}
-		currentobject.Spec.BackoffLimit = pointer.Int32Ptr(3)
+		currentobject.Spec.BackoffLimit = jobBackoffLimit(d, pointer.Int32Ptr(3))
+		currentobject.Spec.TTLSecondsAfterFinished = jobTTLSeconds(d)
+		pvcInitResources, err := initContainerResources("pvc-init", d)
+		if err != nil {
+			return err
+		}
 		// Increasing the limit temporarily to fix https://gitlab.cern.ch/webservices/webframeworks-planning/-/issues/479
 		currentobject.Spec.Template.Spec = corev1.PodSpec{
 			InitContainers: []corev1.Container{{
-				Image:           "bash",
+				Image:           mirroredImage("bash"),
 				Name:            "pvc-init",
 				ImagePullPolicy: "IfNotPresent",
-				Command:         []string{"bash", "-c", "mkdir -p $DRUPAL_SHARED_VOLUME/{files,private,modules,themes}"},
+				Command:         sharedVolumeMkdirCommand(d),
+				Resources:       pvcInitResources,
 				Env: []corev1.EnvVar{
 					{
 						Name:  "DRUPAL_SHARED_VOLUME",
@@ -1497,7 +2805,9 @@ func jobForDrupalSiteInstallation(currentobject *batchv1.Job, databaseSecret str
 					MountPath: "/drupal-data",
 				}},
 			}},
-			RestartPolicy: "Never",
+			RestartPolicy:     "Never",
+			PriorityClassName: priorityClassName(d),
+			ImagePullSecrets:  imagePullSecrets(d),
 			Containers: []corev1.Container{{
 				Image:           sitebuilderImageRefToUse(d, releaseID(d)).Name,
 				Name:            "drush",
@@ -1508,7 +2818,7 @@ func jobForDrupalSiteInstallation(currentobject *batchv1.Job, databaseSecret str
 					},
 				},
 				Command: siteInstallJobForDrupalSite(),
-				Env: []corev1.EnvVar{
+				Env: append([]corev1.EnvVar{
 					{
 						Name:  "DRUPAL_SHARED_VOLUME",
 						Value: "/drupal-data",
@@ -1517,8 +2827,8 @@ func jobForDrupalSiteInstallation(currentobject *batchv1.Job, databaseSecret str
 						Name:  "SMTPHOST",
 						Value: SMTPHost,
 					},
-				},
-				EnvFrom: []corev1.EnvFromSource{
+				}, adminAccountEnv(d)...),
+				EnvFrom: append([]corev1.EnvFromSource{
 					{
 						SecretRef: &corev1.SecretEnvSource{
 							LocalObjectReference: corev1.LocalObjectReference{
@@ -1533,7 +2843,7 @@ func jobForDrupalSiteInstallation(currentobject *batchv1.Job, databaseSecret str
 							},
 						},
 					},
-				},
+				}, extraEnvFromSecrets(d)...),
 				VolumeMounts: []corev1.VolumeMount{
 					{
 						Name:      "drupal-directory-" + d.Name,
@@ -1592,6 +2902,8 @@ func jobForDrupalSiteInstallation(currentobject *batchv1.Job, databaseSecret str
 				},
 			},
 		}
+		mountExtraSettings(&currentobject.Spec.Template.Spec, d, "drush")
+		mountBackupExcludedPaths(&currentobject.Spec.Template.Spec, d, "pvc-init", "drush")
 		ls["app"] = "drush"
 		for k, v := range ls {
 			currentobject.Labels[k] = v
@@ -1611,6 +2923,13 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 		currentobject.Spec.Template.ObjectMeta = metav1.ObjectMeta{
 			Labels: ls,
 		}
+		currentobject.Spec.BackoffLimit = jobBackoffLimit(d, nil)
+		currentobject.Spec.TTLSecondsAfterFinished = jobTTLSeconds(d)
+		// The database dump of the source site needs its own memory, so the `initContainerResources` of the spec don't apply to it
+		srcDBBackupResources, err := reqLimDict("src-db-backup", d.Spec.QoSClass)
+		if err != nil {
+			return err
+		}
 		currentobject.Spec.Template.Spec = corev1.PodSpec{
 			InitContainers: []corev1.Container{
 				{
@@ -1618,11 +2937,7 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 					Name:            "src-db-backup",
 					ImagePullPolicy: "Always",
 					Command:         takeBackup(emptyDir + "dbBackUp.sql"),
-					Resources: corev1.ResourceRequirements{
-						Requests: corev1.ResourceList{
-							corev1.ResourceMemory: resource.MustParse(jobMemoryRequest),
-						},
-					},
+					Resources:       srcDBBackupResources,
 					Env: []corev1.EnvVar{
 						{
 							Name:  "DRUPAL_SHARED_VOLUME",
@@ -1633,7 +2948,7 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 						{
 							SecretRef: &corev1.SecretEnvSource{
 								LocalObjectReference: corev1.LocalObjectReference{
-									Name: "dbcredentials-" + string(d.Spec.Configuration.CloneFrom),
+									Name: cloneSourceDBSecretName(d),
 								},
 							},
 						},
@@ -1647,19 +2962,21 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 					},
 				},
 			},
-			RestartPolicy: "Never",
+			RestartPolicy:     "Never",
+			PriorityClassName: priorityClassName(d),
+			ImagePullSecrets:  imagePullSecrets(d),
 			Containers: []corev1.Container{{
 				Image:           sitebuilderImageRefToUse(d, releaseID(d)).Name,
 				Name:            "dest-clone",
 				ImagePullPolicy: "Always",
-				Command:         cloneSource(emptyDir + "dbBackUp.sql"),
+				Command:         cloneSource(emptyDir+"dbBackUp.sql", d.Spec.Configuration.CloneStrategy, emptyDir+cloneProgressFile),
 				Env: []corev1.EnvVar{
 					{
 						Name:  "DRUPAL_SHARED_VOLUME",
 						Value: "/drupal-data-source",
 					},
 				},
-				EnvFrom: []corev1.EnvFromSource{
+				EnvFrom: append([]corev1.EnvFromSource{
 					{
 						SecretRef: &corev1.SecretEnvSource{
 							LocalObjectReference: corev1.LocalObjectReference{
@@ -1674,11 +2991,12 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 							},
 						},
 					},
-				},
+				}, extraEnvFromSecrets(d)...),
 				VolumeMounts: []corev1.VolumeMount{
 					{
-						Name:      "drupal-directory-" + string(d.Spec.Configuration.CloneFrom),
+						Name:      cloneSourceVolumeName(d),
 						MountPath: "/drupal-data-source",
+						ReadOnly:  true,
 					},
 					{
 						Name:      "drupal-directory-" + d.Name,
@@ -1712,10 +3030,11 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 					},
 				},
 				{
-					Name: "drupal-directory-" + string(d.Spec.Configuration.CloneFrom),
+					Name: cloneSourceVolumeName(d),
 					VolumeSource: corev1.VolumeSource{
 						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: "pv-claim-" + string(d.Spec.Configuration.CloneFrom),
+							ClaimName: cloneSourceClaimName(d),
+							ReadOnly:  true,
 						},
 					},
 				},
@@ -1746,6 +3065,61 @@ func jobForDrupalSiteClone(currentobject *batchv1.Job, databaseSecret string, d
 				},
 			},
 		}
+		// A cloneFrom DrupalSite of another project is pulled from its clone export job, instead of mounting its volume and DB credentials
+		if crossNamespaceClone(d) {
+			podSpec := &currentobject.Spec.Template.Spec
+			podSpec.InitContainers = nil
+			podSpec.Containers[0].Command = cloneFromExport(d, emptyDir+"dbBackUp.sql", emptyDir+cloneProgressFile)
+			podSpec.Containers[0].Env = []corev1.EnvVar{{
+				Name:  "DRUPAL_SHARED_VOLUME",
+				Value: "/drupal-data",
+			}, {
+				Name: "RSYNC_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cloneSourceSecretName(d)},
+					Key:                  "RSYNC_PASSWORD",
+				}},
+			}}
+			mounts := []corev1.VolumeMount{}
+			for _, volumeMount := range podSpec.Containers[0].VolumeMounts {
+				if volumeMount.Name != cloneSourceVolumeName(d) {
+					mounts = append(mounts, volumeMount)
+				}
+			}
+			podSpec.Containers[0].VolumeMounts = mounts
+			volumes := []corev1.Volume{}
+			for _, volume := range podSpec.Volumes {
+				if volume.Name != cloneSourceVolumeName(d) {
+					volumes = append(volumes, volume)
+				}
+			}
+			podSpec.Volumes = volumes
+		}
+		mountExtraSettings(&currentobject.Spec.Template.Spec, d, "dest-clone")
+		mountBackupExcludedPaths(&currentobject.Spec.Template.Spec, d, "dest-clone")
+		// The URL rewrite runs after the clone has imported the database
+		if rewrite := d.Spec.Configuration.CloneURLRewrite; EnableCloneURLRewrite && rewrite != nil {
+			urlRewrite := currentobject.Spec.Template.Spec.Containers[0]
+			urlRewrite.Name = "url-rewrite"
+			urlRewrite.Command = rewriteHost()
+			// The hosts are passed in the environment, not in the PHP code, so that they can't inject code
+			urlRewrite.Env = []corev1.EnvVar{
+				{
+					Name:  "DRUPAL_SHARED_VOLUME",
+					Value: "/drupal-data",
+				},
+				{
+					Name:  "URL_REWRITE_FROM",
+					Value: string(rewrite.From),
+				},
+				{
+					Name:  "URL_REWRITE_TO",
+					Value: string(rewrite.To),
+				},
+			}
+			currentobject.Spec.Template.Spec.InitContainers = append(currentobject.Spec.Template.Spec.InitContainers, currentobject.Spec.Template.Spec.Containers[0])
+			currentobject.Spec.Template.Spec.Containers = []corev1.Container{urlRewrite}
+		}
 		ls["app"] = "clone"
 		for k, v := range ls {
 			currentobject.Labels[k] = v
@@ -1783,6 +3157,43 @@ func taskRunForEasystartRestore(currentobject *pipelinev1.TaskRun, d *webservice
 	return nil
 }
 
+// backupResources are the resources that can be added to the scheduled backups through `backupIncludedResources`
+var backupResources = map[string]bool{
+	"pods":                   true,
+	"configmaps":             true,
+	"secrets":                true,
+	"services":               true,
+	"persistentvolumeclaims": true,
+	"serviceaccounts":        true,
+	"routes":                 true,
+}
+
+// backupIncludedResources returns the resources included in the scheduled backups: the pods, followed by the ones of the spec
+func backupIncludedResources(d *webservicesv1a1.DrupalSite) []string {
+	resources := []string{"pods"}
+	included := map[string]bool{"pods": true}
+	for _, resource := range d.Spec.Configuration.BackupIncludedResources {
+		if !included[resource] {
+			included[resource] = true
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// backupSchedule returns the cron schedule of the backups of the site: every other night, at a time between 20:00 and 05:59.
+// The day, hour and minute are derived from a hash of the site's namespace/name, so that the backups of the sites are spread out
+// and the schedule of a site stays the same every time it's generated.
+func backupSchedule(d *webservicesv1a1.DrupalSite) string {
+	acceptedHoursForBackup := []string{"20", "21", "22", "23", "0", "1", "2", "3", "4", "5"}
+	oddOrEven := []string{"1", "2"}
+	hash := md5.Sum([]byte(d.Namespace + "/" + d.Name))
+	hour := acceptedHoursForBackup[int(hash[0])%len(acceptedHoursForBackup)]
+	minute := strconv.Itoa(int(binary.BigEndian.Uint16(hash[1:3])) % 60)
+	alternateDay := oddOrEven[int(hash[3])%len(oddOrEven)]
+	return minute + " " + hour + " " + alternateDay + "-31/2 * *"
+}
+
 // scheduledBackupsForDrupalSite returns a velero Schedule object that creates scheduled backups
 func scheduledBackupsForDrupalSite(currentobject *velerov1.Schedule, d *webservicesv1a1.DrupalSite) error {
 	// Do not add owner references here. As this object is created in a different namespace. Instead the deletion
@@ -1794,30 +3205,27 @@ func scheduledBackupsForDrupalSite(currentobject *velerov1.Schedule, d *webservi
 		currentobject.Labels = map[string]string{}
 	}
 
+	// Label values are capped at 63 characters, so the labels only carry hashes of the project and the site.
+	// Their full names are kept in the annotations.
+	// ref: https://gitlab.cern.ch/webservices/webframeworks-planning/-/issues/457
 	hash := md5.Sum([]byte(d.Namespace))
 	currentobject.Labels["drupal.webservices.cern.ch/projectHash"] = hex.EncodeToString(hash[:])
-	// These labels need to be removed, as annotations support longer values.
-	// But this can be done only after upgrading velero to 1.5 or higher which supports propagating annotations
-	// from schedules to the backups.
-	// ref: https://gitlab.cern.ch/webservices/webframeworks-planning/-/issues/457
-	currentobject.Labels["drupal.webservices.cern.ch/project"] = d.Namespace
-	currentobject.Labels["drupal.webservices.cern.ch/drupalSite"] = d.Name
+	siteHash := md5.Sum([]byte(d.Name))
+	currentobject.Labels["drupal.webservices.cern.ch/drupalSiteHash"] = hex.EncodeToString(siteHash[:])
+	delete(currentobject.Labels, "drupal.webservices.cern.ch/project")
+	delete(currentobject.Labels, "drupal.webservices.cern.ch/drupalSite")
 
 	currentobject.Annotations["drupal.webservices.cern.ch/project"] = d.Namespace
 	currentobject.Annotations["drupal.webservices.cern.ch/drupalSite"] = d.Name
 
 	if currentobject.CreationTimestamp.IsZero() || len(currentobject.Spec.Schedule) == 0 {
-		acceptedHoursForBackup := []string{"20", "21", "22", "23", "0", "1", "2", "3", "4", "5"}
-		oddOrEven := []string{"1", "2"}
-		randomHour := acceptedHoursForBackup[rand.Intn(len(acceptedHoursForBackup))]
-		randomMinute := strconv.Itoa(rand.Intn(60))
-		randomAlternateDay := oddOrEven[rand.Intn(len(oddOrEven))]
-		currentobject.Spec.Schedule = randomMinute + " " + randomHour + " " + randomAlternateDay + "-31/2 * *"
+		currentobject.Spec.Schedule = backupSchedule(d)
 	}
 
 	currentobject.Spec.Template = velerov1.BackupSpec{
 		IncludedNamespaces: []string{d.Namespace},
-		IncludedResources:  []string{"pods"},
+		IncludedResources:  backupIncludedResources(d),
+		StorageLocation:    d.Spec.Configuration.BackupStorageLocation,
 		// Add label selector to pick up the right pod and the respective PVC
 		LabelSelector: &metav1.LabelSelector{
 			MatchLabels: map[string]string{
@@ -1825,9 +3233,9 @@ func scheduledBackupsForDrupalSite(currentobject *velerov1.Schedule, d *webservi
 				"drupalSite": d.Name,
 			},
 		},
-		// TTL is 14 days. The backups are deleted automatically after this duration
+		// The backups are deleted automatically after this duration
 		TTL: metav1.Duration{
-			Duration: 14 * 24 * time.Hour,
+			Duration: BackupTTL,
 		},
 	}
 	// Set UseOwnerReferencesInBackup to False since we do not want the Backups to be deleted when Schedule object is deleted or modified
@@ -1879,11 +3287,14 @@ func secretForS2iGitlabTrigger(currentobject *corev1.Secret, d *webservicesv1a1.
 // updateConfigMapForPHPFPM modifies the configmap to include the php-fpm settings file,
 // but only if it's freshly created
 func updateConfigMapForPHPFPM(ctx context.Context, currentobject *corev1.ConfigMap, d *webservicesv1a1.DrupalSite, c client.Client) error {
-	configPath := "/tmp/runtime-config/qos-" + string(d.Spec.Configuration.QoSClass) + "/php-fpm.conf"
+	configPath := RuntimeConfigDir + "/qos-" + string(d.Spec.Configuration.QoSClass) + "/php-fpm.conf"
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return newApplicationError(fmt.Errorf("reading PHP-FPM configMap failed: %w", err), ErrFilesystemIO)
 	}
+	if err := validatePHPFPMConfig(string(content)); err != nil {
+		return newApplicationError(fmt.Errorf("invalid PHP-FPM configuration template %s: %w", configPath, err), ErrInvalidConfigTemplate)
+	}
 
 	addOwnerRefToObject(currentobject, asOwner(d))
 
@@ -1911,11 +3322,14 @@ func updateConfigMapForPHPFPM(ctx context.Context, currentobject *corev1.ConfigM
 // updateConfigMapForNginxGlobal modifies the configmap to include the Nginx settings file.
 // If the file contents change, it rolls out a new deployment.
 func updateConfigMapForNginxGlobal(ctx context.Context, currentobject *corev1.ConfigMap, d *webservicesv1a1.DrupalSite, c client.Client) error {
-	configPath := "/tmp/runtime-config/qos-" + string(d.Spec.Configuration.QoSClass) + "/nginx-global.conf"
+	configPath := RuntimeConfigDir + "/qos-" + string(d.Spec.Configuration.QoSClass) + "/nginx-global.conf"
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return newApplicationError(fmt.Errorf("reading Nginx configuration failed: %w", err), ErrFilesystemIO)
 	}
+	if err := validateNginxConfig(string(content)); err != nil {
+		return newApplicationError(fmt.Errorf("invalid Nginx configuration template %s: %w", configPath, err), ErrInvalidConfigTemplate)
+	}
 
 	addOwnerRefToObject(currentobject, asOwner(d))
 
@@ -1925,6 +3339,11 @@ func updateConfigMapForNginxGlobal(ctx context.Context, currentobject *corev1.Co
 			"global.conf": string(content),
 		}
 	}
+	// The extraNginxConfig of the spec is enforced in its own key, which the nginx server block includes
+	if currentobject.Data == nil {
+		currentobject.Data = map[string]string{}
+	}
+	currentobject.Data[extraNginxConfigKey] = d.Spec.Configuration.ExtraNginxConfig
 
 	if currentobject.Annotations == nil {
 		currentobject.Annotations = map[string]string{}
@@ -1940,9 +3359,61 @@ func updateConfigMapForNginxGlobal(ctx context.Context, currentobject *corev1.Co
 	return nil
 }
 
+// trustedHostPatternsMarker and trustedHostPatternsEndMarker delimit the trusted host patterns of the site's URLs in settings.php
+const (
+	trustedHostPatternsMarker    = "\n// trusted_host_patterns of the DrupalSite's URLs, managed by the operator\n"
+	trustedHostPatternsEndMarker = "// end of the trusted_host_patterns managed by the operator\n"
+)
+
+// extraSettingsInclude is the include of the extraSettingsConfigMap in settings.php
+const extraSettingsInclude = "\n// Extra settings of the site, from the ConfigMap given in `extraSettingsConfigMap`\n" +
+	"if (file_exists($app_root . '/' . $site_path . '/settings.local.php')) {\n" +
+	"  include $app_root . '/' . $site_path . '/settings.local.php';\n" +
+	"}\n"
+
+// withExtraSettingsInclude adds the include of the extraSettingsConfigMap at the end of settings.php, if it's missing,
+// eg in the settings of the sites created before the extraSettingsConfigMap existed
+func withExtraSettingsInclude(settings string) string {
+	if strings.Contains(settings, extraSettingsInclude) {
+		return settings
+	}
+	return settings + extraSettingsInclude
+}
+
+// trustedHostPatterns returns the PHP statement that sets Drupal's trusted host patterns to the URLs of the site, its canary URL
+// and the pod's own hostname, which the probes may use
+func trustedHostPatterns(d *webservicesv1a1.DrupalSite) string {
+	urls := append([]webservicesv1a1.Url{}, d.Spec.SiteURL...)
+	if d.Spec.CanaryURL != "" {
+		urls = append(urls, d.Spec.CanaryURL)
+	}
+	phpQuote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	statement := "$settings['trusted_host_patterns'] = [\n"
+	for _, url := range urls {
+		statement += "  '^" + phpQuote.Replace(regexp.QuoteMeta(string(url))) + "$',\n"
+	}
+	statement += "  '^localhost$',\n  '^' . preg_quote(getenv('HOSTNAME')) . '$',\n];\n"
+	return statement
+}
+
+// withTrustedHostPatterns replaces the trusted host patterns in settings.php.
+// They go before the include of the extraSettingsConfigMap, which can still override them, or else at the end.
+func withTrustedHostPatterns(settings string, d *webservicesv1a1.DrupalSite) string {
+	if i := strings.Index(settings, trustedHostPatternsMarker); i >= 0 {
+		if j := strings.Index(settings[i:], trustedHostPatternsEndMarker); j >= 0 {
+			settings = settings[:i] + settings[i+j+len(trustedHostPatternsEndMarker):]
+		}
+	}
+	patterns := trustedHostPatternsMarker + trustedHostPatterns(d) + trustedHostPatternsEndMarker
+	if i := strings.Index(settings, extraSettingsInclude); i >= 0 {
+		return settings[:i] + patterns + settings[i:]
+	}
+	return settings + patterns
+}
+
 // updateConfigMapForSiteSettings modifies the configmap to include the file settings.php
 func updateConfigMapForSiteSettings(ctx context.Context, currentobject *corev1.ConfigMap, d *webservicesv1a1.DrupalSite, c client.Client) error {
-	configPath := "/tmp/runtime-config/sitebuilder/settings.php"
+	configPath := RuntimeConfigDir + "/sitebuilder/settings.php"
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return newApplicationError(fmt.Errorf("reading settings.php failed: %w", err), ErrFilesystemIO)
@@ -1956,6 +3427,12 @@ func updateConfigMapForSiteSettings(ctx context.Context, currentobject *corev1.C
 			"settings.php": string(content),
 		}
 	}
+	// The trusted host patterns follow the URLs of the spec, so that Drupal accepts the requests to a newly added URL.
+	// The include of the extraSettingsConfigMap comes after them, so that it can override them.
+	if currentobject.Data == nil {
+		currentobject.Data = map[string]string{}
+	}
+	currentobject.Data["settings.php"] = withTrustedHostPatterns(withExtraSettingsInclude(currentobject.Data["settings.php"]), d)
 
 	if currentobject.Labels == nil {
 		currentobject.Labels = map[string]string{}
@@ -1973,7 +3450,7 @@ func updateConfigMapForSiteSettings(ctx context.Context, currentobject *corev1.C
 
 // updateConfigMapForPHPCLI modifies the configmap to include the file config.ini for php CLI
 func updateConfigMapForPHPCLI(ctx context.Context, currentobject *corev1.ConfigMap, d *webservicesv1a1.DrupalSite, c client.Client) error {
-	configPath := "/tmp/runtime-config/sitebuilder/config.ini"
+	configPath := RuntimeConfigDir + "/sitebuilder/config.ini"
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return newApplicationError(fmt.Errorf("reading config.ini failed: %w", err), ErrFilesystemIO)
@@ -2013,6 +3490,16 @@ func addOwnerRefToObject(obj metav1.Object, ownerRef metav1.OwnerReference) {
 	obj.SetOwnerReferences(append(obj.GetOwnerReferences(), ownerRef))
 }
 
+// ownedBySite checks if the object belongs to the given site, through its owner reference or its `drupalSite` label
+func ownedBySite(obj metav1.Object, d *webservicesv1a1.DrupalSite) bool {
+	for _, o := range obj.GetOwnerReferences() {
+		if d.UID != "" && o.UID == d.UID {
+			return true
+		}
+	}
+	return obj.GetLabels()["drupalSite"] == d.Name
+}
+
 // asOwner returns an OwnerReference set as the memcached CR
 func asOwner(d *webservicesv1a1.DrupalSite) metav1.OwnerReference {
 	trueVar := true
@@ -2025,10 +3512,12 @@ func asOwner(d *webservicesv1a1.DrupalSite) metav1.OwnerReference {
 	}
 }
 
-// siteInstallJobForDrupalSite outputs the command needed for jobForDrupalSiteDrush
+// siteInstallJobForDrupalSite outputs the command needed for jobForDrupalSiteDrush.
+// It doesn't reinstall a site that is already installed, eg when a volume restored from a backup is reused.
+// Like isDrupalSiteInstalled, the site only counts as installed if the check succeeds without any output on stderr
 func siteInstallJobForDrupalSite() []string {
 	// return []string{"sh", "-c", "echo"}
-	return []string{"/operations/ensure-site-install.sh"}
+	return []string{"sh", "-c", "stderr=$(" + checkIfSiteIsInstalled()[0] + " 2>&1 >/dev/null) && [ -z \"$stderr\" ] && echo 'The site is already installed, skipping the installation' || /operations/ensure-site-install.sh"}
 }
 
 // enableSiteMaintenanceModeCommandForDrupalSite outputs the command needed to enable maintenance mode
@@ -2046,6 +3535,11 @@ func checkUpdbStatus() []string {
 	return []string{"/operations/check-updb-status.sh"}
 }
 
+// checkDrupalCoreVersion outputs the command needed to check the Drupal core version of the site
+func checkDrupalCoreVersion() []string {
+	return []string{"drush", "status", "--field=drupal-version"}
+}
+
 // runUpDBCommand outputs the command needed to update the database in drupal
 func runUpDBCommand() []string {
 	return []string{"/operations/run-updb.sh"}
@@ -2062,10 +3556,127 @@ func restoreBackup(filename string) []string {
 }
 
 // cloneSource outputs the command need to clone a drupal site
-func cloneSource(filepath string) []string {
+// With the "rsync" strategy, the files are copied with rsync instead of clone.sh, and the DB backup is restored afterwards.
+// rsync skips the files that a previous try of the job already copied, and `--partial` keeps the partly copied ones, so the copy resumes
+// on a job retry. `--info=progress2` reports the progress of the whole copy to progressFile, which `--no-inc-recursive` makes accurate.
+func cloneSource(filepath string, strategy webservicesv1a1.CloneStrategy, progressFile string) []string {
+	if strategy == webservicesv1a1.CloneStrategyRsync {
+		return []string{"sh", "-c", "rsync --archive --partial --no-inc-recursive --info=progress2 /drupal-data-source/ /drupal-data/ > " + progressFile +
+			" && " + strings.Join(restoreBackup(filepath), " ")}
+	}
 	return []string{"/operations/clone.sh", "-p", filepath}
 }
 
+// readCloneProgress outputs the command needed to read the last progress report of the rsync clone
+func readCloneProgress(progressFile string) []string {
+	return []string{"tail", "-c", "256", progressFile}
+}
+
+// cloneProgressPattern matches the percentages in the progress reports of rsync
+var cloneProgressPattern = regexp.MustCompile(`(\d{1,3})%`)
+
+// parseCloneProgress returns the last percentage reported by rsync in the given output, eg "1,234,567  45%  10.00MB/s  0:01:23"
+func parseCloneProgress(output string) (int32, bool) {
+	matches := cloneProgressPattern.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	progress, err := strconv.Atoi(matches[len(matches)-1][1])
+	if err != nil || progress > 100 {
+		return 0, false
+	}
+	return int32(progress), true
+}
+
+// rewriteHostFunctions defines the PHP function $rewrite, that replaces $URL_REWRITE_FROM with $URL_REWRITE_TO in a value of the database.
+// A plain replace would corrupt the PHP-serialized values, whose strings are prefixed with their length,
+// so these are walked to rewrite their strings and recompute the lengths. Serialized values that it can't walk are left as they are.
+const rewriteHostFunctions = `$from = getenv('URL_REWRITE_FROM');
+$to = getenv('URL_REWRITE_TO');
+// $walk returns the rewritten serialized value that starts at $s[$p] and the position after it, or null if it isn't serialized
+$walk = function ($s, $p) use (&$walk, &$rewrite) {
+  $type = $s[$p] ?? '';
+  if ($type === 'N' && substr($s, $p, 2) === 'N;') {
+    return ['N;', $p + 2];
+  }
+  if (in_array($type, ['b', 'i', 'd', 'r', 'R'], true) && ($s[$p + 1] ?? '') === ':') {
+    $end = strpos($s, ';', $p);
+    return $end === false ? null : [substr($s, $p, $end + 1 - $p), $end + 1];
+  }
+  if (preg_match('/\G([sE]):(\d+):"/', $s, $m, 0, $p)) {
+    $start = $p + strlen($m[0]);
+    $length = (int) $m[2];
+    if (substr($s, $start + $length, 2) !== '";') {
+      return null;
+    }
+    $value = substr($s, $start, $length);
+    if ($m[1] === 's') {
+      $value = $rewrite($value);
+    }
+    return [$m[1] . ':' . strlen($value) . ':"' . $value . '";', $start + $length + 2];
+  }
+  if (preg_match('/\G(?:a|O:\d+:"[^"]*"):(\d+):\{/', $s, $m, 0, $p)) {
+    $out = $m[0];
+    $p += strlen($m[0]);
+    for ($i = 0; $i < 2 * (int) $m[1]; $i++) {
+      $item = $walk($s, $p);
+      if ($item === null) {
+        return null;
+      }
+      $out .= $item[0];
+      $p = $item[1];
+    }
+    return ($s[$p] ?? '') === '}' ? [$out . '}', $p + 1] : null;
+  }
+  // The payload of a custom serialization is left as it is
+  if (preg_match('/\GC:\d+:"[^"]*":(\d+):\{/', $s, $m, 0, $p)) {
+    $end = $p + strlen($m[0]) + (int) $m[1];
+    return ($s[$end] ?? '') === '}' ? [substr($s, $p, $end + 1 - $p), $end + 1] : null;
+  }
+  return null;
+};
+$rewrite = function ($value) use ($walk, $from, $to) {
+  if (strpos($value, $from) === false) {
+    return $value;
+  }
+  $serialized = $walk($value, 0);
+  if ($serialized !== null && $serialized[1] === strlen($value)) {
+    return $serialized[0];
+  }
+  if ($value === 'b:0;' || @unserialize($value, ['allowed_classes' => false]) !== false) {
+    return $value;
+  }
+  return str_replace($from, $to, $value);
+};
+`
+
+// rewriteHostDatabase applies $rewrite to the values of all the text columns of the site's database that contain $URL_REWRITE_FROM,
+// then flushes the caches that still hold the old values
+const rewriteHostDatabase = `$db = \Drupal::database();
+$quote = function ($name) {
+  return chr(96) . str_replace(chr(96), chr(96) . chr(96), $name) . chr(96);
+};
+$columns = $db->query("SELECT TABLE_NAME AS table_name, COLUMN_NAME AS column_name FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND DATA_TYPE IN ('char', 'varchar', 'tinytext', 'text', 'mediumtext', 'longtext', 'tinyblob', 'blob', 'mediumblob', 'longblob')")->fetchAll();
+foreach ($columns as $column) {
+  $table = $quote($column->table_name);
+  $name = $quote($column->column_name);
+  $values = $db->query("SELECT $name FROM $table WHERE $name LIKE :from", [':from' => '%' . $db->escapeLike($from) . '%'])->fetchCol();
+  foreach ($values as $value) {
+    $rewritten = $rewrite($value);
+    if ($rewritten !== $value) {
+      $db->query("UPDATE $table SET $name = :rewritten WHERE BINARY $name = :value", [':rewritten' => $rewritten, ':value' => $value]);
+    }
+  }
+}
+drupal_flush_all_caches();
+`
+
+// rewriteHost outputs the command needed to replace the host $URL_REWRITE_FROM with $URL_REWRITE_TO in the site's database.
+// drush has no search-replace command, so it evaluates the PHP of rewriteHostFunctions and rewriteHostDatabase.
+func rewriteHost() []string {
+	return []string{"drush", "php:eval", rewriteHostFunctions + rewriteHostDatabase}
+}
+
 // encryptBasicAuthPassword encrypts a password for basic authentication
 // Since we are using SabreDAV, the specific format to follow: https://sabre.io/dav/authentication/#using-the-file-backend
 func encryptBasicAuthPassword(password string) string {
@@ -2074,6 +3685,31 @@ func encryptBasicAuthPassword(password string) string {
 	return webdavHashPrefix + hex.EncodeToString(hashedPassword[:])
 }
 
+// defaultSharedVolumeDirectories are the directories of the shared volume created at install time, unless the spec sets `sharedVolumeDirectories`
+var defaultSharedVolumeDirectories = []string{"files", "private", "modules", "themes"}
+
+// sharedVolumeMkdirCommand outputs the command that creates the directories of the shared volume at install time.
+// The directories are arguments of mkdir, not of a shell, so that they can't inject commands.
+func sharedVolumeMkdirCommand(d *webservicesv1a1.DrupalSite) []string {
+	directories := d.Spec.Configuration.SharedVolumeDirectories
+	if len(directories) == 0 {
+		directories = defaultSharedVolumeDirectories
+	}
+	command := []string{"mkdir", "-p"}
+	for _, directory := range directories {
+		command = append(command, path.Join("/drupal-data", directory))
+	}
+	return command
+}
+
+// webDAVDigestPattern is the format of the htdigest that encryptBasicAuthPassword generates
+var webDAVDigestPattern = regexp.MustCompile(`^` + webDAVDefaultLogin + `:SabreDAV:[0-9a-f]{32}$`)
+
+// webDAVDigestWellFormed checks that the htdigest of the WebDAV secret is in the SabreDAV format, eg not a basic auth hash
+func webDAVDigestWellFormed(htdigest string) bool {
+	return webDAVDigestPattern.MatchString(htdigest)
+}
+
 // checkIfSiteIsInstalled outputs the command to check if a site is initialized or not
 func checkIfSiteIsInstalled() []string {
 	return []string{"/operations/check-if-installed.sh"}
@@ -2089,6 +3725,33 @@ func syncDrupalFilesToEmptydir() []string {
 	return []string{"/operations/sync-drupal-emptydir.sh"}
 }
 
+// logRotationCommand outputs the command of the log-rotation sidecar, which rotates the log files that the server containers write in their
+// shared emptyDir. Every minute, a log file larger than the maxSize of the rotation is copied aside and truncated, so that the processes
+// writing it keep their file handle, and the copies older than the retention are deleted.
+// The size and the retention are formatted as numbers, so that they can't inject commands.
+func logRotationCommand(rotation *webservicesv1a1.LogRotation) []string {
+	maxSize := resource.MustParse(defaultLogRotationMaxSize)
+	if rotation.MaxSize != nil {
+		maxSize = *rotation.MaxSize
+	}
+	retention := defaultLogRotationRetention
+	if rotation.Retention != nil {
+		retention = rotation.Retention.Duration
+	}
+	retentionMinutes := int64(retention.Minutes())
+	if retentionMinutes < 1 {
+		retentionMinutes = 1
+	}
+	script := fmt.Sprintf(`while true; do
+  for log in %[1]s/*.log; do
+    if [ -f "$log" ] && [ "$(stat -c %%s "$log")" -gt %[2]d ]; then cp "$log" "$log.$(date +%%s)" && : > "$log"; fi
+  done
+  find %[1]s -maxdepth 1 -name '*.log.*' -mmin +%[3]d -delete
+  sleep 60
+done`, logsDirectory, maxSize.Value(), retentionMinutes)
+	return []string{"sh", "-c", script}
+}
+
 // tailDrupalLogs outputs the command to tail the drupal log file
 func tailDrupalLogs() []string {
 	return []string{"/operations/tail-drupal-logs.sh"}
@@ -2099,6 +3762,34 @@ func customProbe(probe string) []string {
 	return []string{"/operations/probe-site.sh", "-p", probe}
 }
 
+// applyProbeTimings overrides the timing of the probe with the fields of the spec that are set
+func applyProbeTimings(probe *v1.Probe, timings *webservicesv1a1.ProbeTimings) {
+	if timings == nil {
+		return
+	}
+	if timings.InitialDelaySeconds > 0 {
+		probe.InitialDelaySeconds = timings.InitialDelaySeconds
+	}
+	if timings.PeriodSeconds > 0 {
+		probe.PeriodSeconds = timings.PeriodSeconds
+	}
+	if timings.TimeoutSeconds > 0 {
+		probe.TimeoutSeconds = timings.TimeoutSeconds
+	}
+	if timings.FailureThreshold > 0 {
+		probe.FailureThreshold = timings.FailureThreshold
+	}
+}
+
+// startupFailureThreshold returns the failures of a startup probe with the given period that add up to the StartupTimeout
+func startupFailureThreshold(periodSeconds int32) int32 {
+	threshold := int32(math.Ceil(StartupTimeout.Seconds() / float64(periodSeconds)))
+	if threshold < 1 {
+		return 1
+	}
+	return threshold
+}
+
 // startupProbe outputs the command to check the /_site/_php-fpm-status
 func startupProbe() []string {
 	return []string{"/operations/startup-probe-site.sh"}
@@ -2137,6 +3828,111 @@ func expectedDeploymentReplicas(currentnamespace *corev1.Namespace, qosClass web
 	}
 }
 
+// deploymentStrategy returns the strategy of the site's deployment: the one of the spec, else a RollingUpdate.
+// The parameters of a RollingUpdate get the defaults of the API server, so that the deployment isn't updated again after being defaulted.
+func deploymentStrategy(d *webservicesv1a1.DrupalSite) appsv1.DeploymentStrategy {
+	strategy := appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType}
+	if d.Spec.Configuration.DeploymentStrategy != nil {
+		strategy = *d.Spec.Configuration.DeploymentStrategy.DeepCopy()
+	}
+	if strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return strategy
+	}
+	strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+	if strategy.RollingUpdate == nil {
+		strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{}
+	}
+	defaultPercent := intstr.FromString("25%")
+	if strategy.RollingUpdate.MaxSurge == nil {
+		strategy.RollingUpdate.MaxSurge = &defaultPercent
+	}
+	if strategy.RollingUpdate.MaxUnavailable == nil {
+		strategy.RollingUpdate.MaxUnavailable = &defaultPercent
+	}
+	return strategy
+}
+
+// backupHookCommand returns the command of the hook that runs before each backup: it dumps the database.
+// The hook never modifies the files of the site, the `backupExcludedPaths` are left out with mountBackupExcludedPaths
+func backupHookCommand() string {
+	script := "/operations/database-backup.sh -f database_backup.sql"
+	// Keeps the format of the annotation that the deployments were created with, to not roll them out
+	quoted, _ := json.Marshal(script)
+	return "[\"sh\",\"-c\", " + string(quoted) + "]"
+}
+
+// backupHookTimeout returns how long the database dump before a backup may take: the override in the spec, else 30m plus 6m
+// for every GiB of DiskSize, up to 12h
+func backupHookTimeout(d *webservicesv1a1.DrupalSite) time.Duration {
+	const (
+		minTimeout = 30 * time.Minute
+		perGiB     = 6 * time.Minute
+		maxTimeout = 12 * time.Hour
+	)
+	if d.Spec.Configuration.BackupHookTimeout != nil {
+		return d.Spec.Configuration.BackupHookTimeout.Duration
+	}
+	diskSize, err := resource.ParseQuantity(d.Spec.Configuration.DiskSize)
+	if err != nil {
+		return 90 * time.Minute
+	}
+	gib := (diskSize.Value() + (1 << 30) - 1) >> 30
+	if timeout := minTimeout + time.Duration(gib)*perGiB; timeout < maxTimeout {
+		return timeout
+	}
+	return maxTimeout
+}
+
+// replicaAntiAffinity returns the affinity that spreads the replicas of the site on different nodes, if it has more than one
+func replicaAntiAffinity(d *webservicesv1a1.DrupalSite, replicas int32) *corev1.Affinity {
+	if replicas <= 1 {
+		return nil
+	}
+	ls := labelsForDrupalSite(d.Name)
+	ls["app"] = "drupal"
+	term := corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: ls},
+		TopologyKey:   "kubernetes.io/hostname",
+	}
+	if d.Spec.Configuration.ReplicaAntiAffinity == webservicesv1a1.ReplicaAntiAffinityRequired {
+		return &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+		}}
+	}
+	return &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{Weight: 100, PodAffinityTerm: term}},
+	}}
+}
+
+// imagePullSecrets returns the pull secrets of the site's pods: the `imagePullSecret` of the spec, if set
+func imagePullSecrets(d *webservicesv1a1.DrupalSite) []corev1.LocalObjectReference {
+	if d.Spec.Configuration.ImagePullSecret == "" {
+		return nil
+	}
+	return []corev1.LocalObjectReference{{Name: d.Spec.Configuration.ImagePullSecret}}
+}
+
+// priorityClassName returns the PriorityClass of the site's pods: the one set in the spec, else "openshift-user-critical" for critical sites
+func priorityClassName(d *webservicesv1a1.DrupalSite) string {
+	switch {
+	case d.Spec.Configuration.PriorityClassName != "":
+		return d.Spec.Configuration.PriorityClassName
+	case d.Spec.QoSClass == webservicesv1a1.QoSCritical:
+		// openshift-user-critical is part of the default OKD4 Priority classes
+		// https://github.com/openshift/cluster-config-operator/blob/168704868381c88551627239d132a3900eedc14f/manifests/0000_50_config-operator_09_user-priority-class.yaml
+		return "openshift-user-critical"
+	default:
+		return ""
+	}
+}
+
+// namespaceBlocked checks if both annotations that block a namespace are set
+func namespaceBlocked(namespace *corev1.Namespace) bool {
+	_, isBlockedTimestampAnnotationSet := namespace.Annotations["blocked.webservices.cern.ch/blocked-timestamp"]
+	_, isBlockedReasonAnnotationSet := namespace.Annotations["blocked.webservices.cern.ch/reason"]
+	return isBlockedTimestampAnnotationSet && isBlockedReasonAnnotationSet
+}
+
 // getDeploymentConfiguration precalculates all the configuration that the server deployment needs, including:
 // pod replicas, resource req/lim
 // NOTE: this includes the default resource limits for PHP
@@ -2159,6 +3955,20 @@ func (r *DrupalSiteReconciler) getDeploymentConfiguration(ctx context.Context, d
 	if err != nil {
 		return DeploymentConfig{}, false, false, newApplicationError(err, ErrInvalidSpec)
 	}
+	// Multiple replicas can only be scheduled together if they can all mount the site's volume
+	if replicas > 1 {
+		pvc := &corev1.PersistentVolumeClaim{}
+		err := r.Get(ctx, types.NamespacedName{Name: "pv-claim-" + drupalSite.Name, Namespace: drupalSite.Namespace}, pvc)
+		switch {
+		case k8sapierrors.IsNotFound(err):
+		case err != nil:
+			return DeploymentConfig{}, false, false, newApplicationError(err, ErrClientK8s)
+		default:
+			if err := validateVolumeAccessForReplicas(pvc, replicas); err != nil {
+				return DeploymentConfig{}, false, false, newApplicationError(err, ErrInvalidSpec)
+			}
+		}
+	}
 	if drupalSite.Status.ExpectedDeploymentReplicas == nil || *drupalSite.Status.ExpectedDeploymentReplicas != replicas {
 		drupalSite.Status.ExpectedDeploymentReplicas = &replicas
 		updateStatus = true
@@ -2190,6 +4000,20 @@ func (r *DrupalSiteReconciler) getDeploymentConfiguration(ctx context.Context, d
 		return
 	}
 
+	// Operator-wide overrides given as cmdline arguments take precedence over the QoS class defaults
+	if !reflect.DeepEqual(PhpFpmResources, corev1.ResourceRequirements{}) {
+		phpResources = PhpFpmResources
+	}
+	if !reflect.DeepEqual(NginxResources, corev1.ResourceRequirements{}) {
+		nginxResources = NginxResources
+	}
+	if !reflect.DeepEqual(PhpFpmExporterResources, corev1.ResourceRequirements{}) {
+		phpExporterResources = PhpFpmExporterResources
+	}
+	if !reflect.DeepEqual(WebDAVResources, corev1.ResourceRequirements{}) {
+		webDAVResources = WebDAVResources
+	}
+
 	// Get config override (currently only PHP resources)
 
 	configOverride, reconcileErr := r.getConfigOverride(ctx, drupalSite)
@@ -2220,6 +4044,40 @@ func (r *DrupalSiteReconciler) getDeploymentConfiguration(ctx context.Context, d
 	config = DeploymentConfig{replicas: replicas,
 		phpResources: phpResources, nginxResources: nginxResources, phpExporterResources: phpExporterResources, webDAVResources: webDAVResources, cronResources: cronResources, drupalLogsResources: drupalLogsResources,
 	}
+	if configOverride != nil && configOverride.DrupalLogs.Rotation != nil {
+		config.drupalLogsRotation = configOverride.DrupalLogs.Rotation
+		if config.logRotationResources, err = reqLimDict("log-rotation", drupalSite.Spec.QoSClass); err != nil {
+			reconcileErr = newApplicationError(err, ErrFunctionDomain)
+			return
+		}
+	}
+
+	// Pods beyond the ResourceQuota of the namespace are never created, so keep the replicas that run until the quota allows more.
+	// The site keeps serving meanwhile, so QuotaExceeded is only a warning
+	shortfall, replicasFit, running, reconcileErr := r.quotaShortfall(ctx, drupalSite, config)
+	if reconcileErr != nil {
+		return
+	}
+	if !replicasFit {
+		config.replicas = running
+	}
+	if len(shortfall) > 0 {
+		if setConditionStatus(drupalSite, "QuotaExceeded", true, newApplicationError(fmt.Errorf("%s", strings.Join(shortfall, "; ")), ErrQuotaExceeded), false) {
+			r.Recorder.Eventf(drupalSite, corev1.EventTypeWarning, "QuotaExceeded", "The ResourceQuota of the namespace doesn't allow the server pods: %s", strings.Join(shortfall, "; "))
+			updateStatus = true
+		}
+	} else {
+		updateStatus = drupalSite.Status.Conditions.RemoveCondition("QuotaExceeded") || updateStatus
+	}
+
+	// Report the resources on the status, so that owners can see what their QoS class grants
+	effectiveResources := map[string]corev1.ResourceRequirements{
+		"php-fpm": phpResources, "nginx": nginxResources, "php-fpm-exporter": phpExporterResources, "webdav": webDAVResources, "cron": cronResources, "drupal-logs": drupalLogsResources,
+	}
+	if !equality.Semantic.DeepEqual(drupalSite.Status.EffectiveResources, effectiveResources) {
+		drupalSite.Status.EffectiveResources = effectiveResources
+		updateStatus = true
+	}
 	return
 }
 
@@ -2231,6 +4089,8 @@ type DeploymentConfig struct {
 	webDAVResources      corev1.ResourceRequirements
 	cronResources        corev1.ResourceRequirements
 	drupalLogsResources  corev1.ResourceRequirements
+	drupalLogsRotation   *webservicesv1a1.LogRotation
+	logRotationResources corev1.ResourceRequirements
 }
 
 func (r *DrupalSiteReconciler) getConfigOverride(ctx context.Context, drp *webservicesv1a1.DrupalSite) (*webservicesv1a1.DrupalSiteConfigOverrideSpec, reconcileError) {
@@ -2245,6 +4105,17 @@ func (r *DrupalSiteReconciler) getConfigOverride(ctx context.Context, drp *webse
 	return &configOverride.Spec, nil
 }
 
+// removeContainer removes a container from the deployment, if it exists
+func removeContainer(name string, currentobject *appsv1.Deployment) {
+	containers := []corev1.Container{}
+	for _, container := range currentobject.Spec.Template.Spec.Containers {
+		if container.Name != name {
+			containers = append(containers, container)
+		}
+	}
+	currentobject.Spec.Template.Spec.Containers = containers
+}
+
 // containerExists checks if a container exists on the deployment
 // if it doesn't exists, it adds it
 func containerExists(name string, currentobject *appsv1.Deployment) {
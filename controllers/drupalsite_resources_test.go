@@ -0,0 +1,3919 @@
+/*
+Copyright 2021 CERN.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	buildv1 "github.com/openshift/api/build/v1"
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/operator-framework/operator-lib/status"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	dbodv1a1 "gitlab.cern.ch/drupal/paas/dbod-operator/api/v1alpha1"
+	drupalwebservicesv1alpha1 "gitlab.cern.ch/drupal/paas/drupalsite-operator/api/v1alpha1"
+	authz "gitlab.cern.ch/paas-tools/operators/authz-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// These specs exercise the resource builders and the reconciler helpers directly, without waiting for the controller.
+
+// podListCountingClient counts the pod lists, with which every exec into a server pod starts
+type podListCountingClient struct {
+	client.Client
+	podLists int
+}
+
+func (c *podListCountingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, isPodList := list.(*corev1.PodList); isPodList {
+		c.podLists++
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+// writeCountingClient counts the writes to the API server, including the ones to the status
+type writeCountingClient struct {
+	client.Client
+	writes int
+}
+
+func (c *writeCountingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.writes++
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *writeCountingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.writes++
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *writeCountingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.writes++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *writeCountingClient) Status() client.StatusWriter {
+	return &writeCountingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type writeCountingStatusWriter struct {
+	client.StatusWriter
+	client *writeCountingClient
+}
+
+func (w *writeCountingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	w.client.writes++
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *writeCountingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.client.writes++
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+// statusWriteCountingClient counts the writes to the status, without sending them to the API server
+type statusWriteCountingClient struct {
+	client.Client
+	statusWrites int
+}
+
+func (c *statusWriteCountingClient) Status() client.StatusWriter {
+	return &statusWriteCounter{client: c}
+}
+
+type statusWriteCounter struct {
+	client.StatusWriter
+	client *statusWriteCountingClient
+}
+
+func (w *statusWriteCounter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	w.client.statusWrites++
+	return nil
+}
+
+func (w *statusWriteCounter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.client.statusWrites++
+	return nil
+}
+
+// summaryLogSink is a logger that records the key-value pairs of the "Reconcile summary" messages logged at V(1)
+type summaryLogSink struct {
+	logr.Logger
+	level   int
+	summary map[string]interface{}
+}
+
+func (l *summaryLogSink) V(level int) logr.Logger {
+	return &summaryLogSink{Logger: l.Logger, level: level, summary: l.summary}
+}
+
+func (l *summaryLogSink) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return l
+}
+
+func (l *summaryLogSink) Info(msg string, keysAndValues ...interface{}) {
+	if msg != "Reconcile summary" || l.level != 1 {
+		return
+	}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		l.summary[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+}
+
+// newTestDrupalSite returns a minimal DrupalSite that the resource builders can work with
+func newTestDrupalSite(name, namespace string) *drupalwebservicesv1alpha1.DrupalSite {
+	return &drupalwebservicesv1alpha1.DrupalSite{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: drupalwebservicesv1alpha1.DrupalSiteSpec{
+			Version: drupalwebservicesv1alpha1.Version{
+				Name:        "v8.9-1",
+				ReleaseSpec: "stable",
+			},
+			Configuration: drupalwebservicesv1alpha1.Configuration{
+				DiskSize:      "10Gi",
+				QoSClass:      drupalwebservicesv1alpha1.QoSStandard,
+				DatabaseClass: drupalwebservicesv1alpha1.DBODStandard,
+			},
+			SiteURL: []drupalwebservicesv1alpha1.Url{dummySiteUrl},
+		},
+	}
+}
+
+// newTestReconciler returns a reconciler that uses the envtest client
+func newTestReconciler() *DrupalSiteReconciler {
+	return &DrupalSiteReconciler{
+		Client:    k8sClient,
+		APIReader: k8sClient,
+		Scheme:    scheme,
+		Log:       ctrl.Log.WithName("controllers").WithName("DrupalSite"),
+		Recorder:  record.NewFakeRecorder(10),
+	}
+}
+
+// containerByName returns the container with the given name from the server deployment
+func containerByName(deploy *appsv1.Deployment, name string) corev1.Container {
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		if container.Name == name {
+			return container
+		}
+	}
+	return corev1.Container{}
+}
+
+var _ = Describe("DrupalSite resources", func() {
+	ctx := context.Background()
+
+	Describe("Building the server deployment", func() {
+		Context("With container resources given as cmdline arguments", func() {
+			It("Should use them instead of the QoS class defaults", func() {
+				var err error
+				defer func() {
+					NginxResources = corev1.ResourceRequirements{}
+					PhpFpmResources = corev1.ResourceRequirements{}
+					PhpFpmExporterResources = corev1.ResourceRequirements{}
+					WebDAVResources = corev1.ResourceRequirements{}
+				}()
+				NginxResources, err = ParseResourceRequestLimit("11Mi,41m,21Mi,901m")
+				Expect(err).NotTo(HaveOccurred())
+				PhpFpmResources, err = ParseResourceRequestLimit("301Mi,101m,641Mi,3001m")
+				Expect(err).NotTo(HaveOccurred())
+				PhpFpmExporterResources, err = ParseResourceRequestLimit("26Mi,5m,36Mi,41m")
+				Expect(err).NotTo(HaveOccurred())
+				WebDAVResources, err = ParseResourceRequestLimit("11Mi,21m,101Mi,501m")
+				Expect(err).NotTo(HaveOccurred())
+
+				d := newTestDrupalSite("test-resource-flags", "default")
+				config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				Expect(reconcileErr).To(BeNil())
+				deploy := &appsv1.Deployment{}
+				Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+
+				Expect(containerByName(deploy, "nginx").Resources).To(Equal(NginxResources))
+				Expect(containerByName(deploy, "php-fpm").Resources).To(Equal(PhpFpmResources))
+				Expect(containerByName(deploy, "php-fpm-exporter").Resources).To(Equal(PhpFpmExporterResources))
+				Expect(containerByName(deploy, "webdav").Resources).To(Equal(WebDAVResources))
+				defaultCronResources, _ := reqLimDict("cron", d.Spec.QoSClass)
+				Expect(containerByName(deploy, "cron").Resources).To(Equal(defaultCronResources))
+			})
+		})
+		Context("With a revision history limit", func() {
+			It("Should keep only that many old ReplicaSets", func() {
+				defer func() { DeploymentRevisionHistoryLimit = 0 }()
+				DeploymentRevisionHistoryLimit = 2
+
+				d := newTestDrupalSite("test-revision-history", "default")
+				config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				Expect(reconcileErr).To(BeNil())
+				deploy := &appsv1.Deployment{}
+				Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+				Expect(*deploy.Spec.RevisionHistoryLimit).To(Equal(int32(2)))
+			})
+		})
+		Context("With multiple replicas", func() {
+			It("Should require a ReadWriteMany volume", func() {
+				pvc := &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "pv-claim-test"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					},
+				}
+				Expect(validateVolumeAccessForReplicas(pvc, 1)).To(Succeed())
+				Expect(validateVolumeAccessForReplicas(pvc, 3)).NotTo(Succeed())
+
+				pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+				Expect(validateVolumeAccessForReplicas(pvc, 3)).To(Succeed())
+
+				By("Expecting the server PVC to be ReadWriteMany")
+				d := newTestDrupalSite("test-rwx", "default")
+				Expect(persistentVolumeClaimForDrupalSite(pvc, d)).To(Succeed())
+				Expect(validateVolumeAccessForReplicas(pvc, 3)).To(Succeed())
+			})
+		})
+		Context("With malformed container resources", func() {
+			It("Should fail to parse them", func() {
+				_, err := ParseResourceRequestLimit("10Mi,40m")
+				Expect(err).To(HaveOccurred())
+				_, err = ParseResourceRequestLimit("10Mi,40m,20Mi,lots")
+				Expect(err).To(HaveOccurred())
+				resources, err := ParseResourceRequestLimit("")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resources).To(Equal(corev1.ResourceRequirements{}))
+			})
+		})
+	})
+
+	Describe("Checking the DBOD provisioning", func() {
+		newDatabase := func(name string, databaseStatus map[string]interface{}) {
+			database := &dbodv1a1.Database{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec: dbodv1a1.DatabaseSpec{
+					DbName:      name,
+					DbUser:      name,
+					ExtraLabels: map[string]string{},
+				},
+			}
+			Expect(k8sClient.Create(ctx, database)).To(Succeed())
+			if databaseStatus == nil {
+				return
+			}
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(dbodv1a1.GroupVersion.WithKind("Database"))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, u)).To(Succeed())
+			u.Object["status"] = databaseStatus
+			Expect(k8sClient.Status().Update(ctx, u)).To(Succeed())
+		}
+
+		Context("With a Database that isn't provisioned within the timeout", func() {
+			It("Should return a permanent error once the Database is older than the timeout", func() {
+				defer func() { DBODProvisioningTimeout = time.Hour }()
+				newDatabase("test-dbod-failed", nil)
+				d := newTestDrupalSite("test-dbod-failed", "default")
+				err := newTestReconciler().checkDBODProvisioning(ctx, d)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Temporary()).To(BeTrue())
+
+				By("Aging the Database past the timeout")
+				DBODProvisioningTimeout = 2 * time.Second
+				Eventually(func() error {
+					return newTestReconciler().checkDBODProvisioning(ctx, d)
+				}, timeout, interval).Should(MatchError(ContainSubstring("didn't assign an instance to the database in 2s")))
+				err = newTestReconciler().checkDBODProvisioning(ctx, d)
+				Expect(err.Temporary()).To(BeFalse())
+				Expect(err.Unwrap()).To(Equal(ErrDBODProvisioningFailed))
+
+				By("Provisioning the Database after the timeout")
+				u := &unstructured.Unstructured{}
+				u.SetGroupVersionKind(dbodv1a1.GroupVersion.WithKind("Database"))
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-dbod-failed", Namespace: "default"}, u)).To(Succeed())
+				u.Object["status"] = map[string]interface{}{"assignedDBODInstance": "dbod-test"}
+				Expect(k8sClient.Status().Update(ctx, u)).To(Succeed())
+				Expect(newTestReconciler().checkDBODProvisioning(ctx, d)).To(BeNil())
+			})
+		})
+		Context("With a Database without status or not created", func() {
+			It("Should return a temporary error", func() {
+				newDatabase("test-dbod-new", nil)
+				err := newTestReconciler().checkDBODProvisioning(ctx, newTestDrupalSite("test-dbod-new", "default"))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Temporary()).To(BeTrue())
+				Expect(err.Unwrap()).To(Equal(ErrDBOD))
+				err = newTestReconciler().checkDBODProvisioning(ctx, newTestDrupalSite("test-dbod-missing", "default"))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Temporary()).To(BeTrue())
+			})
+		})
+		Context("With a provisioned Database", func() {
+			It("Should succeed", func() {
+				newDatabase("test-dbod-provisioned", map[string]interface{}{"assignedDBODInstance": "dbod-test"})
+				Expect(newTestReconciler().checkDBODProvisioning(ctx, newTestDrupalSite("test-dbod-provisioned", "default"))).To(BeNil())
+			})
+			It("Should report the DBOD instance on the status", func() {
+				newDatabase("test-dbod-instance", map[string]interface{}{"assignedDBODInstance": "dbod-test"})
+				d := newTestDrupalSite("test-dbod-instance", "default")
+				Expect(newTestReconciler().checkDBODProvisioning(ctx, d)).To(BeNil())
+				Expect(d.Status.DatabaseInstance).To(Equal("dbod-test"))
+
+				By("Moving the database to another DBOD instance")
+				u := &unstructured.Unstructured{}
+				u.SetGroupVersionKind(dbodv1a1.GroupVersion.WithKind("Database"))
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-dbod-instance", Namespace: "default"}, u)).To(Succeed())
+				u.Object["status"] = map[string]interface{}{"assignedDBODInstance": "dbod-test-2"}
+				Expect(k8sClient.Status().Update(ctx, u)).To(Succeed())
+				Expect(newTestReconciler().checkDBODProvisioning(ctx, d)).To(BeNil())
+				Expect(d.Status.DatabaseInstance).To(Equal("dbod-test-2"))
+			})
+		})
+		Context("With a secondary database", func() {
+			It("Should provision both databases and only be provisioned once both are", func() {
+				d := newTestDrupalSite("test-dbod-secondary", "default")
+				d.UID = "0b4b5a4e-3f0c-4a7e-9a53-2f7d0c5e8a11"
+				d.Spec.Configuration.SecondaryDatabaseClass = drupalwebservicesv1alpha1.DBODSSD
+				Expect(newTestReconciler().ensureResourceX(ctx, d, "dbod_cr", ctrl.Log)).To(BeNil())
+				Expect(newTestReconciler().ensureResourceX(ctx, d, "secondary_dbod_cr", ctrl.Log)).To(BeNil())
+				primary, secondary := &dbodv1a1.Database{}, &dbodv1a1.Database{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-dbod-secondary", Namespace: "default"}, primary)).To(Succeed())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-dbod-secondary-secondary", Namespace: "default"}, secondary)).To(Succeed())
+				Expect(secondary.Spec.DatabaseClass).To(Equal("ssd"))
+				Expect(secondary.Spec.DbName).NotTo(Equal(primary.Spec.DbName))
+				Expect(newTestReconciler().isDBODProvisioned(ctx, d)).To(BeFalse())
+
+				setProvisioned := func(name string) {
+					u := &unstructured.Unstructured{}
+					u.SetGroupVersionKind(dbodv1a1.GroupVersion.WithKind("Database"))
+					Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, u)).To(Succeed())
+					u.Object["status"] = map[string]interface{}{"assignedDBODInstance": "dbod-test"}
+					Expect(k8sClient.Status().Update(ctx, u)).To(Succeed())
+				}
+				By("Provisioning the primary database")
+				setProvisioned("test-dbod-secondary")
+				Expect(newTestReconciler().isDBODProvisioned(ctx, d)).To(BeFalse())
+				err := newTestReconciler().checkDBODProvisioning(ctx, d)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Temporary()).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("secondary database"))
+
+				By("Provisioning the secondary database")
+				setProvisioned("test-dbod-secondary-secondary")
+				Expect(newTestReconciler().isDBODProvisioned(ctx, d)).To(BeTrue())
+				Expect(newTestReconciler().checkDBODProvisioning(ctx, d)).To(BeNil())
+
+				By("Giving the credentials of both databases to php-fpm")
+				config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				Expect(reconcileErr).To(BeNil())
+				deploy := &appsv1.Deployment{}
+				Expect(deploymentForDrupalSite(deploy, databaseSecretName(d), d, releaseID(d), config)).To(Succeed())
+				envFrom := containerByName(deploy, "php-fpm").EnvFrom
+				Expect(envFrom[0].SecretRef.Name).To(Equal("dbcredentials-test-dbod-secondary"))
+				Expect(envFrom).To(ContainElement(corev1.EnvFromSource{
+					Prefix:    "SECONDARY_",
+					SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "dbcredentials-test-dbod-secondary-secondary"}},
+				}))
+			})
+			It("Should neither adopt nor delete a Database of another site", func() {
+				d := newTestDrupalSite("test-dbod-foreign", "default")
+				d.Spec.Configuration.SecondaryDatabaseClass = drupalwebservicesv1alpha1.DBODSSD
+				foreign := &dbodv1a1.Database{
+					// The primary database of the site "test-dbod-foreign-secondary"
+					ObjectMeta: metav1.ObjectMeta{Name: secondaryDatabaseName(d), Namespace: "default", Labels: map[string]string{"drupalSite": "test-dbod-foreign-secondary"}},
+					Spec:       dbodv1a1.DatabaseSpec{DbName: "other", DbUser: "other", ExtraLabels: map[string]string{}},
+				}
+				Expect(k8sClient.Create(ctx, foreign)).To(Succeed())
+				Expect(newTestReconciler().ensureResourceX(ctx, d, "secondary_dbod_cr", ctrl.Log)).NotTo(BeNil())
+				Expect(newTestReconciler().ensureResourceX(ctx, d, "dbod_cr", ctrl.Log)).To(BeNil())
+
+				pending, err := newTestReconciler().ensureNoDatabase(ctx, d, ctrl.Log)
+				Expect(err).To(BeNil())
+				Expect(pending).To(BeTrue())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: secondaryDatabaseName(d), Namespace: "default"}, foreign)).To(Succeed())
+				Expect(foreign.DeletionTimestamp).To(BeNil())
+				Expect(foreign.Labels).To(HaveKeyWithValue("drupalSite", "test-dbod-foreign-secondary"))
+				Expect(foreign.Spec.DbName).To(Equal("other"))
+			})
+		})
+	})
+
+	Describe("Counting the running upgrades", func() {
+		Context("With a limit of concurrent upgrades", func() {
+			It("Should count the other sites with an upgrade in progress", func() {
+				d := newTestDrupalSite("test-upgrade", "default")
+				inProgress := func(name, namespace string) drupalwebservicesv1alpha1.DrupalSite {
+					site := newTestDrupalSite(name, namespace)
+					site.Annotations = map[string]string{"updateInProgress": "true"}
+					return *site
+				}
+				sites := []drupalwebservicesv1alpha1.DrupalSite{
+					*newTestDrupalSite("test-idle", "default"),
+					inProgress("test-upgrade", "default"),
+					inProgress("test-upgrade", "other"),
+					inProgress("test-other", "default"),
+				}
+				Expect(countUpgradesInProgress(sites, d)).To(Equal(2))
+
+				By("Expecting no limit by default")
+				slotAvailable, err := newTestReconciler().upgradeSlotAvailable(ctx, d)
+				Expect(err).To(BeNil())
+				Expect(slotAvailable).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Ensuring the Tekton extra permissions", func() {
+		newProject := func(namespace string, dpcSpec *drupalwebservicesv1alpha1.DrupalProjectConfigSpec) {
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+			if dpcSpec != nil {
+				Expect(k8sClient.Create(ctx, &drupalwebservicesv1alpha1.DrupalProjectConfig{
+					ObjectMeta: metav1.ObjectMeta{Name: namespace, Namespace: namespace},
+					Spec:       *dpcSpec,
+				})).To(Succeed())
+			}
+		}
+		bindingExists := func(namespace string) bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "tektoncd-extra-permissions-" + namespace}, &rbacv1.ClusterRoleBinding{})
+			return err == nil
+		}
+
+		Context("With a project that opted in", func() {
+			It("Should create the ClusterRoleBinding", func() {
+				newProject("tekton-enabled", &drupalwebservicesv1alpha1.DrupalProjectConfigSpec{EnableTektonExtraPermissions: true})
+				d := newTestDrupalSite("test", "tekton-enabled")
+				Eventually(func() error {
+					return newTestReconciler().ensureTektonExtraPermissions(ctx, d, ctrl.Log)
+				}).Should(BeNil())
+				Eventually(func() bool { return bindingExists("tekton-enabled") }).Should(BeTrue())
+			})
+		})
+		Context("With a project that didn't opt in", func() {
+			It("Should not create the ClusterRoleBinding, except for easystart", func() {
+				newProject("tekton-disabled", &drupalwebservicesv1alpha1.DrupalProjectConfigSpec{})
+				d := newTestDrupalSite("test", "tekton-disabled")
+				Expect(newTestReconciler().ensureTektonExtraPermissions(ctx, d, ctrl.Log)).To(BeNil())
+				Consistently(func() bool { return bindingExists("tekton-disabled") }).Should(BeFalse())
+
+				newProject("tekton-no-dpc", nil)
+				d = newTestDrupalSite("test", "tekton-no-dpc")
+				Expect(newTestReconciler().ensureTektonExtraPermissions(ctx, d, ctrl.Log)).To(BeNil())
+				Consistently(func() bool { return bindingExists("tekton-no-dpc") }).Should(BeFalse())
+
+				By("Expecting easystart sites to get the ClusterRoleBinding")
+				d.Spec.Configuration.Easystart = "enable"
+				Expect(newTestReconciler().ensureTektonExtraPermissions(ctx, d, ctrl.Log)).To(BeNil())
+				Eventually(func() bool { return bindingExists("tekton-no-dpc") }).Should(BeTrue())
+
+				By("Expecting an existing ClusterRoleBinding to be left in place when disabled")
+				d.Spec.Configuration.Easystart = ""
+				Expect(newTestReconciler().ensureTektonExtraPermissions(ctx, d, ctrl.Log)).To(BeNil())
+				Consistently(func() bool { return bindingExists("tekton-no-dpc") }).Should(BeTrue())
+			})
+		})
+	})
+
+	Describe("Validating the QoS configuration templates", func() {
+		Context("With the templates shipped in the chart", func() {
+			It("Should accept every QoS class", func() {
+				Expect(ValidateQoSConfigTemplates(filepath.Join("..", "chart", "drupalsite-operator", "runtime-config"))).To(Succeed())
+			})
+		})
+		Context("With a missing template directory", func() {
+			It("Should fail", func() {
+				Expect(ValidateQoSConfigTemplates(filepath.Join("..", "chart", "missing"))).NotTo(Succeed())
+			})
+		})
+		Context("With a QoS class without templates", func() {
+			It("Should reject the spec before reconciling the site", func() {
+				Expect(ValidateQoSConfigTemplates(filepath.Join("..", "chart", "drupalsite-operator", "runtime-config"))).To(Succeed())
+				Expect(qosClasses).To(HaveKey(drupalwebservicesv1alpha1.QoSTest))
+				defer func(classes map[drupalwebservicesv1alpha1.QoSClass]bool) { qosClasses = classes }(qosClasses)
+				qosClasses = map[drupalwebservicesv1alpha1.QoSClass]bool{drupalwebservicesv1alpha1.QoSStandard: true}
+
+				spec := newTestDrupalSite("test", "default").Spec
+				spec.QoSClass = drupalwebservicesv1alpha1.QoSStandard
+				Expect(validateSpec(spec, true)).To(BeNil())
+				spec.QoSClass = drupalwebservicesv1alpha1.QoSTest
+				err := validateSpec(spec, true)
+				Expect(err).NotTo(BeNil())
+				Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+				Expect(err.Error()).To(ContainSubstring("qosClass \"test\" has no configuration templates"))
+			})
+		})
+		Context("With a template edited after startup", func() {
+			It("Should report it on demand without changing the accepted QoS classes", func() {
+				chartDir := filepath.Join("..", "chart", "drupalsite-operator", "runtime-config")
+				Expect(ValidateQoSConfigTemplates(chartDir)).To(Succeed())
+				accepted := qosClasses
+				dir, err := ioutil.TempDir("", "runtime-config")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+				for _, qosDir := range []string{"qos-standard", "qos-critical"} {
+					Expect(os.Mkdir(filepath.Join(dir, qosDir), 0755)).To(Succeed())
+					for _, file := range []string{"php-fpm.conf", "nginx-global.conf"} {
+						content, err := ioutil.ReadFile(filepath.Join(chartDir, qosDir, file))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(ioutil.WriteFile(filepath.Join(dir, qosDir, file), content, 0644)).To(Succeed())
+					}
+				}
+				check := QoSConfigTemplatesCheck(dir)
+				Expect(check(nil)).To(Succeed())
+
+				By("Breaking the nginx template of a QoS class")
+				Expect(ioutil.WriteFile(filepath.Join(dir, "qos-critical", "nginx-global.conf"), []byte("http {\n  server_tokens off\n}\n"), 0644)).To(Succeed())
+				err = check(nil)
+				Expect(err).NotTo(BeNil())
+				Expect(err.Error()).To(ContainSubstring("QoS class critical: nginx-global.conf"))
+				Expect(qosClasses).To(Equal(accepted))
+			})
+		})
+		Context("With a broken nginx template", func() {
+			It("Should reject it", func() {
+				Expect(validateNginxConfig("http {\n  server_tokens off;\n}\n")).To(Succeed())
+				Expect(validateNginxConfig("http {\n  server_tokens off\n}\n")).NotTo(Succeed())
+				Expect(validateNginxConfig("http {\n  server_tokens off;\n")).NotTo(Succeed())
+				Expect(validateNginxConfig("server_tokens off;\n}\n")).NotTo(Succeed())
+				Expect(validateNginxConfig("add_header X-Test \"value;\n")).NotTo(Succeed())
+			})
+		})
+		Context("With a broken php-fpm template", func() {
+			It("Should reject it", func() {
+				valid := "[www]\npm = dynamic\npm.max_children = 10\npm.min_spare_servers = 2\npm.max_spare_servers = 4\n"
+				Expect(validatePHPFPMConfig(valid)).To(Succeed())
+				Expect(validatePHPFPMConfig("[www]\npm = dynamic\npm.max_children = 3\npm.min_spare_servers = 2\npm.max_spare_servers = 4\n")).NotTo(Succeed())
+				Expect(validatePHPFPMConfig("[www]\npm = sometimes\n")).NotTo(Succeed())
+				Expect(validatePHPFPMConfig("[www]\npm.max_children\n")).NotTo(Succeed())
+				Expect(validatePHPFPMConfig("pm = static\n")).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("Reporting the site URLs", func() {
+		Context("With two site URLs", func() {
+			It("Should report both Routes with their admission state", func() {
+				d := newTestDrupalSite("test-site-urls", "default")
+				d.UID = "6a41c7a4-2cf2-4d4d-9a39-1a7f0cbd5c1a"
+				d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"admitted-test.webtest.cern.ch", "pending-test.webtest.cern.ch"}
+				Expect(newTestReconciler().ensureResourceX(ctx, d, "svc_nginx", ctrl.Log)).To(BeNil())
+				Expect(newTestReconciler().ensureResourceX(ctx, d, "route", ctrl.Log)).To(BeNil())
+
+				By("Admitting the Route of the first URL")
+				siteURLs, err := newTestReconciler().siteURLStatus(ctx, d)
+				Expect(err).To(BeNil())
+				Expect(siteURLs).To(HaveLen(2))
+				route := &routev1.Route{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: siteURLs[0].RouteName, Namespace: d.Namespace}, route)).To(Succeed())
+				route.Status.Ingress = []routev1.RouteIngress{{
+					Host: route.Spec.Host,
+					Conditions: []routev1.RouteIngressCondition{{
+						Type:   routev1.RouteAdmitted,
+						Status: corev1.ConditionTrue,
+					}},
+				}}
+				Expect(k8sClient.Status().Update(ctx, route)).To(Succeed())
+
+				By("Expecting both URLs on the status")
+				Eventually(func() []drupalwebservicesv1alpha1.URLStatus {
+					siteURLs, _ := newTestReconciler().siteURLStatus(ctx, d)
+					return siteURLs
+				}).Should(ConsistOf(
+					drupalwebservicesv1alpha1.URLStatus{URL: d.Spec.SiteURL[0], RouteName: siteURLs[0].RouteName, Admitted: true},
+					drupalwebservicesv1alpha1.URLStatus{URL: d.Spec.SiteURL[1], RouteName: siteURLs[1].RouteName, Admitted: false},
+				))
+			})
+		})
+	})
+
+	Describe("Validating the site URLs", func() {
+		Context("With unique hostnames", func() {
+			It("Should be valid", func() {
+				spec := newTestDrupalSite("test", "default").Spec
+				spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-1.webtest.cern.ch", "test-2.webtest.cern.ch"}
+				Expect(validateSpec(spec, true)).To(BeNil())
+			})
+		})
+		Context("With a duplicate URL", func() {
+			It("Should be an invalid spec naming the URL", func() {
+				spec := newTestDrupalSite("test", "default").Spec
+				spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-1.webtest.cern.ch", "test-1.webtest.cern.ch"}
+				err := validateSpec(spec, false)
+				Expect(err).NotTo(BeNil())
+				Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+				Expect(err.Error()).To(ContainSubstring("test-1.webtest.cern.ch"))
+			})
+		})
+		Context("With an empty list", func() {
+			It("Should only be valid before the site is published", func() {
+				spec := newTestDrupalSite("test", "default").Spec
+				spec.SiteURL = []drupalwebservicesv1alpha1.Url{}
+				Expect(validateSpec(spec, false)).To(BeNil())
+				err := validateSpec(spec, true)
+				Expect(err).NotTo(BeNil())
+				Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+			})
+		})
+		Context("With invalid characters", func() {
+			It("Should be an invalid spec naming the URL", func() {
+				for _, url := range []drupalwebservicesv1alpha1.Url{"test_site!.webtest.cern.ch", "Test.webtest.cern.ch", "test site.webtest.cern.ch"} {
+					err := validateSiteURLs([]drupalwebservicesv1alpha1.Url{url}, false)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring(string(url)))
+				}
+			})
+		})
+	})
+
+	Describe("Cloning a site with a URL rewrite", func() {
+		var d *drupalwebservicesv1alpha1.DrupalSite
+		BeforeEach(func() {
+			d = newTestDrupalSite("test-clone", "default")
+			d.Spec.Configuration.CloneFrom = "test"
+			d.Spec.Configuration.CloneURLRewrite = &drupalwebservicesv1alpha1.URLRewrite{From: dummySiteUrl, To: "test-clone.webtest.cern.ch"}
+		})
+		AfterEach(func() {
+			EnableCloneURLRewrite = false
+		})
+		Context("With the URL rewrite disabled", func() {
+			It("Should only clone", func() {
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "dbcredentials-test-clone", d)).To(Succeed())
+				Expect(job.Spec.Template.Spec.Containers).To(HaveLen(1))
+				Expect(job.Spec.Template.Spec.Containers[0].Name).To(Equal("dest-clone"))
+			})
+		})
+		Context("With the URL rewrite enabled", func() {
+			It("Should rewrite the URLs after the clone", func() {
+				EnableCloneURLRewrite = true
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "dbcredentials-test-clone", d)).To(Succeed())
+				initContainers := job.Spec.Template.Spec.InitContainers
+				Expect(initContainers[len(initContainers)-1].Name).To(Equal("dest-clone"))
+				Expect(job.Spec.Template.Spec.Containers).To(HaveLen(1))
+				Expect(job.Spec.Template.Spec.Containers[0].Name).To(Equal("url-rewrite"))
+				urlRewrite := job.Spec.Template.Spec.Containers[0]
+				Expect(urlRewrite.Command).To(Equal(rewriteHost()))
+				Expect(urlRewrite.Env).To(ContainElement(corev1.EnvVar{Name: "URL_REWRITE_FROM", Value: dummySiteUrl}))
+				Expect(urlRewrite.Env).To(ContainElement(corev1.EnvVar{Name: "URL_REWRITE_TO", Value: "test-clone.webtest.cern.ch"}))
+
+				By("Expecting a site without cloneURLRewrite to only clone")
+				d.Spec.Configuration.CloneURLRewrite = nil
+				job = &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "dbcredentials-test-clone", d)).To(Succeed())
+				Expect(job.Spec.Template.Spec.Containers[0].Name).To(Equal("dest-clone"))
+			})
+			It("Should rewrite the host in the PHP-serialized values without breaking their lengths", func() {
+				if _, err := exec.LookPath("php"); err != nil {
+					Skip("php is not installed")
+				}
+				serialized := func(value string) string {
+					return fmt.Sprintf(`s:%d:"%s";`, len(value), value)
+				}
+				oldURL, newURL := "https://old.webtest.cern.ch/node/1", "https://new-site.webtest.cern.ch/node/1"
+				customSerialized := `C:11:"ArrayObject":` + fmt.Sprint(len(`x:i:0;a:1:{i:0;`+serialized(oldURL)+`};m:a:0:{}`)) + `:{x:i:0;a:1:{i:0;` + serialized(oldURL) + `};m:a:0:{}}`
+				for _, value := range []struct {
+					old, rewritten string
+				}{
+					{"Read " + oldURL, "Read " + newURL},
+					{serialized(oldURL), serialized(newURL)},
+					{`a:2:{` + serialized("link") + serialized(oldURL) + `i:0;b:1;}`, `a:2:{` + serialized("link") + serialized(newURL) + `i:0;b:1;}`},
+					{`O:8:"stdClass":1:{` + serialized("link") + serialized(`a:1:{i:0;`+serialized(oldURL)+`}`) + `}`,
+						`O:8:"stdClass":1:{` + serialized("link") + serialized(`a:1:{i:0;`+serialized(newURL)+`}`) + `}`},
+					{customSerialized, customSerialized},
+				} {
+					rewrite := exec.Command("php", "-r", rewriteHostFunctions+`echo $rewrite(getenv('VALUE'));`)
+					rewrite.Env = append(os.Environ(), "URL_REWRITE_FROM=old.webtest.cern.ch", "URL_REWRITE_TO=new-site.webtest.cern.ch", "VALUE="+value.old)
+					output, err := rewrite.Output()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(output)).To(Equal(value.rewritten), value.old)
+				}
+			})
+		})
+	})
+
+	Describe("Mirroring the images", func() {
+		AfterEach(func() {
+			ImageRegistryMirror = ""
+		})
+		Context("Without a mirror", func() {
+			It("Should keep the images", func() {
+				Expect(mirroredImage("bash")).To(Equal("bash"))
+				Expect(mirroredImage("gitlab-registry.cern.ch/drupal/paas/sabredav/webdav:tag")).To(Equal("gitlab-registry.cern.ch/drupal/paas/sabredav/webdav:tag"))
+			})
+		})
+		Context("With a mirror", func() {
+			It("Should replace the registry of every image", func() {
+				ImageRegistryMirror = "mirror.example.org:5000/cache/"
+				Expect(mirroredImage("bash")).To(Equal("mirror.example.org:5000/cache/library/bash"))
+				Expect(mirroredImage("library/bash:5")).To(Equal("mirror.example.org:5000/cache/library/bash:5"))
+				Expect(mirroredImage("gitlab-registry.cern.ch/drupal/paas/sabredav/webdav:tag")).To(Equal("mirror.example.org:5000/cache/drupal/paas/sabredav/webdav:tag"))
+				Expect(mirroredImage("localhost/site-builder")).To(Equal("mirror.example.org:5000/cache/site-builder"))
+
+				By("Expecting the deployment images to be mirrored")
+				d := newTestDrupalSite("test-mirror", "default")
+				Expect(sitebuilderImageRefToUse(d, releaseID(d)).Name).To(HavePrefix("mirror.example.org:5000/cache/"))
+				deploy := &appsv1.Deployment{}
+				config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				Expect(reconcileErr).To(BeNil())
+				Expect(deploymentForDrupalSite(deploy, "dbcredentials-test-mirror", d, releaseID(d), config)).To(Succeed())
+				for _, container := range append(deploy.Spec.Template.Spec.InitContainers, deploy.Spec.Template.Spec.Containers...) {
+					Expect(container.Image).To(HavePrefix("mirror.example.org:5000/cache/"))
+				}
+			})
+		})
+	})
+
+	Describe("Deploying a canary version", func() {
+		It("Should serve the canary releaseID without touching the site's deployment", func() {
+			d := newTestDrupalSite("test-canary", "default")
+			d.UID = "0b5b2f0e-5d0b-4b8c-a3c6-2a9c4c0d6c1e"
+			d.Spec.CanaryVersion = &drupalwebservicesv1alpha1.Version{Name: "v9.3-1", ReleaseSpec: "RELEASE-2022.02.03T11-18-39Z"}
+			d.Spec.CanaryURL = "canary-test.webtest.cern.ch"
+			Expect(validateSpec(d.Spec, true)).To(BeNil())
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+
+			By("Expecting the site's deployment to be created")
+			deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
+			_, err := ctrl.CreateOrUpdate(ctx, k8sClient, deploy, func() error {
+				return deploymentForDrupalSite(deploy, databaseSecretName(d), d, releaseID(d), config)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Expecting the canary deployment to use the canary releaseID")
+			Expect(r.ensureCanary(ctx, d, config, ctrl.Log)).To(BeNil())
+			canary := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, canary)).To(Succeed())
+			Expect(canary.Spec.Template.ObjectMeta.Annotations["releaseID"]).To(Equal("v9.3-1-RELEASE-2022.02.03T11-18-39Z"))
+			Expect(containerByName(canary, "php-fpm").Image).To(Equal(sitebuilderImageRefToUse(d, canaryReleaseID(d)).Name))
+			Expect(containerByName(canary, "cron").Name).To(BeEmpty())
+			Expect(containerByName(canary, "webdav").Name).To(BeEmpty())
+
+			By("Expecting the canary to mount the site's files read-only")
+			for _, name := range []string{"nginx", "php-fpm"} {
+				Expect(containerByName(canary, name).VolumeMounts).To(ContainElement(corev1.VolumeMount{
+					Name: "drupal-directory-" + d.Name, MountPath: "/drupal-data", ReadOnly: true,
+				}), name)
+			}
+			for _, container := range canary.Spec.Template.Spec.Containers {
+				for _, volumeMount := range container.VolumeMounts {
+					if volumeMount.Name == "drupal-directory-"+d.Name {
+						Expect(volumeMount.ReadOnly).To(BeTrue(), container.Name)
+					}
+				}
+			}
+			Expect(containerByName(deploy, "php-fpm").VolumeMounts).To(ContainElement(corev1.VolumeMount{
+				Name: "drupal-directory-" + d.Name, MountPath: "/drupal-data",
+			}))
+
+			By("Expecting the canary pods not to be backed up")
+			for _, annotation := range []string{"pre.hook.backup.velero.io/container", "pre.hook.backup.velero.io/command", "pre.hook.backup.velero.io/timeout", "backup.velero.io/backup-volumes"} {
+				Expect(canary.Spec.Template.Annotations).NotTo(HaveKey(annotation))
+				Expect(deploy.Spec.Template.Annotations).To(HaveKey(annotation))
+			}
+			Expect(canary.Spec.Selector.MatchLabels).To(Equal(labelsForCanary(d)))
+			svc := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(Equal(labelsForCanary(d)))
+			route := &routev1.Route{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, route)).To(Succeed())
+			Expect(route.Spec.Host).To(Equal("canary-test.webtest.cern.ch"))
+			Expect(route.Spec.To.Name).To(Equal(canaryName(d)))
+
+			By("Expecting the site's deployment to be untouched")
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]).To(Equal(releaseID(d)))
+			Expect(deploy.Spec.Template.ObjectMeta.Labels["app"]).To(Equal("drupal"))
+
+			By("Expecting the canary route to be removed while the site isn't published")
+			d.Spec.Publish = pointer.BoolPtr(false)
+			Expect(r.ensureCanary(ctx, d, config, ctrl.Log)).To(BeNil())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, &routev1.Route{})
+				return k8sapierrors.IsNotFound(err)
+			}).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, &appsv1.Deployment{})).To(Succeed())
+			d.Spec.Publish = nil
+			Expect(r.ensureCanary(ctx, d, config, ctrl.Log)).To(BeNil())
+
+			By("Expecting the canary to be removed when the fields are cleared")
+			d.Spec.CanaryVersion = nil
+			d.Spec.CanaryURL = ""
+			Expect(r.ensureNoCanary(ctx, d, ctrl.Log)).To(BeNil())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, &appsv1.Deployment{})
+				return k8sapierrors.IsNotFound(err)
+			}).Should(BeTrue())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: canaryName(d), Namespace: d.Namespace}, &routev1.Route{})
+				return k8sapierrors.IsNotFound(err)
+			}).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, &appsv1.Deployment{})).To(Succeed())
+		})
+		It("Should require a canary URL that the site doesn't use", func() {
+			spec := newTestDrupalSite("test", "default").Spec
+			spec.CanaryVersion = &drupalwebservicesv1alpha1.Version{Name: "v9.3-1"}
+			Expect(validateSpec(spec, true)).NotTo(BeNil())
+			spec.CanaryURL = dummySiteUrl
+			Expect(validateSpec(spec, true)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Cloning a site with a clone strategy", func() {
+		var d *drupalwebservicesv1alpha1.DrupalSite
+		BeforeEach(func() {
+			d = newTestDrupalSite("test-clone-strategy", "default")
+			d.Spec.Configuration.CloneFrom = "test"
+		})
+		Context("With the default strategy", func() {
+			It("Should copy the files in one shot", func() {
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "dbcredentials-test-clone-strategy", d)).To(Succeed())
+				Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"/operations/clone.sh", "-p", "/var/empty-run/dbBackUp.sql"}))
+			})
+		})
+		Context("With the rsync strategy", func() {
+			It("Should stream the files and report the progress", func() {
+				d.Spec.Configuration.CloneStrategy = drupalwebservicesv1alpha1.CloneStrategyRsync
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "dbcredentials-test-clone-strategy", d)).To(Succeed())
+				Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"sh", "-c",
+					"rsync --archive --partial --no-inc-recursive --info=progress2 /drupal-data-source/ /drupal-data/ > /var/empty-run/" + cloneProgressFile +
+						" && /operations/database-restore.sh -f /var/empty-run/dbBackUp.sql"}))
+				By("Expecting the DB backup step to remain")
+				Expect(job.Spec.Template.Spec.InitContainers[0].Name).To(Equal("src-db-backup"))
+			})
+			It("Should parse the last progress reported by rsync", func() {
+				progress, ok := parseCloneProgress("     32,768   2%    1.00MB/s    0:00:01\r  1,234,567  45%   10.00MB/s    0:01:23 (xfr#12, to-chk=100/200)")
+				Expect(ok).To(BeTrue())
+				Expect(progress).To(BeEquivalentTo(45))
+				_, ok = parseCloneProgress("sending incremental file list")
+				Expect(ok).To(BeFalse())
+			})
+			It("Should read the progress from the clone container, also once it's an init container", func() {
+				running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+				pod := corev1.Pod{Status: corev1.PodStatus{
+					InitContainerStatuses: []corev1.ContainerStatus{{Name: "src-db-backup", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}}, {Name: "dest-clone", State: running}},
+					ContainerStatuses:     []corev1.ContainerStatus{{Name: "url-rewrite", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}}},
+				}}
+				Expect(containerRunning(pod, "dest-clone")).To(BeTrue())
+				Expect(containerRunning(pod, "url-rewrite")).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Reporting the effective resources", func() {
+		It("Should report the resources of every container after the overrides", func() {
+			var err error
+			defer func() {
+				PhpFpmResources = corev1.ResourceRequirements{}
+			}()
+			PhpFpmResources, err = ParseResourceRequestLimit("302Mi,102m,642Mi,3002m")
+			Expect(err).NotTo(HaveOccurred())
+
+			d := newTestDrupalSite("test-effective-resources", "default")
+			config, _, updateStatus, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(updateStatus).To(BeTrue())
+			Expect(d.Status.EffectiveResources).To(HaveLen(6))
+			Expect(d.Status.EffectiveResources["php-fpm"]).To(Equal(PhpFpmResources))
+			Expect(d.Status.EffectiveResources["nginx"]).To(Equal(config.nginxResources))
+
+			By("Expecting no status update when the resources didn't change")
+			_, _, updateStatus, reconcileErr = newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(updateStatus).To(BeFalse())
+		})
+	})
+
+	Describe("Cloning a site that is being updated", func() {
+		It("Should only consider stable sites safe to clone", func() {
+			source := newTestDrupalSite("test", "default")
+			Expect(isSiteUpdating(source)).To(BeFalse())
+			source.Annotations = map[string]string{"updateInProgress": "true"}
+			Expect(isSiteUpdating(source)).To(BeTrue())
+			source.Annotations = nil
+			setDBUpdatesPending(source)
+			Expect(isSiteUpdating(source)).To(BeTrue())
+			removeDBUpdatesPending(source)
+			Expect(isSiteUpdating(source)).To(BeFalse())
+		})
+		It("Should block the clone while the cloneFrom site is updating", func() {
+			source := newTestDrupalSite("test-clone-blocked-source", "default")
+			source.Annotations = map[string]string{"updateInProgress": "true"}
+			Expect(k8sClient.Create(ctx, source)).To(Succeed())
+			d := newTestDrupalSite("test-clone-blocked", "default")
+			d.Spec.Configuration.CloneFrom = "test-clone-blocked-source"
+
+			blocked, err := newTestReconciler().cloneSourceUpdating(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(blocked).To(BeTrue())
+
+			By("Expecting the clone to proceed once the cloneFrom site is stable")
+			Eventually(func() error {
+				k8sClient.Get(ctx, types.NamespacedName{Name: source.Name, Namespace: source.Namespace}, source)
+				delete(source.Annotations, "updateInProgress")
+				return k8sClient.Update(ctx, source)
+			}).Should(Succeed())
+			Eventually(func() bool {
+				blocked, _ := newTestReconciler().cloneSourceUpdating(ctx, d)
+				return blocked
+			}).Should(BeFalse())
+		})
+	})
+
+	Describe("Cloning a site from a different version", func() {
+		It("Should detect that the cloned database needs DB updates", func() {
+			source := newTestDrupalSite("test-clone-version-source", "default")
+			Expect(k8sClient.Create(ctx, source)).To(Succeed())
+			d := newTestDrupalSite("test-clone-version", "default")
+			d.Spec.Configuration.CloneFrom = "test-clone-version-source"
+
+			mismatch, err := newTestReconciler().cloneVersionMismatch(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(mismatch).To(BeFalse())
+
+			d.Spec.Version.ReleaseSpec = source.Spec.Version.ReleaseSpec + "-newer"
+			mismatch, err = newTestReconciler().cloneVersionMismatch(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(mismatch).To(BeTrue())
+		})
+	})
+
+	Describe("Creating the routes", func() {
+		It("Should wait for the service to exist", func() {
+			d := newTestDrupalSite("test-route-guard", "default")
+			d.UID = "5f0e64a8-3c1e-4d7f-b0a1-7a1d2b3c4d5e"
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"route-guard.webtest.cern.ch"}
+			err := newTestReconciler().ensureResourceX(ctx, d, "route", ctrl.Log)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Temporary()).To(BeTrue())
+			siteURLs, _ := newTestReconciler().siteURLStatus(ctx, d)
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: siteURLs[0].RouteName, Namespace: d.Namespace}, &routev1.Route{})).NotTo(Succeed())
+
+			By("Expecting the route once the service exists")
+			Expect(newTestReconciler().ensureResourceX(ctx, d, "svc_nginx", ctrl.Log)).To(BeNil())
+			Eventually(func() reconcileError {
+				return newTestReconciler().ensureResourceX(ctx, d, "route", ctrl.Log)
+			}).Should(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: siteURLs[0].RouteName, Namespace: d.Namespace}, &routev1.Route{})).To(Succeed())
+		})
+	})
+
+	Describe("Retrying failed reconciliations", func() {
+		It("Should back off between the configured delays", func() {
+			defer func(start, max int) { StartRateLimiterMillis, MaxRateLimiterSeconds = start, max }(StartRateLimiterMillis, MaxRateLimiterSeconds)
+			StartRateLimiterMillis = 500
+			MaxRateLimiterSeconds = 300
+
+			rateLimiter := newRateLimiter()
+			Expect(rateLimiter.When("test")).To(Equal(500 * time.Millisecond))
+			Expect(rateLimiter.When("test")).To(Equal(time.Second))
+			for i := 0; i < 20; i++ {
+				rateLimiter.When("test")
+			}
+			Expect(rateLimiter.When("test")).To(Equal(300 * time.Second))
+			rateLimiter.Forget("test")
+			Expect(rateLimiter.When("test")).To(Equal(500 * time.Millisecond))
+		})
+	})
+
+	Describe("Configuring the controller", func() {
+		It("Should run the configured number of concurrent reconciliations", func() {
+			defer func(count int) { ParallelThreadCount = count }(ParallelThreadCount)
+			ParallelThreadCount = 8
+			options := controllerOptions()
+			Expect(options.MaxConcurrentReconciles).To(Equal(8))
+			Expect(options.RateLimiter).NotTo(BeNil())
+		})
+	})
+
+	Describe("Reconciling a site in a blocked namespace", func() {
+		It("Should detect the blocked namespace", func() {
+			namespace := &corev1.Namespace{}
+			Expect(namespaceBlocked(namespace)).To(BeFalse())
+			namespace.Annotations = map[string]string{"blocked.webservices.cern.ch/blocked-timestamp": "2021-06-02T09:41:38Z"}
+			Expect(namespaceBlocked(namespace)).To(BeFalse())
+			namespace.Annotations["blocked.webservices.cern.ch/reason"] = "test"
+			Expect(namespaceBlocked(namespace)).To(BeTrue())
+		})
+		It("Should not exec into the server pod", func() {
+			d := newTestDrupalSite("test-blocked", "default")
+			ls := labelsForDrupalSite(d.Name)
+			deploy := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: ls},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: ls},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deploy)).To(Succeed())
+			deploy.Status.Replicas = 1
+			deploy.Status.ReadyReplicas = 1
+			Expect(k8sClient.Status().Update(ctx, deploy)).To(Succeed())
+
+			countingClient := &podListCountingClient{Client: k8sClient}
+			r := newTestReconciler()
+			r.Client = countingClient
+			Eventually(func() bool {
+				return r.isDrupalSiteReady(ctx, d)
+			}).Should(BeTrue())
+
+			setConditionStatus(d, "Blocked", true, nil, false)
+			Expect(r.isDrupalSiteInstalled(ctx, d)).To(BeFalse())
+			dbUpdateNeeded, err := r.dbUpdateNeeded(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(dbUpdateNeeded).To(BeFalse())
+			Expect(countingClient.podLists).To(BeZero())
+
+			By("Expecting the exec to be attempted once the namespace is unblocked")
+			d.Status.Conditions.RemoveCondition("Blocked")
+			r.isDrupalSiteInstalled(ctx, d)
+			Expect(countingClient.podLists).NotTo(BeZero())
+		})
+	})
+
+	Describe("Registering the OIDC return URIs", func() {
+		It("Should keep the wildcard path for both schemes", func() {
+			returnURI, err := oidcReturnURI("https", "test-oidc.webtest.cern.ch")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(returnURI).To(Equal("https://test-oidc.webtest.cern.ch/openid-connect/*"))
+			returnURI, err = oidcReturnURI("http", "test-oidc.webtest.cern.ch")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(returnURI).To(Equal("http://test-oidc.webtest.cern.ch/openid-connect/*"))
+			_, err = oidcReturnURI("ftp", "test-oidc.webtest.cern.ch")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Should use the configured scheme and keep the return URIs of the site URLs", func() {
+			defer func() { OidcReturnURIScheme = "https" }()
+			OidcReturnURIScheme = "http"
+			d := newTestDrupalSite("test-oidc-scheme", "default")
+			d.UID = "9b1e5c3a-6d28-4f7b-8e40-c2a7d5f1b396"
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-oidc-scheme.webtest.cern.ch"}
+			r := newTestReconciler()
+			Expect(r.ensureResourceX(ctx, d, "oidc_return_uri", ctrl.Log)).To(BeNil())
+			Expect(r.ensureNoExtraOidcReturnUriResource(ctx, d, "drupal", ctrl.Log)).To(BeNil())
+
+			hash := md5.Sum([]byte(d.Spec.SiteURL[0]))
+			returnURI := &authz.OidcReturnURI{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name + "-" + hex.EncodeToString(hash[0:4]), Namespace: d.Namespace}, returnURI)).To(Succeed())
+			Expect(returnURI.Spec.RedirectURI).To(Equal("http://test-oidc-scheme.webtest.cern.ch/openid-connect/*"))
+		})
+	})
+
+	Describe("Locking the DB updates", func() {
+		It("Should only run one of two concurrent DB updates", func() {
+			d := newTestDrupalSite("test-dbupdate-lock", "default")
+			d.UID = "e7a3c1d9-4f26-4b8a-9c05-1b6d8e2f7a34"
+			r := newTestReconciler()
+			var updbRuns int32
+			attempted := make(chan bool)
+			done := make(chan bool)
+			for i := 0; i < 2; i++ {
+				go func() {
+					defer GinkgoRecover()
+					release, acquired := r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+					attempted <- acquired
+					if acquired {
+						atomic.AddInt32(&updbRuns, 1)
+						// Hold the lock until both updates have tried to take it
+						<-done
+						release()
+					}
+				}()
+			}
+			Expect([]bool{<-attempted, <-attempted}).To(ConsistOf(true, false))
+			close(done)
+			Expect(atomic.LoadInt32(&updbRuns)).To(Equal(int32(1)))
+
+			By("Expecting the lock to be released")
+			Eventually(func() bool {
+				release, acquired := r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+				if acquired {
+					release()
+				}
+				return acquired
+			}).Should(BeTrue())
+		})
+		It("Should take over a lock older than the timeout", func() {
+			defer func() { DBUpdateLockTimeout = time.Hour }()
+			d := newTestDrupalSite("test-dbupdate-lock-timeout", "default")
+			d.UID = "2d5f8b7c-a1e9-4c36-b4d2-96e0f3a7c158"
+			r := newTestReconciler()
+			_, acquired := r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+			Expect(acquired).To(BeTrue())
+			_, acquired = r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+			Expect(acquired).To(BeFalse())
+
+			DBUpdateLockTimeout = 0
+			release, acquired := r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+			Expect(acquired).To(BeTrue())
+			release()
+		})
+		It("Should keep the DB updates of a clone pending while another one holds the lock", func() {
+			d := newTestDrupalSite("test-dbupdate-lock-clone", "default")
+			d.UID = "9a4e2c71-d8b3-4f05-a6e1-3c7b9d0f2e58"
+			setConditionStatus(d, "CloneDBUpdatePending", true, nil, false)
+			r := newTestReconciler()
+			release, acquired := r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+			Expect(acquired).To(BeTrue())
+			defer release()
+
+			update, attempted := r.updateDBSchema(ctx, d, ctrl.Log)
+			Expect(update).To(BeFalse())
+			Expect(attempted).To(BeFalse())
+			update, requeue := r.updateClonedDBSchema(ctx, d, ctrl.Log)
+			Expect(update).To(BeFalse())
+			Expect(requeue).To(BeTrue())
+			Expect(d.ConditionTrue("CloneDBUpdatePending")).To(BeTrue())
+		})
+	})
+
+	Describe("Creating the PVC", func() {
+		It("Should use the default storage class", func() {
+			defer func() { DefaultStorageClass = "cephfs-no-backup" }()
+			DefaultStorageClass = "standard-rwx"
+			pvc := &corev1.PersistentVolumeClaim{}
+			Expect(persistentVolumeClaimForDrupalSite(pvc, newTestDrupalSite("test-storage-class", "default"))).To(Succeed())
+			Expect(pvc.Spec.StorageClassName).To(Equal(pointer.StringPtr("standard-rwx")))
+		})
+	})
+
+	Describe("Changing the disk size", func() {
+		pvcSize := func(d *drupalwebservicesv1alpha1.DrupalSite) string {
+			pvc := &corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "pv-claim-" + d.Name, Namespace: d.Namespace}, pvc)).To(Succeed())
+			size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			return size.String()
+		}
+		It("Should expand the volume when the disk size grows", func() {
+			storageClass := &storagev1.StorageClass{
+				ObjectMeta:           metav1.ObjectMeta{Name: "cephfs-no-backup"},
+				Provisioner:          "cephfs.csi.ceph.com",
+				AllowVolumeExpansion: pointer.BoolPtr(true),
+			}
+			if err := k8sClient.Create(ctx, storageClass); err != nil {
+				Expect(k8sapierrors.IsAlreadyExists(err)).To(BeTrue())
+			}
+			d := newTestDrupalSite("test-disk-grow", "default")
+			d.UID = "8e2f4a61-0c3d-4b7e-a915-6d2c7f8b1e43"
+			r := newTestReconciler()
+			Expect(r.ensureResourceX(ctx, d, "pvc_drupal", ctrl.Log)).To(BeNil())
+			// Only bound claims can be expanded
+			pvc := &corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "pv-claim-" + d.Name, Namespace: d.Namespace}, pvc)).To(Succeed())
+			pvc.Status.Phase = corev1.ClaimBound
+			Expect(k8sClient.Status().Update(ctx, pvc)).To(Succeed())
+
+			d.Spec.Configuration.DiskSize = "20Gi"
+			Expect(r.validateDiskSize(ctx, d)).To(BeNil())
+			Expect(r.ensureResourceX(ctx, d, "pvc_drupal", ctrl.Log)).To(BeNil())
+			Expect(pvcSize(d)).To(Equal("20Gi"))
+		})
+		It("Should reject a disk size that shrinks the volume", func() {
+			d := newTestDrupalSite("test-disk-shrink", "default")
+			d.UID = "b41d7c2e-95a3-4e6f-8d20-3f7a1c9e5b86"
+			r := newTestReconciler()
+			Expect(r.ensureResourceX(ctx, d, "pvc_drupal", ctrl.Log)).To(BeNil())
+
+			d.Spec.Configuration.DiskSize = "5Gi"
+			err := r.validateDiskSize(ctx, d)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+			Expect(r.ensureResourceX(ctx, d, "pvc_drupal", ctrl.Log)).To(BeNil())
+			Expect(pvcSize(d)).To(Equal("10Gi"))
+		})
+	})
+
+	Describe("Reporting the DB updates", func() {
+		It("Should count the pending updates listed by check-updb-status.sh", func() {
+			sout := ` Module   Update ID   Type              Description
+ -------- ----------- ----------------- -----------------------------------
+  system   8901        hook_update_n     Update the stored schema data.
+  node     8902        hook_update_n     Fix the node access table.
+  views    views_post  post-update       Update the views configuration.
+`
+			Expect(pendingDBUpdates(sout)).To(Equal(3))
+			Expect(pendingDBUpdates("")).To(Equal(0))
+		})
+		It("Should report the end of the run-updb.sh output in the DBUpdatesFailed condition", func() {
+			d := newTestDrupalSite("test-updb-failure", "default")
+			stdout := strings.Repeat("[notice] Update started\n", 500) + "[error] SQLSTATE[42S01]: Table 'cache_page' already exists"
+			setConditionStatus(d, "DBUpdatesFailed", true, dbUpdateFailure(stdout, "", fmt.Errorf("command terminated with exit code 1")), false)
+			condition := d.Status.Conditions.GetCondition("DBUpdatesFailed")
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Reason).To(BeEquivalentTo(ErrDBUpdateFailed.Error()))
+			Expect(condition.Message).To(ContainSubstring("Table 'cache_page' already exists"))
+			Expect(condition.Message).To(HaveSuffix("command terminated with exit code 1"))
+			Expect(len(condition.Message)).To(BeNumerically("<=", maxDrushOutputLength))
+		})
+	})
+
+	Describe("Overriding the sitebuilder image", func() {
+		const override = "registry.cern.ch/drupal/paas/sitebuilder@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		It("Should use the override in the deployment and the install job", func() {
+			d := newTestDrupalSite("test-image-override", "default")
+			d.Spec.Configuration.SiteBuilderImageOverride = override
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "php-fpm").Image).To(Equal(override))
+			Expect(containerByName(deploy, "cron").Image).To(Equal(override))
+
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Image).To(Equal(override))
+		})
+		It("Should not pin the failsafe release", func() {
+			d := newTestDrupalSite("test-image-override", "default")
+			d.Spec.Configuration.SiteBuilderImageOverride = override
+			Expect(sitebuilderImageRefToUse(d, "previous-release").Name).To(Equal(mirroredImage(SiteBuilderImage + ":previous-release")))
+		})
+		It("Should reject a reference without a tag or digest", func() {
+			spec := newTestDrupalSite("test-image-override", "default").Spec
+			spec.Configuration.SiteBuilderImageOverride = "registry.cern.ch/drupal/paas/sitebuilder"
+			err := validateSpec(spec, false)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+		})
+	})
+
+	Describe("Removing a site URL", func() {
+		It("Should delete the drupal and webdav routes and OidcReturnURIs of that URL", func() {
+			d := newTestDrupalSite("test-remove-url", "default")
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-remove-url-1.webtest.cern.ch", "test-remove-url-2.webtest.cern.ch"}
+			for _, label := range []string{"drupal", "webdav"} {
+				for i, url := range d.Spec.SiteURL {
+					host := string(url)
+					if label == "webdav" {
+						host = "webdav-" + host
+					}
+					ls := labelsForDrupalSite(d.Name)
+					ls["app"] = "drupal"
+					ls["route"] = label
+					Expect(k8sClient.Create(ctx, &routev1.Route{
+						ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%s-%d", d.Name, label, i), Namespace: d.Namespace, Labels: ls},
+						Spec:       routev1.RouteSpec{Host: host, To: routev1.RouteTargetReference{Kind: "Service", Name: d.Name}},
+					})).To(Succeed())
+					delete(ls, "route")
+					ls["oidcReturnURI"] = label
+					Expect(k8sClient.Create(ctx, &authz.OidcReturnURI{
+						ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%s-%d", d.Name, label, i), Namespace: d.Namespace, Labels: ls},
+						Spec:       authz.OidcReturnURISpec{RedirectURI: "https://" + host + "/openid-connect/*"},
+					})).To(Succeed())
+				}
+			}
+
+			d.Spec.SiteURL = d.Spec.SiteURL[:1]
+			r := newTestReconciler()
+			for _, label := range []string{"drupal", "webdav"} {
+				Expect(r.ensureNoExtraRouteResource(ctx, d, label, ctrl.Log)).To(BeNil())
+				Expect(r.ensureNoExtraOidcReturnUriResource(ctx, d, label, ctrl.Log)).To(BeNil())
+			}
+			for _, label := range []string{"drupal", "webdav"} {
+				kept := types.NamespacedName{Name: fmt.Sprintf("%s-%s-0", d.Name, label), Namespace: d.Namespace}
+				removed := types.NamespacedName{Name: fmt.Sprintf("%s-%s-1", d.Name, label), Namespace: d.Namespace}
+				Expect(k8sClient.Get(ctx, kept, &routev1.Route{})).To(Succeed())
+				Expect(k8sClient.Get(ctx, kept, &authz.OidcReturnURI{})).To(Succeed())
+				Eventually(func() bool {
+					return k8sapierrors.IsNotFound(k8sClient.Get(ctx, removed, &routev1.Route{}))
+				}).Should(BeTrue())
+				Eventually(func() bool {
+					return k8sapierrors.IsNotFound(k8sClient.Get(ctx, removed, &authz.OidcReturnURI{}))
+				}).Should(BeTrue())
+			}
+		})
+	})
+
+	Describe("Logging the reconcile summary", func() {
+		It("Should report the state of the site and the resources of the pass", func() {
+			d := newTestDrupalSite("test-reconcile-summary", "default")
+			d.Annotations = map[string]string{"updateInProgress": "true"}
+			d.Status.ReleaseID.Current = "new"
+			d.Status.ReleaseID.Failsafe = "old"
+			setReady(d)
+			setConditionStatus(d, "Serving", false, nil, false)
+			sink := &summaryLogSink{Logger: ctrl.Log, summary: map[string]interface{}{}}
+			logReconcileSummary(sink, d, &reconcileSummary{ensured: []string{"pvc_drupal", "deployment"}, skipped: []string{"route"}})
+			Expect(sink.summary).To(Equal(map[string]interface{}{
+				"conditions":         "Ready=True,Serving=False",
+				"updateInProgress":   true,
+				"releaseID.current":  "new",
+				"releaseID.failsafe": "old",
+				"ensured":            "pvc_drupal,deployment",
+				"skipped":            "route",
+			}))
+		})
+	})
+
+	Describe("Ensuring the deployment while the database secret is empty", func() {
+		It("Should preserve the existing deployment until the secret is populated", func() {
+			d := newTestDrupalSite("test-empty-db-secret", "default")
+			d.UID = "3c9d6e2a-7b41-4f0e-9a52-d81e6f4b2a90"
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			createdReleaseID := deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]
+
+			By("Expecting the deployment to be left untouched while the secret is empty")
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: databaseSecretName(d), Namespace: d.Namespace}}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			d.Spec.Version.ReleaseSpec = "newer"
+			config.replicas = 0
+			Eventually(func() bool {
+				err := newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)
+				return err != nil && err.Temporary()
+			}).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(*deploy.Spec.Replicas).To(Equal(int32(1)))
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]).To(Equal(createdReleaseID))
+
+			By("Expecting the deployment to be updated once the secret is populated")
+			secret.Data = map[string][]byte{"DB_PASSWORD": []byte("password")}
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+			Eventually(func() reconcileError {
+				return newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)
+			}).Should(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]).To(Equal(releaseID(d)))
+		})
+	})
+
+	Describe("Publishing a site after a failed update", func() {
+		It("Should block publishing until the update is recovered", func() {
+			d := newTestDrupalSite("test-publish-blocked", "default")
+			Expect(setPublishBlocked(d)).To(BeFalse())
+			Expect(d.ConditionTrue("PublishBlocked")).To(BeFalse())
+
+			setConditionStatus(d, "DBUpdatesFailed", true, newApplicationError(fmt.Errorf("updb failed"), ErrDBUpdateFailed), false)
+			Expect(setPublishBlocked(d)).To(BeTrue())
+			Expect(d.ConditionTrue("PublishBlocked")).To(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("PublishBlocked").Message).To(ContainSubstring("DBUpdatesFailed"))
+
+			d.Status.Conditions.RemoveCondition("DBUpdatesFailed")
+			Expect(setPublishBlocked(d)).To(BeTrue())
+			Expect(d.ConditionTrue("PublishBlocked")).To(BeFalse())
+		})
+	})
+
+	Describe("Reporting the Drupal core version", func() {
+		It("Should check the version again after the interval or a new release", func() {
+			defer func() { DrupalCoreVersionInterval = 0 }()
+			DrupalCoreVersionInterval = time.Hour
+			now := time.Now()
+
+			d := newTestDrupalSite("test-core-version", "default")
+			d.Status.ReleaseID.Current = "v9.3-1-RELEASE-2022.02.03T11-18-39Z"
+			Expect(drupalCoreVersionCheckDue(d, now)).To(BeTrue())
+
+			d.Status.DrupalCoreVersion = &drupalwebservicesv1alpha1.DrupalCoreVersion{
+				Version:   "9.3.3",
+				ReleaseID: d.Status.ReleaseID.Current,
+				CheckTime: metav1.NewTime(now.Add(-time.Minute)),
+			}
+			Expect(drupalCoreVersionCheckDue(d, now)).To(BeFalse())
+			Expect(drupalCoreVersionCheckDue(d, now.Add(time.Hour))).To(BeTrue())
+
+			d.Status.ReleaseID.Current = "v9.3-2-RELEASE-2022.03.03T11-18-39Z"
+			Expect(drupalCoreVersionCheckDue(d, now)).To(BeTrue())
+		})
+		It("Should keep the previous version if the check fails", func() {
+			d := newTestDrupalSite("test-core-version-failed", "default")
+			d.Status.DrupalCoreVersion = &drupalwebservicesv1alpha1.DrupalCoreVersion{Version: "9.3.3"}
+			newTestReconciler().updateDrupalCoreVersion(ctx, d, ctrl.Log)
+			Expect(d.Status.DrupalCoreVersion.Version).To(Equal("9.3.3"))
+			Expect(d.Status.DrupalCoreVersion.CheckTime.IsZero()).To(BeFalse())
+		})
+	})
+
+	Describe("Terminating TLS on the routes", func() {
+		It("Should terminate at the router by default", func() {
+			d := newTestDrupalSite("test-route-tls", "default")
+			route := &routev1.Route{}
+			Expect(routeForDrupalSite(route, d, "route-tls.webtest.cern.ch", nil)).To(Succeed())
+			Expect(route.Spec.TLS).To(Equal(&routev1.TLSConfig{
+				Termination:                   routev1.TLSTerminationEdge,
+				InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyRedirect,
+			}))
+		})
+		It("Should reencrypt towards the pods", func() {
+			d := newTestDrupalSite("test-route-tls", "default")
+			d.Spec.Configuration.RouteTLS = &drupalwebservicesv1alpha1.RouteTLS{Termination: "reencrypt"}
+			tls := routeTLSConfig(d, nil)
+			Expect(tls.Termination).To(Equal(routev1.TLSTerminationReencrypt))
+			Expect(tls.Certificate).To(BeEmpty())
+		})
+		It("Should serve the certificate of the referenced Secret", func() {
+			d := newTestDrupalSite("test-route-tls-byo", "default")
+			d.Spec.Configuration.RouteTLS = &drupalwebservicesv1alpha1.RouteTLS{CertificateSecret: "test-route-tls-byo-cert"}
+			_, err := newTestReconciler().routeCertificateSecret(ctx, d)
+			Expect(err).NotTo(BeNil())
+
+			Expect(k8sClient.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-route-tls-byo-cert", Namespace: d.Namespace},
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       []byte("certificate"),
+					corev1.TLSPrivateKeyKey: []byte("key"),
+					"ca.crt":                []byte("ca"),
+				},
+			})).To(Succeed())
+			var secret *corev1.Secret
+			Eventually(func() reconcileError {
+				secret, err = newTestReconciler().routeCertificateSecret(ctx, d)
+				return err
+			}).Should(BeNil())
+			tls := routeTLSConfig(d, secret)
+			Expect(tls.Termination).To(Equal(routev1.TLSTerminationEdge))
+			Expect(tls.Certificate).To(Equal("certificate"))
+			Expect(tls.Key).To(Equal("key"))
+			Expect(tls.CACertificate).To(Equal("ca"))
+		})
+	})
+
+	Describe("Checking if a site is serving", func() {
+		deployment := &appsv1.Deployment{
+			Spec:   appsv1.DeploymentSpec{Replicas: pointer.Int32Ptr(2)},
+			Status: appsv1.DeploymentStatus{ReadyReplicas: 2},
+		}
+		runningPod := func(releaseID string) corev1.Pod {
+			return corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"releaseID": releaseID}},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			}
+		}
+		It("Should serve when all the replicas are ready with the releaseID of the spec", func() {
+			Expect(deploymentServing(deployment, []corev1.Pod{runningPod("v9-new"), runningPod("v9-new")}, "v9-new")).To(BeTrue())
+		})
+		It("Should not serve while a pod runs a different releaseID", func() {
+			Expect(deploymentServing(deployment, []corev1.Pod{runningPod("v9-old"), runningPod("v9-new")}, "v9-new")).To(BeFalse())
+			failedPod := runningPod("v9-old")
+			failedPod.Status.Phase = corev1.PodFailed
+			Expect(deploymentServing(deployment, []corev1.Pod{failedPod, runningPod("v9-new")}, "v9-new")).To(BeTrue())
+		})
+		It("Should not serve while some replicas are not ready", func() {
+			notReady := deployment.DeepCopy()
+			notReady.Status.ReadyReplicas = 1
+			Expect(deploymentServing(notReady, []corev1.Pod{runningPod("v9-new")}, "v9-new")).To(BeFalse())
+		})
+	})
+
+	Describe("Reporting the stuck conditions", func() {
+		It("Should report a failure condition that has been true for longer than the threshold", func() {
+			d := newTestDrupalSite("test-stuck-conditions", "default")
+			d.Status.Conditions = status.Conditions{
+				{Type: "DBUpdatesFailed", Status: corev1.ConditionTrue, Reason: "UpdateFailed", LastTransitionTime: metav1.NewTime(time.Now().Add(-3 * time.Hour))},
+				{Type: "Ready", Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(time.Now().Add(-10 * time.Minute))},
+				{Type: "Initialized", Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(time.Now().Add(-3 * time.Hour))},
+			}
+			stuck := stuckConditions(d, time.Now())
+			Expect(stuck).To(HaveLen(1))
+			Expect(stuck[0].Type).To(Equal(status.ConditionType("DBUpdatesFailed")))
+
+			By("Emitting an event")
+			recorder := record.NewFakeRecorder(10)
+			r := newTestReconciler()
+			r.Recorder = recorder
+			r.reportStuckConditions(d, ctrl.Log)
+			Expect(recorder.Events).To(Receive(And(ContainSubstring("ConditionStuck"), ContainSubstring("DBUpdatesFailed has been True since"))))
+			Expect(recorder.Events).NotTo(Receive())
+
+			By("Disabling the reports")
+			defer func(threshold time.Duration) { StuckConditionThreshold = threshold }(StuckConditionThreshold)
+			StuckConditionThreshold = 0
+			Expect(stuckConditions(d, time.Now())).To(BeEmpty())
+		})
+	})
+
+	Describe("Retrying a failed DB update through the annotation", func() {
+		It("Should retry the DB update once per annotation", func() {
+			d := newTestDrupalSite("test-retry-db-update", "default")
+			setConditionStatus(d, "DBUpdatesFailed", true, newApplicationError(fmt.Errorf("updb failed"), ErrPodExec), false)
+			setDBUpdatesPending(d)
+			Expect(retryDBUpdate(d)).To(BeFalse())
+			Expect(d.ConditionTrue("DBUpdatesFailed")).To(BeTrue())
+
+			d.Annotations = map[string]string{retryDBUpdateAnnotation: "true"}
+			Expect(retryDBUpdate(d)).To(BeTrue())
+			Expect(d.ConditionTrue("DBUpdatesFailed")).To(BeFalse())
+			Expect(d.ConditionTrue("DBUpdatesPending")).To(BeFalse())
+			Expect(d.Annotations).To(HaveKey(retryDBUpdateAnnotation))
+			Expect(retryDBUpdate(d)).To(BeFalse())
+
+			By("Failing the retried DB update again")
+			setConditionStatus(d, "DBUpdatesFailed", true, newApplicationError(fmt.Errorf("updb failed"), ErrPodExec), false)
+			Expect(unsetRetryDBUpdate(d)).To(BeTrue())
+			Expect(d.Annotations).NotTo(HaveKey(retryDBUpdateAnnotation))
+			Expect(unsetRetryDBUpdate(d)).To(BeFalse())
+			Expect(retryDBUpdate(d)).To(BeFalse())
+			Expect(d.ConditionTrue("DBUpdatesFailed")).To(BeTrue())
+		})
+		It("Should keep the annotation while the retry can't be attempted", func() {
+			d := newTestDrupalSite("test-retry-db-update-lock", "default")
+			d.UID = "c2f7a9e4-1b3d-4e86-9f50-d4a8b6c1e7f2"
+			d.Annotations = map[string]string{retryDBUpdateAnnotation: "true"}
+			r := newTestReconciler()
+			release, acquired := r.acquireDBUpdateLock(ctx, d, ctrl.Log)
+			Expect(acquired).To(BeTrue())
+			defer release()
+
+			update, retried, attempted := r.runDBUpdate(ctx, d, ctrl.Log)
+			Expect(update).To(BeFalse())
+			Expect(retried).To(BeFalse())
+			Expect(attempted).To(BeFalse())
+			Expect(d.Annotations).To(HaveKey(retryDBUpdateAnnotation))
+		})
+	})
+
+	Describe("Restricting the URLs to the allowed domains", func() {
+		It("Should only accept the URLs of the allowed domains, and the default URL", func() {
+			defer func(suffixes []string) { AllowedSiteURLSuffixes = suffixes }(AllowedSiteURLSuffixes)
+			AllowedSiteURLSuffixes = []string{"web.cern.ch", ".webtest.cern.ch"}
+			d := newTestDrupalSite("test-url-suffixes", "default")
+			for url, allowed := range map[drupalwebservicesv1alpha1.Url]bool{
+				"mysite.web.cern.ch":      true,
+				"web.cern.ch":             true,
+				"mysite.webtest.cern.ch":  true,
+				"mysite.example.org":      false,
+				"mysite.notweb.cern.ch":   false,
+				"web.cern.ch.example.org": false,
+				"mysite.webtest.cern.chx": false,
+			} {
+				d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{url}
+				if allowed {
+					Expect(validateSiteURLSuffixes(d, nil)).To(BeNil(), string(url))
+				} else {
+					Expect(validateSiteURLSuffixes(d, nil)).NotTo(BeNil(), string(url))
+				}
+			}
+
+			By("Checking the canary URL")
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"mysite.web.cern.ch"}
+			d.Spec.CanaryURL = "canary.example.org"
+			err := validateSiteURLSuffixes(d, nil)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+			d.Spec.CanaryURL = ""
+
+			By("Allowing the default URL of the project")
+			dpc := &drupalwebservicesv1alpha1.DrupalProjectConfig{Spec: drupalwebservicesv1alpha1.DrupalProjectConfigSpec{DefaultDomain: "app.example.org"}}
+			d.Spec.SiteURL = nil
+			Expect(applyProjectDefaults(d, dpc)).To(BeTrue())
+			Expect(d.Spec.SiteURL).To(Equal([]drupalwebservicesv1alpha1.Url{"test-url-suffixes-default.app.example.org"}))
+			Expect(validateSiteURLSuffixes(d, dpc)).To(BeNil())
+
+			By("Allowing any URL without allowed domains")
+			AllowedSiteURLSuffixes = nil
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"mysite.example.org"}
+			Expect(validateSiteURLSuffixes(d, nil)).To(BeNil())
+		})
+	})
+
+	Describe("Watching the S2I builds", func() {
+		It("Should enqueue the DrupalSite of a Build whose phase changed", func() {
+			running := &buildv1.Build{
+				ObjectMeta: metav1.ObjectMeta{Name: "sitebuilder-s2i-test-1", Namespace: "build-watch", Labels: map[string]string{"drupalSite": "test-build-watch"}},
+				Status:     buildv1.BuildStatus{Phase: buildv1.BuildPhaseRunning},
+			}
+			complete := running.DeepCopy()
+			complete.Status.Phase = buildv1.BuildPhaseComplete
+			queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer queue.ShutDown()
+			handler.EnqueueRequestsFromMapFunc(drupalSiteForBuild).Update(event.UpdateEvent{ObjectOld: running, ObjectNew: complete}, queue)
+			Expect(queue.Len()).To(Equal(1))
+			request, _ := queue.Get()
+			Expect(request).To(Equal(reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-build-watch", Namespace: "build-watch"}}))
+
+			By("Ignoring the Builds of other BuildConfigs")
+			Expect(drupalSiteForBuild(&buildv1.Build{ObjectMeta: metav1.ObjectMeta{Name: "other-1", Namespace: "build-watch"}})).To(BeEmpty())
+		})
+	})
+
+	Describe("Setting the resources of the image builds", func() {
+		It("Should use the buildResources of the site, or else the operator-wide ones", func() {
+			defer func(resources corev1.ResourceRequirements) { BuildResources = resources }(BuildResources)
+			var err error
+			BuildResources, err = ResourceRequestLimit("2Gi", "1000m", "4Gi", "2000m")
+			Expect(err).NotTo(HaveOccurred())
+			d := newTestDrupalSite("test-build-resources", "default")
+			d.Spec.Configuration.ExtraConfigurationRepo = "https://gitlab.cern.ch/drupal/test-extra-config"
+			bc := &buildv1.BuildConfig{}
+			Expect(buildConfigForDrupalSiteBuilderS2I(bc, d)).To(Succeed())
+			Expect(bc.Spec.CommonSpec.Resources).To(Equal(BuildResources))
+
+			By("Overriding them in the spec")
+			override, err := ResourceRequestLimit("6Gi", "1000m", "8Gi", "2000m")
+			Expect(err).NotTo(HaveOccurred())
+			d.Spec.Configuration.BuildResources = &override
+			Expect(validateSpec(d.Spec, true)).To(BeNil())
+			bc.CreationTimestamp = metav1.Now()
+			Expect(buildConfigForDrupalSiteBuilderS2I(bc, d)).To(Succeed())
+			Expect(bc.Spec.CommonSpec.Resources).To(Equal(override))
+
+			By("Rejecting a request above its limit")
+			override.Requests[corev1.ResourceMemory] = resource.MustParse("10Gi")
+			Expect(validateSpec(d.Spec, true)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Deferring the update of a site without autoUpdate", func() {
+		It("Should keep the running release until the update is approved", func() {
+			d := newTestDrupalSite("test-deferred-update", "default")
+			d.UID = "5e1f7a3b-9c2d-4b8e-a6f0-2d4c6e8a0b1c"
+			d.Spec.Configuration.AutoUpdate = pointer.BoolPtr(false)
+			Expect(k8sClient.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: databaseSecretName(d), Namespace: d.Namespace},
+				Data:       map[string][]byte{"DB_PASSWORD": []byte("password")},
+			})).To(Succeed())
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			runningReleaseID := deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]
+			Expect(updateUnapproved(d)).To(BeNil())
+
+			By("Deferring the update to a new release")
+			d.Spec.Version.ReleaseSpec = "deferred"
+			unapproved := updateUnapproved(d)
+			Expect(unapproved).NotTo(BeNil())
+			Expect(unapproved.Unwrap()).To(Equal(ErrUpdateUnapproved))
+			Expect(unapproved.Error()).To(ContainSubstring(approveUpdateAnnotation + "=" + releaseID(d)))
+			Expect(newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)).To(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]).To(Equal(runningReleaseID))
+			Expect(updatePendingResolved(d, runningReleaseID)).To(BeFalse())
+
+			By("Keeping the rest of the deployment up to date while the update is pending")
+			runningImage := containerByName(deploy, "php-fpm").Image
+			config.replicas = 2
+			Expect(newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)).To(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(*deploy.Spec.Replicas).To(Equal(int32(2)))
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]).To(Equal(runningReleaseID))
+			Expect(containerByName(deploy, "php-fpm").Image).To(Equal(runningImage))
+
+			By("Resolving the pending update when the version is reverted")
+			Expect(updatePendingResolved(d, releaseID(d))).To(BeTrue())
+
+			By("Approving the update")
+			d.Annotations = map[string]string{approveUpdateAnnotation: releaseID(d)}
+			Expect(updateUnapproved(d)).To(BeNil())
+			Expect(updatePendingResolved(d, runningReleaseID)).To(BeTrue())
+			Eventually(func() string {
+				newTestReconciler().ensureDrupalDeployment(ctx, d, config, ctrl.Log)
+				k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)
+				return deploy.Spec.Template.ObjectMeta.Annotations["releaseID"]
+			}).Should(Equal(releaseID(d)))
+
+			By("Deferring the next update again")
+			d.Spec.Version.ReleaseSpec = "next"
+			Expect(updateUnapproved(d)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Rendering the trusted host patterns", func() {
+		It("Should trust the URLs of the spec and follow their changes", func() {
+			d := newTestDrupalSite("test-trusted-hosts", "default")
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-trusted-hosts.webtest.cern.ch", "other.web.cern.ch"}
+			cm := &corev1.ConfigMap{}
+			Expect(updateConfigMapForSiteSettings(ctx, cm, d, k8sClient)).To(Succeed())
+			settings := cm.Data["settings.php"]
+			Expect(settings).To(ContainSubstring("$settings['hash_salt']"))
+			Expect(settings).To(ContainSubstring(`'^test-trusted-hosts\.webtest\.cern\.ch$',`))
+			Expect(settings).To(ContainSubstring(`'^other\.web\.cern\.ch$',`))
+			Expect(strings.Index(settings, trustedHostPatternsMarker)).To(BeNumerically("<", strings.Index(settings, "settings.local.php")))
+
+			By("Changing the URLs of the spec")
+			cm.CreationTimestamp = metav1.Now()
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"new.web.cern.ch"}
+			Expect(updateConfigMapForSiteSettings(ctx, cm, d, k8sClient)).To(Succeed())
+			settings = cm.Data["settings.php"]
+			Expect(settings).To(ContainSubstring(`'^new\.web\.cern\.ch$',`))
+			Expect(settings).NotTo(ContainSubstring("other"))
+			Expect(strings.Count(settings, trustedHostPatternsMarker)).To(Equal(1))
+			Expect(strings.Count(settings, "trusted_host_patterns'] = [\n")).To(Equal(1))
+		})
+		It("Should add the include of the extra settings to the settings of the existing sites", func() {
+			d := newTestDrupalSite("test-extra-settings-include", "default")
+			cm := &corev1.ConfigMap{}
+			Expect(updateConfigMapForSiteSettings(ctx, cm, d, k8sClient)).To(Succeed())
+			Expect(strings.Count(cm.Data["settings.php"], extraSettingsInclude)).To(Equal(1))
+
+			By("Enforcing the include in settings that lack it")
+			cm.CreationTimestamp = metav1.Now()
+			cm.Data["settings.php"] = "<?php\n$settings['hash_salt'] = 'salt';\n"
+			Expect(updateConfigMapForSiteSettings(ctx, cm, d, k8sClient)).To(Succeed())
+			settings := cm.Data["settings.php"]
+			Expect(strings.Count(settings, extraSettingsInclude)).To(Equal(1))
+			Expect(strings.Index(settings, trustedHostPatternsMarker)).To(BeNumerically("<", strings.Index(settings, extraSettingsInclude)))
+			Expect(updateConfigMapForSiteSettings(ctx, cm, d, k8sClient)).To(Succeed())
+			Expect(cm.Data["settings.php"]).To(Equal(settings))
+		})
+	})
+
+	Describe("Reporting a failed backup", func() {
+		It("Should set the BackupFailed condition while the most recent backup of the site failed", func() {
+			d := newTestDrupalSite("test-backup-failed", "default")
+			siteHash := md5.Sum([]byte(d.Name))
+			backup := func(name string, phase velerov1.BackupPhase, started time.Time) velerov1.Backup {
+				return velerov1.Backup{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"drupal.webservices.cern.ch/drupalSiteHash": hex.EncodeToString(siteHash[:])}},
+					Status:     velerov1.BackupStatus{Phase: phase, StartTimestamp: &metav1.Time{Time: started}, Errors: 1},
+				}
+			}
+			now := time.Now()
+			backups := []velerov1.Backup{
+				backup("backup-completed", velerov1.BackupPhaseCompleted, now.Add(-2*time.Hour)),
+				backup("backup-hook-timeout", velerov1.BackupPhasePartiallyFailed, now.Add(-time.Hour)),
+				backup("backup-in-progress", velerov1.BackupPhaseInProgress, now),
+			}
+			failedBackup := lastBackupFailed(d, backups)
+			Expect(failedBackup).NotTo(BeNil())
+			Expect(failedBackup.Name).To(Equal("backup-hook-timeout"))
+
+			recorder := record.NewFakeRecorder(10)
+			r := newTestReconciler()
+			r.Recorder = recorder
+			Expect(r.setBackupFailed(d, failedBackup)).To(BeTrue())
+			condition := d.Status.Conditions.GetCondition("BackupFailed")
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.IsTrue()).To(BeTrue())
+			Expect(condition.Reason).To(Equal(status.ConditionReason(ErrBackupFailed.Error())))
+			Expect(condition.Message).To(ContainSubstring("backup backup-hook-timeout PartiallyFailed with 1 errors"))
+			Expect(recorder.Events).To(Receive(ContainSubstring("BackupFailed")))
+			By("Emitting the event once per failed backup")
+			Expect(r.setBackupFailed(d, failedBackup)).To(BeFalse())
+			Expect(recorder.Events).NotTo(Receive())
+
+			By("Clearing the condition once a backup of the site completes")
+			backups = append(backups, backup("backup-next", velerov1.BackupPhaseCompleted, now.Add(time.Minute)))
+			Expect(lastBackupFailed(d, backups)).To(BeNil())
+			Expect(r.setBackupFailed(d, nil)).To(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("BackupFailed")).To(BeNil())
+		})
+	})
+
+	Describe("Cloning a site from another namespace", func() {
+		Context("With a cloneFrom site of the same namespace", func() {
+			It("Should mount the volume and the DB credentials of the cloneFrom site", func() {
+				d := newTestDrupalSite("test-clone-same-namespace", "default")
+				d.Spec.Configuration.CloneFrom = "live"
+				Expect(crossNamespaceClone(d)).To(BeFalse())
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "test-db-secret", d)).To(Succeed())
+				Expect(job.Spec.Template.Spec.InitContainers[0].EnvFrom[0].SecretRef.Name).To(Equal("dbcredentials-live"))
+				Expect(job.Spec.Template.Spec.Volumes).To(ContainElement(corev1.Volume{
+					Name:         "drupal-directory-live",
+					VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pv-claim-live", ReadOnly: true}},
+				}))
+			})
+		})
+		Context("With a cloneFrom site of another namespace", func() {
+			It("Should pull the cloneFrom site from its export, without its volume or DB credentials", func() {
+				d := newTestDrupalSite("test-clone-cross-namespace", "default")
+				d.Spec.Configuration.CloneFrom = "other-project/live"
+				Expect(crossNamespaceClone(d)).To(BeTrue())
+				Expect(cloneSourceKey(d)).To(Equal(types.NamespacedName{Namespace: "other-project", Name: "live"}))
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "test-db-secret", d)).To(Succeed())
+				podSpec := job.Spec.Template.Spec
+				Expect(podSpec.InitContainers).To(BeEmpty())
+				for _, volume := range podSpec.Volumes {
+					Expect(volume.Name).NotTo(Equal("drupal-directory-live"))
+					if volume.PersistentVolumeClaim != nil {
+						Expect(volume.PersistentVolumeClaim.ClaimName).To(Equal("pv-claim-test-clone-cross-namespace"))
+					}
+				}
+				for _, envFrom := range podSpec.Containers[0].EnvFrom {
+					Expect(envFrom.SecretRef.Name).NotTo(Equal("dbcredentials-live"))
+				}
+				Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+					Name: "RSYNC_PASSWORD",
+					ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "clone-source-test-clone-cross-namespace"},
+						Key:                  "RSYNC_PASSWORD",
+					}},
+				}))
+				Expect(podSpec.Containers[0].Command[2]).To(ContainSubstring("rsync://clone@" + cloneExportName(d) + ".other-project.svc:8873/files/ /drupal-data/"))
+			})
+			It("Should name the export uniquely per site", func() {
+				d1 := newTestDrupalSite("c", "a-b")
+				d2 := newTestDrupalSite("b-c", "a")
+				Expect(cloneExportName(d1)).NotTo(Equal(cloneExportName(d2)))
+				Expect(len(cloneExportName(d1))).To(BeNumerically("<=", 63))
+			})
+			It("Should export the cloneFrom site from its own namespace, until the clone job finishes", func() {
+				namespace := "clone-export-source"
+				Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+				Expect(k8sClient.Create(ctx, newTestDrupalSite("live", namespace))).To(Succeed())
+				Expect(k8sClient.Create(ctx, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "dbcredentials-live", Namespace: namespace},
+					StringData: map[string]string{"DB_PASSWORD": "secret"},
+				})).To(Succeed())
+				sourceClaim := &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "pv-claim-live", Namespace: namespace},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+						Resources:   corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+					},
+				}
+				Expect(k8sClient.Create(ctx, sourceClaim)).To(Succeed())
+
+				d := newTestDrupalSite("test-clone-export", "default")
+				d.UID = "5b8d2e4f-7a1c-4d39-b6e0-3f9c8a2d1e47"
+				d.Spec.Configuration.CloneFrom = drupalwebservicesv1alpha1.CloneFrom(namespace + "/live")
+				r := newTestReconciler()
+				Eventually(func() reconcileError {
+					return r.ensureCloneSourceExport(ctx, d, ctrl.Log)
+				}).Should(BeNil())
+
+				By("Running the export in the namespace of the cloneFrom site, with its own volume and DB credentials")
+				exportJob := &batchv1.Job{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cloneExportName(d), Namespace: namespace}, exportJob)).To(Succeed())
+				exportPod := exportJob.Spec.Template.Spec
+				Expect(exportPod.InitContainers[0].EnvFrom[0].SecretRef.Name).To(Equal("dbcredentials-live"))
+				Expect(exportPod.Volumes).To(ContainElement(corev1.Volume{
+					Name:         "drupal-directory-live",
+					VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pv-claim-live", ReadOnly: true}},
+				}))
+				for _, volumeMount := range exportPod.Containers[0].VolumeMounts {
+					if volumeMount.Name != "rsyncd" {
+						Expect(volumeMount.ReadOnly).To(BeTrue(), volumeMount.Name)
+					}
+				}
+				Expect(exportPod.Containers[0].Command[2]).To(ContainSubstring("read only = true"))
+				service := &corev1.Service{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cloneExportName(d), Namespace: namespace}, service)).To(Succeed())
+				Expect(service.Spec.Selector).To(Equal(exportJob.Spec.Template.Labels))
+
+				By("Sharing only the password of the export with the namespace of the site")
+				secret := &corev1.Secret{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cloneSourceSecretName(d), Namespace: d.Namespace}, secret)).To(Succeed())
+				Expect(secret.Data).To(HaveKey("RSYNC_PASSWORD"))
+				Expect(secret.Data).To(HaveLen(1))
+				exportSecret := &corev1.Secret{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cloneExportName(d), Namespace: namespace}, exportSecret)).To(Succeed())
+				Expect(exportSecret.Data["RSYNC_PASSWORD"]).To(Equal(secret.Data["RSYNC_PASSWORD"]))
+				Expect(k8sapierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "dbcredentials-live", Namespace: d.Namespace}, &corev1.Secret{}))).To(BeTrue())
+
+				By("Keeping the password when ensured again")
+				Expect(r.ensureCloneSourceExport(ctx, d, ctrl.Log)).To(BeNil())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cloneExportName(d), Namespace: namespace}, exportSecret)).To(Succeed())
+				Expect(exportSecret.Data["RSYNC_PASSWORD"]).To(Equal(secret.Data["RSYNC_PASSWORD"]))
+
+				By("Deleting the export once the clone job has failed")
+				Expect(r.isCloneJobFinished(ctx, d)).To(BeFalse())
+				job := &batchv1.Job{}
+				Expect(jobForDrupalSiteClone(job, "test-db-secret", d)).To(Succeed())
+				job.Name, job.Namespace = "clone-"+d.Name, d.Namespace
+				Expect(k8sClient.Create(ctx, job)).To(Succeed())
+				job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+				Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+				Eventually(func() bool {
+					return r.isCloneJobFinished(ctx, d)
+				}).Should(BeTrue())
+				Expect(r.ensureNoCloneSourceExport(ctx, d, ctrl.Log)).To(BeNil())
+				for _, key := range []types.NamespacedName{{Name: cloneExportName(d), Namespace: namespace}, {Name: cloneSourceSecretName(d), Namespace: d.Namespace}} {
+					Eventually(func() bool {
+						return k8sapierrors.IsNotFound(k8sClient.Get(ctx, key, &corev1.Secret{}))
+					}).Should(BeTrue(), key.String())
+				}
+				Eventually(func() bool {
+					return k8sapierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: cloneExportName(d), Namespace: namespace}, &batchv1.Job{}))
+				}).Should(BeTrue())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "pv-claim-live", Namespace: namespace}, &corev1.PersistentVolumeClaim{})).To(Succeed())
+			})
+			It("Should only accept the cloneFrom site if its project allows clones to the namespace", func() {
+				namespace := "clone-source-project"
+				Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+				dpc := &drupalwebservicesv1alpha1.DrupalProjectConfig{ObjectMeta: metav1.ObjectMeta{Name: namespace, Namespace: namespace}}
+				Expect(k8sClient.Create(ctx, dpc)).To(Succeed())
+				Expect(k8sClient.Create(ctx, newTestDrupalSite("live", namespace))).To(Succeed())
+				d := newTestDrupalSite("test-clone-permitted", "default")
+
+				d.Spec.Configuration.CloneFrom = drupalwebservicesv1alpha1.CloneFrom(namespace + "/missing")
+				_, reconcileErr := newTestReconciler().validateCloneSource(ctx, d)
+				Expect(reconcileErr).NotTo(BeNil())
+				Expect(reconcileErr.Error()).To(ContainSubstring("CloneFrom DrupalSite doesn't exist"))
+
+				d.Spec.Configuration.CloneFrom = drupalwebservicesv1alpha1.CloneFrom(namespace + "/live")
+				Eventually(func() string {
+					_, reconcileErr := newTestReconciler().validateCloneSource(ctx, d)
+					if reconcileErr == nil {
+						return ""
+					}
+					return reconcileErr.Error()
+				}).Should(ContainSubstring("doesn't allow clones to namespace default"))
+
+				By("Allowing the clones to the namespace")
+				dpc.Spec.AllowCloneTo = []string{"default"}
+				Expect(k8sClient.Update(ctx, dpc)).To(Succeed())
+				Eventually(func() reconcileError {
+					_, reconcileErr := newTestReconciler().validateCloneSource(ctx, d)
+					return reconcileErr
+				}).Should(BeNil())
+			})
+		})
+	})
+
+	Describe("Checking the ResourceQuota of the namespace", func() {
+		It("Should keep the replicas at 0 and report the shortfall while the quota is too small", func() {
+			namespace := "quota-exceeded"
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+			quota := &corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: namespace},
+				Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("10m")}},
+			}
+			Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+			quota.Status = corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("10m")},
+				Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("0")},
+			}
+			Expect(k8sClient.Status().Update(ctx, quota)).To(Succeed())
+
+			d := newTestDrupalSite("test-quota", namespace)
+			Eventually(func() bool {
+				config, _, updateStatus, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				return reconcileErr == nil && updateStatus && config.replicas == 0
+			}).Should(BeTrue())
+			condition := d.Status.Conditions.GetCondition("QuotaExceeded")
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.IsTrue()).To(BeTrue())
+			Expect(condition.Reason).To(Equal(status.ConditionReason(ErrQuotaExceeded.Error())))
+			Expect(condition.Message).To(ContainSubstring("ResourceQuota compute lacks requests.cpu"))
+
+			By("Clearing the condition once the quota allows the pods")
+			quota.Status.Hard = corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("100")}
+			Expect(k8sClient.Status().Update(ctx, quota)).To(Succeed())
+			Eventually(func() bool {
+				config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				return reconcileErr == nil && config.replicas > 0
+			}).Should(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("QuotaExceeded")).To(BeNil())
+		})
+		It("Should warn, but keep the replicas, while the quota only lacks room for the surge of a rolling update", func() {
+			namespace := "quota-surge"
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+			quota := &corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "pods", Namespace: namespace},
+				Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")}},
+			}
+			Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+			quota.Status = corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")},
+				Used: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("0")},
+			}
+			Expect(k8sClient.Status().Update(ctx, quota)).To(Succeed())
+
+			d := newTestDrupalSite("test-quota-surge", namespace)
+			Eventually(func() bool {
+				config, _, updateStatus, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+				return reconcileErr == nil && updateStatus && config.replicas == 1
+			}).Should(BeTrue())
+			Expect(d.ConditionTrue("QuotaExceeded")).To(BeTrue())
+			Expect(hasFailureCondition(d)).To(BeFalse())
+		})
+		It("Should only count the quotas whose scopes match the server pods", func() {
+			scoped := func(scopes []corev1.ResourceQuotaScope, selector ...corev1.ScopedResourceSelectorRequirement) corev1.ResourceQuota {
+				quota := corev1.ResourceQuota{Spec: corev1.ResourceQuotaSpec{Scopes: scopes}}
+				if len(selector) > 0 {
+					quota.Spec.ScopeSelector = &corev1.ScopeSelector{MatchExpressions: selector}
+				}
+				return quota
+			}
+			Expect(quotaAppliesToServerPods(scoped(nil), false, "")).To(BeTrue())
+			Expect(quotaAppliesToServerPods(scoped([]corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeNotTerminating, corev1.ResourceQuotaScopeNotBestEffort}), false, "")).To(BeTrue())
+			Expect(quotaAppliesToServerPods(scoped([]corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeTerminating}), false, "")).To(BeFalse())
+			Expect(quotaAppliesToServerPods(scoped([]corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}), false, "")).To(BeFalse())
+			Expect(quotaAppliesToServerPods(scoped([]corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}), true, "")).To(BeTrue())
+
+			By("Matching the priority class of the pods")
+			priorityIn := corev1.ScopedResourceSelectorRequirement{
+				ScopeName: corev1.ResourceQuotaScopePriorityClass, Operator: corev1.ScopeSelectorOpIn, Values: []string{"openshift-user-critical"},
+			}
+			Expect(quotaAppliesToServerPods(scoped(nil, priorityIn), false, "openshift-user-critical")).To(BeTrue())
+			Expect(quotaAppliesToServerPods(scoped(nil, priorityIn), false, "")).To(BeFalse())
+			priorityIn.Operator = corev1.ScopeSelectorOpNotIn
+			Expect(quotaAppliesToServerPods(scoped(nil, priorityIn), false, "")).To(BeTrue())
+			Expect(quotaAppliesToServerPods(scoped(nil, corev1.ScopedResourceSelectorRequirement{
+				ScopeName: corev1.ResourceQuotaScopeTerminating, Operator: corev1.ScopeSelectorOpDoesNotExist,
+			}), false, "")).To(BeTrue())
+		})
+		It("Should count the surge of a rolling update", func() {
+			d := newTestDrupalSite("test-quota-max-surge", "default")
+			Expect(maxSurgePods(d, 4)).To(Equal(int32(1)))
+			Expect(maxSurgePods(d, 1)).To(Equal(int32(1)))
+			d.Spec.Configuration.DeploymentStrategy = &appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+			Expect(maxSurgePods(d, 4)).To(Equal(int32(0)))
+		})
+	})
+
+	Describe("Ramping the traffic up to a new release", func() {
+		It("Should weight the backends by the ratio of ready pods", func() {
+			for _, ratio := range []struct {
+				readyOld, readyNew   int
+				oldWeight, newWeight int32
+			}{{0, 0, 100, 0}, {3, 0, 100, 0}, {2, 1, 67, 33}, {1, 1, 50, 50}, {1, 3, 25, 75}, {0, 2, 0, 100}} {
+				oldWeight, newWeight := rampWeights(ratio.readyOld, ratio.readyNew)
+				Expect([]int32{oldWeight, newWeight}).To(Equal([]int32{ratio.oldWeight, ratio.newWeight}))
+			}
+		})
+		It("Should shift the routes to the new release as its pods become ready", func() {
+			d := newTestDrupalSite("test-traffic-ramp", "default")
+			d.UID = "7e6f5a4b-3c2d-4e1f-9a8b-7c6d5e4f3a2b"
+			d.Spec.Configuration.TrafficRamp = true
+			d.Status.ReleaseID.Failsafe = "v8.9-1-old"
+			Expect(trafficRampActive(d)).To(BeTrue())
+			route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: "test-traffic-ramp-route", Namespace: d.Namespace}}
+			Expect(routeForDrupalSite(route, d, "test-traffic-ramp.webtest.cern.ch", nil)).To(Succeed())
+			Expect(k8sClient.Create(ctx, route)).To(Succeed())
+			newPod := func(name string, release string) *corev1.Pod {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: d.Namespace,
+						Labels: map[string]string{"app": "drupal", "drupalSite": d.Name, releaseLabel: releaseLabelValue(release)}},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx"}}},
+				}
+				Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+				return pod
+			}
+			setReady := func(pod *corev1.Pod) {
+				pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+				Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			}
+			weights := func() []int32 {
+				Expect(newTestReconciler().ensureTrafficRamp(ctx, d, ctrl.Log)).To(BeNil())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: route.Name, Namespace: route.Namespace}, route)).To(Succeed())
+				Expect(route.Spec.To.Name).To(Equal(releaseServiceName(d, "v8.9-1-old")))
+				Expect(route.Spec.AlternateBackends).To(HaveLen(1))
+				Expect(route.Spec.AlternateBackends[0].Name).To(Equal(releaseServiceName(d, releaseID(d))))
+				return []int32{*route.Spec.To.Weight, *route.Spec.AlternateBackends[0].Weight}
+			}
+			oldPods := []*corev1.Pod{newPod("test-traffic-ramp-old-1", "v8.9-1-old"), newPod("test-traffic-ramp-old-2", "v8.9-1-old")}
+			setReady(oldPods[0])
+			setReady(oldPods[1])
+			newPods := []*corev1.Pod{newPod("test-traffic-ramp-new-1", releaseID(d)), newPod("test-traffic-ramp-new-2", releaseID(d))}
+			Expect(weights()).To(Equal([]int32{100, 0}))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: releaseServiceName(d, releaseID(d)), Namespace: d.Namespace}, &corev1.Service{})).To(Succeed())
+
+			By("Getting a pod of the new release ready")
+			setReady(newPods[0])
+			Expect(weights()).To(Equal([]int32{67, 33}))
+
+			By("Replacing the old pods")
+			setReady(newPods[1])
+			for _, pod := range oldPods {
+				Expect(k8sClient.Delete(ctx, pod, client.GracePeriodSeconds(0))).To(Succeed())
+			}
+			Eventually(weights).Should(Equal([]int32{0, 100}))
+
+			By("Finishing the update")
+			d.Status.ReleaseID.Failsafe = releaseID(d)
+			Expect(trafficRampActive(d)).To(BeFalse())
+			Expect(routeForDrupalSite(route, d, "test-traffic-ramp.webtest.cern.ch", nil)).To(Succeed())
+			Expect(route.Spec.To.Name).To(Equal(d.Name))
+			Expect(route.Spec.AlternateBackends).To(BeEmpty())
+			Expect(newTestReconciler().ensureNoReleaseServices(ctx, d, ctrl.Log)).To(BeNil())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: releaseServiceName(d, releaseID(d)), Namespace: d.Namespace}, &corev1.Service{})
+				return k8sapierrors.IsNotFound(err)
+			}).Should(BeTrue())
+		})
+	})
+
+	Describe("Restricting the operator to a namespace", func() {
+		It("Should restrict the cache of the manager, skip the Tekton ClusterRoleBinding and not watch the velero Backups", func() {
+			defer func(namespace string) { WatchNamespace = namespace }(WatchNamespace)
+			options := ctrl.Options{}
+			RestrictToWatchNamespace(&options)
+			Expect(options.Namespace).To(BeEmpty())
+			Expect(options.ClientDisableCacheFor).To(BeEmpty())
+			Expect(watchesVeleroBackups()).To(BeTrue())
+
+			WatchNamespace = "tekton-namespaced"
+			RestrictToWatchNamespace(&options)
+			Expect(options.Namespace).To(Equal("tekton-namespaced"))
+			Expect(options.ClientDisableCacheFor).To(ContainElement(&velerov1.Schedule{}))
+			Expect(watchesVeleroBackups()).To(BeFalse())
+
+			By("Skipping the Tekton extra permissions of an easystart site")
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tekton-namespaced"}})).To(Succeed())
+			d := newTestDrupalSite("test", "tekton-namespaced")
+			d.Spec.Configuration.Easystart = "enable"
+			Expect(newTestReconciler().ensureTektonExtraPermissions(ctx, d, ctrl.Log)).To(BeNil())
+			Consistently(func() error {
+				return k8sClient.Get(ctx, types.NamespacedName{Name: "tektoncd-extra-permissions-tekton-namespaced"}, &rbacv1.ClusterRoleBinding{})
+			}).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Creating the directories of the shared volume", func() {
+		It("Should create the default directories, or the ones of the spec, without a shell", func() {
+			d := newTestDrupalSite("test-shared-directories", "default")
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(job.Spec.Template.Spec.InitContainers[0].Command).To(Equal([]string{
+				"mkdir", "-p", "/drupal-data/files", "/drupal-data/private", "/drupal-data/modules", "/drupal-data/themes",
+			}))
+
+			By("Setting the directories in the spec")
+			d.Spec.Configuration.SharedVolumeDirectories = []string{"files", "translations", "config/sync", "x; rm -rf /"}
+			job = &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(job.Spec.Template.Spec.InitContainers[0].Command).To(Equal([]string{
+				"mkdir", "-p", "/drupal-data/files", "/drupal-data/translations", "/drupal-data/config/sync", "/drupal-data/x; rm -rf",
+			}))
+
+			By("Rejecting a directory outside of the shared volume")
+			d.Spec.Configuration.SharedVolumeDirectories = []string{"../etc"}
+			err := validateSpec(d.Spec, true)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+		})
+	})
+
+	Describe("Correcting a malformed WebDAV secret", func() {
+		It("Should regenerate the SabreDAV htdigest and restart the deployment", func() {
+			d := newTestDrupalSite("test-webdav-malformed", "default")
+			d.UID = "3c1d2e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+			d.Spec.Configuration.WebDAVPassword = "test-password"
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
+			_, err := ctrl.CreateOrUpdate(ctx, k8sClient, deploy, func() error {
+				return deploymentForDrupalSite(deploy, databaseSecretName(d), d, releaseID(d), config)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "webdav-secret-" + d.Name, Namespace: d.Namespace},
+				StringData: map[string]string{"htdigest": "admin:$apr1$Ae3Kx2pQ$0Ay0FYQ8eH5Ugm1WmmSYV/"},
+			})).To(Succeed())
+
+			Expect(r.ensureResourceX(ctx, d, "webdav_secret", ctrl.Log)).To(BeNil())
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "webdav-secret-" + d.Name, Namespace: d.Namespace}, secret)).To(Succeed())
+			Expect(string(secret.Data["htdigest"])).To(Equal(encryptBasicAuthPassword("test-password")))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations).To(HaveKey(restartedAtAnnotation))
+
+			By("Leaving a well-formed secret and the deployment alone")
+			delete(deploy.Spec.Template.ObjectMeta.Annotations, restartedAtAnnotation)
+			Expect(k8sClient.Update(ctx, deploy)).To(Succeed())
+			Expect(r.ensureResourceX(ctx, d, "webdav_secret", ctrl.Log)).To(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations).NotTo(HaveKey(restartedAtAnnotation))
+		})
+	})
+
+	Describe("Including extra nginx configuration", func() {
+		It("Should include it in the nginx server block and roll the deployment out when it changes", func() {
+			d := newTestDrupalSite("test-extra-nginx", "default")
+			d.UID = "9d2e4f7a-1b3c-4e5d-8f6a-7b8c9d0e1f2a"
+			d.Spec.Configuration.ExtraNginxConfig = "client_max_body_size 64m;"
+			Expect(validateSpec(d.Spec, true)).To(BeNil())
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
+			_, err := ctrl.CreateOrUpdate(ctx, k8sClient, deploy, func() error {
+				return deploymentForDrupalSite(deploy, databaseSecretName(d), d, releaseID(d), config)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deploy.Spec.Template.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+				Name: "nginx-global-config", MountPath: "/etc/nginx/custom.d/extra-nginx-config.conf", SubPath: "server.conf", ReadOnly: true,
+			}))
+			for _, name := range []string{"php-fpm-", "site-settings-", "php-cli-config-"} {
+				Expect(k8sClient.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name + d.Name, Namespace: d.Namespace}})).To(Succeed())
+			}
+			nginxConfig := func() map[string]string {
+				Expect(r.ensureResourceX(ctx, d, "cm_nginx_global", ctrl.Log)).To(BeNil())
+				cm := &corev1.ConfigMap{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "nginx-global-" + d.Name, Namespace: d.Namespace}, cm)).To(Succeed())
+				return cm.Data
+			}
+			hash := func() string {
+				requeue, err := r.ensureDeploymentConfigmapHash(ctx, d, ctrl.Log)
+				Expect(err).To(BeNil())
+				Expect(requeue).To(BeFalse())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+				return deploy.Spec.Template.ObjectMeta.Annotations["nginx-configmap/hash"]
+			}
+			content := nginxConfig()
+			Expect(content["server.conf"]).To(Equal("client_max_body_size 64m;"))
+			Expect(content["global.conf"]).To(ContainSubstring("worker_processes"))
+			Expect(content["global.conf"]).NotTo(ContainSubstring("client_max_body_size"))
+			before := hash()
+
+			By("Changing the extra configuration")
+			d.Spec.Configuration.ExtraNginxConfig = "client_max_body_size 128m;"
+			Expect(nginxConfig()["server.conf"]).To(Equal("client_max_body_size 128m;"))
+			Expect(hash()).NotTo(Equal(before))
+
+			By("Removing the extra configuration")
+			d.Spec.Configuration.ExtraNginxConfig = ""
+			Expect(nginxConfig()).To(HaveKeyWithValue("server.conf", ""))
+		})
+		It("Should reject a blank, binary or broken snippet", func() {
+			spec := newTestDrupalSite("test", "default").Spec
+			for _, snippet := range []string{" \n", "\x00\x01", "location / {"} {
+				spec.Configuration.ExtraNginxConfig = snippet
+				err := validateSpec(spec, true)
+				Expect(err).NotTo(BeNil())
+				Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+			}
+		})
+	})
+
+	Describe("Waiting for the pod of a new release", func() {
+		It("Should requeue within the grace period and fail the rollout after it", func() {
+			d := newTestDrupalSite("test-pod-start-grace", "default")
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod-start-grace-pod",
+					Namespace:   d.Namespace,
+					Labels:      map[string]string{"app": "drupal", "drupalSite": d.Name},
+					Annotations: map[string]string{"releaseID": releaseID(d)},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			defer k8sClient.Delete(ctx, pod)
+			pod.Status.Phase = corev1.PodPending
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			defer func(period time.Duration) { PodStartGracePeriod = period }(PodStartGracePeriod)
+
+			PodStartGracePeriod = time.Hour
+			requeue, reconcileErr := newTestReconciler().didVersionRollOutSucceed(ctx, d, releaseID(d))
+			Expect(requeue).To(BeTrue())
+			Expect(reconcileErr).NotTo(BeNil())
+			Expect(reconcileErr.Unwrap()).To(Equal(ErrPodNotRunning))
+
+			By("Exceeding the grace period")
+			PodStartGracePeriod = 0
+			requeue, reconcileErr = newTestReconciler().didVersionRollOutSucceed(ctx, d, releaseID(d))
+			Expect(requeue).To(BeFalse())
+			Expect(reconcileErr).NotTo(BeNil())
+			Expect(reconcileErr.Unwrap()).To(Equal(ErrDeploymentUpdateFailed))
+		})
+	})
+
+	Describe("Reporting the releases of a site", func() {
+		It("Should mirror the releaseID and the update annotation", func() {
+			d := newTestDrupalSite("test-release-status", "default")
+			d.Status.ReleaseID.Current = releaseID(d)
+			d.Status.ReleaseID.Failsafe = releaseID(d)
+			summarizeStatus(d)
+			Expect(d.Status.CurrentRelease).To(Equal("v8.9-1-stable"))
+			Expect(d.Status.FailsafeRelease).To(Equal("v8.9-1-stable"))
+			Expect(d.Status.UpdateInProgress).To(BeFalse())
+
+			By("Updating the site")
+			d.Spec.Version.ReleaseSpec = "newer"
+			d.Status.ReleaseID.Current = releaseID(d)
+			setUpdateInProgress(d)
+			summarizeStatus(d)
+			Expect(d.Status.CurrentRelease).To(Equal("v8.9-1-newer"))
+			Expect(d.Status.FailsafeRelease).To(Equal("v8.9-1-stable"))
+			Expect(d.Status.UpdateInProgress).To(BeTrue())
+
+			By("Completing the update")
+			d.Status.ReleaseID.Failsafe = releaseID(d)
+			delete(d.Annotations, "updateInProgress")
+			summarizeStatus(d)
+			Expect(d.Status.FailsafeRelease).To(Equal("v8.9-1-newer"))
+			Expect(d.Status.UpdateInProgress).To(BeFalse())
+		})
+	})
+
+	Describe("Spreading the replicas of a site", func() {
+		It("Should prefer different nodes for the replicas of a site with more than one", func() {
+			d := newTestDrupalSite("test-anti-affinity", "default")
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			config.replicas = 1
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Affinity).To(BeNil())
+
+			By("Running 3 replicas")
+			config.replicas = 3
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Affinity).NotTo(BeNil())
+			preferred := deploy.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			Expect(preferred).To(HaveLen(1))
+			Expect(preferred[0].PodAffinityTerm.TopologyKey).To(Equal("kubernetes.io/hostname"))
+			Expect(preferred[0].PodAffinityTerm.LabelSelector.MatchLabels).To(Equal(map[string]string{"app": "drupal", "drupalSite": d.Name}))
+
+			By("Requiring different nodes")
+			d.Spec.Configuration.ReplicaAntiAffinity = drupalwebservicesv1alpha1.ReplicaAntiAffinityRequired
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(BeEmpty())
+			Expect(deploy.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+		})
+	})
+
+	Describe("Unpublishing a site", func() {
+		It("Should remove all the routes and OIDC return URIs of an installed site", func() {
+			d := newTestDrupalSite("test-unpublished", "default")
+			d.UID = "test-unpublished-uid"
+			d.Spec.SiteURL = []drupalwebservicesv1alpha1.Url{"test-unpublished.webtest.cern.ch"}
+			setInitialized(d)
+			setConditionStatus(d, "Serving", true, nil, false)
+			r := newTestReconciler()
+			Expect(r.ensureResourceX(ctx, d, "svc_nginx", ctrl.Log)).To(BeNil())
+			Expect(r.ensureResourceX(ctx, d, "route", ctrl.Log)).To(BeNil())
+			Expect(r.ensureResourceX(ctx, d, "oidc_return_uri", ctrl.Log)).To(BeNil())
+			routes := func() []routev1.Route {
+				routeList := &routev1.RouteList{}
+				Expect(k8sClient.List(ctx, routeList, client.InNamespace(d.Namespace), client.MatchingLabels{"drupalSite": d.Name})).To(Succeed())
+				return routeList.Items
+			}
+			returnURIs := func() []authz.OidcReturnURI {
+				returnURIList := &authz.OidcReturnURIList{}
+				Expect(k8sClient.List(ctx, returnURIList, client.InNamespace(d.Namespace), client.MatchingLabels{"drupalSite": d.Name})).To(Succeed())
+				return returnURIList.Items
+			}
+			Expect(routes()).NotTo(BeEmpty())
+			Expect(returnURIs()).NotTo(BeEmpty())
+
+			By("Setting publish to false")
+			d.Spec.Publish = pointer.BoolPtr(false)
+			Expect(r.ensureIngress(ctx, d, &reconcileSummary{}, ctrl.Log)).To(BeEmpty())
+			Eventually(routes).Should(BeEmpty())
+			Eventually(returnURIs).Should(BeEmpty())
+			Expect(r.ensureResourceX(ctx, d, "route", ctrl.Log)).To(BeNil())
+			Expect(r.ensureResourceX(ctx, d, "oidc_return_uri", ctrl.Log)).To(BeNil())
+			Consistently(routes).Should(BeEmpty())
+			Expect(returnURIs()).To(BeEmpty())
+		})
+	})
+
+	Describe("Rotating the logs of the site", func() {
+		It("Should run a log-rotation sidecar on the logs volume while the config override sets a rotation", func() {
+			d := newTestDrupalSite("test-log-rotation", "default")
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "log-rotation").Name).To(BeEmpty())
+			Expect(containerByName(deploy, "drupal-logs").Env).To(BeEmpty())
+
+			By("Overriding the rotation")
+			maxSize := resource.MustParse("50Mi")
+			override := &drupalwebservicesv1alpha1.DrupalSiteConfigOverride{
+				ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace},
+				Spec: drupalwebservicesv1alpha1.DrupalSiteConfigOverrideSpec{
+					DrupalLogs: drupalwebservicesv1alpha1.DrupalLogs{Rotation: &drupalwebservicesv1alpha1.LogRotation{
+						MaxSize:   &maxSize,
+						Retention: &metav1.Duration{Duration: 72 * time.Hour},
+					}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, override)).To(Succeed())
+			rotatedConfig, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), rotatedConfig)).To(Succeed())
+			sidecar := containerByName(deploy, "log-rotation")
+			Expect(sidecar.Name).To(Equal("log-rotation"))
+			Expect(sidecar.Image).To(Equal(sitebuilderImageRefToUse(d, releaseID(d)).Name))
+			Expect(sidecar.VolumeMounts).To(ConsistOf(corev1.VolumeMount{Name: "empty-dir", MountPath: "/var/run/"}))
+			Expect(sidecar.Resources).To(Equal(rotatedConfig.logRotationResources))
+			Expect(sidecar.Command).To(HaveLen(3))
+			Expect(sidecar.Command[2]).To(ContainSubstring("-gt 52428800"))
+			Expect(sidecar.Command[2]).To(ContainSubstring("-mmin +4320"))
+			// The sidecar rotates the logs in the volume that the server containers write them to
+			volumeNames := []string{}
+			for _, volume := range deploy.Spec.Template.Spec.Volumes {
+				volumeNames = append(volumeNames, volume.Name)
+			}
+			Expect(volumeNames).To(ContainElement("empty-dir"))
+			Expect(containerByName(deploy, "drupal-logs").VolumeMounts).To(ContainElement(sidecar.VolumeMounts[0]))
+
+			By("Defaulting the unset fields of the rotation")
+			Expect(logRotationCommand(&drupalwebservicesv1alpha1.LogRotation{})[2]).To(And(
+				ContainSubstring("-gt 104857600"), ContainSubstring("-mmin +1440")))
+
+			By("Ensuring the resources again")
+			now := time.Now()
+			before, err := ensuredResourcesHash(d, config, nil, now)
+			Expect(err).NotTo(HaveOccurred())
+			after, err := ensuredResourcesHash(d, rotatedConfig, nil, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after).NotTo(Equal(before))
+
+			By("Removing the rotation from the config override")
+			deploy.CreationTimestamp = metav1.Now()
+			Expect(k8sClient.Delete(ctx, override)).To(Succeed())
+			Eventually(func() bool {
+				unrotatedConfig, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+				return reconcileErr == nil && unrotatedConfig.drupalLogsRotation == nil
+			}, timeout, interval).Should(BeTrue())
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "log-rotation").Name).To(BeEmpty())
+			Expect(containerByName(deploy, "drupal-logs").Name).To(Equal("drupal-logs"))
+		})
+	})
+
+	Describe("Running the post-install commands", func() {
+		It("Should run the commands until they succeed once", func() {
+			d := newTestDrupalSite("test-post-install", "default")
+			d.Spec.Configuration.PostInstallCommands = []string{"drush en -y views", "drush cim -y"}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			countingClient := &podListCountingClient{Client: k8sClient}
+			r := newTestReconciler()
+			r.Client = countingClient
+
+			By("Reporting the failure of a command")
+			now := time.Now()
+			Expect(r.runPostInstallCommands(ctx, d, ctrl.Log, now)).To(BeTrue())
+			Expect(countingClient.podLists).To(Equal(1))
+			condition := d.Status.Conditions.GetCondition("PostInstallComplete")
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.IsFalse()).To(BeTrue())
+			Expect(condition.Message).To(ContainSubstring("drush en -y views"))
+			Expect(d.Status.PostInstall.Completed).To(Equal(0))
+			Expect(d.Status.PostInstall.Failures).To(Equal(1))
+
+			By("Not running them again once they completed")
+			setConditionStatus(d, "PostInstallComplete", true, nil, false)
+			Expect(r.runPostInstallCommands(ctx, d, ctrl.Log, now)).To(BeFalse())
+			Expect(countingClient.podLists).To(Equal(1))
+		})
+		It("Should resume from the command that failed", func() {
+			defer func() { execToPod = execToPodThroughAPI }()
+			d := newTestDrupalSite("test-post-install-resume", "default")
+			d.Spec.Configuration.PostInstallCommands = []string{"drush en -y views", "drush cim -y"}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-post-install-resume-pod",
+					Namespace:   d.Namespace,
+					Labels:      map[string]string{"drupalSite": d.Name, "app": "drupal"},
+					Annotations: map[string]string{"releaseID": releaseID(d)},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.Phase = corev1.PodRunning
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			ran := []string{}
+			failing := "drush cim -y"
+			execToPod = func(containerName, podName, namespace string, stdin io.Reader, command ...string) (string, string, error) {
+				ran = append(ran, strings.Join(command, " "))
+				if strings.Join(command, " ") == failing {
+					return "", "configuration is invalid", fmt.Errorf("command terminated with exit code 1")
+				}
+				return "", "", nil
+			}
+			r := newTestReconciler()
+
+			By("Recording the command that succeeded")
+			now := time.Now()
+			Eventually(func() int {
+				ran = nil
+				d.Status.PostInstall = nil
+				r.runPostInstallCommands(ctx, d, ctrl.Log, now)
+				return d.Status.PostInstall.Completed
+			}).Should(Equal(1))
+			Expect(ran).To(Equal([]string{"drush en -y views", "drush cim -y"}))
+			Expect(d.Status.PostInstall.Failures).To(Equal(1))
+
+			By("Backing off before retrying the failed command")
+			Expect(postInstallRetryIn(d, now)).To(Equal(postInstallRetryDelay))
+			Expect(postInstallRetryIn(d, now.Add(postInstallRetryDelay))).To(BeNumerically("<=", 0))
+			ran = nil
+			Expect(r.runPostInstallCommands(ctx, d, ctrl.Log, now.Add(postInstallRetryDelay))).To(BeTrue())
+			Expect(ran).To(Equal([]string{"drush cim -y"}))
+			Expect(d.Status.PostInstall.Failures).To(Equal(2))
+			Expect(postInstallRetryIn(d, now.Add(postInstallRetryDelay))).To(Equal(2 * postInstallRetryDelay))
+			d.Status.PostInstall.Failures = 100
+			Expect(postInstallRetryIn(d, now.Add(postInstallRetryDelay))).To(Equal(postInstallMaxRetryDelay))
+
+			By("Completing once the failed command succeeds")
+			failing = ""
+			ran = nil
+			Expect(r.runPostInstallCommands(ctx, d, ctrl.Log, now.Add(postInstallMaxRetryDelay))).To(BeTrue())
+			Expect(ran).To(Equal([]string{"drush cim -y"}))
+			Expect(d.Status.PostInstall.Completed).To(Equal(2))
+			Expect(d.Status.PostInstall.Failures).To(Equal(0))
+			Expect(d.ConditionTrue("PostInstallComplete")).To(BeTrue())
+		})
+		It("Should pass the quoted arguments of a command as one argument", func() {
+			defer func() { execToPod = execToPodThroughAPI }()
+			d := newTestDrupalSite("test-post-install-quoted", "default")
+			d.Spec.Configuration.PostInstallCommands = []string{`drush cset system.site name 'My Site'`, `drush sset maintenance_message "It's \"closed\""`}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-post-install-quoted-pod",
+					Namespace:   d.Namespace,
+					Labels:      map[string]string{"drupalSite": d.Name, "app": "drupal"},
+					Annotations: map[string]string{"releaseID": releaseID(d)},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.Phase = corev1.PodRunning
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			ran := [][]string{}
+			execToPod = func(containerName, podName, namespace string, stdin io.Reader, command ...string) (string, string, error) {
+				ran = append(ran, command)
+				return "", "", nil
+			}
+			r := newTestReconciler()
+			Eventually(func() bool {
+				ran = nil
+				d.Status.PostInstall = nil
+				r.runPostInstallCommands(ctx, d, ctrl.Log, time.Now())
+				return d.ConditionTrue("PostInstallComplete")
+			}).Should(BeTrue())
+			Expect(ran).To(Equal([][]string{
+				{"drush", "cset", "system.site", "name", "My Site"},
+				{"drush", "sset", "maintenance_message", `It's "closed"`},
+			}))
+		})
+		It("Should reject commands that are not allowed", func() {
+			spec := newTestDrupalSite("test-post-install", "default").Spec
+			for _, command := range []string{"rm -rf /drupal-data", "drush sql-drop -y", "drush", "drush php:eval 'echo 1;'", "drush cset system.site name 'My Site", `drush cr \`} {
+				spec.Configuration.PostInstallCommands = []string{command}
+				Expect(validateSpec(spec, false)).NotTo(BeNil(), command)
+			}
+		})
+	})
+
+	Describe("Failing to install a site", func() {
+		It("Should report the failure of the install job and not recreate it until the spec changes", func() {
+			r := newTestReconciler()
+			d := newTestDrupalSite("test-install-failed", "default")
+			d.UID = "test-install-failed-uid"
+			d.Generation = 1
+			Expect(r.ensureResourceX(ctx, d, "site_install_job", ctrl.Log)).To(BeNil())
+			jobKey := types.NamespacedName{Name: "ensure-site-install-" + d.Name, Namespace: d.Namespace}
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+			update, reconcileErr := r.checkInstallFailure(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(update).To(BeFalse())
+
+			By("Exhausting the retries of the job")
+			job.Status.Failed = *job.Spec.BackoffLimit + 1
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-x1", Namespace: d.Namespace, Labels: map[string]string{"job-name": job.Name}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "drush", Image: "drush"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name: "drush",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					ExitCode: 1, Message: "Database connection refused", FinishedAt: metav1.Now(),
+				}},
+			}}
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			update, reconcileErr = r.checkInstallFailure(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(update).To(BeTrue())
+			Expect(d.ConditionTrue("InstallFailed")).To(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("InstallFailed").Message).To(ContainSubstring("Database connection refused"))
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateError))
+
+			By("Not recreating the job")
+			Expect(k8sClient.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))).To(Succeed())
+			Eventually(func() bool {
+				return k8sapierrors.IsNotFound(k8sClient.Get(ctx, jobKey, &batchv1.Job{}))
+			}).Should(BeTrue())
+			Expect(r.ensureResourceX(ctx, d, "site_install_job", ctrl.Log)).To(BeNil())
+			Expect(k8sapierrors.IsNotFound(k8sClient.Get(ctx, jobKey, &batchv1.Job{}))).To(BeTrue())
+
+			By("Trying again once the spec changes")
+			d.Generation = 2
+			update, reconcileErr = r.checkInstallFailure(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			Expect(update).To(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("InstallFailed")).To(BeNil())
+			Expect(r.ensureResourceX(ctx, d, "site_install_job", ctrl.Log)).To(BeNil())
+			Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+			Expect(job.Annotations).To(HaveKeyWithValue(siteGenerationAnnotation, "2"))
+		})
+		It("Should not report the failure of the install job of a previous spec", func() {
+			r := newTestReconciler()
+			d := newTestDrupalSite("test-install-failed-stale", "default")
+			d.UID = "test-install-failed-stale-uid"
+			d.Generation = 1
+			Expect(r.ensureResourceX(ctx, d, "site_install_job", ctrl.Log)).To(BeNil())
+			jobKey := types.NamespacedName{Name: "ensure-site-install-" + d.Name, Namespace: d.Namespace}
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+			job.Status.Failed = *job.Spec.BackoffLimit + 1
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+
+			By("Ignoring the failed job while it's being deleted")
+			job.Finalizers = []string{"drupal.cern.ch/test"}
+			Expect(k8sClient.Update(ctx, job)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, job)).To(Succeed())
+			Eventually(func() bool {
+				update, reconcileErr := r.checkInstallFailure(ctx, d)
+				return reconcileErr == nil && !update && k8sClient.Get(ctx, jobKey, job) == nil && !job.DeletionTimestamp.IsZero()
+			}).Should(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("InstallFailed")).To(BeNil())
+			job.Finalizers = nil
+			Expect(k8sClient.Update(ctx, job)).To(Succeed())
+			Eventually(func() bool {
+				return k8sapierrors.IsNotFound(k8sClient.Get(ctx, jobKey, &batchv1.Job{}))
+			}).Should(BeTrue())
+
+			By("Deleting the failed job of a previous generation")
+			Expect(r.ensureResourceX(ctx, d, "site_install_job", ctrl.Log)).To(BeNil())
+			Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+			job.Status.Failed = *job.Spec.BackoffLimit + 1
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+			d.Generation = 2
+			Eventually(func() bool {
+				update, reconcileErr := r.checkInstallFailure(ctx, d)
+				return reconcileErr == nil && !update && k8sapierrors.IsNotFound(k8sClient.Get(ctx, jobKey, &batchv1.Job{}))
+			}).Should(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("InstallFailed")).To(BeNil())
+		})
+	})
+
+	Describe("Pulling the images with a pull secret", func() {
+		It("Should set the pull secret of the spec on the pods, the jobs and the builds", func() {
+			d := newTestDrupalSite("test-image-pull-secret", "default")
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.ImagePullSecrets).To(BeEmpty())
+
+			By("Setting the pull secret")
+			d.Spec.Configuration.ImagePullSecret = "registry-credentials"
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			pullSecrets := []corev1.LocalObjectReference{{Name: "registry-credentials"}}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.ImagePullSecrets).To(Equal(pullSecrets))
+			install := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(install, "test-db-secret", d)).To(Succeed())
+			Expect(install.Spec.Template.Spec.ImagePullSecrets).To(Equal(pullSecrets))
+			bc := &buildv1.BuildConfig{}
+			Expect(buildConfigForDrupalSiteBuilderS2I(bc, d)).To(Succeed())
+			Expect(bc.Spec.Strategy.SourceStrategy.PullSecret).To(Equal(&pullSecrets[0]))
+
+			By("Waiting for the pull secret to exist")
+			missing := newTestReconciler().checkImagePullSecret(ctx, d)
+			Expect(missing).NotTo(BeNil())
+			Expect(missing.Unwrap()).To(Equal(ErrSecretMissing))
+			Expect(missing.Temporary()).To(BeTrue())
+			Expect(missing.Error()).To(ContainSubstring(`imagePullSecret: secret "registry-credentials" doesn't exist`))
+			Expect(k8sClient.Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry-credentials", Namespace: d.Namespace}})).To(Succeed())
+			Expect(newTestReconciler().checkImagePullSecret(ctx, d)).To(BeNil())
+		})
+	})
+
+	Describe("Creating the administrator account", func() {
+		envByName := func(job *batchv1.Job, name string) *corev1.EnvVar {
+			for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+				if env.Name == name {
+					return &env
+				}
+			}
+			return nil
+		}
+		BeforeEach(func() {
+			EnableAdminAccount = true
+		})
+		AfterEach(func() {
+			EnableAdminAccount = false
+		})
+		It("Should pass a generated password to the install job through a Secret", func() {
+			d := newTestDrupalSite("test-admin-account", "default")
+			d.UID = "test-admin-account-uid"
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(envByName(job, "DRUPAL_ADMIN_NAME").Value).To(Equal("admin"))
+			password := envByName(job, "DRUPAL_ADMIN_PASSWORD")
+			Expect(password.Value).To(BeEmpty())
+			Expect(password.ValueFrom.SecretKeyRef.Name).To(Equal("admin-account-" + d.Name))
+			Expect(password.ValueFrom.SecretKeyRef.Key).To(Equal("password"))
+
+			By("Generating the password once")
+			r := newTestReconciler()
+			Expect(r.ensureResourceX(ctx, d, "admin_account_secret", ctrl.Log)).To(BeNil())
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "admin-account-" + d.Name, Namespace: d.Namespace}, secret)).To(Succeed())
+			generated := string(secret.Data["password"])
+			Expect(len(generated)).To(BeNumerically(">=", 32))
+			Expect(r.ensureResourceX(ctx, d, "admin_account_secret", ctrl.Log)).To(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "admin-account-" + d.Name, Namespace: d.Namespace}, secret)).To(Succeed())
+			Expect(string(secret.Data["password"])).To(Equal(generated))
+		})
+		It("Should use the account of the spec", func() {
+			d := newTestDrupalSite("test-admin-account", "default")
+			d.Spec.Configuration.AdminAccount = &drupalwebservicesv1alpha1.AdminAccount{Name: "webmaster", PasswordSecret: "site-admin"}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(envByName(job, "DRUPAL_ADMIN_NAME").Value).To(Equal("webmaster"))
+			Expect(envByName(job, "DRUPAL_ADMIN_PASSWORD").ValueFrom.SecretKeyRef.Name).To(Equal("site-admin"))
+
+			By("Rejecting an invalid Secret name")
+			d.Spec.Configuration.AdminAccount.PasswordSecret = "Site Admin"
+			Expect(validateSpec(d.Spec, false)).NotTo(BeNil())
+		})
+		It("Should not pass the account unless admin accounts are enabled", func() {
+			EnableAdminAccount = false
+			d := newTestDrupalSite("test-admin-account", "default")
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			d.Spec.Configuration.AdminAccount = &drupalwebservicesv1alpha1.AdminAccount{Name: "webmaster"}
+			Expect(validateSpec(d.Spec, false)).NotTo(BeNil())
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(envByName(job, "DRUPAL_ADMIN_NAME")).To(BeNil())
+			Expect(envByName(job, "DRUPAL_ADMIN_PASSWORD")).To(BeNil())
+		})
+	})
+
+	Describe("Collecting the stale S2I BuildConfigs", func() {
+		It("Should keep only the BuildConfig of the current version, and those still building", func() {
+			r := newTestReconciler()
+			d := newTestDrupalSite("test-stale-bc", "default")
+			d.UID = "test-stale-bc-uid"
+			d.Spec.Configuration.ExtraConfigurationRepo = "https://gitlab.cern.ch/example/site-config"
+			Expect(r.ensureResourceX(ctx, d, "bc_s2i", ctrl.Log)).To(BeNil())
+			oldName := "sitebuilder-s2i-" + nameVersionHash(d)
+
+			By("Changing the version")
+			d.Spec.Version.ReleaseSpec = "newer"
+			Expect(r.ensureResourceX(ctx, d, "bc_s2i", ctrl.Log)).To(BeNil())
+			currentName := "sitebuilder-s2i-" + nameVersionHash(d)
+			Expect(currentName).NotTo(Equal(oldName))
+
+			By("Keeping a stale BuildConfig while its build runs")
+			building := &buildv1.BuildConfig{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: oldName, Namespace: d.Namespace}, building)).To(Succeed())
+			building.ObjectMeta = metav1.ObjectMeta{Name: "sitebuilder-s2i-building", Namespace: d.Namespace, Labels: building.Labels}
+			Expect(k8sClient.Create(ctx, building)).To(Succeed())
+			build := &buildv1.Build{ObjectMeta: metav1.ObjectMeta{Name: "sitebuilder-s2i-building-1", Namespace: d.Namespace,
+				Labels: map[string]string{"openshift.io/build-config.name": building.Name}}}
+			Expect(k8sClient.Create(ctx, build)).To(Succeed())
+			build.Status.Phase = buildv1.BuildPhaseRunning
+			Expect(k8sClient.Status().Update(ctx, build)).To(Succeed())
+
+			Expect(r.ensureNoStaleBuildConfigs(ctx, d, ctrl.Log)).To(BeNil())
+			names := func() []string {
+				bcList := &buildv1.BuildConfigList{}
+				Expect(k8sClient.List(ctx, bcList, client.InNamespace(d.Namespace), client.MatchingLabels{"drupalSite": d.Name})).To(Succeed())
+				names := []string{}
+				for _, bc := range bcList.Items {
+					names = append(names, bc.Name)
+				}
+				return names
+			}
+			Expect(names()).To(ConsistOf(currentName, building.Name))
+
+			By("Deleting it once the build completes")
+			build.Status.Phase = buildv1.BuildPhaseComplete
+			Expect(k8sClient.Status().Update(ctx, build)).To(Succeed())
+			Expect(r.ensureNoStaleBuildConfigs(ctx, d, ctrl.Log)).To(BeNil())
+			Expect(names()).To(ConsistOf(currentName))
+		})
+	})
+
+	Describe("Overriding the images of the sidecars", func() {
+		It("Should use the images of the spec, else the ones of the operator", func() {
+			d := newTestDrupalSite("test-sidecar-images", "default")
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "php-fpm-exporter").Image).To(Equal(mirroredImage(PhpFpmExporterImage)))
+			Expect(containerByName(deploy, "webdav").Image).To(Equal(mirroredImage(WebDAVImage)))
+
+			By("Overriding both images")
+			d.Spec.Configuration.PhpFpmExporterImageOverride = "registry.example.org/php-fpm-exporter:v2.0.0-rc1"
+			d.Spec.Configuration.WebDAVImageOverride = "registry.example.org/webdav@sha256:" + strings.Repeat("a", 64)
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "php-fpm-exporter").Image).To(Equal(d.Spec.Configuration.PhpFpmExporterImageOverride))
+			Expect(containerByName(deploy, "webdav").Image).To(Equal(d.Spec.Configuration.WebDAVImageOverride))
+
+			By("Rejecting an invalid image reference")
+			d.Spec.Configuration.WebDAVImageOverride = "not a valid image"
+			Expect(validateSpec(d.Spec, false)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Summarizing the state of a site", func() {
+		It("Should derive the state from the conditions", func() {
+			d := newTestDrupalSite("test-site-state", "default")
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateInstalling))
+			setConditionStatus(d, "Initialized", true, nil, false)
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateNotReady))
+			setConditionStatus(d, "Ready", true, nil, false)
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateReady))
+			setUpdateStep(d, drupalwebservicesv1alpha1.UpdateStepRunningUpdb)
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateUpdating))
+			setConditionStatus(d, "DBUpdatesFailed", true, nil, false)
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateError))
+			setConditionStatus(d, "Blocked", true, nil, false)
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateBlocked))
+
+			By("Expecting a failed code update during the install to be an error")
+			d = newTestDrupalSite("test-site-state", "default")
+			setConditionStatus(d, "CodeUpdateFailed", true, nil, false)
+			Expect(siteState(d)).To(Equal(drupalwebservicesv1alpha1.SiteStateError))
+		})
+	})
+
+	Describe("Probing the php-fpm container", func() {
+		It("Should check that php-fpm listens, with the timing of the spec", func() {
+			d := newTestDrupalSite("test-php-fpm-probes", "default")
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			readiness := containerByName(deploy, "php-fpm").ReadinessProbe
+			Expect(readiness).NotTo(BeNil())
+			Expect(readiness.TCPSocket.Port).To(Equal(intstr.FromInt(9000)))
+			Expect(readiness.FailureThreshold).To(BeEquivalentTo(6))
+			Expect(containerByName(deploy, "php-fpm").LivenessProbe).NotTo(BeNil())
+
+			By("Overriding the timing of the probes")
+			d.Spec.Configuration.PhpFpmReadinessProbe = &drupalwebservicesv1alpha1.ProbeTimings{PeriodSeconds: 30, FailureThreshold: 10}
+			d.Spec.Configuration.PhpFpmLivenessProbe = &drupalwebservicesv1alpha1.ProbeTimings{InitialDelaySeconds: 600}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			readiness = containerByName(deploy, "php-fpm").ReadinessProbe
+			Expect(readiness.PeriodSeconds).To(BeEquivalentTo(30))
+			Expect(readiness.FailureThreshold).To(BeEquivalentTo(10))
+			Expect(readiness.TimeoutSeconds).To(BeEquivalentTo(5))
+			Expect(containerByName(deploy, "php-fpm").LivenessProbe.InitialDelaySeconds).To(BeEquivalentTo(600))
+			Expect(containerByName(deploy, "php-fpm").LivenessProbe.PeriodSeconds).To(BeEquivalentTo(210))
+		})
+		It("Should only run the liveness probes after the containers started", func() {
+			defer func(timeout time.Duration) { StartupTimeout = timeout }(StartupTimeout)
+			StartupTimeout = 5 * time.Minute
+			d := newTestDrupalSite("test-startup-probes", "default")
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			for _, name := range []string{"nginx", "php-fpm"} {
+				startup := containerByName(deploy, name).StartupProbe
+				Expect(startup).NotTo(BeNil(), name)
+				Expect(startup.PeriodSeconds*startup.FailureThreshold).To(BeNumerically(">=", 300), name)
+				Expect(startup.PeriodSeconds*(startup.FailureThreshold-1)).To(BeNumerically("<", 300), name)
+			}
+			Expect(containerByName(deploy, "nginx").StartupProbe.TCPSocket.Port).To(Equal(intstr.FromInt(8080)))
+			Expect(containerByName(deploy, "php-fpm").LivenessProbe.InitialDelaySeconds).To(BeNumerically("<=", 60))
+
+			By("Shortening the startup timeout")
+			StartupTimeout = 10 * time.Second
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "php-fpm").StartupProbe.FailureThreshold).To(BeEquivalentTo(4))
+			Expect(containerByName(deploy, "nginx").StartupProbe.FailureThreshold).To(BeEquivalentTo(2))
+		})
+	})
+
+	Describe("Choosing the deployment strategy", func() {
+		deploy := func(d *drupalwebservicesv1alpha1.DrupalSite) *appsv1.Deployment {
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			return deploy
+		}
+		It("Should default to a RollingUpdate with the defaults of the API server", func() {
+			d := newTestDrupalSite("test-strategy-default", "default")
+			defaultPercent := intstr.FromString("25%")
+			Expect(deploy(d).Spec.Strategy).To(Equal(appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &defaultPercent, MaxUnavailable: &defaultPercent},
+			}))
+		})
+		It("Should set the maxSurge and maxUnavailable of a RollingUpdate", func() {
+			d := newTestDrupalSite("test-strategy-rolling", "default")
+			maxSurge, maxUnavailable := intstr.FromInt(1), intstr.FromInt(0)
+			d.Spec.Configuration.DeploymentStrategy = &appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable},
+			}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			strategy := deploy(d).Spec.Strategy
+			Expect(strategy.RollingUpdate.MaxSurge).To(Equal(&maxSurge))
+			Expect(strategy.RollingUpdate.MaxUnavailable).To(Equal(&maxUnavailable))
+		})
+		It("Should recreate the pods", func() {
+			d := newTestDrupalSite("test-strategy-recreate", "default")
+			d.Spec.Configuration.DeploymentStrategy = &appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			Expect(deploy(d).Spec.Strategy).To(Equal(appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}))
+
+			By("Rejecting rollingUpdate parameters or an unknown type")
+			maxSurge := intstr.FromInt(1)
+			d.Spec.Configuration.DeploymentStrategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge}
+			Expect(validateSpec(d.Spec, false)).NotTo(BeNil())
+			d.Spec.Configuration.DeploymentStrategy = &appsv1.DeploymentStrategy{Type: "BlueGreen"}
+			Expect(validateSpec(d.Spec, false)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Skipping the resources of a steady site", func() {
+		BeforeEach(func() {
+			ResourcesResyncPeriod = time.Hour
+		})
+		AfterEach(func() {
+			ResourcesResyncPeriod = 0
+		})
+		It("Should not write anything when nothing the resources depend on changed", func() {
+			d := newTestDrupalSite("test-steady-resources", "default")
+			setConditionStatus(d, "Initialized", true, nil, false)
+			setConditionStatus(d, "Ready", true, nil, false)
+			setConditionStatus(d, "Serving", true, nil, false)
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+			hash, err := ensuredResourcesHash(d, config, nil, now)
+			Expect(err).NotTo(HaveOccurred())
+			d.Status.EnsuredResourcesHash = hash
+
+			countingClient := &writeCountingClient{Client: k8sClient}
+			r.Client = countingClient
+			summary := &reconcileSummary{}
+			Expect(r.ensureResourcesIfChanged(ctx, d, config, nil, summary, ctrl.Log, now.Add(ResourcesResyncPeriod/2))).To(BeNil())
+			Expect(countingClient.writes).To(BeZero())
+			Expect(summary.skipped).To(ContainElement("resources"))
+
+			By("Expecting the hash to change with the spec, the conditions and the resync period")
+			changed := d.DeepCopy()
+			changed.Spec.Configuration.ExtraEnv = []corev1.EnvVar{{Name: "FOO", Value: "bar"}}
+			Expect(ensuredResourcesHash(changed, config, nil, now)).NotTo(Equal(hash))
+			changed = d.DeepCopy()
+			setConditionStatus(changed, "PublishBlocked", true, nil, false)
+			Expect(ensuredResourcesHash(changed, config, nil, now)).NotTo(Equal(hash))
+			Expect(ensuredResourcesHash(d, config, nil, now.Add(ResourcesResyncPeriod))).NotTo(Equal(hash))
+
+			By("Expecting a site that isn't serving not to be steady")
+			setConditionStatus(d, "Serving", false, nil, false)
+			Expect(resourcesSteady(d)).To(BeFalse())
+		})
+		It("Should only write the hash on the status when it changes", func() {
+			d := newTestDrupalSite("test-steady-resources-hash", "default")
+			setConditionStatus(d, "Initialized", true, nil, false)
+			setConditionStatus(d, "Ready", true, nil, false)
+			setConditionStatus(d, "Serving", true, nil, false)
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			countingClient := &statusWriteCountingClient{Client: k8sClient}
+			r.Client = countingClient
+			now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+			record := func(at time.Time) {
+				hash, err := ensuredResourcesHash(d, config, nil, at)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(r.recordEnsuredResourcesHash(ctx, d, hash, ctrl.Log)).To(BeNil())
+			}
+
+			record(now)
+			Expect(countingClient.statusWrites).To(Equal(1))
+			By("Not writing the same hash again, within the resync period")
+			record(now)
+			record(now.Add(ResourcesResyncPeriod - time.Second))
+			Expect(countingClient.statusWrites).To(Equal(1))
+			By("Writing the new hash of the next resync period")
+			record(now.Add(ResourcesResyncPeriod))
+			Expect(countingClient.statusWrites).To(Equal(2))
+			By("Not writing the hash of a site that isn't steady")
+			setConditionStatus(d, "Serving", false, nil, false)
+			record(now.Add(2 * ResourcesResyncPeriod))
+			Expect(countingClient.statusWrites).To(Equal(2))
+		})
+		Context("Reconciling a steady site twice within the resync period", func() {
+			It("Should only ensure the resources on the first reconciliation", func() {
+				d := newTestDrupalSite("test-steady-resources-resync", "default")
+				d.UID = "6c1f3e9a-58b2-4d7c-9e0a-b3d4f5a6c7e8"
+				setConditionStatus(d, "Initialized", true, nil, false)
+				setConditionStatus(d, "Ready", true, nil, false)
+				setConditionStatus(d, "Serving", true, nil, false)
+				r := newTestReconciler()
+				config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+				Expect(reconcileErr).To(BeNil())
+				countingClient := &writeCountingClient{Client: &statusWriteCountingClient{Client: k8sClient}}
+				r.Client = countingClient
+				now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+				Expect(r.ensureResourcesIfChanged(ctx, d, config, nil, &reconcileSummary{}, ctrl.Log, now)).To(BeNil())
+				Expect(d.Status.EnsuredResourcesHash).NotTo(BeEmpty())
+				cliConfig := types.NamespacedName{Name: "php-cli-config-" + d.Name, Namespace: d.Namespace}
+				configmap := &corev1.ConfigMap{}
+				Expect(k8sClient.Get(ctx, cliConfig, configmap)).To(Succeed())
+
+				By("Not recreating a deleted resource on a second reconciliation within the period")
+				Expect(k8sClient.Delete(ctx, configmap)).To(Succeed())
+				countingClient.writes = 0
+				summary := &reconcileSummary{}
+				Expect(r.ensureResourcesIfChanged(ctx, d, config, nil, summary, ctrl.Log, now.Add(ResourcesResyncPeriod/2))).To(BeNil())
+				Expect(countingClient.writes).To(BeZero())
+				Expect(summary.skipped).To(ContainElement("resources"))
+				Expect(k8sapierrors.IsNotFound(k8sClient.Get(ctx, cliConfig, &corev1.ConfigMap{}))).To(BeTrue())
+
+				By("Recreating it on the first reconciliation of the next period")
+				summary = &reconcileSummary{}
+				Expect(r.ensureResourcesIfChanged(ctx, d, config, nil, summary, ctrl.Log, now.Add(ResourcesResyncPeriod))).To(BeNil())
+				Expect(summary.skipped).NotTo(ContainElement("resources"))
+				Expect(k8sClient.Get(ctx, cliConfig, &corev1.ConfigMap{})).To(Succeed())
+			})
+		})
+	})
+
+	Describe("Rotating the WebDAV password", func() {
+		It("Should regenerate the htdigest and roll the deployment out when the password is cleared", func() {
+			d := newTestDrupalSite("test-webdav-rotation", "default")
+			_, err := newTestReconciler().ensureSpecFinalizer(ctx, d, nil, ctrl.Log)
+			Expect(err).To(BeNil())
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			secret := &corev1.Secret{}
+			Expect(secretForWebDAV(secret, d)).To(Succeed())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			htdigest := secret.StringData["htdigest"]
+			hash := deploy.Spec.Template.ObjectMeta.Annotations["webdav-secret/hash"]
+			Expect(hash).NotTo(BeEmpty())
+
+			By("Clearing the password")
+			password := d.Spec.Configuration.WebDAVPassword
+			d.Spec.Configuration.WebDAVPassword = ""
+			update, err := newTestReconciler().ensureSpecFinalizer(ctx, d, nil, ctrl.Log)
+			Expect(err).To(BeNil())
+			Expect(update).To(BeTrue())
+			Expect(d.Spec.Configuration.WebDAVPassword).NotTo(BeEmpty())
+			Expect(d.Spec.Configuration.WebDAVPassword).NotTo(Equal(password))
+
+			By("Expecting a new htdigest and deployment hash")
+			Expect(secretForWebDAV(secret, d)).To(Succeed())
+			Expect(secret.StringData["htdigest"]).NotTo(Equal(htdigest))
+			Expect(secret.StringData["htdigest"]).To(Equal(encryptBasicAuthPassword(d.Spec.Configuration.WebDAVPassword)))
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations["webdav-secret/hash"]).NotTo(Equal(hash))
+		})
+		It("Should report the admission of the WebDAV routes", func() {
+			d := newTestDrupalSite("test-webdav-ready", "default")
+			ready, found, err := newTestReconciler().webDAVReady(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+
+			ls := labelsForDrupalSite(d.Name)
+			ls["app"] = "drupal"
+			ls["route"] = "webdav"
+			route := &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{Name: "webdav-test-webdav-ready", Namespace: d.Namespace, Labels: ls},
+				Spec: routev1.RouteSpec{
+					Host: "webdav-test-webdav-ready.webtest.cern.ch",
+					To:   routev1.RouteTargetReference{Kind: "Service", Name: d.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, route)).To(Succeed())
+			ready, found, err = newTestReconciler().webDAVReady(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(ready).To(BeFalse())
+
+			By("Admitting the route")
+			route.Status.Ingress = []routev1.RouteIngress{{
+				Host:       route.Spec.Host,
+				Conditions: []routev1.RouteIngressCondition{{Type: routev1.RouteAdmitted, Status: corev1.ConditionTrue}},
+			}}
+			Expect(k8sClient.Status().Update(ctx, route)).To(Succeed())
+			Eventually(func() bool {
+				ready, _, _ := newTestReconciler().webDAVReady(ctx, d)
+				return ready
+			}).Should(BeTrue())
+		})
+	})
+
+	Describe("Including extra settings", func() {
+		It("Should mount the settings.local.php of the ConfigMap in the server and the jobs", func() {
+			d := newTestDrupalSite("test-extra-settings", "default")
+			d.Spec.Configuration.ExtraSettingsConfigMap = "my-settings"
+			volumeNames := func(volumes []corev1.Volume) (names []string) {
+				for _, v := range volumes {
+					names = append(names, v.Name)
+				}
+				return
+			}
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "php-fpm").VolumeMounts).To(ContainElement(corev1.VolumeMount{
+				Name: extraSettingsVolume, MountPath: "/app/web/sites/default/settings.local.php", SubPath: "settings.local.php", ReadOnly: true,
+			}))
+			Expect(volumeNames(deploy.Spec.Template.Spec.Volumes)).To(ContainElement(extraSettingsVolume))
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "test-db-secret", d)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+				Name: extraSettingsVolume, MountPath: "/app/web/sites/default/settings.local.php", SubPath: "settings.local.php", ReadOnly: true,
+			}))
+
+			By("Expecting the mount to be removed with the field")
+			d.Spec.Configuration.ExtraSettingsConfigMap = ""
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			for _, mount := range containerByName(deploy, "php-fpm").VolumeMounts {
+				Expect(mount.Name).NotTo(Equal(extraSettingsVolume))
+			}
+			Expect(volumeNames(deploy.Spec.Template.Spec.Volumes)).NotTo(ContainElement(extraSettingsVolume))
+		})
+		It("Should roll the deployment out when the content of the ConfigMap changes", func() {
+			d := newTestDrupalSite("test-extra-settings-rollout", "default")
+			d.UID = "5f0c6c3a-7f1e-4d0a-9a0b-3c2f1e8d4b7a"
+			d.Spec.Configuration.ExtraSettingsConfigMap = "test-extra-settings-rollout-local"
+			r := newTestReconciler()
+			config, _, _, reconcileErr := r.getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace}}
+			_, err := ctrl.CreateOrUpdate(ctx, k8sClient, deploy, func() error {
+				return deploymentForDrupalSite(deploy, databaseSecretName(d), d, releaseID(d), config)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			for _, name := range []string{"php-fpm-", "nginx-global-", "site-settings-", "php-cli-config-"} {
+				Expect(k8sClient.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name + d.Name, Namespace: d.Namespace}})).To(Succeed())
+			}
+			extraSettings := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: d.Spec.Configuration.ExtraSettingsConfigMap, Namespace: d.Namespace},
+				Data:       map[string]string{"settings.local.php": "<?php $settings['a'] = 1;"},
+			}
+			Expect(k8sClient.Create(ctx, extraSettings)).To(Succeed())
+
+			hash := func() string {
+				requeue, err := r.ensureDeploymentConfigmapHash(ctx, d, ctrl.Log)
+				Expect(err).To(BeNil())
+				Expect(requeue).To(BeFalse())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+				return deploy.Spec.Template.ObjectMeta.Annotations["extra-settings-configmap/hash"]
+			}
+			before := hash()
+			Expect(before).NotTo(BeEmpty())
+
+			By("Expecting the hash to change with the content")
+			extraSettings.Data["settings.local.php"] = "<?php $settings['a'] = 2;"
+			Expect(k8sClient.Update(ctx, extraSettings)).To(Succeed())
+			Expect(hash()).NotTo(Equal(before))
+		})
+	})
+
+	Describe("Keeping the history of the backups", func() {
+		backupAt := func(name string, daysAgo int) drupalwebservicesv1alpha1.Backup {
+			date := metav1.NewTime(time.Now().Add(-time.Duration(daysAgo) * 24 * time.Hour))
+			return drupalwebservicesv1alpha1.Backup{BackupName: name, Date: &date, DrupalSiteName: "test-backup-history"}
+		}
+		It("Should keep the most recent expired backups, marked as expired", func() {
+			d := newTestDrupalSite("test-backup-history", "default")
+			d.Spec.Configuration.ExpiredBackupsHistory = 2
+			d.Status.AvailableBackups = []drupalwebservicesv1alpha1.Backup{backupAt("day-1", 1), backupAt("day-14", 14), backupAt("day-15", 15), backupAt("day-16", 16)}
+
+			By("Expecting the backups deleted by velero to be kept as expired")
+			backups := retainExpiredBackups(d, []drupalwebservicesv1alpha1.Backup{backupAt("day-0", 0), backupAt("day-1", 1)})
+			Expect(backups).To(HaveLen(4))
+			Expect(backups[0].Expired).To(BeFalse())
+			Expect(backups[1].Expired).To(BeFalse())
+			Expect(backups[2].BackupName).To(Equal("day-14"))
+			Expect(backups[2].Expired).To(BeTrue())
+			Expect(backups[3].BackupName).To(Equal("day-15"))
+			Expect(backups[3].Expired).To(BeTrue())
+			Expect(backupsChanged(backups, d.Status.AvailableBackups)).To(BeTrue())
+
+			By("Expecting the history to age out as more backups expire")
+			d.Status.AvailableBackups = backups
+			backups = retainExpiredBackups(d, []drupalwebservicesv1alpha1.Backup{backupAt("day-0", 0)})
+			Expect(backups).To(HaveLen(3))
+			Expect(backups[1].BackupName).To(Equal("day-1"))
+			Expect(backups[1].Expired).To(BeTrue())
+			Expect(backups[2].BackupName).To(Equal("day-14"))
+		})
+		It("Should report the completion of the most recent backup", func() {
+			d := newTestDrupalSite("test-backup-history", "default")
+			backups := []drupalwebservicesv1alpha1.Backup{backupAt("day-2", 2), backupAt("day-1", 1)}
+			Expect(setLastBackupTime(d, backups)).To(BeTrue())
+			Expect(d.Status.LastBackupTime).To(Equal(backups[1].Date))
+
+			By("Expecting no update without a newer backup")
+			Expect(setLastBackupTime(d, backups)).To(BeFalse())
+			Expect(setLastBackupTime(d, backups[:1])).To(BeFalse())
+			Expect(d.Status.LastBackupTime).To(Equal(backups[1].Date))
+			Expect(setLastBackupTime(d, append(backups, backupAt("day-0", 0)))).To(BeTrue())
+		})
+		It("Should drop the expired backups by default", func() {
+			d := newTestDrupalSite("test-backup-history", "default")
+			d.Status.AvailableBackups = []drupalwebservicesv1alpha1.Backup{backupAt("day-0", 0), backupAt("day-15", 15)}
+			backups := retainExpiredBackups(d, []drupalwebservicesv1alpha1.Backup{backupAt("day-0", 0)})
+			Expect(backups).To(HaveLen(1))
+			Expect(backupsChanged(backups, d.Status.AvailableBackups)).To(BeTrue())
+			d.Status.AvailableBackups = backups
+			Expect(backupsChanged(retainExpiredBackups(d, []drupalwebservicesv1alpha1.Backup{backupAt("day-0", 0)}), d.Status.AvailableBackups)).To(BeFalse())
+		})
+	})
+
+	Describe("Memoizing the checks of a reconciliation", func() {
+		It("Should exec the same check into the server pod once per reconciliation", func() {
+			d := newTestDrupalSite("test-exec-cache", "default")
+			countingClient := &podListCountingClient{Client: k8sClient}
+			r := newTestReconciler()
+			r.Client = countingClient
+
+			reconcileCtx := withExecCache(ctx)
+			_, firstErr := r.dbUpdateNeeded(reconcileCtx, d)
+			_, secondErr := r.dbUpdateNeeded(reconcileCtx, d)
+			Expect(countingClient.podLists).To(Equal(1))
+			Expect(secondErr).To(Equal(firstErr))
+
+			By("Expecting the next reconciliation to check again")
+			r.dbUpdateNeeded(withExecCache(ctx), d)
+			Expect(countingClient.podLists).To(Equal(2))
+		})
+	})
+
+	Describe("Clearing the caches through the annotation", func() {
+		It("Should reload the caches once per annotation", func() {
+			d := newTestDrupalSite("test-clear-cache", "default")
+			d.Annotations = map[string]string{clearCacheAnnotation: "true"}
+			setReady(d)
+			r := newTestReconciler()
+			statusChanged, requested := r.clearCacheIfRequested(ctx, d, ctrl.Log)
+			Expect(requested).To(BeTrue())
+			Expect(statusChanged).To(BeTrue())
+			Expect(d.Status.LastDrushOutput).To(HavePrefix("$ /operations/clear-cache.sh\n"))
+			Expect(d.Annotations).NotTo(HaveKey(clearCacheAnnotation))
+
+			d.Status.LastDrushOutput = "previous output"
+			statusChanged, requested = r.clearCacheIfRequested(ctx, d, ctrl.Log)
+			Expect(requested).To(BeFalse())
+			Expect(statusChanged).To(BeFalse())
+			Expect(d.Status.LastDrushOutput).To(Equal("previous output"))
+		})
+		It("Should wait for the site to be ready", func() {
+			d := newTestDrupalSite("test-clear-cache", "default")
+			d.Annotations = map[string]string{clearCacheAnnotation: "true"}
+			_, requested := newTestReconciler().clearCacheIfRequested(ctx, d, ctrl.Log)
+			Expect(requested).To(BeFalse())
+			Expect(d.Annotations).To(HaveKey(clearCacheAnnotation))
+			Expect(d.Status.LastDrushOutput).To(BeEmpty())
+		})
+	})
+
+	Describe("Reporting a PVC that can't be provisioned", func() {
+		It("Should report the PVC events after the grace period", func() {
+			defer func() { PVCProvisioningGracePeriod = 10 * time.Minute }()
+			d := newTestDrupalSite("test-pvc-pending", "default")
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-claim-" + d.Name, Namespace: d.Namespace},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+					Resources:   corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pvc)).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-claim-test-pvc-pending.event", Namespace: d.Namespace},
+				InvolvedObject: corev1.ObjectReference{
+					Kind:      "PersistentVolumeClaim",
+					Name:      pvc.Name,
+					Namespace: pvc.Namespace,
+					UID:       pvc.UID,
+				},
+				Type:          corev1.EventTypeWarning,
+				Reason:        "ProvisioningFailed",
+				Message:       "access mode ReadWriteMany is not supported",
+				LastTimestamp: metav1.Now(),
+			})).To(Succeed())
+			r := newTestReconciler()
+
+			By("Expecting no failure within the grace period")
+			failure, err := r.storageProvisioningFailure(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(failure).To(BeNil())
+
+			By("Expecting the failure with the event message after the grace period")
+			PVCProvisioningGracePeriod = 0
+			Eventually(func() bool {
+				failure, err = r.storageProvisioningFailure(ctx, d)
+				return err == nil && failure != nil
+			}).Should(BeTrue())
+			Expect(setConditionStatus(d, "StorageProvisioningFailed", true, failure, false)).To(BeTrue())
+			condition := d.Status.Conditions.GetCondition("StorageProvisioningFailed")
+			Expect(string(condition.Reason)).To(Equal(ErrStorageProvisioningFailed.Error()))
+			Expect(condition.Message).To(ContainSubstring("access mode ReadWriteMany is not supported"))
+		})
+	})
+
+	Describe("Setting the resources of the init containers", func() {
+		It("Should give every init container of the jobs resources", func() {
+			d := newTestDrupalSite("test-init-resources", "default")
+			d.Spec.Configuration.CloneFrom = "test-init-resources-source"
+			install := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(install, "test-db-secret", d)).To(Succeed())
+			clone := &batchv1.Job{}
+			Expect(jobForDrupalSiteClone(clone, "test-db-secret", d)).To(Succeed())
+			for _, container := range append(install.Spec.Template.Spec.InitContainers, clone.Spec.Template.Spec.InitContainers...) {
+				Expect(container.Resources.Requests).NotTo(BeEmpty(), container.Name)
+				Expect(container.Resources.Limits).NotTo(BeEmpty(), container.Name)
+			}
+		})
+		It("Should use the resources of the spec", func() {
+			d := newTestDrupalSite("test-init-resources", "default")
+			override, err := ResourceRequestLimit("50Mi", "20m", "100Mi", "200m")
+			Expect(err).NotTo(HaveOccurred())
+			d.Spec.Configuration.InitContainerResources = &override
+			install := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(install, "test-db-secret", d)).To(Succeed())
+			Expect(install.Spec.Template.Spec.InitContainers[0].Resources).To(Equal(override))
+
+			By("Keeping the resources of the database dump of the clone")
+			d.Spec.Configuration.CloneFrom = "test-init-resources-source"
+			clone := &batchv1.Job{}
+			Expect(jobForDrupalSiteClone(clone, "test-db-secret", d)).To(Succeed())
+			dumpResources, err := reqLimDict("src-db-backup", d.Spec.QoSClass)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clone.Spec.Template.Spec.InitContainers[0].Name).To(Equal("src-db-backup"))
+			Expect(clone.Spec.Template.Spec.InitContainers[0].Resources).To(Equal(dumpResources))
+		})
+	})
+
+	Describe("Detecting a version drift", func() {
+		It("Should flag a stale pod only after the grace period", func() {
+			defer func() { VersionDriftGracePeriod = time.Hour }()
+			d := newTestDrupalSite("test-version-drift", "default")
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-version-drift-pod",
+					Namespace:   d.Namespace,
+					Labels:      map[string]string{"drupalSite": d.Name, "app": "drupal"},
+					Annotations: map[string]string{"releaseID": "v8.9-1-stale"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.Phase = corev1.PodRunning
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			r := newTestReconciler()
+
+			By("Expecting no drift within the grace period")
+			drift, err := r.versionDrift(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(drift).To(BeNil())
+
+			By("Expecting the drift after the grace period")
+			VersionDriftGracePeriod = 0
+			Eventually(func() bool {
+				drift, err = r.versionDrift(ctx, d)
+				return err == nil && drift != nil
+			}).Should(BeTrue())
+			Expect(drift.Error()).To(ContainSubstring("v8.9-1-stale"))
+
+			By("Expecting no drift while an update is in progress")
+			d.Annotations = map[string]string{"updateInProgress": "true"}
+			drift, err = r.versionDrift(ctx, d)
+			Expect(err).To(BeNil())
+			Expect(drift).To(BeNil())
+		})
+	})
+
+	Describe("Injecting extra env secrets", func() {
+		It("Should add them after the secrets of the operator in the deployment and the jobs", func() {
+			d := newTestDrupalSite("test-extra-env-from", "default")
+			d.Spec.Configuration.ExtraEnvFromSecrets = []string{"test-third-party-creds"}
+			d.Spec.Configuration.CloneFrom = "test-extra-env-from-source"
+			extra := corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "test-third-party-creds"}}}
+
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			phpEnvFrom := containerByName(deploy, "php-fpm").EnvFrom
+			Expect(phpEnvFrom).To(HaveLen(3))
+			Expect(phpEnvFrom[0].SecretRef.Name).To(Equal("test-db-secret"))
+			Expect(phpEnvFrom[2]).To(Equal(extra))
+			Expect(containerByName(deploy, "cron").EnvFrom).To(ConsistOf(extra))
+
+			install := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(install, "test-db-secret", d)).To(Succeed())
+			Expect(install.Spec.Template.Spec.Containers[0].EnvFrom).To(ContainElement(extra))
+			clone := &batchv1.Job{}
+			Expect(jobForDrupalSiteClone(clone, "test-db-secret", d)).To(Succeed())
+			Expect(clone.Spec.Template.Spec.Containers[0].EnvFrom).To(ContainElement(extra))
+		})
+		It("Should wait for the secrets to exist", func() {
+			d := newTestDrupalSite("test-extra-env-from", "default")
+			d.Spec.Configuration.ExtraEnvFromSecrets = []string{"test-extra-env-from-creds"}
+			r := newTestReconciler()
+			err := r.checkExtraEnvFromSecrets(ctx, d)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Temporary()).To(BeTrue())
+			Expect(err.Unwrap()).To(Equal(ErrSecretMissing))
+			Expect(setConditionStatus(d, "SecretMissing", true, err, false)).To(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("SecretMissing").Message).To(ContainSubstring(`secret "test-extra-env-from-creds" doesn't exist`))
+
+			Expect(k8sClient.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-extra-env-from-creds", Namespace: d.Namespace},
+				StringData: map[string]string{"API_TOKEN": "token"},
+			})).To(Succeed())
+			Eventually(func() error {
+				if err := r.checkExtraEnvFromSecrets(ctx, d); err != nil {
+					return err
+				}
+				return nil
+			}).Should(Succeed())
+		})
+	})
+
+	Describe("Setting extra env vars", func() {
+		It("Should add them to the php-fpm and cron containers", func() {
+			d := newTestDrupalSite("test-extra-env", "default")
+			d.Spec.Configuration.ExtraEnv = []corev1.EnvVar{{Name: "FEATURE_FLAG", Value: "on"}}
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(containerByName(deploy, "php-fpm").Env).To(ContainElements(
+				corev1.EnvVar{Name: "DRUPAL_SHARED_VOLUME", Value: "/drupal-data"},
+				corev1.EnvVar{Name: "FEATURE_FLAG", Value: "on"},
+			))
+			Expect(containerByName(deploy, "cron").Env).To(ContainElement(corev1.EnvVar{Name: "FEATURE_FLAG", Value: "on"}))
+		})
+		It("Should reject overriding an env var of the operator", func() {
+			spec := newTestDrupalSite("test-extra-env", "default").Spec
+			spec.Configuration.ExtraEnv = []corev1.EnvVar{{Name: "DRUPAL_SHARED_VOLUME", Value: "/tmp"}}
+			err := validateSpec(spec, false)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+		})
+	})
+
+	Describe("Verifying a rollback", func() {
+		It("Should report a failsafe version that fails to roll out too", func() {
+			d := newTestDrupalSite("test-rollback-failed", "default")
+			d.Status.ReleaseID.Failsafe = "v8.9-1-failsafe"
+			setConditionStatus(d, "CodeUpdateFailed", true, newApplicationError(fmt.Errorf("pod did not roll out successfully"), ErrDeploymentUpdateFailed), false)
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-rollback-failed-pod",
+					Namespace:   d.Namespace,
+					Labels:      map[string]string{"drupalSite": d.Name, "app": "drupal"},
+					Annotations: map[string]string{"releaseID": d.Status.ReleaseID.Failsafe},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.Phase = corev1.PodFailed
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+			recorder := record.NewFakeRecorder(1)
+			r := newTestReconciler()
+			r.Recorder = recorder
+			Eventually(func() bool {
+				update, requeue := r.verifyRollback(ctx, d)
+				return update && !requeue
+			}).Should(BeTrue())
+			Expect(d.ConditionTrue("RollbackFailed")).To(BeTrue())
+			Expect(<-recorder.Events).To(ContainSubstring("RollbackFailed"))
+
+			By("Expecting the condition to be removed once the failsafe version runs")
+			pod.Status.Phase = corev1.PodRunning
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			Eventually(func() bool {
+				update, _ := r.verifyRollback(ctx, d)
+				return update
+			}).Should(BeTrue())
+			Expect(d.Status.Conditions.GetCondition("RollbackFailed")).To(BeNil())
+		})
+	})
+
+	Describe("Restarting a site", func() {
+		It("Should roll out the deployment again", func() {
+			d := newTestDrupalSite("test-restart", "default")
+			Expect(newTestReconciler().restartDeployment(ctx, d)).To(BeNil())
+
+			ls := labelsForDrupalSite(d.Name)
+			deploy := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: ls},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: ls},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deploy)).To(Succeed())
+			Eventually(func() reconcileError {
+				return newTestReconciler().restartDeployment(ctx, d)
+			}).Should(BeNil())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: d.Name, Namespace: d.Namespace}, deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.ObjectMeta.Annotations).To(HaveKey(restartedAtAnnotation))
+		})
+	})
+
+	Describe("Installing a site", func() {
+		It("Should not reinstall a site that is already installed", func() {
+			d := newTestDrupalSite("test-install-check", "default")
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "dbcredentials-test-install-check", d)).To(Succeed())
+			command := job.Spec.Template.Spec.Containers[0].Command
+			Expect(command).To(Equal([]string{"sh", "-c", `stderr=$(/operations/check-if-installed.sh 2>&1 >/dev/null) && [ -z "$stderr" ] && ` +
+				`echo 'The site is already installed, skipping the installation' || /operations/ensure-site-install.sh`}))
+
+			By("Installing the site when the check fails or writes to stderr")
+			for _, check := range []struct {
+				script    string
+				installed bool
+			}{{"exit 0", true}, {"exit 1", false}, {"echo 'Drupal bootstrap failed' >&2; exit 0", false}, {"echo 'installed'", true}} {
+				script := strings.Replace(command[2], "/operations/check-if-installed.sh", "sh -c \""+check.script+"\"", 1)
+				script = strings.Replace(script, "/operations/ensure-site-install.sh", "echo installing", 1)
+				output, err := exec.Command("sh", "-c", script).Output()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Contains(string(output), "installing")).To(Equal(!check.installed), check.script)
+			}
+		})
+		It("Should clean up the finished jobs after an hour by default", func() {
+			d := newTestDrupalSite("test-install-job-defaults", "default")
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "dbcredentials-test-install-job-defaults", d)).To(Succeed())
+			Expect(job.Spec.BackoffLimit).To(Equal(pointer.Int32Ptr(3)))
+			Expect(job.Spec.TTLSecondsAfterFinished).To(Equal(pointer.Int32Ptr(defaultJobTTLSeconds)))
+		})
+		It("Should use the configured backoff limit and TTL for the install and clone jobs", func() {
+			d := newTestDrupalSite("test-install-job-config", "default")
+			d.Spec.Configuration.InstallJobBackoffLimit = pointer.Int32Ptr(1)
+			d.Spec.Configuration.JobTTLSeconds = pointer.Int32Ptr(600)
+			installJob := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(installJob, "dbcredentials-test-install-job-config", d)).To(Succeed())
+			cloneJob := &batchv1.Job{}
+			Expect(jobForDrupalSiteClone(cloneJob, "dbcredentials-test-install-job-config", d)).To(Succeed())
+			for _, job := range []*batchv1.Job{installJob, cloneJob} {
+				Expect(job.Spec.BackoffLimit).To(Equal(pointer.Int32Ptr(1)))
+				Expect(job.Spec.TTLSecondsAfterFinished).To(Equal(pointer.Int32Ptr(600)))
+			}
+		})
+		It("Should not recreate the install job of an initialized site", func() {
+			d := newTestDrupalSite("test-install-initialized", "default")
+			setInitialized(d)
+			Expect(newTestReconciler().ensureResourceX(ctx, d, "site_install_job", ctrl.Log)).To(BeNil())
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "ensure-site-install-" + d.Name, Namespace: d.Namespace}, &batchv1.Job{})
+			Expect(k8sapierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Taking backups", func() {
+		It("Should give the database dump time according to the disk size", func() {
+			d := newTestDrupalSite("test-backup-timeout", "default")
+			for diskSize, timeout := range map[string]string{
+				"500Mi":  "36m0s",
+				"2000Mi": "42m0s",
+				"10Gi":   "1h30m0s",
+				"100Gi":  "10h30m0s",
+				"1Ti":    "12h0m0s",
+			} {
+				d.Spec.Configuration.DiskSize = diskSize
+				Expect(backupHookTimeout(d).String()).To(Equal(timeout), diskSize)
+			}
+
+			By("Expecting the override to set the annotation of the deployment")
+			d.Spec.Configuration.BackupHookTimeout = &metav1.Duration{Duration: 3 * time.Hour}
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "dbcredentials-test-backup-timeout", d, releaseID(d), config)).To(Succeed())
+			timeout, err := time.ParseDuration(deploy.Spec.Template.ObjectMeta.Annotations["pre.hook.backup.velero.io/timeout"])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(timeout).To(Equal(3 * time.Hour))
+		})
+		It("Should include the additional resources of the spec", func() {
+			d := newTestDrupalSite("test-backup-resources", "default")
+			d.Spec.Configuration.BackupIncludedResources = []string{"configmaps", "pods"}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			schedule := &velerov1.Schedule{}
+			Expect(scheduledBackupsForDrupalSite(schedule, d)).To(Succeed())
+			Expect(schedule.Spec.Template.IncludedResources).To(Equal([]string{"pods", "configmaps"}))
+		})
+		It("Should spread the schedules of the sites and keep each one stable", func() {
+			a := newTestDrupalSite("test-backup-schedule-a", "default")
+			b := newTestDrupalSite("test-backup-schedule-b", "default")
+			Expect(backupSchedule(a)).To(Equal("41 2 2-31/2 * *"))
+			Expect(backupSchedule(b)).To(Equal("22 21 1-31/2 * *"))
+			Expect(backupSchedule(a)).To(Equal(backupSchedule(newTestDrupalSite("test-backup-schedule-a", "default"))))
+
+			By("Keeping the schedule of an existing Schedule")
+			schedule := &velerov1.Schedule{}
+			Expect(scheduledBackupsForDrupalSite(schedule, a)).To(Succeed())
+			Expect(schedule.Spec.Schedule).To(Equal(backupSchedule(a)))
+			schedule.CreationTimestamp = metav1.Now()
+			schedule.Spec.Schedule = "0 3 * * *"
+			Expect(scheduledBackupsForDrupalSite(schedule, a)).To(Succeed())
+			Expect(schedule.Spec.Schedule).To(Equal("0 3 * * *"))
+		})
+		It("Should mount the excluded paths from a volume that isn't backed up", func() {
+			d := newTestDrupalSite("test-backup-excluded", "default")
+			hook := backupHookCommand()
+			Expect(hook).To(Equal("[\"sh\",\"-c\", \"/operations/database-backup.sh -f database_backup.sql\"]"))
+
+			By("Excluding the aggregated assets")
+			d.Spec.Configuration.BackupExcludedPaths = []string{"files/css", "files/js/"}
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			Expect(backupHookCommand()).To(Equal(hook))
+			config, _, _, reconcileErr := newTestReconciler().getDeploymentConfiguration(ctx, d)
+			Expect(reconcileErr).To(BeNil())
+			deploy := &appsv1.Deployment{}
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			Expect(deploy.Spec.Template.Annotations["backup.velero.io/backup-volumes"]).To(Equal("drupal-directory-test-backup-excluded"))
+			Expect(deploy.Spec.Template.Spec.Volumes).To(ContainElement(corev1.Volume{
+				Name:         backupExcludedVolume,
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pv-claim-excluded-test-backup-excluded"}},
+			}))
+			excludedMounts := []corev1.VolumeMount{
+				{Name: backupExcludedVolume, MountPath: "/drupal-data/files/css", SubPath: "files/css"},
+				{Name: backupExcludedVolume, MountPath: "/drupal-data/files/js", SubPath: "files/js"},
+			}
+			for _, name := range []string{"nginx", "php-fpm", "webdav", "cron"} {
+				Expect(containerByName(deploy, name).VolumeMounts).To(ContainElements(excludedMounts), name)
+			}
+			installJob := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(installJob, "test-db-secret", d)).To(Succeed())
+			Expect(installJob.Spec.Template.Spec.Volumes).To(ContainElement(corev1.Volume{
+				Name:         backupExcludedVolume,
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pv-claim-excluded-test-backup-excluded"}},
+			}))
+			Expect(installJob.Spec.Template.Spec.InitContainers[0].VolumeMounts).To(ContainElements(excludedMounts))
+			Expect(installJob.Spec.Template.Spec.Containers[0].VolumeMounts).To(ContainElements(excludedMounts))
+			cloneJob := &batchv1.Job{}
+			Expect(jobForDrupalSiteClone(cloneJob, "test-db-secret", d)).To(Succeed())
+			Expect(cloneJob.Spec.Template.Spec.Containers[0].VolumeMounts).To(ContainElements(excludedMounts))
+			d.UID = "5d1c8e7a-2b4f-4c93-8e06-a7f35b9d2c41"
+			Expect(newTestReconciler().ensureResourceX(ctx, d, "pvc_backup_excluded", ctrl.Log)).To(BeNil())
+			pvc := &corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "pv-claim-excluded-test-backup-excluded", Namespace: "default"}, pvc)).To(Succeed())
+			Expect(pvc.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteMany))
+
+			By("Removing the excluded paths")
+			d.Spec.Configuration.BackupExcludedPaths = nil
+			Expect(deploymentForDrupalSite(deploy, "test-db-secret", d, releaseID(d), config)).To(Succeed())
+			for _, volume := range deploy.Spec.Template.Spec.Volumes {
+				Expect(volume.Name).NotTo(Equal(backupExcludedVolume))
+			}
+			for _, volumeMount := range containerByName(deploy, "php-fpm").VolumeMounts {
+				Expect(volumeMount.Name).NotTo(Equal(backupExcludedVolume))
+			}
+			Expect(newTestReconciler().ensureNoBackupExcludedClaim(ctx, d, ctrl.Log)).To(BeNil())
+			// The PVC protection may keep the PVC until the pods that mount it are gone
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: "pv-claim-excluded-test-backup-excluded", Namespace: "default"}, pvc)
+				return k8sapierrors.IsNotFound(err) || (err == nil && !pvc.DeletionTimestamp.IsZero())
+			}, timeout, interval).Should(BeTrue())
+
+			By("Rejecting the paths that hold data")
+			for _, excluded := range []string{"files", "private", "files/styles", "/etc", "../files", "files/css/../..", ".", "it's"} {
+				d.Spec.Configuration.BackupExcludedPaths = []string{excluded}
+				err := validateSpec(d.Spec, false)
+				Expect(err).NotTo(BeNil(), excluded)
+				Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+			}
+		})
+		It("Should store the backups in the configured location", func() {
+			d := newTestDrupalSite("test-backup-location", "default")
+			schedule := &velerov1.Schedule{}
+			Expect(scheduledBackupsForDrupalSite(schedule, d)).To(Succeed())
+			Expect(schedule.Spec.Template.StorageLocation).To(BeEmpty())
+
+			By("Setting a BackupStorageLocation")
+			d.Spec.Configuration.BackupStorageLocation = "backups-region-b"
+			Expect(validateSpec(d.Spec, false)).To(BeNil())
+			Expect(scheduledBackupsForDrupalSite(schedule, d)).To(Succeed())
+			Expect(schedule.Spec.Template.StorageLocation).To(Equal("backups-region-b"))
+
+			By("Rejecting an invalid name")
+			d.Spec.Configuration.BackupStorageLocation = "Backups Region B"
+			err := validateSpec(d.Spec, false)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+		})
+		It("Should create the Schedule of a project with a long name", func() {
+			d := newTestDrupalSite("test-backup-long-project", strings.Repeat("long-project-name-", 4))
+			schedule := &velerov1.Schedule{ObjectMeta: metav1.ObjectMeta{Name: generateScheduleName(d.Namespace, d.Name), Namespace: "default"}}
+			Expect(scheduledBackupsForDrupalSite(schedule, d)).To(Succeed())
+			Expect(k8sClient.Create(ctx, schedule)).To(Succeed())
+			hash := md5.Sum([]byte(d.Namespace))
+			Expect(schedule.Labels).To(HaveKeyWithValue("drupal.webservices.cern.ch/projectHash", hex.EncodeToString(hash[:])))
+			Expect(schedule.Labels).NotTo(HaveKey("drupal.webservices.cern.ch/project"))
+			Expect(schedule.Annotations).To(HaveKeyWithValue("drupal.webservices.cern.ch/project", d.Namespace))
+			Expect(schedule.Annotations).To(HaveKeyWithValue("drupal.webservices.cern.ch/drupalSite", d.Name))
+		})
+		It("Should reject unknown resources", func() {
+			spec := newTestDrupalSite("test-backup-resources", "default").Spec
+			spec.Configuration.BackupIncludedResources = []string{"deploymentconfigs"}
+			err := validateSpec(spec, false)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Unwrap()).To(Equal(ErrInvalidSpec))
+		})
+	})
+
+	Describe("Setting a priority class", func() {
+		It("Should schedule the site's pods with it", func() {
+			d := newTestDrupalSite("test-priority", "default")
+			Expect(priorityClassName(d)).To(BeEmpty())
+			d.Spec.QoSClass = drupalwebservicesv1alpha1.QoSCritical
+			Expect(priorityClassName(d)).To(Equal("openshift-user-critical"))
+			d.Spec.Configuration.PriorityClassName = "drupal-production"
+			Expect(priorityClassName(d)).To(Equal("drupal-production"))
+
+			job := &batchv1.Job{}
+			Expect(jobForDrupalSiteInstallation(job, "dbcredentials-test-priority", d)).To(Succeed())
+			Expect(job.Spec.Template.Spec.PriorityClassName).To(Equal("drupal-production"))
+		})
+		It("Should require an existing PriorityClass", func() {
+			d := newTestDrupalSite("test-priority-missing", "default")
+			d.Spec.Configuration.PriorityClassName = "drupal-production"
+			_, err := newTestReconciler().ensureSpecFinalizer(ctx, d, nil, ctrl.Log)
+			Expect(err).NotTo(BeNil())
+
+			By("Expecting the spec to be accepted once the PriorityClass exists")
+			Expect(k8sClient.Create(ctx, &schedulingv1.PriorityClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "drupal-production"},
+				Value:      1000,
+			})).To(Succeed())
+			Eventually(func() reconcileError {
+				_, err := newTestReconciler().ensureSpecFinalizer(ctx, d, nil, ctrl.Log)
+				return err
+			}).Should(BeNil())
+		})
+	})
+
+	Describe("Applying the defaults of the project", func() {
+		dpc := &drupalwebservicesv1alpha1.DrupalProjectConfig{
+			Spec: drupalwebservicesv1alpha1.DrupalProjectConfigSpec{
+				PrimarySiteName: "test-project-live",
+				DefaultDomain:   "webtest.cern.ch",
+				DefaultQoSClass: drupalwebservicesv1alpha1.QoSTest,
+			},
+		}
+		It("Should apply them to a new site without explicit values", func() {
+			d := newTestDrupalSite("test-project-dev", "default")
+			d.Spec.QoSClass = ""
+			d.Spec.SiteURL = nil
+			update, err := newTestReconciler().ensureSpecFinalizer(ctx, d, dpc, ctrl.Log)
+			Expect(err).To(BeNil())
+			Expect(update).To(BeTrue())
+			Expect(d.Spec.QoSClass).To(Equal(drupalwebservicesv1alpha1.QoSTest))
+			Expect(d.Spec.SiteURL).To(ConsistOf(drupalwebservicesv1alpha1.Url("test-project-dev-default.webtest.cern.ch")))
+			Expect(validateSpec(d.Spec, true)).To(BeNil())
+		})
+		It("Should give the primary site the URL of the project", func() {
+			d := newTestDrupalSite("test-project-live", "default")
+			d.Spec.SiteURL = nil
+			Expect(applyProjectDefaults(d, dpc)).To(BeTrue())
+			Expect(d.Spec.SiteURL).To(ConsistOf(drupalwebservicesv1alpha1.Url("default.webtest.cern.ch")))
+		})
+		It("Should keep the explicit values", func() {
+			d := newTestDrupalSite("test-project-dev", "default")
+			d.Spec.QoSClass = drupalwebservicesv1alpha1.QoSCritical
+			urls := d.Spec.SiteURL
+			Expect(applyProjectDefaults(d, dpc)).To(BeFalse())
+			Expect(d.Spec.QoSClass).To(Equal(drupalwebservicesv1alpha1.QoSCritical))
+			Expect(d.Spec.SiteURL).To(Equal(urls))
+		})
+		It("Should default to the standard QoS class without a project config", func() {
+			d := newTestDrupalSite("test-project-dev", "default")
+			d.Spec.QoSClass = ""
+			Expect(applyProjectDefaults(d, nil)).To(BeTrue())
+			Expect(d.Spec.QoSClass).To(Equal(drupalwebservicesv1alpha1.QoSStandard))
+		})
+		It("Should leave the URL empty without a project domain", func() {
+			d := newTestDrupalSite("test-project-dev", "default")
+			d.Spec.SiteURL = nil
+			Expect(applyProjectDefaults(d, nil)).To(BeFalse())
+			Expect(applyProjectDefaults(d, &drupalwebservicesv1alpha1.DrupalProjectConfig{})).To(BeFalse())
+			Expect(d.Spec.SiteURL).To(BeEmpty())
+			Expect(validateSpec(d.Spec, true)).NotTo(BeNil())
+		})
+		It("Should reject a default URL that isn't a valid hostname", func() {
+			d := newTestDrupalSite("test-project-dev", "default")
+			d.Spec.SiteURL = nil
+			Expect(applyProjectDefaults(d, &drupalwebservicesv1alpha1.DrupalProjectConfig{
+				Spec: drupalwebservicesv1alpha1.DrupalProjectConfigSpec{DefaultDomain: "Web_Test.cern.ch"},
+			})).To(BeTrue())
+			Expect(validateSpec(d.Spec, true)).NotTo(BeNil())
+		})
+	})
+
+	Describe("Pausing the operator", func() {
+		It("Should not touch any resource", func() {
+			defer func() { Paused = false }()
+			Paused = true
+			// Any request to the API server would panic
+			r := newTestReconciler()
+			r.Client = nil
+			Expect(func() {
+				result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "test-paused", Namespace: "default"}})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(ctrl.Result{}))
+			}).NotTo(Panic())
+		})
+	})
+
+	Describe("Running a drush command through the annotation", func() {
+		AfterEach(func() {
+			execToPod = execToPodThroughAPI
+		})
+		It("Should run a whitelisted command in the php-fpm container and report its output", func() {
+			d := newTestDrupalSite("test-drush-whitelisted", "default")
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-drush-whitelisted-pod",
+					Namespace:   d.Namespace,
+					Labels:      map[string]string{"drupalSite": d.Name, "app": "drupal"},
+					Annotations: map[string]string{"releaseID": releaseID(d)},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "php-fpm", Image: "php-fpm"}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.Phase = corev1.PodRunning
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+			var execContainer, execPod string
+			var execCommand []string
+			execToPod = func(containerName, podName, namespace string, stdin io.Reader, command ...string) (string, string, error) {
+				execContainer, execPod, execCommand = containerName, podName, command
+				return "Cache rebuild complete.\n", "", nil
+			}
+			Eventually(func() []string {
+				execCommand = nil
+				newTestReconciler().runDrushCommand(ctx, d, "cr", ctrl.Log)
+				return execCommand
+			}).Should(Equal([]string{"drush", "cr"}))
+			Expect(execContainer).To(Equal("php-fpm"))
+			Expect(execPod).To(Equal(pod.Name))
+			Expect(d.Status.LastDrushOutput).To(Equal("$ drush cr\nCache rebuild complete.\n"))
+		})
+		It("Should reject a command that is not whitelisted", func() {
+			d := newTestDrupalSite("test-drush-rejected", "default")
+			d.Status.LastDrushOutput = "previous output"
+			recorder := record.NewFakeRecorder(1)
+			r := newTestReconciler()
+			r.Recorder = recorder
+			Expect(r.runDrushCommand(ctx, d, "sql-drop", ctrl.Log)).To(BeFalse())
+			Expect(d.Status.LastDrushOutput).To(Equal("previous output"))
+			Expect(<-recorder.Events).To(ContainSubstring("DrushCommandRejected"))
+		})
+		It("Should keep the end of long outputs", func() {
+			output := formatDrushOutput([]string{"drush", "status"}, strings.Repeat("a", maxDrushOutputLength)+"end", "", nil)
+			Expect(output).To(HaveLen(maxDrushOutputLength))
+			Expect(output).To(HaveSuffix("end"))
+		})
+	})
+})
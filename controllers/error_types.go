@@ -17,13 +17,22 @@ var (
 	ErrClientK8s                   = errors.New("k8sAPIClientError")
 	ErrPodExec                     = errors.New("ExecInPodError")
 	ErrFilesystemIO                = errors.New("FilesystemIOError")
+	ErrInvalidConfigTemplate       = errors.New("InvalidConfigTemplateError")
 	ErrDBOD                        = errors.New("DBODError")
+	ErrDBODProvisioningFailed      = errors.New("DBODProvisioningError")
+	ErrStorageProvisioningFailed   = errors.New("StorageProvisioningError")
 	ErrBuildFailed                 = errors.New("BuildError")
 	ErrDeploymentUpdateFailed      = errors.New("DeploymentUpdateError")
 	ErrDBUpdateFailed              = errors.New("DBUpdateError")
 	ErrRollBack                    = errors.New("RollbackError")
 	ErrPodNotRunning               = errors.New("PodNotRunning")
 	ErrSupportedDrupalVersionsNone = errors.New("SupportedDrupalVersionsNoneError")
+	ErrDeletionUnconfirmed         = errors.New("DeletionUnconfirmed")
+	ErrInstallFailed               = errors.New("InstallError")
+	ErrQuotaExceeded               = errors.New("QuotaExceeded")
+	ErrBackupFailed                = errors.New("BackupFailed")
+	ErrUpdateUnapproved            = errors.New("UpdateUnapproved")
+	ErrSecretMissing               = errors.New("SecretMissing")
 )
 
 type reconcileError interface {
@@ -86,6 +95,14 @@ func (e *applicationError) Temporary() bool {
 		return false
 	case ErrDeploymentUpdateFailed:
 		return false
+	case ErrDBODProvisioningFailed:
+		return false
+	case ErrStorageProvisioningFailed:
+		return false
+	case ErrDeletionUnconfirmed:
+		return false
+	case ErrInstallFailed:
+		return false
 	default:
 		return true
 	}
@@ -43,6 +43,9 @@ func getClientsetFromConfig(config *rest.Config) (*kubernetes.Clientset, error)
 	return clientset, nil
 }
 
+// execToPod runs the commands in the pods, through execToPodThroughAPI. The tests replace it, as they have no container runtime
+var execToPod = execToPodThroughAPI
+
 // execToPodThroughAPI exec to the pod with the command specified non-interactively.
 // :param string command: list of the str which specify the command.
 // :param string pod_name: Pod name
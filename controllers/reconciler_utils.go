@@ -19,8 +19,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -87,6 +91,72 @@ func setConditionStatus(drp *webservicesv1a1.DrupalSite, conditionType status.Co
 	return drp.Status.Conditions.SetCondition(condition())
 }
 
+// summarizeStatus sets the fields of the status that summarize the rest of the site's state for external tooling:
+// the state, and the releases and update of the site, from the releaseID and the 'updateInProgress' annotation
+func summarizeStatus(drp *webservicesv1a1.DrupalSite) {
+	drp.Status.State = siteState(drp)
+	drp.Status.CurrentRelease = drp.Status.ReleaseID.Current
+	drp.Status.FailsafeRelease = drp.Status.ReleaseID.Failsafe
+	drp.Status.UpdateInProgress = drp.Annotations["updateInProgress"] == "true"
+}
+
+// siteState summarizes the conditions of the site and its update step in a single state
+func siteState(drp *webservicesv1a1.DrupalSite) webservicesv1a1.SiteState {
+	switch {
+	case drp.ConditionTrue("Blocked"):
+		return webservicesv1a1.SiteStateBlocked
+	case hasFailureCondition(drp):
+		return webservicesv1a1.SiteStateError
+	case !drp.ConditionTrue("Initialized"):
+		return webservicesv1a1.SiteStateInstalling
+	case drp.Status.UpdateStep != "":
+		return webservicesv1a1.SiteStateUpdating
+	case drp.ConditionTrue("Ready"):
+		return webservicesv1a1.SiteStateReady
+	default:
+		return webservicesv1a1.SiteStateNotReady
+	}
+}
+
+// failureConditions are the conditions that put the site in the Error state while they're true
+var failureConditions = map[status.ConditionType]bool{
+	"Error": true, "CodeUpdateFailed": true, "DBUpdatesFailed": true, "RollbackFailed": true, "StorageProvisioningFailed": true, "InstallFailed": true,
+}
+
+// hasFailureCondition checks if any of the failure conditions of the site is true
+func hasFailureCondition(drp *webservicesv1a1.DrupalSite) bool {
+	for _, c := range drp.Status.Conditions {
+		if failureConditions[c.Type] && c.IsTrue() {
+			return true
+		}
+	}
+	return false
+}
+
+// stuckConditions returns the failure conditions of the site that are true, and its Ready condition if it's false,
+// that haven't transitioned for longer than StuckConditionThreshold
+func stuckConditions(drp *webservicesv1a1.DrupalSite, now time.Time) []status.Condition {
+	if StuckConditionThreshold <= 0 {
+		return nil
+	}
+	stuck := []status.Condition{}
+	for _, c := range drp.Status.Conditions {
+		if ((failureConditions[c.Type] && c.IsTrue()) || (c.Type == "Ready" && c.IsFalse())) && now.Sub(c.LastTransitionTime.Time) > StuckConditionThreshold {
+			stuck = append(stuck, c)
+		}
+	}
+	return stuck
+}
+
+// setUpdateStep reports the given step of the update process on the drupalSite status
+func setUpdateStep(drp *webservicesv1a1.DrupalSite, step webservicesv1a1.UpdateStep) bool {
+	if drp.Status.UpdateStep == step {
+		return false
+	}
+	drp.Status.UpdateStep = step
+	return true
+}
+
 // setUpdateInProgress sets the 'updateInProgress' annotation on the drupalSite object
 func setUpdateInProgress(drp *webservicesv1a1.DrupalSite) bool {
 	if len(drp.Annotations) == 0 {
@@ -112,6 +182,27 @@ func unsetUpdateInProgress(drp *webservicesv1a1.DrupalSite) bool {
 	return false
 }
 
+// setUpgradeQueued sets the 'upgradeQueued' annotation on the drupalSite object
+func setUpgradeQueued(drp *webservicesv1a1.DrupalSite) bool {
+	if len(drp.Annotations) == 0 {
+		drp.Annotations = map[string]string{}
+	}
+	if drp.Annotations["upgradeQueued"] == "true" {
+		return false
+	}
+	drp.Annotations["upgradeQueued"] = "true"
+	return true
+}
+
+// unsetUpgradeQueued removes the 'upgradeQueued' annotation on the drupalSite object
+func unsetUpgradeQueued(drp *webservicesv1a1.DrupalSite) bool {
+	if _, isSet := drp.Annotations["upgradeQueued"]; isSet {
+		delete(drp.Annotations, "upgradeQueued")
+		return true
+	}
+	return false
+}
+
 // setDBUpdatesPending sets the 'DBUpdatesPending' status on the drupalSite object
 func setDBUpdatesPending(drp *webservicesv1a1.DrupalSite) (update bool) {
 	return drp.Status.Conditions.SetCondition(status.Condition{
@@ -125,6 +216,59 @@ func removeDBUpdatesPending(drp *webservicesv1a1.DrupalSite) (update bool) {
 	return drp.Status.Conditions.RemoveCondition("DBUpdatesPending")
 }
 
+// retryDBUpdate removes the 'DBUpdatesFailed' and 'DBUpdatesPending' status of the drupalSite object if the retryDBUpdateAnnotation is set,
+// so that the DB update runs again, starting with a fresh backup. The annotation is kept until the DB update is attempted
+func retryDBUpdate(drp *webservicesv1a1.DrupalSite) (update bool) {
+	if _, isSet := drp.Annotations[retryDBUpdateAnnotation]; !isSet || !drp.ConditionTrue("DBUpdatesFailed") {
+		return false
+	}
+	update = drp.Status.Conditions.RemoveCondition("DBUpdatesFailed")
+	return drp.Status.Conditions.RemoveCondition("DBUpdatesPending") || update
+}
+
+// unsetRetryDBUpdate removes the retryDBUpdateAnnotation on the drupalSite object, once the DB update was attempted
+func unsetRetryDBUpdate(drp *webservicesv1a1.DrupalSite) bool {
+	if _, isSet := drp.Annotations[retryDBUpdateAnnotation]; isSet {
+		delete(drp.Annotations, retryDBUpdateAnnotation)
+		return true
+	}
+	return false
+}
+
+// setPublishBlocked sets the 'PublishBlocked' status on the drupalSite object while its last update has failed, and removes it otherwise.
+// The routes of the site are not ensured while it is set, since the site could be in an inconsistent state
+func setPublishBlocked(drp *webservicesv1a1.DrupalSite) (update bool) {
+	for _, failure := range []status.ConditionType{"CodeUpdateFailed", "DBUpdatesFailed"} {
+		if drp.ConditionTrue(failure) {
+			return setConditionStatus(drp, "PublishBlocked", true, newApplicationError(fmt.Errorf("the routes are not published until the failed update (%s) is recovered", failure), ErrPermanent), false)
+		}
+	}
+	return drp.Status.Conditions.RemoveCondition("PublishBlocked")
+}
+
+// reconcileSummary records the resources that a reconciliation pass ensured, or skipped because the site wasn't ready for them
+type reconcileSummary struct {
+	ensured []string
+	skipped []string
+}
+
+// logReconcileSummary logs the state of the site and the resources ensured or skipped during the reconciliation, as one greppable line
+func logReconcileSummary(log logr.Logger, drp *webservicesv1a1.DrupalSite, summary *reconcileSummary) {
+	conditions := make([]string, 0, len(drp.Status.Conditions))
+	for _, condition := range drp.Status.Conditions {
+		conditions = append(conditions, string(condition.Type)+"="+string(condition.Status))
+	}
+	sort.Strings(conditions)
+	log.V(1).Info("Reconcile summary",
+		"conditions", strings.Join(conditions, ","),
+		"updateInProgress", drp.Annotations["updateInProgress"] == "true",
+		"releaseID.current", drp.Status.ReleaseID.Current,
+		"releaseID.failsafe", drp.Status.ReleaseID.Failsafe,
+		"ensured", strings.Join(summary.ensured, ","),
+		"skipped", strings.Join(summary.skipped, ","),
+	)
+}
+
 // updateCRorFailReconcile tries to update the Custom Resource and logs any error
 func (r *DrupalSiteReconciler) updateDrupalProjectConfigCR(ctx context.Context, log logr.Logger, dpc *webservicesv1a1.DrupalProjectConfig) error {
 	err := r.Update(ctx, dpc)
@@ -155,6 +299,7 @@ func (r *DrupalSiteReconciler) updateCRorFailReconcile(ctx context.Context, log
 // updateCRStatusOrFailReconcile tries to update the Custom Resource Status and logs any error
 func (r *DrupalSiteReconciler) updateCRStatusOrFailReconcile(ctx context.Context, log logr.Logger, drp *webservicesv1a1.DrupalSite) (
 	reconcile.Result, error) {
+	summarizeStatus(drp)
 	if err := r.Status().Update(ctx, drp); err != nil {
 		if k8sapierrors.IsConflict(err) {
 			log.V(4).Info("DrupalSite.Status changed while reconciling. Requeuing.")
@@ -192,7 +337,12 @@ func (r *DrupalSiteReconciler) getBuildStatus(ctx context.Context, resource stri
 
 // nameVersionHash returns a hash using the drupalSite name and version
 func nameVersionHash(drp *webservicesv1a1.DrupalSite) string {
-	hash := md5.Sum([]byte(drp.Name + releaseID(drp)))
+	return nameReleaseHash(drp.Name, releaseID(drp))
+}
+
+// nameReleaseHash returns a hash using the drupalSite name and the given releaseID
+func nameReleaseHash(name, releaseID string) string {
+	hash := md5.Sum([]byte(name + releaseID))
 	return hex.EncodeToString(hash[0:7])
 }
 
@@ -228,6 +378,33 @@ func ResourceRequestLimit(memReq, cpuReq, memLim, cpuLim string) (corev1.Resourc
 	}, nil
 }
 
+// validateVolumeAccessForReplicas checks that the given PVC can be mounted by all the replicas of the server deployment.
+// Replicas can be scheduled on different nodes, so more than 1 replica needs a ReadWriteMany volume.
+func validateVolumeAccessForReplicas(pvc *corev1.PersistentVolumeClaim, replicas int32) error {
+	if replicas <= 1 {
+		return nil
+	}
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == corev1.ReadWriteMany {
+			return nil
+		}
+	}
+	return fmt.Errorf("%d replicas need a ReadWriteMany volume, but PVC %s has access modes %v: pods can't be scheduled together", replicas, pvc.Name, pvc.Spec.AccessModes)
+}
+
+// ParseResourceRequestLimit parses a cmdline argument of the form "memReq,cpuReq,memLim,cpuLim" into ResourceRequirements.
+// An empty value returns empty ResourceRequirements, meaning that the defaults apply.
+func ParseResourceRequestLimit(value string) (corev1.ResourceRequirements, error) {
+	if value == "" {
+		return corev1.ResourceRequirements{}, nil
+	}
+	values := strings.Split(value, ",")
+	if len(values) != 4 {
+		return corev1.ResourceRequirements{}, fmt.Errorf("expected 4 comma-separated values \"memReq,cpuReq,memLim,cpuLim\", got %q", value)
+	}
+	return ResourceRequestLimit(strings.TrimSpace(values[0]), strings.TrimSpace(values[1]), strings.TrimSpace(values[2]), strings.TrimSpace(values[3]))
+}
+
 // reqLimDict returns the resource requests and limits for a given QoS class and container.
 // TODO: this should be part of operator configuration, read from a YAML file with format
 // defaultResources:
@@ -271,6 +448,12 @@ func reqLimDict(container string, qosClass webservicesv1a1.QoSClass) (corev1.Res
 		return ResourceRequestLimit("10Mi", "10m", "20Mi", "80m")
 	case "drupal-logs":
 		return ResourceRequestLimit("10Mi", "4m", "15Mi", "15m")
+	case "log-rotation":
+		return ResourceRequestLimit("10Mi", "4m", "20Mi", "20m")
+	case "pvc-init":
+		return ResourceRequestLimit("10Mi", "10m", "20Mi", "100m")
+	case "src-db-backup":
+		return ResourceRequestLimit(jobMemoryRequest, "50m", "2Gi", "1000m")
 	}
 	return corev1.ResourceRequirements{
 		Requests: corev1.ResourceList{},
@@ -278,6 +461,15 @@ func reqLimDict(container string, qosClass webservicesv1a1.QoSClass) (corev1.Res
 	}, newApplicationError(fmt.Errorf("undefined keys for the reqLimDict function"), ErrFunctionDomain)
 }
 
+// initContainerResources returns the resource requests/limits of the given lightweight init container of the site's jobs:
+// the `initContainerResources` of the site if set, otherwise the defaults of the container
+func initContainerResources(container string, d *webservicesv1a1.DrupalSite) (corev1.ResourceRequirements, error) {
+	if d.Spec.Configuration.InitContainerResources != nil {
+		return *d.Spec.Configuration.InitContainerResources, nil
+	}
+	return reqLimDict(container, d.Spec.QoSClass)
+}
+
 // getPodForVersion fetches the list of the pods for the current deployment and returns the first one from the list
 func (r *DrupalSiteReconciler) getPodForVersion(ctx context.Context, d *webservicesv1a1.DrupalSite, releaseID string) (corev1.Pod, reconcileError) {
 	podList := corev1.PodList{}
@@ -313,6 +505,15 @@ func generateRandomPassword() string {
 	return hex.EncodeToString(hash[:])[0:10]
 }
 
+// generateSecurePassword generates a random password of 32 characters from the cryptographic random source
+func generateSecurePassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 func createKeyValuePairs(m map[string]string) string {
 	b := new(bytes.Buffer)
 	for key, value := range m {
@@ -352,7 +553,30 @@ func generateScheduleName(namespace string, siteName string) string {
 	return namespace + "-" + hex.EncodeToString(siteNameHash[:])[0:4]
 }
 
-// getGracePeriodForPodToStartDuringUpgrade returns the time in minutes to wait for the new version of Drupal pod to start during version upgrade
-func getGracePeriodForPodToStartDuringUpgrade(d *webservicesv1a1.DrupalSite) float64 {
-	return 10 // 10minutes
+// getGracePeriodForPodToStartDuringUpgrade returns how long to wait for the new version of Drupal pod to start during version upgrade
+func getGracePeriodForPodToStartDuringUpgrade(d *webservicesv1a1.DrupalSite) time.Duration {
+	return PodStartGracePeriod
+}
+
+// imageOrOverride returns the override of the site's spec verbatim if it's set, else the mirrored image of the operator
+func imageOrOverride(image string, override string) string {
+	if override != "" {
+		return override
+	}
+	return mirroredImage(image)
+}
+
+// mirroredImage replaces the registry of the given image with the ImageRegistryMirror, if one is set.
+// Images without a registry are Docker Hub images, eg "bash" becomes "<mirror>/library/bash".
+func mirroredImage(image string) string {
+	if ImageRegistryMirror == "" {
+		return image
+	}
+	repository := image
+	if components := strings.SplitN(image, "/", 2); len(components) == 2 && (strings.ContainsAny(components[0], ".:") || components[0] == "localhost") {
+		repository = components[1]
+	} else if len(components) == 1 {
+		repository = "library/" + image
+	}
+	return strings.TrimSuffix(ImageRegistryMirror, "/") + "/" + repository
 }
@@ -0,0 +1,184 @@
+/*
+Copyright 2021 CERN.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	webservicesv1a1 "gitlab.cern.ch/drupal/paas/drupalsite-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podQuotaUsage returns what a server pod with the given deployment config counts against a ResourceQuota
+func podQuotaUsage(config DeploymentConfig) corev1.ResourceList {
+	usage := corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")}
+	add := func(name corev1.ResourceName, q resource.Quantity) {
+		sum := usage[name]
+		sum.Add(q)
+		usage[name] = sum
+	}
+	for _, resources := range []corev1.ResourceRequirements{config.phpResources, config.nginxResources, config.phpExporterResources,
+		config.webDAVResources, config.cronResources, config.drupalLogsResources, config.logRotationResources} {
+		if q, ok := resources.Requests[corev1.ResourceCPU]; ok {
+			add(corev1.ResourceRequestsCPU, q)
+			add(corev1.ResourceCPU, q)
+		}
+		if q, ok := resources.Requests[corev1.ResourceMemory]; ok {
+			add(corev1.ResourceRequestsMemory, q)
+			add(corev1.ResourceMemory, q)
+		}
+		if q, ok := resources.Limits[corev1.ResourceCPU]; ok {
+			add(corev1.ResourceLimitsCPU, q)
+		}
+		if q, ok := resources.Limits[corev1.ResourceMemory]; ok {
+			add(corev1.ResourceLimitsMemory, q)
+		}
+	}
+	return usage
+}
+
+// quotaShortfall checks the ResourceQuotas of the site's namespace that apply to its server pods, against the server pods that the deployment config adds.
+// A rolling update can create up to maxSurge pods on top of the replicas, so they are counted too.
+// It returns what each quota lacks, if anything, whether the replicas alone fit, and the number of server pods that the site runs already.
+func (r *DrupalSiteReconciler) quotaShortfall(ctx context.Context, d *webservicesv1a1.DrupalSite, config DeploymentConfig) (shortfall []string, replicasFit bool, running int32, transientErr reconcileError) {
+	quotaList := &corev1.ResourceQuotaList{}
+	if err := r.List(ctx, quotaList, client.InNamespace(d.Namespace)); err != nil {
+		return nil, false, 0, newApplicationError(err, ErrClientK8s)
+	}
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(d.Namespace), client.MatchingLabels{"app": "drupal", "drupalSite": d.Name}); err != nil {
+		return nil, false, 0, newApplicationError(err, ErrClientK8s)
+	}
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp.IsZero() && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			running++
+		}
+	}
+	perPod := podQuotaUsage(config)
+	// A pod without any requests nor limits is BestEffort. The usage always counts the pod itself
+	bestEffort := len(perPod) == 1
+	quotas := []corev1.ResourceQuota{}
+	for _, quota := range quotaList.Items {
+		if quotaAppliesToServerPods(quota, bestEffort, priorityClassName(d)) {
+			quotas = append(quotas, quota)
+		}
+	}
+	additional := config.replicas - running
+	if len(quotas) == 0 || additional+maxSurgePods(d, config.replicas) <= 0 {
+		return nil, true, running, nil
+	}
+	shortfall = quotaShortfallFor(quotas, perPod, additional+maxSurgePods(d, config.replicas))
+	replicasFit = len(shortfall) == 0 || additional <= 0 || len(quotaShortfallFor(quotas, perPod, additional)) == 0
+	return shortfall, replicasFit, running, nil
+}
+
+// quotaShortfallFor returns what each of the quotas lacks for the given number of additional pods
+func quotaShortfallFor(quotas []corev1.ResourceQuota, perPod corev1.ResourceList, additional int32) (shortfall []string) {
+	needed := corev1.ResourceList{}
+	for name, usage := range perPod {
+		total := resource.Quantity{}
+		for i := int32(0); i < additional; i++ {
+			total.Add(usage)
+		}
+		needed[name] = total
+	}
+	for _, quota := range quotas {
+		for name, need := range needed {
+			hard, ok := quota.Status.Hard[name]
+			if !ok {
+				continue
+			}
+			available := hard.DeepCopy()
+			available.Sub(quota.Status.Used[name])
+			if need.Cmp(available) > 0 {
+				shortfall = append(shortfall, fmt.Sprintf("ResourceQuota %s lacks %s: %s requested, %s available", quota.Name, name, need.String(), available.String()))
+			}
+		}
+	}
+	sort.Strings(shortfall)
+	return shortfall
+}
+
+// maxSurgePods returns how many pods a rolling update of the site's deployment can create on top of the replicas
+func maxSurgePods(d *webservicesv1a1.DrupalSite, replicas int32) int32 {
+	strategy := deploymentStrategy(d)
+	if strategy.Type != appsv1.RollingUpdateDeploymentStrategyType {
+		return 0
+	}
+	// The API server rounds the surge up
+	surge, err := intstr.GetValueFromIntOrPercent(strategy.RollingUpdate.MaxSurge, int(replicas), true)
+	if err != nil {
+		return 0
+	}
+	return int32(surge)
+}
+
+// quotaAppliesToServerPods checks if the scopes of the quota match the site's server pods, with the given QoS and priority class.
+// The server pods never set activeDeadlineSeconds, so they are "NotTerminating", and they don't use cross-namespace affinity.
+// See https://kubernetes.io/docs/concepts/policy/resource-quotas/#quota-scopes
+func quotaAppliesToServerPods(quota corev1.ResourceQuota, bestEffort bool, priorityClass string) bool {
+	requirements := []corev1.ScopedResourceSelectorRequirement{}
+	for _, scope := range quota.Spec.Scopes {
+		requirements = append(requirements, corev1.ScopedResourceSelectorRequirement{ScopeName: scope, Operator: corev1.ScopeSelectorOpExists})
+	}
+	if quota.Spec.ScopeSelector != nil {
+		requirements = append(requirements, quota.Spec.ScopeSelector.MatchExpressions...)
+	}
+	for _, requirement := range requirements {
+		if !scopeMatchesServerPods(requirement, bestEffort, priorityClass) {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeMatchesServerPods checks a single scope requirement of a quota against the server pods
+func scopeMatchesServerPods(requirement corev1.ScopedResourceSelectorRequirement, bestEffort bool, priorityClass string) bool {
+	if requirement.ScopeName == corev1.ResourceQuotaScopePriorityClass {
+		switch requirement.Operator {
+		case corev1.ScopeSelectorOpIn, corev1.ScopeSelectorOpNotIn:
+			in := false
+			for _, value := range requirement.Values {
+				in = in || value == priorityClass
+			}
+			return in == (requirement.Operator == corev1.ScopeSelectorOpIn)
+		case corev1.ScopeSelectorOpDoesNotExist:
+			return priorityClass == ""
+		default:
+			return priorityClass != ""
+		}
+	}
+	var matches bool
+	switch requirement.ScopeName {
+	case corev1.ResourceQuotaScopeNotTerminating:
+		matches = true
+	case corev1.ResourceQuotaScopeBestEffort:
+		matches = bestEffort
+	case corev1.ResourceQuotaScopeNotBestEffort:
+		matches = !bestEffort
+	}
+	if requirement.Operator == corev1.ScopeSelectorOpDoesNotExist {
+		return !matches
+	}
+	return matches
+}
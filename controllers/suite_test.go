@@ -20,6 +20,7 @@ import (
 	"flag"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -134,10 +135,25 @@ var _ = BeforeSuite(func(done Done) {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
+	StartRateLimiterMillis = 5
+	BackupTTL = 14 * 24 * time.Hour
+	MaxRateLimiterSeconds = 10
+	DefaultStorageClass = "cephfs-no-backup"
+	DBUpdateLockTimeout = time.Hour
+	OidcReturnURIScheme = "https"
+	VersionDriftGracePeriod = time.Hour
+	DBODProvisioningTimeout = time.Hour
+	PVCProvisioningGracePeriod = 10 * time.Minute
+	PodStartGracePeriod = 10 * time.Minute
+	StuckConditionThreshold = time.Hour
+	// The tests expect the resources to be ensured on every reconciliation
+	ResourcesResyncPeriod = 0
 	err = (&DrupalSiteReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
-		Log:    ctrl.Log.WithName("controllers").WithName("DrupalSite"),
+		Client:    k8sManager.GetClient(),
+		APIReader: k8sManager.GetAPIReader(),
+		Scheme:    k8sManager.GetScheme(),
+		Log:       ctrl.Log.WithName("controllers").WithName("DrupalSite"),
+		Recorder:  k8sManager.GetEventRecorderFor("drupalsite-controller"),
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
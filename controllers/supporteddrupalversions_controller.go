@@ -67,6 +67,10 @@ func (r *SupportedDrupalVersionsReconciler) SetupWithManager(mgr ctrl.Manager) e
 func (r *SupportedDrupalVersionsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("Request.Namespace", req.NamespacedName, "Request.Name", req.Name)
 	log.V(1).Info("Updating SupportedDrupalVersions resource")
+	if Paused {
+		log.V(1).Info("The operator is paused, skipping the reconciliation")
+		return ctrl.Result{}, nil
+	}
 
 	drupalVersionsList := &drupalwebservicesv1alpha1.SupportedDrupalVersionsList{}
 	err := r.Client.List(ctx, drupalVersionsList)
@@ -92,7 +96,7 @@ func (r *SupportedDrupalVersionsReconciler) Reconcile(ctx context.Context, req c
 	}
 
 	// Get all registry tags of SiteBuilderImage
-	registryTags, err := getRegistryTags(SiteBuilderImage)
+	registryTags, err := getRegistryTags(mirroredImage(SiteBuilderImage))
 	if err != nil {
 		log.Error(err, fmt.Sprintf("Failed to get tags of %s", SiteBuilderImage))
 		return reconcile.Result{}, err
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 CERN.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	routev1 "github.com/openshift/api/route/v1"
+	webservicesv1a1 "gitlab.cern.ch/drupal/paas/drupalsite-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/utils/pointer"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// releaseLabel is the label of the server pods with the release they run, set when the site has a traffic ramp
+const releaseLabel = "drupalRelease"
+
+// releaseLabelValue is the value of the releaseLabel for the given releaseID, short enough for the name of a Service
+func releaseLabelValue(releaseID string) string {
+	hash := md5.Sum([]byte(releaseID))
+	return hex.EncodeToString(hash[0:4])
+}
+
+// releaseServiceName is the name of the Service that selects the server pods of the given release
+func releaseServiceName(d *webservicesv1a1.DrupalSite, releaseID string) string {
+	return d.Name + "-" + releaseLabelValue(releaseID)
+}
+
+// trafficRampActive checks if the site shifts the traffic of its routes from the failsafe release to the new one.
+// A failed update keeps serving the failsafe release through the site's Service.
+func trafficRampActive(d *webservicesv1a1.DrupalSite) bool {
+	return d.Spec.Configuration.TrafficRamp && d.Status.ReleaseID.Failsafe != "" && d.Status.ReleaseID.Failsafe != releaseID(d) &&
+		!d.ConditionTrue("CodeUpdateFailed")
+}
+
+// rampWeights returns the weights of the routes' backends, in proportion to the ready pods of the old and the new release
+func rampWeights(readyOld int, readyNew int) (oldWeight int32, newWeight int32) {
+	if readyOld+readyNew == 0 {
+		return 100, 0
+	}
+	newWeight = int32((100*readyNew + (readyOld+readyNew)/2) / (readyOld + readyNew))
+	return 100 - newWeight, newWeight
+}
+
+// releaseServiceForDrupalSite returns the Service that selects the server pods of the given release
+func releaseServiceForDrupalSite(currentobject *corev1.Service, d *webservicesv1a1.DrupalSite, releaseID string) error {
+	if err := serviceForDrupalSite(currentobject, d); err != nil {
+		return err
+	}
+	currentobject.Labels[releaseLabel] = releaseLabelValue(releaseID)
+	selector := labelsForDrupalSite(d.Name)
+	selector["app"] = "drupal"
+	selector[releaseLabel] = releaseLabelValue(releaseID)
+	currentobject.Spec.Selector = selector
+	return nil
+}
+
+// readyPodsByRelease counts the ready server pods of the site by the value of their releaseLabel
+func (r *DrupalSiteReconciler) readyPodsByRelease(ctx context.Context, d *webservicesv1a1.DrupalSite) (map[string]int, reconcileError) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(d.Namespace), client.MatchingLabels{"app": "drupal", "drupalSite": d.Name}); err != nil {
+		return nil, newApplicationError(err, ErrClientK8s)
+	}
+	ready := map[string]int{}
+	for _, pod := range podList.Items {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready[pod.Labels[releaseLabel]]++
+			}
+		}
+	}
+	return ready, nil
+}
+
+// ensureTrafficRamp weights the backends of the site's routes by the ready pods of the failsafe and the new release.
+// Until any pod with the releaseLabel is ready, eg if the ramp was enabled during the update, the routes keep the site's Service.
+func (r *DrupalSiteReconciler) ensureTrafficRamp(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	oldRelease, newRelease := d.Status.ReleaseID.Failsafe, releaseID(d)
+	for _, release := range []string{oldRelease, newRelease} {
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: releaseServiceName(d, release), Namespace: d.Namespace}}
+		_, err := controllerruntime.CreateOrUpdate(ctx, r.Client, svc, func() error {
+			return releaseServiceForDrupalSite(svc, d, release)
+		})
+		if err != nil {
+			log.Error(err, "Failed to ensure Resource", "Kind", svc.TypeMeta.Kind, "Resource.Namespace", svc.Namespace, "Resource.Name", svc.Name)
+			return newApplicationError(err, ErrClientK8s)
+		}
+	}
+	ready, transientErr := r.readyPodsByRelease(ctx, d)
+	if transientErr != nil {
+		return transientErr
+	}
+	readyOld, readyNew := ready[releaseLabelValue(oldRelease)], ready[releaseLabelValue(newRelease)]
+	to := routev1.RouteTargetReference{Kind: "Service", Name: d.Name, Weight: pointer.Int32Ptr(100)}
+	var alternateBackends []routev1.RouteTargetReference
+	if readyOld+readyNew > 0 {
+		oldWeight, newWeight := rampWeights(readyOld, readyNew)
+		to = routev1.RouteTargetReference{Kind: "Service", Name: releaseServiceName(d, oldRelease), Weight: pointer.Int32Ptr(oldWeight)}
+		alternateBackends = []routev1.RouteTargetReference{{Kind: "Service", Name: releaseServiceName(d, newRelease), Weight: pointer.Int32Ptr(newWeight)}}
+	}
+
+	routeList := &routev1.RouteList{}
+	if err := r.List(ctx, routeList, client.InNamespace(d.Namespace), client.MatchingLabels{"app": "drupal", "drupalSite": d.Name, "route": "drupal"}); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	for i := range routeList.Items {
+		route := &routeList.Items[i]
+		if reflect.DeepEqual(route.Spec.To, to) && reflect.DeepEqual(route.Spec.AlternateBackends, alternateBackends) {
+			continue
+		}
+		route.Spec.To = to
+		route.Spec.AlternateBackends = alternateBackends
+		if err := r.Update(ctx, route); err != nil {
+			return newApplicationError(err, ErrClientK8s)
+		}
+		log.V(3).Info("Shifted the traffic of the route to the new release", "Route", route.Name, "readyOld", readyOld, "readyNew", readyNew)
+	}
+	return nil
+}
+
+// ensureNoReleaseServices deletes the Services of the traffic ramp, once the site doesn't shift its traffic between releases
+func (r *DrupalSiteReconciler) ensureNoReleaseServices(ctx context.Context, d *webservicesv1a1.DrupalSite, log logr.Logger) (transientErr reconcileError) {
+	selector := labels.SelectorFromSet(labels.Set{"app": "drupal", "drupalSite": d.Name})
+	hasRelease, err := labels.NewRequirement(releaseLabel, selection.Exists, nil)
+	if err != nil {
+		return newApplicationError(err, ErrFunctionDomain)
+	}
+	serviceList := &corev1.ServiceList{}
+	if err := r.List(ctx, serviceList, client.InNamespace(d.Namespace), client.MatchingLabelsSelector{Selector: selector.Add(*hasRelease)}); err != nil {
+		return newApplicationError(err, ErrClientK8s)
+	}
+	for i := range serviceList.Items {
+		log.V(3).Info("Deleting the Service of the traffic ramp", "Service", serviceList.Items[i].Name)
+		if err := r.Delete(ctx, &serviceList.Items[i]); err != nil && !k8sapierrors.IsNotFound(err) {
+			return newApplicationError(err, ErrClientK8s)
+		}
+	}
+	return nil
+}
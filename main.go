@@ -17,9 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"flag"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -45,6 +47,7 @@ import (
 	pipelinev1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 )
 
 var (
@@ -88,6 +91,34 @@ func main() {
 	flag.BoolVar(&controllers.EnableTopologySpread, "enable-topology-spread", false, "Enable avaliability zone scheduling for critical site deployments")
 	flag.StringVar(&controllers.ClusterName, "cluster-name", "", "Name of the cluster the operator is deployed on")
 	flag.StringVar(&controllers.EasystartBackupName, "easystart-backup-name", "", "The name of the easy-start backup")
+	flag.IntVar(&controllers.StartRateLimiterMillis, "start-rate-limiter-millis", 500, "The initial delay in milliseconds before retrying a failed DrupalSite reconciliation. The delay doubles on every consecutive failure")
+	flag.IntVar(&controllers.MaxRateLimiterSeconds, "max-rate-limiter-seconds", 300, "The maximum delay in seconds before retrying a failed DrupalSite reconciliation")
+	flag.IntVar(&controllers.MaxConcurrentUpgrades, "max-concurrent-upgrades", 0, "The maximum number of DrupalSite version upgrades running at the same time across the cluster. 0 means no limit")
+	flag.StringVar(&controllers.ImageRegistryMirror, "image-registry-mirror", "", "The registry (with an optional path prefix) that replaces the registry of all the images deployed by the operator, eg for air-gapped clusters")
+	flag.DurationVar(&controllers.BackupTTL, "backup-ttl", 14*24*time.Hour, "The duration after which the scheduled backups of the DrupalSites are deleted")
+	flag.IntVar(&controllers.DeploymentRevisionHistoryLimit, "deployment-revision-history-limit", 2, "The number of old ReplicaSets kept for each DrupalSite deployment, to allow a manual rollback")
+	flag.DurationVar(&controllers.DrupalCoreVersionInterval, "drupal-core-version-interval", 0, "How often the Drupal core version running on the DrupalSites is checked and reported on their status. 0 disables the check")
+	flag.BoolVar(&controllers.Paused, "paused", false, "Pause the reconciliation of all the resources, eg during cluster maintenance. Health and metrics are still served")
+	flag.DurationVar(&controllers.ResourcesResyncPeriod, "resources-resync-period", 10*time.Minute, "How often the resources of a steady DrupalSite are ensured even if nothing they depend on changed. 0 ensures them on every reconciliation")
+	flag.DurationVar(&controllers.DBODProvisioningTimeout, "dbod-provisioning-timeout", time.Hour, "How long the DBOD operator can take to assign an instance to the database of a DrupalSite, before the DatabaseProvisioned condition reports a failure")
+	flag.DurationVar(&controllers.PVCProvisioningGracePeriod, "pvc-provisioning-grace-period", 10*time.Minute, "How long the PVC of a DrupalSite can stay pending, before the StorageProvisioningFailed condition is set")
+	flag.DurationVar(&controllers.PodStartGracePeriod, "pod-start-grace-period", 10*time.Minute, "How long the pod of a new release can stay pending during an update of a DrupalSite, before the rollout is considered failed")
+	flag.DurationVar(&controllers.StuckConditionThreshold, "stuck-condition-threshold", time.Hour, "How long a failure condition of a DrupalSite can stay true, or its Ready condition false, before it is logged and reported with a ConditionStuck event. 0 disables the reports")
+	flag.StringVar(&controllers.WatchNamespace, "watch-namespace", "", "Only reconcile the DrupalSites of this namespace, and skip the cluster-scoped Tekton ClusterRoleBindings. All namespaces are watched by default")
+	var allowedSiteURLSuffixes string
+	flag.StringVar(&allowedSiteURLSuffixes, "allowed-site-url-suffixes", "", "Comma-separated domains that the URLs of the DrupalSites must belong to, eg the domains admitted by the router. Any domain is allowed by default")
+	flag.DurationVar(&controllers.StartupTimeout, "startup-timeout", 5*time.Minute, "How long the nginx and php-fpm containers of a DrupalSite can take to start, before they are restarted. Their liveness probes only run after they started")
+	flag.DurationVar(&controllers.VersionDriftGracePeriod, "version-drift-grace-period", time.Hour, "How long a DrupalSite can run a pod of an older release than its spec without an update in progress, before the VersionDrift condition is set")
+	flag.StringVar(&controllers.OidcReturnURIScheme, "oidc-return-uri-scheme", "https", "The scheme (http or https) of the OIDC return URIs registered for the DrupalSites")
+	flag.DurationVar(&controllers.DBUpdateLockTimeout, "db-update-lock-timeout", time.Hour, "How long a DB update of a DrupalSite holds the lock that prevents concurrent DB updates, before another one can take it over")
+	flag.StringVar(&controllers.DefaultStorageClass, "default-storage-class", "cephfs-no-backup", "The storage class of the PVCs of the DrupalSites")
+	flag.BoolVar(&controllers.EnableCloneURLRewrite, "enable-clone-url-rewrite", false, "Enable rewriting the URLs in the content of cloned sites that set cloneURLRewrite")
+	flag.BoolVar(&controllers.EnableAdminAccount, "enable-admin-account", true, "Pass the adminAccount of the DrupalSites to their install job, as DRUPAL_ADMIN_NAME and DRUPAL_ADMIN_PASSWORD. If disabled, DrupalSites that set adminAccount are rejected")
+	var nginxResources, phpFpmResources, phpFpmExporterResources, webDAVResources string
+	flag.StringVar(&nginxResources, "nginx-resources", "", "Resource requests/limits of the nginx container as \"memReq,cpuReq,memLim,cpuLim\", overriding the QoS class defaults")
+	flag.StringVar(&phpFpmResources, "php-fpm-resources", "", "Resource requests/limits of the php-fpm container as \"memReq,cpuReq,memLim,cpuLim\", overriding the QoS class defaults")
+	flag.StringVar(&phpFpmExporterResources, "php-fpm-exporter-resources", "", "Resource requests/limits of the php-fpm-exporter container as \"memReq,cpuReq,memLim,cpuLim\", overriding the QoS class defaults")
+	flag.StringVar(&webDAVResources, "webdav-resources", "", "Resource requests/limits of the webdav container as \"memReq,cpuReq,memLim,cpuLim\", overriding the QoS class defaults")
 	opts := zap.Options{
 		Development: false,
 	}
@@ -95,33 +126,70 @@ func main() {
 	flag.Parse()
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if controllers.OidcReturnURIScheme != "http" && controllers.OidcReturnURIScheme != "https" {
+		setupLog.Error(errors.New("oidc-return-uri-scheme must be http or https"), "Invalid configuration: unknown OIDC return URI scheme")
+		os.Exit(1)
+	}
+	if allowedSiteURLSuffixes != "" {
+		controllers.AllowedSiteURLSuffixes = strings.Split(allowedSiteURLSuffixes, ",")
+	}
+	if controllers.DefaultStorageClass == "" {
+		setupLog.Error(errors.New("default-storage-class can't be empty"), "Invalid configuration: no storage class for the PVCs")
+		os.Exit(1)
+	}
+
 	var err error
 	controllers.BuildResources, err = controllers.ResourceRequestLimit("2Gi", "1000m", "4Gi", "2000m")
 	if err != nil {
 		setupLog.Error(err, "Invalid configuration: can't parse build resources")
 		os.Exit(1)
 	}
+	for _, containerResources := range []struct {
+		flag      string
+		value     string
+		resources *corev1.ResourceRequirements
+	}{
+		{"nginx-resources", nginxResources, &controllers.NginxResources},
+		{"php-fpm-resources", phpFpmResources, &controllers.PhpFpmResources},
+		{"php-fpm-exporter-resources", phpFpmExporterResources, &controllers.PhpFpmExporterResources},
+		{"webdav-resources", webDAVResources, &controllers.WebDAVResources},
+	} {
+		*containerResources.resources, err = controllers.ParseResourceRequestLimit(containerResources.value)
+		if err != nil {
+			setupLog.Error(err, "Invalid configuration: can't parse container resources", "flag", containerResources.flag)
+			os.Exit(1)
+		}
+	}
+
+	if err := controllers.ValidateQoSConfigTemplates(controllers.RuntimeConfigDir); err != nil {
+		setupLog.Error(err, "Invalid configuration: broken QoS class configuration template")
+		os.Exit(1)
+	}
 
 	// Seed value for generating random Cron values in Velero backup objects & cronjobs
 	rand.Seed(time.Now().UnixNano())
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "78d40201.cern.ch",
-	})
+	}
+	controllers.RestrictToWatchNamespace(&options)
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.DrupalSiteReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("DrupalSite"),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Log:       ctrl.Log.WithName("controllers").WithName("DrupalSite"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("drupalsite-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DrupalSite")
 		os.Exit(1)
@@ -145,6 +213,10 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("config-templates", controllers.QoSConfigTemplatesCheck(controllers.RuntimeConfigDir)); err != nil {
+		setupLog.Error(err, "unable to set up the configuration templates check")
+		os.Exit(1)
+	}
 
 	setupLog.V(1).Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {